@@ -0,0 +1,458 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zefrenchwan/patterns.git/oidc"
+	"go.uber.org/zap/zapcore"
+)
+
+// CONFIG_FILE_VARIABLE is the environment variable for the path of the configuration file
+const CONFIG_FILE_VARIABLE = "PATTERNS_CONFIG"
+
+// SIGNING_KEY_SECRET_SIZE is the size in bytes of the secret encrypting signing keys (AES-256)
+const SIGNING_KEY_SECRET_SIZE = 32
+
+// OIDC_ROLES are the roles an issuer may grant through groups
+var OIDC_ROLES = []string{"manager", "modifier", "observer", "granter"}
+
+// Duration is a duration read as a golang duration string, for instance 30s
+type Duration time.Duration
+
+// UnmarshalJSON reads a duration from a string
+func (d *Duration) UnmarshalJSON(content []byte) error {
+	var value string
+	if err := json.Unmarshal(content, &value); err != nil {
+		return err
+	} else if parsed, err := time.ParseDuration(value); err != nil {
+		return err
+	} else {
+		*d = Duration(parsed)
+	}
+
+	return nil
+}
+
+// MarshalJSON writes a duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Configuration contains the settings of the server.
+// Values come from defaults, then configuration file, then environment variables
+type Configuration struct {
+	// Port to listen to, as :number. PATTERNS_PORT
+	Port string `json:"port"`
+	// DatabaseUrl is the postgresql url. PATTERNS_DB_URL
+	DatabaseUrl string `json:"database_url"`
+	// ReadTimeout is the maximum duration to read a request. PATTERNS_READ_TIMEOUT
+	ReadTimeout Duration `json:"read_timeout"`
+	// WriteTimeout is the maximum duration to write a response. PATTERNS_WRITE_TIMEOUT
+	WriteTimeout Duration `json:"write_timeout"`
+	// IdleTimeout is the maximum duration of an idle keep alive connection. PATTERNS_IDLE_TIMEOUT
+	IdleTimeout Duration `json:"idle_timeout"`
+	// ShutdownTimeout is the maximum duration to drain requests on shutdown. PATTERNS_SHUTDOWN_TIMEOUT
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+	// MaxBodySize is the maximum size of a request body, in bytes. PATTERNS_MAX_BODY_SIZE
+	MaxBodySize int64 `json:"max_body_size"`
+	// TLSCertFile is the certificate file, TLS is off if empty. PATTERNS_TLS_CERT
+	TLSCertFile string `json:"tls_cert_file"`
+	// TLSKeyFile is the private key file of the certificate. PATTERNS_TLS_KEY
+	TLSKeyFile string `json:"tls_key_file"`
+	// PoolMaxConns is the maximum size of the connections pool, 0 for pgx default. PATTERNS_DB_MAX_CONNS
+	PoolMaxConns int32 `json:"pool_max_conns"`
+	// PoolMinConns is the minimum size of the connections pool. PATTERNS_DB_MIN_CONNS
+	PoolMinConns int32 `json:"pool_min_conns"`
+	// PoolMaxConnLifetime is the maximum duration of a connection, 0 for pgx default. PATTERNS_DB_MAX_CONN_LIFETIME
+	PoolMaxConnLifetime Duration `json:"pool_max_conn_lifetime"`
+	// PoolMaxConnIdleTime is the maximum duration of an idle connection, 0 for pgx default. PATTERNS_DB_MAX_CONN_IDLE_TIME
+	PoolMaxConnIdleTime Duration `json:"pool_max_conn_idle_time"`
+	// LogLevel is debug, info, warn or error. PATTERNS_LOG_LEVEL
+	LogLevel string `json:"log_level"`
+	// TrashRetention is how long deleted graphs and elements stay in trash. PATTERNS_TRASH_RETENTION
+	TrashRetention Duration `json:"trash_retention"`
+	// TrashPurgePeriod is the delay between two purges of the trash. PATTERNS_TRASH_PURGE_PERIOD
+	TrashPurgePeriod Duration `json:"trash_purge_period"`
+	// AccessTokenDuration is the validity of access tokens. PATTERNS_ACCESS_TOKEN_DURATION
+	AccessTokenDuration Duration `json:"access_token_duration"`
+	// RefreshTokenDuration is the validity of refresh tokens. PATTERNS_REFRESH_TOKEN_DURATION
+	RefreshTokenDuration Duration `json:"refresh_token_duration"`
+	// SigningAlgorithm signs access tokens, EdDSA or RS256. PATTERNS_SIGNING_ALGORITHM
+	SigningAlgorithm string `json:"signing_algorithm"`
+	// SigningKeyRotation is the duration a key signs tokens before a new key replaces it. PATTERNS_SIGNING_KEY_ROTATION
+	SigningKeyRotation Duration `json:"signing_key_rotation"`
+	// SigningKeySecret encrypts signing keys in database, as 32 bytes in base64. PATTERNS_SIGNING_KEY_SECRET
+	SigningKeySecret string `json:"signing_key_secret"`
+	// TokenIssuer is the iss claim of access tokens. PATTERNS_TOKEN_ISSUER
+	TokenIssuer string `json:"token_issuer"`
+	// TokenAudience is the aud claim of access tokens. PATTERNS_TOKEN_AUDIENCE
+	TokenAudience string `json:"token_audience"`
+	// PasswordMinLength is the minimum length of new passwords. PATTERNS_PASSWORD_MIN_LENGTH
+	PasswordMinLength int `json:"password_min_length"`
+	// PasswordRequireMixedCase needs upper and lower case letters in new passwords. PATTERNS_PASSWORD_REQUIRE_MIXED_CASE
+	PasswordRequireMixedCase bool `json:"password_require_mixed_case"`
+	// PasswordRequireDigit needs a digit in new passwords. PATTERNS_PASSWORD_REQUIRE_DIGIT
+	PasswordRequireDigit bool `json:"password_require_digit"`
+	// PasswordRequireSymbol needs a symbol in new passwords. PATTERNS_PASSWORD_REQUIRE_SYMBOL
+	PasswordRequireSymbol bool `json:"password_require_symbol"`
+	// LoginMaxFailures is the number of failed logins that locks a login, 0 for no limit. PATTERNS_LOGIN_MAX_FAILURES
+	LoginMaxFailures int `json:"login_max_failures"`
+	// LoginAddressMaxFailures is the number of failed logins that locks an address, 0 for no limit. PATTERNS_LOGIN_ADDRESS_MAX_FAILURES
+	LoginAddressMaxFailures int `json:"login_address_max_failures"`
+	// LoginFailureWindow is the period failed logins are counted in. PATTERNS_LOGIN_FAILURE_WINDOW
+	LoginFailureWindow Duration `json:"login_failure_window"`
+	// LoginLockoutDuration is the duration of a lockout. PATTERNS_LOGIN_LOCKOUT_DURATION
+	LoginLockoutDuration Duration `json:"login_lockout_duration"`
+	// RateLimitReadRate is the rate of read calls per user and route, per second, 0 for no limit. PATTERNS_RATE_LIMIT_READ_RATE
+	RateLimitReadRate float64 `json:"rate_limit_read_rate"`
+	// RateLimitReadBurst is the burst of read calls per user and route. PATTERNS_RATE_LIMIT_READ_BURST
+	RateLimitReadBurst int `json:"rate_limit_read_burst"`
+	// RateLimitWriteRate is the rate of write calls per user and route, per second, 0 for no limit. PATTERNS_RATE_LIMIT_WRITE_RATE
+	RateLimitWriteRate float64 `json:"rate_limit_write_rate"`
+	// RateLimitWriteBurst is the burst of write calls per user and route. PATTERNS_RATE_LIMIT_WRITE_BURST
+	RateLimitWriteBurst int `json:"rate_limit_write_burst"`
+	// RateLimitFindRate is the rate of find calls per user and route, per second, 0 for no limit. PATTERNS_RATE_LIMIT_FIND_RATE
+	RateLimitFindRate float64 `json:"rate_limit_find_rate"`
+	// RateLimitFindBurst is the burst of find calls per user and route. PATTERNS_RATE_LIMIT_FIND_BURST
+	RateLimitFindBurst int `json:"rate_limit_find_burst"`
+	// OidcIssuer is the url of the OpenID Connect issuer, no external login if empty. PATTERNS_OIDC_ISSUER
+	OidcIssuer string `json:"oidc_issuer"`
+	// OidcClientId is the client id at the issuer. PATTERNS_OIDC_CLIENT_ID
+	OidcClientId string `json:"oidc_client_id"`
+	// OidcClientSecret is the client secret at the issuer. PATTERNS_OIDC_CLIENT_SECRET
+	OidcClientSecret string `json:"oidc_client_secret"`
+	// OidcRedirectUrl is the callback url registered at the issuer. PATTERNS_OIDC_REDIRECT_URL
+	OidcRedirectUrl string `json:"oidc_redirect_url"`
+	// OidcScopes are the requested scopes. PATTERNS_OIDC_SCOPES, separated by spaces
+	OidcScopes []string `json:"oidc_scopes"`
+	// OidcAudience is the aud claim of bearer tokens of the issuer, client id if empty. PATTERNS_OIDC_AUDIENCE
+	OidcAudience string `json:"oidc_audience"`
+	// OidcUsernameClaim is the claim naming local users. PATTERNS_OIDC_USERNAME_CLAIM
+	OidcUsernameClaim string `json:"oidc_username_claim"`
+	// OidcGroupsClaim is the claim listing groups of users. PATTERNS_OIDC_GROUPS_CLAIM
+	OidcGroupsClaim string `json:"oidc_groups_claim"`
+	// OidcAutoProvision creates local users for unknown subjects. PATTERNS_OIDC_AUTO_PROVISION
+	OidcAutoProvision bool `json:"oidc_auto_provision"`
+	// OidcGroupRoles are, per group, roles of its members as class:role. PATTERNS_OIDC_GROUP_ROLES, as json
+	OidcGroupRoles map[string][]string `json:"oidc_group_roles"`
+}
+
+// DefaultConfiguration returns the configuration with no file and no environment variable
+func DefaultConfiguration() Configuration {
+	return Configuration{
+		ReadTimeout:             Duration(30 * time.Second),
+		WriteTimeout:            Duration(60 * time.Second),
+		IdleTimeout:             Duration(120 * time.Second),
+		ShutdownTimeout:         Duration(30 * time.Second),
+		MaxBodySize:             10 << 20,
+		LogLevel:                "info",
+		TrashRetention:          Duration(30 * 24 * time.Hour),
+		TrashPurgePeriod:        Duration(time.Hour),
+		AccessTokenDuration:     Duration(15 * time.Minute),
+		RefreshTokenDuration:    Duration(30 * 24 * time.Hour),
+		SigningAlgorithm:        "EdDSA",
+		SigningKeyRotation:      Duration(7 * 24 * time.Hour),
+		TokenIssuer:             "patterns",
+		TokenAudience:           "patterns",
+		PasswordMinLength:       12,
+		LoginMaxFailures:        5,
+		LoginAddressMaxFailures: 50,
+		LoginFailureWindow:      Duration(15 * time.Minute),
+		LoginLockoutDuration:    Duration(15 * time.Minute),
+		RateLimitReadRate:       20,
+		RateLimitReadBurst:      40,
+		RateLimitWriteRate:      10,
+		RateLimitWriteBurst:     20,
+		RateLimitFindRate:       2,
+		RateLimitFindBurst:      5,
+		OidcUsernameClaim:       "preferred_username",
+		OidcGroupsClaim:         "groups",
+	}
+}
+
+// LoadFromEnvironment loads the configuration from the file in PATTERNS_CONFIG, if any, and from environment variables
+func LoadFromEnvironment() (Configuration, error) {
+	return Load(os.Getenv(CONFIG_FILE_VARIABLE), os.Getenv)
+}
+
+// Load reads the configuration file at path (no file if empty), then overrides values with environment variables from getenv.
+// Result is validated
+func Load(path string, getenv func(string) string) (Configuration, error) {
+	result := DefaultConfiguration()
+	if len(path) != 0 {
+		if content, err := os.ReadFile(path); err != nil {
+			return result, fmt.Errorf("cannot read configuration file: %s", err.Error())
+		} else if err := json.Unmarshal(content, &result); err != nil {
+			return result, fmt.Errorf("invalid configuration file %s: %s", path, err.Error())
+		}
+	}
+
+	var globalErr error
+	overrideString(&result.Port, getenv("PATTERNS_PORT"))
+	overrideString(&result.DatabaseUrl, getenv("PATTERNS_DB_URL"))
+	globalErr = errors.Join(globalErr, overrideDuration(&result.ReadTimeout, "PATTERNS_READ_TIMEOUT", getenv))
+	globalErr = errors.Join(globalErr, overrideDuration(&result.WriteTimeout, "PATTERNS_WRITE_TIMEOUT", getenv))
+	globalErr = errors.Join(globalErr, overrideDuration(&result.IdleTimeout, "PATTERNS_IDLE_TIMEOUT", getenv))
+	globalErr = errors.Join(globalErr, overrideDuration(&result.ShutdownTimeout, "PATTERNS_SHUTDOWN_TIMEOUT", getenv))
+	globalErr = errors.Join(globalErr, overrideInt(&result.MaxBodySize, "PATTERNS_MAX_BODY_SIZE", getenv))
+	overrideString(&result.TLSCertFile, getenv("PATTERNS_TLS_CERT"))
+	overrideString(&result.TLSKeyFile, getenv("PATTERNS_TLS_KEY"))
+	globalErr = errors.Join(globalErr, overrideInt(&result.PoolMaxConns, "PATTERNS_DB_MAX_CONNS", getenv))
+	globalErr = errors.Join(globalErr, overrideInt(&result.PoolMinConns, "PATTERNS_DB_MIN_CONNS", getenv))
+	globalErr = errors.Join(globalErr, overrideDuration(&result.PoolMaxConnLifetime, "PATTERNS_DB_MAX_CONN_LIFETIME", getenv))
+	globalErr = errors.Join(globalErr, overrideDuration(&result.PoolMaxConnIdleTime, "PATTERNS_DB_MAX_CONN_IDLE_TIME", getenv))
+	overrideString(&result.LogLevel, getenv("PATTERNS_LOG_LEVEL"))
+	globalErr = errors.Join(globalErr, overrideDuration(&result.TrashRetention, "PATTERNS_TRASH_RETENTION", getenv))
+	globalErr = errors.Join(globalErr, overrideDuration(&result.TrashPurgePeriod, "PATTERNS_TRASH_PURGE_PERIOD", getenv))
+	globalErr = errors.Join(globalErr, overrideDuration(&result.AccessTokenDuration, "PATTERNS_ACCESS_TOKEN_DURATION", getenv))
+	globalErr = errors.Join(globalErr, overrideDuration(&result.RefreshTokenDuration, "PATTERNS_REFRESH_TOKEN_DURATION", getenv))
+	overrideString(&result.SigningAlgorithm, getenv("PATTERNS_SIGNING_ALGORITHM"))
+	globalErr = errors.Join(globalErr, overrideDuration(&result.SigningKeyRotation, "PATTERNS_SIGNING_KEY_ROTATION", getenv))
+	overrideString(&result.SigningKeySecret, getenv("PATTERNS_SIGNING_KEY_SECRET"))
+	overrideString(&result.TokenIssuer, getenv("PATTERNS_TOKEN_ISSUER"))
+	overrideString(&result.TokenAudience, getenv("PATTERNS_TOKEN_AUDIENCE"))
+	globalErr = errors.Join(globalErr, overrideInt(&result.PasswordMinLength, "PATTERNS_PASSWORD_MIN_LENGTH", getenv))
+	globalErr = errors.Join(globalErr, overrideBool(&result.PasswordRequireMixedCase, "PATTERNS_PASSWORD_REQUIRE_MIXED_CASE", getenv))
+	globalErr = errors.Join(globalErr, overrideBool(&result.PasswordRequireDigit, "PATTERNS_PASSWORD_REQUIRE_DIGIT", getenv))
+	globalErr = errors.Join(globalErr, overrideBool(&result.PasswordRequireSymbol, "PATTERNS_PASSWORD_REQUIRE_SYMBOL", getenv))
+	globalErr = errors.Join(globalErr, overrideInt(&result.LoginMaxFailures, "PATTERNS_LOGIN_MAX_FAILURES", getenv))
+	globalErr = errors.Join(globalErr, overrideInt(&result.LoginAddressMaxFailures, "PATTERNS_LOGIN_ADDRESS_MAX_FAILURES", getenv))
+	globalErr = errors.Join(globalErr, overrideDuration(&result.LoginFailureWindow, "PATTERNS_LOGIN_FAILURE_WINDOW", getenv))
+	globalErr = errors.Join(globalErr, overrideDuration(&result.LoginLockoutDuration, "PATTERNS_LOGIN_LOCKOUT_DURATION", getenv))
+	globalErr = errors.Join(globalErr, overrideFloat(&result.RateLimitReadRate, "PATTERNS_RATE_LIMIT_READ_RATE", getenv))
+	globalErr = errors.Join(globalErr, overrideInt(&result.RateLimitReadBurst, "PATTERNS_RATE_LIMIT_READ_BURST", getenv))
+	globalErr = errors.Join(globalErr, overrideFloat(&result.RateLimitWriteRate, "PATTERNS_RATE_LIMIT_WRITE_RATE", getenv))
+	globalErr = errors.Join(globalErr, overrideInt(&result.RateLimitWriteBurst, "PATTERNS_RATE_LIMIT_WRITE_BURST", getenv))
+	globalErr = errors.Join(globalErr, overrideFloat(&result.RateLimitFindRate, "PATTERNS_RATE_LIMIT_FIND_RATE", getenv))
+	globalErr = errors.Join(globalErr, overrideInt(&result.RateLimitFindBurst, "PATTERNS_RATE_LIMIT_FIND_BURST", getenv))
+	overrideString(&result.OidcIssuer, getenv("PATTERNS_OIDC_ISSUER"))
+	overrideString(&result.OidcClientId, getenv("PATTERNS_OIDC_CLIENT_ID"))
+	overrideString(&result.OidcClientSecret, getenv("PATTERNS_OIDC_CLIENT_SECRET"))
+	overrideString(&result.OidcRedirectUrl, getenv("PATTERNS_OIDC_REDIRECT_URL"))
+	if scopes := getenv("PATTERNS_OIDC_SCOPES"); len(scopes) != 0 {
+		result.OidcScopes = strings.Fields(scopes)
+	}
+
+	overrideString(&result.OidcAudience, getenv("PATTERNS_OIDC_AUDIENCE"))
+	overrideString(&result.OidcUsernameClaim, getenv("PATTERNS_OIDC_USERNAME_CLAIM"))
+	overrideString(&result.OidcGroupsClaim, getenv("PATTERNS_OIDC_GROUPS_CLAIM"))
+	globalErr = errors.Join(globalErr, overrideBool(&result.OidcAutoProvision, "PATTERNS_OIDC_AUTO_PROVISION", getenv))
+	if roles := getenv("PATTERNS_OIDC_GROUP_ROLES"); len(roles) != 0 {
+		if err := json.Unmarshal([]byte(roles), &result.OidcGroupRoles); err != nil {
+			globalErr = errors.Join(globalErr, fmt.Errorf("invalid PATTERNS_OIDC_GROUP_ROLES: %s", err.Error()))
+		}
+	}
+
+	if globalErr != nil {
+		return result, globalErr
+	}
+
+	return result, result.Validate()
+}
+
+// Validate returns an error for invalid or missing values
+func (c Configuration) Validate() error {
+	var globalErr error
+	if !strings.HasPrefix(c.Port, ":") {
+		globalErr = errors.Join(globalErr, fmt.Errorf("invalid port %s : it should be a : and a valid number", c.Port))
+	} else if _, err := strconv.Atoi(c.Port[1:]); err != nil {
+		globalErr = errors.Join(globalErr, fmt.Errorf("invalid port %s : it should be a : and a valid number", c.Port))
+	}
+
+	if len(c.DatabaseUrl) == 0 {
+		globalErr = errors.Join(globalErr, errors.New("no database set"))
+	}
+
+	if (len(c.TLSCertFile) == 0) != (len(c.TLSKeyFile) == 0) {
+		globalErr = errors.Join(globalErr, errors.New("TLS expects both certificate and key"))
+	}
+
+	for name, value := range map[string]Duration{
+		"read timeout":           c.ReadTimeout,
+		"write timeout":          c.WriteTimeout,
+		"idle timeout":           c.IdleTimeout,
+		"shutdown timeout":       c.ShutdownTimeout,
+		"trash purge period":     c.TrashPurgePeriod,
+		"access token duration":  c.AccessTokenDuration,
+		"refresh token duration": c.RefreshTokenDuration,
+		"signing key rotation":   c.SigningKeyRotation,
+		"login failure window":   c.LoginFailureWindow,
+		"login lockout duration": c.LoginLockoutDuration,
+	} {
+		if value <= 0 {
+			globalErr = errors.Join(globalErr, fmt.Errorf("%s should be positive", name))
+		}
+	}
+
+	if c.SigningAlgorithm != "EdDSA" && c.SigningAlgorithm != "RS256" {
+		globalErr = errors.Join(globalErr, fmt.Errorf("invalid signing algorithm %s, expecting EdDSA or RS256", c.SigningAlgorithm))
+	}
+
+	if _, err := c.SigningKeySecretBytes(); err != nil {
+		globalErr = errors.Join(globalErr, err)
+	}
+
+	if len(c.TokenIssuer) == 0 || len(c.TokenAudience) == 0 {
+		globalErr = errors.Join(globalErr, errors.New("token issuer and audience should be set"))
+	}
+
+	if c.PasswordMinLength < 1 {
+		globalErr = errors.Join(globalErr, errors.New("password minimum length should be positive"))
+	}
+
+	if c.LoginMaxFailures < 0 || c.LoginAddressMaxFailures < 0 {
+		globalErr = errors.Join(globalErr, errors.New("login maximum failures should not be negative"))
+	}
+
+	for name, limit := range map[string]struct {
+		rate  float64
+		burst int
+	}{
+		"read":  {c.RateLimitReadRate, c.RateLimitReadBurst},
+		"write": {c.RateLimitWriteRate, c.RateLimitWriteBurst},
+		"find":  {c.RateLimitFindRate, c.RateLimitFindBurst},
+	} {
+		if limit.rate < 0 {
+			globalErr = errors.Join(globalErr, fmt.Errorf("%s rate limit should not be negative", name))
+		} else if limit.rate > 0 && limit.burst < 1 {
+			globalErr = errors.Join(globalErr, fmt.Errorf("%s rate limit burst should be positive", name))
+		}
+	}
+
+	if len(c.OidcIssuer) != 0 {
+		if len(c.OidcClientId) == 0 || len(c.OidcRedirectUrl) == 0 {
+			globalErr = errors.Join(globalErr, errors.New("oidc expects client id and redirect url"))
+		}
+
+		// tokens are routed to their validation by issuer
+		if c.OidcIssuer == c.TokenIssuer {
+			globalErr = errors.Join(globalErr, errors.New("oidc issuer should differ from token issuer"))
+		}
+	}
+
+	for group, roles := range c.OidcGroupRoles {
+		for _, value := range roles {
+			if class, role, ok := oidc.SplitRole(value); !ok || (class != "graph" && class != "user") || !slices.Contains(OIDC_ROLES, role) {
+				globalErr = errors.Join(globalErr, fmt.Errorf("invalid role %s for group %s, expecting graph or user, then : and a role", value, group))
+			}
+		}
+	}
+
+	if c.TrashRetention < 0 {
+		globalErr = errors.Join(globalErr, errors.New("trash retention should not be negative"))
+	}
+
+	if c.MaxBodySize <= 0 {
+		globalErr = errors.Join(globalErr, errors.New("max body size should be positive"))
+	}
+
+	if c.PoolMaxConns < 0 || c.PoolMinConns < 0 {
+		globalErr = errors.Join(globalErr, errors.New("pool sizes should not be negative"))
+	} else if c.PoolMaxConns != 0 && c.PoolMinConns > c.PoolMaxConns {
+		globalErr = errors.Join(globalErr, errors.New("pool minimum size should not exceed maximum size"))
+	}
+
+	if _, err := c.ZapLevel(); err != nil {
+		globalErr = errors.Join(globalErr, err)
+	}
+
+	return globalErr
+}
+
+// UseTLS returns true if server should serve TLS
+func (c Configuration) UseTLS() bool {
+	return len(c.TLSCertFile) != 0 && len(c.TLSKeyFile) != 0
+}
+
+// SigningKeySecretBytes returns the decoded secret to encrypt signing keys
+func (c Configuration) SigningKeySecretBytes() ([]byte, error) {
+	secret, err := base64.StdEncoding.DecodeString(c.SigningKeySecret)
+	if err != nil || len(secret) != SIGNING_KEY_SECRET_SIZE {
+		return nil, fmt.Errorf("signing key secret should be %d bytes in base64", SIGNING_KEY_SECRET_SIZE)
+	}
+
+	return secret, nil
+}
+
+// ZapLevel returns the log level
+func (c Configuration) ZapLevel() (zapcore.Level, error) {
+	level, err := zapcore.ParseLevel(c.LogLevel)
+	if err != nil {
+		return level, fmt.Errorf("invalid log level %s", c.LogLevel)
+	}
+
+	return level, nil
+}
+
+// overrideString sets value if not empty
+func overrideString(destination *string, value string) {
+	if len(value) != 0 {
+		*destination = value
+	}
+}
+
+// overrideBool parses the variable, if set, as a boolean
+func overrideBool(destination *bool, variable string, getenv func(string) string) error {
+	value := getenv(variable)
+	if len(value) == 0 {
+		return nil
+	} else if parsed, err := strconv.ParseBool(value); err != nil {
+		return fmt.Errorf("invalid %s %s: %s", variable, value, err.Error())
+	} else {
+		*destination = parsed
+	}
+
+	return nil
+}
+
+// overrideDuration parses the variable, if set, as a golang duration
+func overrideDuration(destination *Duration, variable string, getenv func(string) string) error {
+	value := getenv(variable)
+	if len(value) == 0 {
+		return nil
+	} else if parsed, err := time.ParseDuration(value); err != nil {
+		return fmt.Errorf("invalid %s %s: %s", variable, value, err.Error())
+	} else {
+		*destination = Duration(parsed)
+	}
+
+	return nil
+}
+
+// overrideFloat parses the variable, if set, as a decimal number
+func overrideFloat(destination *float64, variable string, getenv func(string) string) error {
+	value := getenv(variable)
+	if len(value) == 0 {
+		return nil
+	} else if parsed, err := strconv.ParseFloat(value, 64); err != nil {
+		return fmt.Errorf("invalid %s %s: %s", variable, value, err.Error())
+	} else {
+		*destination = parsed
+	}
+
+	return nil
+}
+
+// overrideInt parses the variable, if set, as an integer
+func overrideInt[T int | int32 | int64](destination *T, variable string, getenv func(string) string) error {
+	value := getenv(variable)
+	if len(value) == 0 {
+		return nil
+	} else if parsed, err := strconv.ParseInt(value, 10, 64); err != nil {
+		return fmt.Errorf("invalid %s %s: %s", variable, value, err.Error())
+	} else if int64(T(parsed)) != parsed {
+		return fmt.Errorf("invalid %s %s: out of range", variable, value)
+	} else {
+		*destination = T(parsed)
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zefrenchwan/patterns.git/config"
+)
+
+// environment returns a getenv function for those values
+func environment(values map[string]string) func(string) string {
+	return func(name string) string {
+		return values[name]
+	}
+}
+
+func TestLoadFileThenEnvironment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "patterns.json")
+	content := `{"port": ":8080", "database_url": "postgres://file", "read_timeout": "5s", "pool_max_conns": 8, "log_level": "debug"}`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := config.Load(path, environment(map[string]string{
+		"PATTERNS_DB_URL":             "postgres://env",
+		"PATTERNS_WRITE_TIMEOUT":      "10s",
+		"PATTERNS_SIGNING_KEY_SECRET": "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=",
+	}))
+
+	if err != nil {
+		t.Fatal(err)
+	} else if result.Port != ":8080" || result.PoolMaxConns != 8 || result.LogLevel != "debug" {
+		t.Errorf("expecting file values, got %v", result)
+	} else if result.DatabaseUrl != "postgres://env" {
+		t.Errorf("environment should override file, got %s", result.DatabaseUrl)
+	} else if time.Duration(result.ReadTimeout) != 5*time.Second || time.Duration(result.WriteTimeout) != 10*time.Second {
+		t.Errorf("invalid timeouts: %v", result)
+	} else if time.Duration(result.IdleTimeout) != time.Duration(config.DefaultConfiguration().IdleTimeout) {
+		t.Error("missing values should be defaults")
+	} else if result.UseTLS() {
+		t.Error("no certificate means no TLS")
+	} else if secret, err := result.SigningKeySecretBytes(); err != nil || len(secret) != config.SIGNING_KEY_SECRET_SIZE {
+		t.Errorf("invalid signing key secret: %v", err)
+	}
+}
+
+func TestLoadInvalidValues(t *testing.T) {
+	valid := map[string]string{
+		"PATTERNS_PORT":               ":8080",
+		"PATTERNS_DB_URL":             "postgres://env",
+		"PATTERNS_SIGNING_KEY_SECRET": "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=",
+	}
+	if _, err := config.Load("", environment(valid)); err != nil {
+		t.Errorf("expecting valid configuration, got %s", err.Error())
+	}
+
+	for variable, value := range map[string]string{
+		"PATTERNS_PORT":                 "8080",
+		"PATTERNS_READ_TIMEOUT":         "soon",
+		"PATTERNS_MAX_BODY_SIZE":        "-1",
+		"PATTERNS_TLS_CERT":             "cert.pem",
+		"PATTERNS_DB_MAX_CONNS":         "99999999999",
+		"PATTERNS_LOG_LEVEL":            "verbose",
+		"PATTERNS_SIGNING_ALGORITHM":    "HS256",
+		"PATTERNS_SIGNING_KEY_SECRET":   "c2hvcnQ=",
+		"PATTERNS_PASSWORD_MIN_LENGTH":  "0",
+		"PATTERNS_LOGIN_MAX_FAILURES":   "-1",
+		"PATTERNS_RATE_LIMIT_FIND_RATE": "fast",
+		"PATTERNS_RATE_LIMIT_READ_RATE": "-1",
+		"PATTERNS_OIDC_ISSUER":          "https://issuer",
+		"PATTERNS_OIDC_AUTO_PROVISION":  "sometimes",
+		"PATTERNS_OIDC_GROUP_ROLES":     `{"admins": ["graph:owner"]}`,
+	} {
+		values := map[string]string{variable: value}
+		for name, current := range valid {
+			if _, found := values[name]; !found {
+				values[name] = current
+			}
+		}
+
+		if _, err := config.Load("", environment(values)); err == nil {
+			t.Errorf("expecting error for %s=%s", variable, value)
+		}
+	}
+}
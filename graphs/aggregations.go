@@ -0,0 +1,164 @@
+package graphs
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/zefrenchwan/patterns.git/nodes"
+)
+
+// BucketUnit defines the size of time buckets for aggregations
+type BucketUnit string
+
+const (
+	// BUCKET_HOUR splits time per hour
+	BUCKET_HOUR BucketUnit = "hour"
+	// BUCKET_DAY splits time per day
+	BUCKET_DAY BucketUnit = "day"
+	// BUCKET_WEEK splits time per week (seven days)
+	BUCKET_WEEK BucketUnit = "week"
+	// BUCKET_MONTH splits time per month
+	BUCKET_MONTH BucketUnit = "month"
+	// BUCKET_YEAR splits time per year
+	BUCKET_YEAR BucketUnit = "year"
+	// MAX_BUCKETS is the max number of buckets for an aggregation, to avoid huge computations
+	MAX_BUCKETS = 10000
+)
+
+// TimeBucket contains aggregated values for elements during [Start, End[
+type TimeBucket struct {
+	// Start of the bucket, included
+	Start time.Time
+	// End of the bucket, excluded
+	End time.Time
+	// Count is the number of elements active at least once during the bucket
+	Count int
+	// ValuesCount is the number of numeric values found for the aggregated attribute
+	ValuesCount int
+	// Min of numeric values, meaningless if ValuesCount is 0
+	Min float64
+	// Max of numeric values, meaningless if ValuesCount is 0
+	Max float64
+	// Sum of numeric values
+	Sum float64
+	// Average of numeric values, meaningless if ValuesCount is 0
+	Average float64
+}
+
+// ParseBucketUnit returns the bucket unit matching value, or an error for an unknown value
+func ParseBucketUnit(value string) (BucketUnit, error) {
+	switch unit := BucketUnit(value); unit {
+	case BUCKET_HOUR, BUCKET_DAY, BUCKET_WEEK, BUCKET_MONTH, BUCKET_YEAR:
+		return unit, nil
+	default:
+		return unit, fmt.Errorf("unknown bucket unit %s", value)
+	}
+}
+
+// nextBucketStart returns the start of the bucket after the one starting at moment
+func nextBucketStart(moment time.Time, unit BucketUnit) time.Time {
+	switch unit {
+	case BUCKET_HOUR:
+		return moment.Add(time.Hour)
+	case BUCKET_DAY:
+		return moment.AddDate(0, 0, 1)
+	case BUCKET_WEEK:
+		return moment.AddDate(0, 0, 7)
+	case BUCKET_MONTH:
+		return moment.AddDate(0, 1, 0)
+	default:
+		return moment.AddDate(1, 0, 0)
+	}
+}
+
+// AggregateActivity splits [start, end[ into buckets and counts, per bucket, elements active during it.
+// If trait is not empty, only elements with that trait are counted.
+// If attribute is not empty, numeric values of that attribute (for entities) are aggregated too.
+// Non numeric values are ignored.
+// Last bucket may be smaller than the others, because it ends at end.
+func (g *Graph) AggregateActivity(trait string, start, end time.Time, unit BucketUnit, attribute string) ([]TimeBucket, error) {
+	if g == nil {
+		return nil, errors.New("nil graph")
+	} else if !start.Before(end) {
+		return nil, errors.New("start should be before end")
+	} else if _, err := ParseBucketUnit(string(unit)); err != nil {
+		return nil, err
+	}
+
+	// build buckets first, to fail fast when there are too many of them
+	var result []TimeBucket
+	for current := start; current.Before(end); current = nextBucketStart(current, unit) {
+		if len(result) >= MAX_BUCKETS {
+			return nil, fmt.Errorf("too many buckets, max is %d", MAX_BUCKETS)
+		}
+
+		bucketEnd := nextBucketStart(current, unit)
+		if bucketEnd.After(end) {
+			bucketEnd = end
+		}
+
+		result = append(result, TimeBucket{Start: current, End: bucketEnd})
+	}
+
+	// then, pick matching elements once
+	matchingIds := g.graphIndexes().traits[trait]
+
+	for index := range result {
+		bucket := &result[index]
+		interval, errInterval := nodes.NewFiniteTimeInterval(bucket.Start, bucket.End, true, false)
+		if errInterval != nil {
+			return nil, errInterval
+		}
+
+		bucketPeriod := nodes.NewPeriod(interval)
+		for _, node := range g.nodesForIds(g.activeIdsDuring(bucketPeriod)) {
+			element := node.Value
+			if element == nil {
+				continue
+			} else if len(trait) != 0 && !matchingIds[element.Id()] {
+				continue
+			}
+
+			bucket.Count++
+
+			if len(attribute) == 0 {
+				continue
+			} else if instance, ok := element.(nodes.FormalInstance); !ok {
+				continue
+			} else if values, err := instance.PeriodValuesForAttribute(attribute); err != nil {
+				return nil, err
+			} else {
+				for value, period := range values {
+					period.Intersection(bucketPeriod)
+					if period.IsEmptyPeriod() {
+						continue
+					} else if numericValue, errParse := strconv.ParseFloat(value, 64); errParse == nil {
+						bucket.addValue(numericValue)
+					}
+				}
+			}
+		}
+
+		if bucket.ValuesCount != 0 {
+			bucket.Average = bucket.Sum / float64(bucket.ValuesCount)
+		}
+	}
+
+	return result, nil
+}
+
+// addValue includes value in the min, max and sum of the bucket
+func (b *TimeBucket) addValue(value float64) {
+	if b.ValuesCount == 0 || value < b.Min {
+		b.Min = value
+	}
+
+	if b.ValuesCount == 0 || value > b.Max {
+		b.Max = value
+	}
+
+	b.Sum += value
+	b.ValuesCount++
+}
@@ -0,0 +1,78 @@
+package graphs
+
+import (
+	"errors"
+	"maps"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/zefrenchwan/patterns.git/nodes"
+)
+
+// Fork returns an independent copy of the graph with a new id, and, for each copied element, the id of its copy.
+// Elements owned by the graph are copied with new ids, and copies have their source as equivalence parent.
+// If flatten is set, elements of imported graphs are copied too.
+// Otherwise, imported elements remain in the fork as they were.
+// Relations of the fork link the copies instead of copied elements
+func (g *Graph) Fork(newId string, flatten bool) (Graph, map[string]string, error) {
+	if g == nil {
+		return NewEmptyGraph(), nil, errors.New("nil graph")
+	} else if len(newId) == 0 || newId == g.Id {
+		return NewEmptyGraph(), nil, errors.New("fork should have a new id")
+	}
+
+	result := NewGraphWithId(newId, g.Name, g.Description)
+
+	for key, values := range g.Metadata {
+		result.Metadata[key] = slices.Clone(values)
+	}
+
+	// sorted to get the same result no matter map order
+	ids := slices.Sorted(maps.Keys(g.values))
+	copies := make(map[string]string)
+	for _, id := range ids {
+		if flatten || g.values[id].SourceGraph == g.Id {
+			copies[id] = uuid.NewString()
+		}
+	}
+
+	for _, id := range ids {
+		node := g.values[id]
+		copyId, copied := copies[id]
+		if !copied {
+			result.SetElement(node.Value, node.SourceGraph, node.Editable, node.EquivalenceParent, node.EquivalenceParentGraph)
+			continue
+		}
+
+		element, errCopy := nodes.CopyElementWithId(node.Value, copyId)
+		if errCopy != nil {
+			return result, nil, errCopy
+		} else if relation, ok := element.(nodes.FormalRelation); ok {
+			if err := remapOperands(relation, copies); err != nil {
+				return result, nil, err
+			}
+		}
+
+		result.SetElement(element, newId, true, id, node.SourceGraph)
+	}
+
+	return result, copies, nil
+}
+
+// remapOperands replaces operands of relation with their copies, if any
+func remapOperands(relation nodes.FormalRelation, copies map[string]string) error {
+	for role, links := range relation.PeriodValuesPerRole() {
+		for operand, period := range links {
+			copyId, found := copies[operand]
+			if !found {
+				continue
+			} else if err := relation.RemovePeriodValueForRole(role, operand, period); err != nil {
+				return err
+			} else if err := relation.AddPeriodValueForRole(role, copyId, period); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
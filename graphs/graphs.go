@@ -40,6 +40,8 @@ type Graph struct {
 	values map[string]Node
 	// dirtyNodes contain the nodes that were changed since load (to ease graph updates)
 	dirtyNodes []string
+	// indexes contain lookups by trait, attribute value and operand, built when needed
+	indexes *graphIndexes
 }
 
 // NewEmptyGraph returns a new empty graph
@@ -48,6 +50,7 @@ func NewEmptyGraph() Graph {
 		Metadata:   make(map[string][]string),
 		values:     make(map[string]Node),
 		dirtyNodes: nil,
+		indexes:    newGraphIndexes(),
 	}
 }
 
@@ -85,6 +88,7 @@ func (g *Graph) MarkExistingElementAsDirty(currentElement nodes.Element) error {
 
 	currentNode.Value = currentElement
 	g.values[elementId] = currentNode
+	g.graphIndexes().index(currentElement)
 
 	return nil
 }
@@ -125,13 +129,32 @@ func (g *Graph) AddToFormalInstance(
 		return errors.New("periods and values for attribute do not match")
 	}
 
+	// group periods per value to merge them at once
+	var orderedValues []string
+	periodsPerValue := make(map[string][]nodes.Period)
 	for index := 0; index < size; index++ {
-		entity.AddValue(attributeName, attributeValues[index], attributePeriods[index])
+		value := attributeValues[index]
+		if _, found := periodsPerValue[value]; !found {
+			orderedValues = append(orderedValues, value)
+		}
+
+		periodsPerValue[value] = append(periodsPerValue[value], attributePeriods[index])
+	}
+
+	for _, value := range orderedValues {
+		if err := entity.AddValue(attributeName, value, nodes.NewPeriodUnion(periodsPerValue[value]...)); err != nil {
+			return err
+		}
 	}
 
 	node.Value = entity
 	g.values[elementId] = node
 
+	indexes := g.graphIndexes()
+	indexes.addTraits(elementId, entity.Traits())
+	indexes.setActivity(elementId, entity.ActivePeriod())
+	indexes.indexAttribute(elementId, entity, attributeName)
+
 	return nil
 }
 
@@ -171,6 +194,11 @@ func (g *Graph) AddToFormalRelation(
 	} else {
 		node.Value = relation
 		g.values[elementId] = node
+
+		indexes := g.graphIndexes()
+		indexes.addTraits(elementId, relation.Traits())
+		indexes.setActivity(elementId, relation.ActivePeriod())
+		indexes.addOperand(elementId, roleName, roleValue)
 		return nil
 	}
 }
@@ -194,20 +222,22 @@ func (g *Graph) SetElement(currentElement nodes.Element, sourceGraph string, edi
 		Value:                  currentElement,
 		Editable:               editable,
 	}
+
+	g.graphIndexes().index(currentElement)
 }
 
-// Nodes returns all the nodes in the graph
+// Nodes returns all the nodes in the graph, sorted by id
 func (g *Graph) Nodes() []Node {
 	if g == nil {
 		return nil
 	}
 
-	var result []Node
-	for _, node := range g.values {
-		result = append(result, node)
+	ids := make(map[string]bool, len(g.values))
+	for id := range g.values {
+		ids[id] = true
 	}
 
-	return result
+	return g.nodesForIds(ids)
 }
 
 // DirtyNodes returns the nodes to save, either an empty slice, or the values
@@ -0,0 +1,75 @@
+package graphs
+
+import (
+	"cmp"
+	"slices"
+)
+
+// ImportedGraph is a graph imported by another one, directly (depth 1) or transitively
+type ImportedGraph struct {
+	// Id of the imported graph
+	Id string
+	// Depth is the minimal number of imports to reach the graph
+	Depth int
+}
+
+// ImportsDAG contains the imports between graphs
+type ImportsDAG struct {
+	// imports are, per graph, its direct imports
+	imports map[string][]string
+}
+
+// NewImportsDAG returns an empty imports graph
+func NewImportsDAG() ImportsDAG {
+	return ImportsDAG{imports: make(map[string][]string)}
+}
+
+// AddImport adds imported to the direct imports of graph
+func (d *ImportsDAG) AddImport(graph, imported string) {
+	if d.imports == nil {
+		d.imports = make(map[string][]string)
+	}
+
+	if !slices.Contains(d.imports[graph], imported) {
+		d.imports[graph] = append(d.imports[graph], imported)
+		slices.Sort(d.imports[graph])
+	}
+}
+
+// DirectImports returns the sorted direct imports of a graph
+func (d ImportsDAG) DirectImports(graph string) []string {
+	return slices.Clone(d.imports[graph])
+}
+
+// TransitiveImports returns all the graphs a graph imports, sorted by depth and then id.
+// Graph itself is not included, even if imports loop
+func (d ImportsDAG) TransitiveImports(graph string) []ImportedGraph {
+	depths := map[string]int{graph: 0}
+	current := []string{graph}
+	for depth := 1; len(current) != 0; depth++ {
+		var next []string
+		for _, id := range current {
+			for _, imported := range d.imports[id] {
+				if _, found := depths[imported]; !found {
+					depths[imported] = depth
+					next = append(next, imported)
+				}
+			}
+		}
+
+		current = next
+	}
+
+	result := make([]ImportedGraph, 0, len(depths)-1)
+	for id, depth := range depths {
+		if id != graph {
+			result = append(result, ImportedGraph{Id: id, Depth: depth})
+		}
+	}
+
+	slices.SortFunc(result, func(a, b ImportedGraph) int {
+		return cmp.Or(cmp.Compare(a.Depth, b.Depth), cmp.Compare(a.Id, b.Id))
+	})
+
+	return result
+}
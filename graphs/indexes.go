@@ -0,0 +1,459 @@
+package graphs
+
+import (
+	"slices"
+	"time"
+
+	"github.com/zefrenchwan/patterns.git/nodes"
+)
+
+// indexedValue is a couple of a key (attribute or role) and a value (attribute value or operand)
+type indexedValue struct {
+	// key is an attribute name or a role
+	key string
+	// value is an attribute value or an operand id
+	value string
+}
+
+// periodValue links an interval to an attribute value
+type periodValue struct {
+	// interval is one of the intervals of the period for that value
+	interval nodes.Interval[time.Time]
+	// value of the attribute
+	value string
+}
+
+// periodOwner is the value stored in attribute interval trees
+type periodOwner struct {
+	// id of the element
+	id string
+	// value of the attribute during the interval
+	value string
+}
+
+// indexedElement keeps what was indexed for an element, to unindex it when it changes
+type indexedElement struct {
+	// traits of the element when indexed
+	traits []string
+	// attributes contains attributes and values for an instance
+	attributes []indexedValue
+	// operands contains roles and operands for a relation
+	operands []indexedValue
+	// activity contains the intervals of activity of the element
+	activity []nodes.Interval[time.Time]
+	// attributePeriods links an attribute to its values and intervals
+	attributePeriods map[string][]periodValue
+}
+
+// graphIndexes contains the indexes of a graph, maintained when nodes change.
+// Attribute values and operands are indexed no matter their period
+type graphIndexes struct {
+	// elements links an element id to its indexed values
+	elements map[string]indexedElement
+	// traits links a trait to the ids of the elements with that trait
+	traits map[string]map[string]bool
+	// attributes links an attribute, then a value, to the ids of the instances
+	attributes map[string]map[string]map[string]bool
+	// operands links an operand id, then a role, to the ids of the relations
+	operands map[string]map[string]map[string]bool
+	// activity links activity intervals to element ids
+	activity *nodes.IntervalTree[time.Time, string]
+	// attributePeriods links an attribute to intervals of its values
+	attributePeriods map[string]*nodes.IntervalTree[time.Time, periodOwner]
+}
+
+// newGraphIndexes returns empty indexes
+func newGraphIndexes() *graphIndexes {
+	return &graphIndexes{
+		elements:   make(map[string]indexedElement),
+		traits:     make(map[string]map[string]bool),
+		attributes: make(map[string]map[string]map[string]bool),
+		operands:   make(map[string]map[string]map[string]bool),
+		activity:   nodes.NewIntervalTree[time.Time, string](nodes.NewTimeComparator()),
+
+		attributePeriods: make(map[string]*nodes.IntervalTree[time.Time, periodOwner]),
+	}
+}
+
+// addToNestedIndex adds id in index[first][second]
+func addToNestedIndex(index map[string]map[string]map[string]bool, first, second, id string) {
+	if index[first] == nil {
+		index[first] = make(map[string]map[string]bool)
+	}
+
+	if index[first][second] == nil {
+		index[first][second] = make(map[string]bool)
+	}
+
+	index[first][second][id] = true
+}
+
+// removeFromNestedIndex removes id from index[first][second], and cleans empty maps
+func removeFromNestedIndex(index map[string]map[string]map[string]bool, first, second, id string) {
+	if index[first] == nil || index[first][second] == nil {
+		return
+	}
+
+	delete(index[first][second], id)
+	if len(index[first][second]) == 0 {
+		delete(index[first], second)
+	}
+
+	if len(index[first]) == 0 {
+		delete(index, first)
+	}
+}
+
+// addTraits indexes traits for element id
+func (i *graphIndexes) addTraits(id string, traits []string) {
+	entry := i.elements[id]
+	for _, trait := range traits {
+		if slices.Contains(entry.traits, trait) {
+			continue
+		}
+
+		entry.traits = append(entry.traits, trait)
+		if i.traits[trait] == nil {
+			i.traits[trait] = make(map[string]bool)
+		}
+
+		i.traits[trait][id] = true
+	}
+
+	i.elements[id] = entry
+}
+
+// addAttributeValue indexes value for attribute of instance id
+func (i *graphIndexes) addAttributeValue(id, attribute, value string) {
+	entry := i.elements[id]
+	key := indexedValue{key: attribute, value: value}
+	if slices.Contains(entry.attributes, key) {
+		return
+	}
+
+	entry.attributes = append(entry.attributes, key)
+	i.elements[id] = entry
+	addToNestedIndex(i.attributes, attribute, value, id)
+}
+
+// addOperand indexes operand in role for relation id
+func (i *graphIndexes) addOperand(id, role, operand string) {
+	entry := i.elements[id]
+	key := indexedValue{key: role, value: operand}
+	if slices.Contains(entry.operands, key) {
+		return
+	}
+
+	entry.operands = append(entry.operands, key)
+	i.elements[id] = entry
+	addToNestedIndex(i.operands, operand, role, id)
+}
+
+// setActivity replaces the indexed activity of element id
+func (i *graphIndexes) setActivity(id string, activity nodes.Period) {
+	entry := i.elements[id]
+	for _, interval := range entry.activity {
+		i.activity.Remove(interval, id)
+	}
+
+	entry.activity = activity.AsIntervals()
+	for _, interval := range entry.activity {
+		i.activity.Insert(interval, id)
+	}
+
+	i.elements[id] = entry
+}
+
+// setAttributePeriods replaces the indexed periods of the values of an attribute for element id
+func (i *graphIndexes) setAttributePeriods(id, attribute string, values map[string]nodes.Period) {
+	entry := i.elements[id]
+	tree := i.attributePeriods[attribute]
+	for _, previous := range entry.attributePeriods[attribute] {
+		tree.Remove(previous.interval, periodOwner{id: id, value: previous.value})
+	}
+
+	if entry.attributePeriods == nil {
+		entry.attributePeriods = make(map[string][]periodValue)
+	}
+
+	var periods []periodValue
+	for value, period := range values {
+		for _, interval := range period.AsIntervals() {
+			periods = append(periods, periodValue{interval: interval, value: value})
+		}
+	}
+
+	if len(periods) != 0 && tree == nil {
+		tree = nodes.NewIntervalTree[time.Time, periodOwner](nodes.NewTimeComparator())
+		i.attributePeriods[attribute] = tree
+	}
+
+	for _, current := range periods {
+		tree.Insert(current.interval, periodOwner{id: id, value: current.value})
+	}
+
+	if len(periods) == 0 {
+		delete(entry.attributePeriods, attribute)
+	} else {
+		entry.attributePeriods[attribute] = periods
+	}
+
+	if tree != nil && tree.Len() == 0 {
+		delete(i.attributePeriods, attribute)
+	}
+
+	i.elements[id] = entry
+}
+
+// remove unindexes element id
+func (i *graphIndexes) remove(id string) {
+	entry, found := i.elements[id]
+	if !found {
+		return
+	}
+
+	for _, trait := range entry.traits {
+		delete(i.traits[trait], id)
+		if len(i.traits[trait]) == 0 {
+			delete(i.traits, trait)
+		}
+	}
+
+	for _, attribute := range entry.attributes {
+		removeFromNestedIndex(i.attributes, attribute.key, attribute.value, id)
+	}
+
+	for _, operand := range entry.operands {
+		removeFromNestedIndex(i.operands, operand.value, operand.key, id)
+	}
+
+	for _, interval := range entry.activity {
+		i.activity.Remove(interval, id)
+	}
+
+	for attribute := range entry.attributePeriods {
+		i.setAttributePeriods(id, attribute, nil)
+	}
+
+	delete(i.elements, id)
+}
+
+// index (re)indexes the full content of an element
+func (i *graphIndexes) index(element nodes.Element) {
+	if element == nil {
+		return
+	}
+
+	id := element.Id()
+	i.remove(id)
+	i.addTraits(id, element.Traits())
+	i.setActivity(id, element.ActivePeriod())
+
+	switch value := element.(type) {
+	case nodes.FormalInstance:
+		for _, attribute := range value.Attributes() {
+			i.indexAttribute(id, value, attribute)
+		}
+	case nodes.FormalRelation:
+		for role, operands := range value.ValuesPerRole() {
+			for _, operand := range operands {
+				i.addOperand(id, role, operand)
+			}
+		}
+	}
+}
+
+// indexAttribute (re)indexes values and periods of attribute for instance.
+// Values no longer active for attribute (removed, or replaced by another value) are unindexed
+func (i *graphIndexes) indexAttribute(id string, instance nodes.FormalInstance, attribute string) {
+	values, err := instance.PeriodValuesForAttribute(attribute)
+	if err != nil {
+		return
+	}
+
+	for attributeValue, period := range values {
+		if period.IsEmptyPeriod() {
+			delete(values, attributeValue)
+		}
+	}
+
+	i.removeAttributeValues(id, attribute, values)
+	for attributeValue := range values {
+		i.addAttributeValue(id, attribute, attributeValue)
+	}
+
+	i.setAttributePeriods(id, attribute, values)
+}
+
+// removeAttributeValues unindexes the values of attribute for instance id that are not in kept
+func (i *graphIndexes) removeAttributeValues(id, attribute string, kept map[string]nodes.Period) {
+	entry, found := i.elements[id]
+	if !found {
+		return
+	}
+
+	remaining := make([]indexedValue, 0, len(entry.attributes))
+	for _, indexed := range entry.attributes {
+		if _, found := kept[indexed.value]; indexed.key == attribute && !found {
+			removeFromNestedIndex(i.attributes, attribute, indexed.value, id)
+		} else {
+			remaining = append(remaining, indexed)
+		}
+	}
+
+	entry.attributes = remaining
+	i.elements[id] = entry
+}
+
+// graphIndexes returns the indexes of the graph, building them if needed
+func (g *Graph) graphIndexes() *graphIndexes {
+	if g.indexes == nil {
+		g.indexes = newGraphIndexes()
+		for _, node := range g.values {
+			g.indexes.index(node.Value)
+		}
+	}
+
+	return g.indexes
+}
+
+// nodesForIds returns the nodes for those ids, sorted by id
+func (g *Graph) nodesForIds(ids map[string]bool) []Node {
+	keys := make([]string, 0, len(ids))
+	for id := range ids {
+		keys = append(keys, id)
+	}
+
+	slices.Sort(keys)
+	result := make([]Node, 0, len(keys))
+	for _, id := range keys {
+		if node, found := g.values[id]; found {
+			result = append(result, node)
+		}
+	}
+
+	return result
+}
+
+// NodesWithTrait returns the nodes implementing trait, sorted by id
+func (g *Graph) NodesWithTrait(trait string) []Node {
+	if g == nil {
+		return nil
+	}
+
+	return g.nodesForIds(g.graphIndexes().traits[trait])
+}
+
+// EntitiesWithAttributeValue returns the nodes having that value for that attribute at least once, sorted by id
+func (g *Graph) EntitiesWithAttributeValue(attribute, value string) []Node {
+	if g == nil {
+		return nil
+	}
+
+	return g.nodesForIds(g.graphIndexes().attributes[attribute][value])
+}
+
+// RelationsWithOperandInRole returns the relations linking operand in that role at least once, sorted by id
+func (g *Graph) RelationsWithOperandInRole(operand, role string) []Node {
+	if g == nil {
+		return nil
+	}
+
+	return g.nodesForIds(g.graphIndexes().operands[operand][role])
+}
+
+// IncomingRelations returns the relations linking operand in any role, sorted by id
+func (g *Graph) IncomingRelations(operand string) []Node {
+	if g == nil {
+		return nil
+	}
+
+	ids := make(map[string]bool)
+	for _, relations := range g.graphIndexes().operands[operand] {
+		for id := range relations {
+			ids[id] = true
+		}
+	}
+
+	return g.nodesForIds(ids)
+}
+
+// idsOf returns the distinct ids of values
+func idsOf[V any](values []V, idFn func(V) string) map[string]bool {
+	result := make(map[string]bool)
+	for _, value := range values {
+		result[idFn(value)] = true
+	}
+
+	return result
+}
+
+// NodesActiveAt returns the nodes active at moment, sorted by id
+func (g *Graph) NodesActiveAt(moment time.Time) []Node {
+	if g == nil {
+		return nil
+	}
+
+	ids := g.graphIndexes().activity.Stabbing(moment)
+	return g.nodesForIds(idsOf(ids, func(id string) string { return id }))
+}
+
+// NodesActiveDuring returns the nodes active at least once during period, sorted by id
+func (g *Graph) NodesActiveDuring(period nodes.Period) []Node {
+	if g == nil {
+		return nil
+	}
+
+	return g.nodesForIds(g.activeIdsDuring(period))
+}
+
+// activeIdsDuring returns the ids of the elements active at least once during period
+func (g *Graph) activeIdsDuring(period nodes.Period) map[string]bool {
+	tree := g.graphIndexes().activity
+	var ids []string
+	for _, interval := range period.AsIntervals() {
+		ids = append(ids, tree.Overlapping(interval)...)
+	}
+
+	return idsOf(ids, func(id string) string { return id })
+}
+
+// AttributeValuesAt returns, per element id, the values of attribute at moment
+func (g *Graph) AttributeValuesAt(attribute string, moment time.Time) map[string][]string {
+	if g == nil {
+		return nil
+	}
+
+	return groupPeriodOwners(g.graphIndexes().attributePeriods[attribute].Stabbing(moment))
+}
+
+// AttributeValuesDuring returns, per element id, the values of attribute at least once during period
+func (g *Graph) AttributeValuesDuring(attribute string, period nodes.Period) map[string][]string {
+	if g == nil {
+		return nil
+	}
+
+	tree := g.graphIndexes().attributePeriods[attribute]
+	var owners []periodOwner
+	for _, interval := range period.AsIntervals() {
+		owners = append(owners, tree.Overlapping(interval)...)
+	}
+
+	return groupPeriodOwners(owners)
+}
+
+// groupPeriodOwners groups values per id, with distinct sorted values
+func groupPeriodOwners(owners []periodOwner) map[string][]string {
+	result := make(map[string][]string)
+	for _, owner := range owners {
+		if !slices.Contains(result[owner.id], owner.value) {
+			result[owner.id] = append(result[owner.id], owner.value)
+		}
+	}
+
+	for _, values := range result {
+		slices.Sort(values)
+	}
+
+	return result
+}
@@ -0,0 +1,210 @@
+package graphs
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+
+	"github.com/zefrenchwan/patterns.git/nodes"
+)
+
+// LineageNode is an element in the equivalence lineage of another element
+type LineageNode struct {
+	// ElementId is the id of the element
+	ElementId string
+	// GraphId is the graph of the element
+	GraphId string
+	// ParentId is the equivalence parent of the element, empty if none or not visible
+	ParentId string
+	// Depth is the distance to the element: negative for ancestors, positive for descendants
+	Depth int
+}
+
+// Lineage is the equivalence lineage of an element: its ancestry chain and its descendants
+type Lineage struct {
+	// Element is the element the lineage is about
+	Element LineageNode
+	// Ancestors are the ancestors of the element, from its parent to the original
+	Ancestors []LineageNode
+	// children are, per element id, its copies sorted by id
+	children map[string][]LineageNode
+}
+
+// NewLineage builds the lineage of elementId given all the nodes of its lineage.
+// Descendants whose parent is not in values are attached to the element
+func NewLineage(elementId string, values []LineageNode) (Lineage, error) {
+	result := Lineage{children: make(map[string][]LineageNode)}
+	descendants := make(map[string]bool)
+	found := false
+	for _, value := range values {
+		switch {
+		case value.ElementId == elementId:
+			result.Element = value
+			found = true
+		case value.Depth < 0:
+			result.Ancestors = append(result.Ancestors, value)
+		case value.Depth > 0:
+			descendants[value.ElementId] = true
+		}
+	}
+
+	if !found {
+		return result, errors.New("element is not in its lineage")
+	}
+
+	slices.SortFunc(result.Ancestors, func(a, b LineageNode) int {
+		return cmp.Compare(b.Depth, a.Depth)
+	})
+
+	for _, value := range values {
+		if value.Depth <= 0 || value.ElementId == elementId {
+			continue
+		}
+
+		parent := value.ParentId
+		if parent != elementId && !descendants[parent] {
+			parent = elementId
+		}
+
+		result.children[parent] = append(result.children[parent], value)
+	}
+
+	for _, children := range result.children {
+		slices.SortFunc(children, func(a, b LineageNode) int {
+			return cmp.Compare(a.ElementId, b.ElementId)
+		})
+	}
+
+	return result, nil
+}
+
+// Children returns the visible copies of an element of the lineage, sorted by id
+func (l Lineage) Children(elementId string) []LineageNode {
+	return slices.Clone(l.children[elementId])
+}
+
+// Descendants returns all the visible copies, transitively, sorted by depth and then id
+func (l Lineage) Descendants() []LineageNode {
+	var result []LineageNode
+	for _, children := range l.children {
+		result = append(result, children...)
+	}
+
+	slices.SortFunc(result, func(a, b LineageNode) int {
+		return cmp.Or(cmp.Compare(a.Depth, b.Depth), cmp.Compare(a.ElementId, b.ElementId))
+	})
+
+	return result
+}
+
+// CollapseEquivalences replaces, in the graph, copies by their oldest equivalent element in the graph.
+// Entities are merged with nodes.MergeEntities (the oldest one wins), relations get all the operands of their copies.
+// Relations then link the remaining elements instead of the collapsed copies.
+// Nodes with collapsed copies are no longer editable, because they are views.
+// It returns, for each collapsed copy, the id of the element replacing it
+func (g *Graph) CollapseEquivalences() (map[string]string, error) {
+	if g == nil {
+		return nil, errors.New("nil graph")
+	}
+
+	replacements := make(map[string]string)
+	for id := range g.values {
+		if root := g.equivalenceRoot(id); root != id {
+			replacements[id] = root
+		}
+	}
+
+	if len(replacements) == 0 {
+		return replacements, nil
+	}
+
+	// merge copies into roots, sorted to get the same result no matter map order
+	copies := make([]string, 0, len(replacements))
+	for id := range replacements {
+		copies = append(copies, id)
+	}
+
+	slices.Sort(copies)
+	for _, id := range copies {
+		rootNode := g.values[replacements[id]]
+		if err := mergeEquivalentElements(rootNode.Value, g.values[id].Value); err != nil {
+			return nil, err
+		}
+
+		rootNode.Editable = false
+		g.values[replacements[id]] = rootNode
+		delete(g.values, id)
+	}
+
+	// redirect operands of remaining relations
+	for id, node := range g.values {
+		if relation, ok := node.Value.(nodes.FormalRelation); !ok {
+			continue
+		} else if err := remapOperands(relation, replacements); err != nil {
+			return nil, err
+		}
+
+		g.values[id] = node
+	}
+
+	g.dirtyNodes = slices.DeleteFunc(g.dirtyNodes, func(id string) bool {
+		_, found := replacements[id]
+		return found
+	})
+
+	// indexes are rebuilt when needed
+	g.indexes = nil
+	return replacements, nil
+}
+
+// equivalenceRoot returns the oldest equivalent element of id within the graph
+func (g *Graph) equivalenceRoot(id string) string {
+	visited := map[string]bool{id: true}
+	current := id
+	for {
+		parent := g.values[current].EquivalenceParent
+		if len(parent) == 0 || visited[parent] {
+			return current
+		} else if _, found := g.values[parent]; !found {
+			return current
+		}
+
+		visited[parent] = true
+		current = parent
+	}
+}
+
+// mergeEquivalentElements merges content of copy into root
+func mergeEquivalentElements(root, copy nodes.Element) error {
+	if rootInstance, ok := root.(nodes.FormalInstance); ok {
+		if copyInstance, ok := copy.(nodes.FormalInstance); !ok {
+			return errors.New("equivalent elements should have the same type")
+		} else {
+			return nodes.MergeEntities(rootInstance, copyInstance)
+		}
+	}
+
+	rootRelation, rootOk := root.(nodes.FormalRelation)
+	copyRelation, copyOk := copy.(nodes.FormalRelation)
+	if !rootOk || !copyOk {
+		return errors.New("equivalent elements should have the same type")
+	} else if err := rootRelation.AddActivePeriod(copyRelation.ActivePeriod()); err != nil {
+		return err
+	}
+
+	for _, trait := range copyRelation.Traits() {
+		if err := rootRelation.AddTrait(trait); err != nil {
+			return err
+		}
+	}
+
+	for role, links := range copyRelation.PeriodValuesPerRole() {
+		for operand, period := range links {
+			if err := rootRelation.AddPeriodValueForRole(role, operand, period); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
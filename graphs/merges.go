@@ -0,0 +1,102 @@
+package graphs
+
+import (
+	"errors"
+	"maps"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/zefrenchwan/patterns.git/nodes"
+)
+
+// ForkMerge is the result of the three-way merge of a fork into the graph it was forked from
+type ForkMerge struct {
+	// Changed are the elements of the graph changed by the merge, per id
+	Changed map[string]nodes.Element
+	// Added are copies of the elements created in the fork, per fork element id
+	Added map[string]nodes.Element
+	// Bases are the new bases of fork elements, per fork element id.
+	// Elements with conflicts keep their previous base
+	Bases map[string]nodes.Element
+	// Conflicts are the conflicting values per element id of the graph
+	Conflicts map[string][]nodes.MergeConflict
+}
+
+// MergeFork computes the three-way merge of fork into the graph, graph is not changed.
+// For each element of the fork copied from an element of the graph,
+// base is the parent at last synchronisation (from bases, per fork element id), ours is the parent and theirs is the fork element.
+// Elements created in the fork are copied with new ids.
+// Relations of the fork link its elements, so operands are replaced by matching elements of the graph before merge
+func (g *Graph) MergeFork(fork Graph, bases map[string]nodes.Element) (ForkMerge, error) {
+	result := ForkMerge{
+		Changed:   make(map[string]nodes.Element),
+		Added:     make(map[string]nodes.Element),
+		Bases:     make(map[string]nodes.Element),
+		Conflicts: make(map[string][]nodes.MergeConflict),
+	}
+
+	if g == nil {
+		return result, errors.New("nil graph")
+	} else if fork.Id == g.Id {
+		return result, errors.New("graph cannot merge itself")
+	}
+
+	// sorted to get the same result no matter map order
+	ids := slices.Sorted(maps.Keys(fork.values))
+	replacements := make(map[string]string)
+	for _, id := range ids {
+		node := fork.values[id]
+		if node.SourceGraph != fork.Id {
+			continue
+		} else if parent, found := g.values[node.EquivalenceParent]; found && parent.SourceGraph == g.Id {
+			replacements[id] = node.EquivalenceParent
+		} else if len(node.EquivalenceParent) == 0 {
+			replacements[id] = uuid.NewString()
+		}
+	}
+
+	for _, id := range ids {
+		targetId, merged := replacements[id]
+		if !merged {
+			continue
+		}
+
+		theirs, errTheirs := nodes.CopyElementWithId(fork.values[id].Value, targetId)
+		if errTheirs != nil {
+			return result, errTheirs
+		} else if relation, ok := theirs.(nodes.FormalRelation); ok {
+			if err := remapOperands(relation, replacements); err != nil {
+				return result, err
+			}
+		}
+
+		parent, found := g.values[targetId]
+		if !found {
+			result.Added[id] = theirs
+			result.Bases[id] = theirs
+			continue
+		}
+
+		ours, errOurs := nodes.CopyElementWithId(parent.Value, targetId)
+		if errOurs != nil {
+			return result, errOurs
+		}
+
+		conflicts, errMerge := nodes.MergeEquivalentElements(bases[id], ours, theirs)
+		if errMerge != nil {
+			return result, errMerge
+		} else if len(conflicts) != 0 {
+			result.Conflicts[targetId] = conflicts
+		} else {
+			result.Bases[id] = ours
+		}
+
+		if differences, err := nodes.CompareEquivalentElements(nil, parent.Value, ours); err != nil {
+			return result, err
+		} else if len(differences) != 0 {
+			result.Changed[targetId] = ours
+		}
+	}
+
+	return result, nil
+}
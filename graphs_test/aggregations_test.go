@@ -0,0 +1,65 @@
+package graphs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zefrenchwan/patterns.git/graphs"
+	"github.com/zefrenchwan/patterns.git/nodes"
+)
+
+func TestAggregateActivity(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	middle := start.AddDate(0, 0, 1)
+	end := start.AddDate(0, 0, 2)
+
+	firstInterval, _ := nodes.NewFiniteTimeInterval(start, middle, true, false)
+	first, _ := nodes.NewEntityDuring([]string{"city"}, nodes.NewPeriod(firstInterval))
+	first.SetValue("size", "10")
+
+	second := nodes.NewEntity([]string{"city"})
+	second.SetValue("size", "30")
+
+	other := nodes.NewEntity([]string{"country"})
+
+	graph := graphs.NewGraph("test", "test")
+	graph.SetElement(&first, graph.Id, true, "", "")
+	graph.SetElement(&second, graph.Id, true, "", "")
+	graph.SetElement(&other, graph.Id, true, "", "")
+
+	buckets, err := graph.AggregateActivity("city", start, end, graphs.BUCKET_DAY, "size")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(buckets) != 2 {
+		t.Fatalf("expecting two buckets, got %d", len(buckets))
+	}
+
+	if buckets[0].Count != 2 || buckets[1].Count != 1 {
+		t.Error("bad count of active elements per bucket")
+	}
+
+	if buckets[0].Min != 10 || buckets[0].Max != 30 || buckets[0].Average != 20 {
+		t.Error("bad aggregation of values in first bucket")
+	}
+
+	if buckets[1].ValuesCount != 1 || buckets[1].Sum != 30 {
+		t.Error("bad aggregation of values in second bucket")
+	}
+}
+
+func TestAggregateActivityErrors(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	graph := graphs.NewEmptyGraph()
+
+	if _, err := graph.AggregateActivity("", start, start, graphs.BUCKET_DAY, ""); err == nil {
+		t.Error("empty range should fail")
+	}
+
+	if _, err := graph.AggregateActivity("", start, start.AddDate(100, 0, 0), graphs.BUCKET_HOUR, ""); err == nil {
+		t.Error("too many buckets should fail")
+	}
+
+	if _, err := graphs.ParseBucketUnit("decade"); err == nil {
+		t.Error("unknown unit should fail")
+	}
+}
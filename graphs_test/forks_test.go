@@ -0,0 +1,72 @@
+package graphs_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/zefrenchwan/patterns.git/graphs"
+	"github.com/zefrenchwan/patterns.git/nodes"
+)
+
+func TestGraphFork(t *testing.T) {
+	graph := graphs.NewGraphWithId("base", "base", "")
+	graph.Metadata["key"] = []string{"value"}
+
+	owned := nodes.NewEntity([]string{"person"})
+	owned.SetValue("name", "Jean")
+	imported := nodes.NewEntity([]string{"company"})
+	relation := nodes.NewRelation([]string{"works for"})
+	relation.SetValuesForRole("subject", []string{owned.Id()})
+	relation.SetValuesForRole("object", []string{imported.Id()})
+
+	graph.SetElement(&owned, "base", true, "", "")
+	graph.SetElement(&imported, "other", false, "", "")
+	graph.SetElement(&relation, "base", true, "", "")
+
+	fork, copies, err := graph.Fork("fork", false)
+	if err != nil {
+		t.Fatal(err)
+	} else if fork.Id != "fork" || !slices.Equal(fork.Metadata["key"], []string{"value"}) {
+		t.Error("fork should keep graph data with new id")
+	} else if len(copies) != 2 {
+		t.Errorf("expecting two copies, got %v", copies)
+	} else if _, found := copies[imported.Id()]; found {
+		t.Error("imported elements should not be copied without flatten")
+	}
+
+	for _, node := range fork.Nodes() {
+		switch node.Value.Id() {
+		case imported.Id():
+			if node.SourceGraph != "other" {
+				t.Error("imported node should not change")
+			}
+		case copies[owned.Id()]:
+			if node.SourceGraph != "fork" || node.EquivalenceParent != owned.Id() || node.EquivalenceParentGraph != "base" {
+				t.Error("copy should have its source as equivalence parent")
+			} else if !nodes.AreSameElements(node.Value, &owned) {
+				t.Error("copy should have the same content")
+			}
+		case copies[relation.Id()]:
+			forked := node.Value.(nodes.FormalRelation).ValuesPerRole()
+			if !slices.Equal(forked["subject"], []string{copies[owned.Id()]}) {
+				t.Error("internal operands should link copies")
+			} else if !slices.Equal(forked["object"], []string{imported.Id()}) {
+				t.Error("imported operands should remain")
+			}
+		default:
+			t.Errorf("unexpected node %s", node.Value.Id())
+		}
+	}
+
+	// copies are independent
+	owned.SetValue("name", "John")
+	if copyNode := fork.NodesWithTrait("person"); len(copyNode) != 1 {
+		t.Error("fork should index its nodes")
+	} else if names, _ := copyNode[0].Value.(nodes.FormalInstance).ValuesForAttribute("name"); !slices.Equal(names, []string{"Jean"}) {
+		t.Error("copy should not change when source changes")
+	}
+
+	if _, copies, _ := graph.Fork("flat", true); len(copies) != 3 {
+		t.Errorf("flatten should copy all elements, got %v", copies)
+	}
+}
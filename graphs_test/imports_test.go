@@ -0,0 +1,35 @@
+package graphs_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/zefrenchwan/patterns.git/graphs"
+)
+
+func TestImportsDAG(t *testing.T) {
+	dag := graphs.NewImportsDAG()
+	dag.AddImport("base", "b")
+	dag.AddImport("base", "a")
+	dag.AddImport("base", "a")
+	dag.AddImport("a", "c")
+	dag.AddImport("b", "c")
+	dag.AddImport("c", "d")
+
+	if direct := dag.DirectImports("base"); !slices.Equal(direct, []string{"a", "b"}) {
+		t.Errorf("unexpected direct imports %v", direct)
+	} else if direct := dag.DirectImports("d"); len(direct) != 0 {
+		t.Errorf("unexpected direct imports %v", direct)
+	}
+
+	expected := []graphs.ImportedGraph{{Id: "a", Depth: 1}, {Id: "b", Depth: 1}, {Id: "c", Depth: 2}, {Id: "d", Depth: 3}}
+	if transitive := dag.TransitiveImports("base"); !slices.Equal(transitive, expected) {
+		t.Errorf("unexpected transitive imports %v", transitive)
+	}
+
+	// loops should not happen, but should not loop forever either
+	dag.AddImport("d", "base")
+	if transitive := dag.TransitiveImports("base"); len(transitive) != 4 {
+		t.Errorf("unexpected transitive imports %v", transitive)
+	}
+}
@@ -0,0 +1,129 @@
+package graphs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zefrenchwan/patterns.git/graphs"
+	"github.com/zefrenchwan/patterns.git/nodes"
+)
+
+func TestGraphIndexes(t *testing.T) {
+	graph := graphs.NewGraph("test", "test")
+
+	paris := nodes.NewEntity([]string{"city"})
+	paris.SetValue("name", "Paris")
+	france := nodes.NewEntity([]string{"country"})
+	france.SetValue("name", "France")
+	graph.SetElement(&paris, graph.Id, true, "", "")
+	graph.SetElement(&france, graph.Id, true, "", "")
+
+	if err := graph.AddToFormalRelation(graph.Id, true, "link", "", "", []string{"capital"}, nodes.NewFullPeriod(), "subject", paris.Id(), nodes.NewFullPeriod()); err != nil {
+		t.Fatal(err)
+	} else if err := graph.AddToFormalRelation(graph.Id, true, "link", "", "", []string{"capital"}, nodes.NewFullPeriod(), "object", france.Id(), nodes.NewFullPeriod()); err != nil {
+		t.Fatal(err)
+	}
+
+	if cities := graph.NodesWithTrait("city"); len(cities) != 1 || cities[0].Value.Id() != paris.Id() {
+		t.Error("bad trait index")
+	}
+
+	if values := graph.EntitiesWithAttributeValue("name", "France"); len(values) != 1 || values[0].Value.Id() != france.Id() {
+		t.Error("bad attribute index")
+	}
+
+	if links := graph.RelationsWithOperandInRole(france.Id(), "object"); len(links) != 1 || links[0].Value.Id() != "link" {
+		t.Error("bad operand index")
+	} else if links := graph.RelationsWithOperandInRole(france.Id(), "subject"); len(links) != 0 {
+		t.Error("operand index should consider role")
+	} else if links := graph.IncomingRelations(paris.Id()); len(links) != 1 {
+		t.Error("bad incoming relations")
+	}
+
+	// changing an element should reindex it
+	paris.RemoveTrait("city")
+	paris.AddTrait("capital")
+	paris.SetValue("name", "Lutece")
+	graph.SetElement(&paris, graph.Id, true, "", "")
+	if len(graph.NodesWithTrait("city")) != 0 || len(graph.NodesWithTrait("capital")) != 2 {
+		t.Error("traits should be reindexed")
+	} else if len(graph.EntitiesWithAttributeValue("name", "Paris")) != 0 {
+		t.Error("previous value should be unindexed")
+	} else if len(graph.EntitiesWithAttributeValue("name", "Lutece")) != 1 {
+		t.Error("new value should be indexed")
+	}
+
+	// nodes are sorted by id
+	all := graph.Nodes()
+	for index := 1; index < len(all); index++ {
+		if all[index-1].Value.Id() > all[index].Value.Id() {
+			t.Error("nodes should be sorted by id")
+		}
+	}
+}
+
+func TestGraphPeriodIndexes(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	middle := start.AddDate(1, 0, 0)
+	end := start.AddDate(2, 0, 0)
+	graph := graphs.NewGraph("test", "test")
+
+	firstInterval, _ := nodes.NewFiniteTimeInterval(start, middle, true, false)
+	first, _ := nodes.NewEntityDuring([]string{"person"}, nodes.NewPeriod(firstInterval))
+	first.SetValue("job", "student")
+	graph.SetElement(&first, graph.Id, true, "", "")
+
+	secondInterval := nodes.NewRightInfiniteTimeInterval(middle, true)
+	if err := graph.AddToFormalInstance(graph.Id, true, "second", "", "", []string{"person"},
+		nodes.NewPeriod(secondInterval), "job", []string{"teacher"}, []nodes.Period{nodes.NewPeriod(secondInterval)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if active := graph.NodesActiveAt(start); len(active) != 1 || active[0].Value.Id() != first.Id() {
+		t.Error("bad activity at start")
+	} else if active := graph.NodesActiveAt(end); len(active) != 1 || active[0].Value.Id() != "second" {
+		t.Error("bad activity at end")
+	} else if active := graph.NodesActiveDuring(nodes.NewFullPeriod()); len(active) != 2 {
+		t.Error("bad activity during full period")
+	}
+
+	if values := graph.AttributeValuesAt("job", end); len(values) != 1 || values["second"][0] != "teacher" {
+		t.Errorf("bad attribute values at end: %v", values)
+	} else if values := graph.AttributeValuesDuring("job", nodes.NewFullPeriod()); len(values) != 2 {
+		t.Errorf("bad attribute values during full period: %v", values)
+	}
+
+	// deactivate first element and reindex it
+	first.SetActivePeriod(nodes.NewEmptyPeriod())
+	graph.MarkExistingElementAsDirty(&first)
+	if active := graph.NodesActiveAt(start); len(active) != 0 {
+		t.Error("activity should be reindexed")
+	}
+}
+
+func TestGraphIndexesRemoveReplacedValues(t *testing.T) {
+	graph := graphs.NewGraph("test", "test")
+	full := nodes.NewFullPeriod()
+	for _, job := range []string{"student", "teacher"} {
+		if err := graph.AddToFormalInstance(graph.Id, true, "person", "", "", []string{"person"},
+			full, "job", []string{job}, []nodes.Period{full}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if matching := graph.EntitiesWithAttributeValue("job", "student"); len(matching) != 0 {
+		t.Error("replaced value should be unindexed")
+	} else if matching := graph.EntitiesWithAttributeValue("job", "teacher"); len(matching) != 1 {
+		t.Error("new value should be indexed")
+	} else if values := graph.AttributeValuesDuring("job", full); len(values["person"]) != 1 || values["person"][0] != "teacher" {
+		t.Errorf("replaced value should have no period, got %v", values)
+	}
+
+	// values removed from instance are unindexed when it is marked as dirty
+	instance := graph.Nodes()[0].Value.(nodes.FormalInstance)
+	instance.RemovePeriodForAttribute("job", full)
+	graph.MarkExistingElementAsDirty(instance)
+	if matching := graph.EntitiesWithAttributeValue("job", "teacher"); len(matching) != 0 {
+		t.Error("removed value should be unindexed")
+	}
+}
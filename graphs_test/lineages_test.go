@@ -0,0 +1,96 @@
+package graphs_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/zefrenchwan/patterns.git/graphs"
+	"github.com/zefrenchwan/patterns.git/nodes"
+)
+
+func TestNewLineage(t *testing.T) {
+	lineage, err := graphs.NewLineage("copy", []graphs.LineageNode{
+		{ElementId: "root", GraphId: "g0", Depth: -2},
+		{ElementId: "parent", GraphId: "g1", ParentId: "root", Depth: -1},
+		{ElementId: "copy", GraphId: "g2", ParentId: "parent", Depth: 0},
+		{ElementId: "b", GraphId: "g3", ParentId: "copy", Depth: 1},
+		{ElementId: "a", GraphId: "g3", ParentId: "copy", Depth: 1},
+		{ElementId: "c", GraphId: "g4", ParentId: "a", Depth: 2},
+		// parent is not visible
+		{ElementId: "d", GraphId: "g4", Depth: 3},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	} else if lineage.Element.GraphId != "g2" {
+		t.Error("bad element")
+	}
+
+	ancestors := make([]string, 0)
+	for _, ancestor := range lineage.Ancestors {
+		ancestors = append(ancestors, ancestor.ElementId)
+	}
+
+	if !slices.Equal(ancestors, []string{"parent", "root"}) {
+		t.Errorf("ancestors should go from parent to root, got %v", ancestors)
+	}
+
+	children := make([]string, 0)
+	for _, child := range lineage.Children("copy") {
+		children = append(children, child.ElementId)
+	}
+
+	if !slices.Equal(children, []string{"a", "b", "d"}) {
+		t.Errorf("unexpected children %v", children)
+	} else if size := len(lineage.Children("a")); size != 1 {
+		t.Errorf("expecting one child, got %d", size)
+	} else if size := len(lineage.Descendants()); size != 4 {
+		t.Errorf("expecting four descendants, got %d", size)
+	}
+
+	if _, err := graphs.NewLineage("unknown", nil); err == nil {
+		t.Error("element should be in its lineage")
+	}
+}
+
+func TestCollapseEquivalences(t *testing.T) {
+	graph := graphs.NewEmptyGraph()
+
+	original := nodes.NewEntity([]string{"person"})
+	original.SetValue("name", "Jean")
+	copy := nodes.NewEntity([]string{"employee"})
+	copy.SetValue("name", "John")
+	copyOfCopy := nodes.NewEntity([]string{"manager"})
+	other := nodes.NewEntity([]string{"company"})
+
+	relation := nodes.NewRelation([]string{"works for"})
+	relation.SetValuesForRole("subject", []string{copyOfCopy.Id()})
+	relation.SetValuesForRole("object", []string{other.Id()})
+
+	graph.SetElement(&original, "g0", true, "", "")
+	graph.SetElement(&copy, "g1", true, original.Id(), "g0")
+	graph.SetElement(&copyOfCopy, "g2", true, copy.Id(), "g1")
+	graph.SetElement(&other, "g2", true, "", "")
+	graph.SetElement(&relation, "g2", true, "", "")
+
+	replacements, err := graph.CollapseEquivalences()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(replacements) != 2 || replacements[copyOfCopy.Id()] != original.Id() {
+		t.Errorf("unexpected replacements %v", replacements)
+	} else if size := len(graph.Nodes()); size != 3 {
+		t.Errorf("expecting three nodes, got %d", size)
+	}
+
+	if traits := original.Traits(); !slices.Contains(traits, "employee") || !slices.Contains(traits, "manager") {
+		t.Errorf("copies should be merged, got %v", traits)
+	} else if names, _ := original.ValuesForAttribute("name"); !slices.Equal(names, []string{"Jean"}) {
+		t.Errorf("original should win, got %v", names)
+	}
+
+	if subjects := relation.ValuesPerRole()["subject"]; !slices.Equal(subjects, []string{original.Id()}) {
+		t.Errorf("relation should link original, got %v", subjects)
+	} else if found := graph.NodesWithTrait("manager"); len(found) != 1 || found[0].Value.Id() != original.Id() {
+		t.Error("indexes should be rebuilt after collapse")
+	}
+}
@@ -0,0 +1,75 @@
+package graphs_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/zefrenchwan/patterns.git/graphs"
+	"github.com/zefrenchwan/patterns.git/nodes"
+)
+
+func TestMergeFork(t *testing.T) {
+	graph := graphs.NewGraphWithId("base", "base", "")
+	jean := nodes.NewEntity([]string{"person"})
+	jean.SetValue("name", "Jean")
+	jean.SetValue("job", "developer")
+	graph.SetElement(&jean, "base", true, "", "")
+
+	fork, copies, errFork := graph.Fork("fork", false)
+	if errFork != nil {
+		t.Fatal(errFork)
+	}
+
+	base, _ := nodes.CopyElementWithId(&jean, jean.Id())
+	bases := map[string]nodes.Element{copies[jean.Id()]: base}
+
+	// graph changes job, fork changes name and adds a relation to a new entity
+	jean.SetValue("job", "manager")
+	forked := fork.NodesWithTrait("person")[0].Value.(nodes.FormalInstance)
+	forked.SetValue("name", "John")
+	company := nodes.NewEntity([]string{"company"})
+	relation := nodes.NewRelation([]string{"works for"})
+	relation.SetValuesForRole("subject", []string{forked.Id()})
+	relation.SetValuesForRole("object", []string{company.Id()})
+	fork.SetElement(&company, "fork", true, "", "")
+	fork.SetElement(&relation, "fork", true, "", "")
+
+	merge, err := graph.MergeFork(fork, bases)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(merge.Conflicts) != 0 {
+		t.Errorf("unexpected conflicts %v", merge.Conflicts)
+	} else if len(merge.Added) != 2 {
+		t.Errorf("expecting two added elements, got %v", merge.Added)
+	} else if len(merge.Bases) != 3 {
+		t.Errorf("expecting bases for all fork elements, got %v", merge.Bases)
+	}
+
+	if changed, found := merge.Changed[jean.Id()]; !found {
+		t.Error("parent should change")
+	} else if names, _ := changed.(nodes.FormalInstance).ValuesForAttribute("name"); !slices.Equal(names, []string{"John"}) {
+		t.Errorf("name should be merged, got %v", names)
+	} else if jobs, _ := changed.(nodes.FormalInstance).ValuesForAttribute("job"); !slices.Equal(jobs, []string{"manager"}) {
+		t.Errorf("job should remain, got %v", jobs)
+	}
+
+	addedCompany := merge.Added[company.Id()]
+	addedRelation, ok := merge.Added[relation.Id()].(nodes.FormalRelation)
+	if addedCompany == nil || !ok {
+		t.Fatal("new elements should be added")
+	} else if addedCompany.Id() == company.Id() {
+		t.Error("added elements should get new ids")
+	} else if operands := addedRelation.ValuesPerRole(); !slices.Equal(operands["subject"], []string{jean.Id()}) {
+		t.Error("added relation should link graph elements")
+	} else if !slices.Equal(operands["object"], []string{addedCompany.Id()}) {
+		t.Error("added relation should link added elements")
+	}
+
+	// both sides change job
+	forked.SetValue("job", "architect")
+	if merge, _ := graph.MergeFork(fork, bases); len(merge.Conflicts[jean.Id()]) != 1 {
+		t.Errorf("expecting job conflict, got %v", merge.Conflicts)
+	} else if _, found := merge.Bases[forked.Id()]; found {
+		t.Error("conflicts should keep previous base")
+	}
+}
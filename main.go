@@ -2,18 +2,30 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
-	"strings"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/zefrenchwan/patterns.git/config"
+	"github.com/zefrenchwan/patterns.git/oidc"
 	"github.com/zefrenchwan/patterns.git/serving"
 	"github.com/zefrenchwan/patterns.git/storage"
 	"go.uber.org/zap"
 )
 
 func main() {
-	rawLogger, err := zap.NewProduction()
+	configuration, errConfiguration := config.LoadFromEnvironment()
+	if errConfiguration != nil {
+		panic(fmt.Errorf("invalid configuration: %s", errConfiguration.Error()))
+	}
+
+	level, _ := configuration.ZapLevel()
+	loggerConfiguration := zap.NewProductionConfig()
+	loggerConfiguration.Level = zap.NewAtomicLevelAt(level)
+	rawLogger, err := loggerConfiguration.Build()
 	if err != nil {
 		panic(err.Error())
 	}
@@ -24,13 +36,16 @@ func main() {
 	}
 	defer rawLogger.Sync()
 
-	dburl := os.Getenv("PATTERNS_DB_URL")
-	if dburl == "" {
-		panic("Error: no database set")
+	// requests use their own context, so that shutdown does not cancel in-flight requests
+	currentContext := context.Background()
+	poolSettings := storage.PoolSettings{
+		MaxConns:        configuration.PoolMaxConns,
+		MinConns:        configuration.PoolMinConns,
+		MaxConnLifetime: time.Duration(configuration.PoolMaxConnLifetime),
+		MaxConnIdleTime: time.Duration(configuration.PoolMaxConnIdleTime),
 	}
 
-	currentContext := context.Background()
-	dao, errDao := storage.NewDao(currentContext, dburl)
+	dao, errDao := storage.NewDao(currentContext, configuration.DatabaseUrl, poolSettings, logger)
 	if errDao != nil {
 		errorMessage := fmt.Sprintf("failed to build dao: %s", errDao.Error())
 		panic(errorMessage)
@@ -38,11 +53,91 @@ func main() {
 		defer dao.Close()
 	}
 
-	servingPort := os.Getenv("PATTERNS_PORT")
-	if !strings.HasPrefix(servingPort, ":") {
-		panic(fmt.Errorf("invalid port %s : it should be a : and a valid number", servingPort))
+	// done on SIGTERM or SIGINT
+	signalContext, stop := signal.NotifyContext(currentContext, syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	trashRetention := time.Duration(configuration.TrashRetention)
+	trashPurgePeriod := time.Duration(configuration.TrashPurgePeriod)
+	serving.StartTrashPurge(signalContext, dao, trashRetention, trashPurgePeriod, logger)
+
+	signingKeySecret, errSecret := configuration.SigningKeySecretBytes()
+	if errSecret != nil {
+		panic(errSecret.Error())
+	}
+
+	settings := serving.ServiceSettings{
+		AccessTokenDuration:  time.Duration(configuration.AccessTokenDuration),
+		RefreshTokenDuration: time.Duration(configuration.RefreshTokenDuration),
+		SigningAlgorithm:     configuration.SigningAlgorithm,
+		SigningKeyRotation:   time.Duration(configuration.SigningKeyRotation),
+		SigningKeySecret:     signingKeySecret,
+		Issuer:               configuration.TokenIssuer,
+		Audience:             configuration.TokenAudience,
+		PasswordPolicy: serving.PasswordPolicy{
+			MinLength:        configuration.PasswordMinLength,
+			RequireMixedCase: configuration.PasswordRequireMixedCase,
+			RequireDigit:     configuration.PasswordRequireDigit,
+			RequireSymbol:    configuration.PasswordRequireSymbol,
+		},
+		Lockout: storage.LockoutPolicy{
+			MaxLoginFailures:   configuration.LoginMaxFailures,
+			MaxAddressFailures: configuration.LoginAddressMaxFailures,
+			FailureWindow:      time.Duration(configuration.LoginFailureWindow),
+			LockoutDuration:    time.Duration(configuration.LoginLockoutDuration),
+		},
+		RateLimits: serving.RateLimits{
+			Read:  serving.RateLimit{Rate: configuration.RateLimitReadRate, Burst: configuration.RateLimitReadBurst},
+			Write: serving.RateLimit{Rate: configuration.RateLimitWriteRate, Burst: configuration.RateLimitWriteBurst},
+			Find:  serving.RateLimit{Rate: configuration.RateLimitFindRate, Burst: configuration.RateLimitFindBurst},
+		},
+		Oidc: oidc.Settings{
+			Issuer:        configuration.OidcIssuer,
+			ClientId:      configuration.OidcClientId,
+			ClientSecret:  configuration.OidcClientSecret,
+			RedirectUrl:   configuration.OidcRedirectUrl,
+			Scopes:        configuration.OidcScopes,
+			Audience:      configuration.OidcAudience,
+			UsernameClaim: configuration.OidcUsernameClaim,
+			GroupsClaim:   configuration.OidcGroupsClaim,
+			AutoProvision: configuration.OidcAutoProvision,
+			GroupRoles:    configuration.OidcGroupRoles,
+		},
+	}
+
+	mux := serving.InitService(dao, currentContext, logger, settings)
+	server := &http.Server{
+		Addr:         configuration.Port,
+		Handler:      http.MaxBytesHandler(mux, configuration.MaxBodySize),
+		ReadTimeout:  time.Duration(configuration.ReadTimeout),
+		WriteTimeout: time.Duration(configuration.WriteTimeout),
+		IdleTimeout:  time.Duration(configuration.IdleTimeout),
+		ErrorLog:     zap.NewStdLog(rawLogger),
 	}
 
-	mux := serving.InitService(dao, currentContext, logger)
-	http.ListenAndServe(servingPort, mux)
+	serverErrors := make(chan error, 1)
+	go func() {
+		logger.Infow("server starting", "port", configuration.Port, "tls", configuration.UseTLS())
+		if configuration.UseTLS() {
+			serverErrors <- server.ListenAndServeTLS(configuration.TLSCertFile, configuration.TLSKeyFile)
+		} else {
+			serverErrors <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serverErrors:
+		if !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("server failed: %s", err.Error())
+		}
+	case <-signalContext.Done():
+		logger.Info("shutdown requested, draining requests")
+		shutdownContext, cancel := context.WithTimeout(currentContext, time.Duration(configuration.ShutdownTimeout))
+		defer cancel()
+		if err := server.Shutdown(shutdownContext); err != nil {
+			logger.Errorf("shutdown failed: %s", err.Error())
+		} else {
+			logger.Info("server stopped")
+		}
+	}
 }
@@ -0,0 +1,357 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are upper bounds, in seconds, for latency histograms
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Default is the registry the application exposes
+var Default = NewRegistry()
+
+// metric is a family of samples sharing a name, written in the prometheus text format
+type metric interface {
+	// kind is counter, gauge or histogram
+	kind() string
+	// writeSamples writes the samples of the family
+	writeSamples(w io.Writer, name string) error
+}
+
+// registeredMetric is a metric and its documentation
+type registeredMetric struct {
+	help  string
+	value metric
+}
+
+// Registry contains metrics per name
+type Registry struct {
+	mutex   sync.Mutex
+	metrics map[string]registeredMetric
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]registeredMetric)}
+}
+
+// register adds a metric, replacing previous metric with the same name
+func (r *Registry) register(name, help string, value metric) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.metrics[name] = registeredMetric{help: help, value: value}
+}
+
+// NewCounterVec registers a counter with labels
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	result := &CounterVec{labels: labels, values: make(map[string]*labelledValue)}
+	r.register(name, help, result)
+	return result
+}
+
+// NewGauge registers a gauge with no label
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	result := &Gauge{}
+	r.register(name, help, result)
+	return result
+}
+
+// NewGaugeFunc registers a gauge whose value is read at each exposition
+func (r *Registry) NewGaugeFunc(name, help string, value func() float64) {
+	r.register(name, help, funcMetric{metricKind: "gauge", value: value})
+}
+
+// NewCounterFunc registers a counter whose value is read at each exposition
+func (r *Registry) NewCounterFunc(name, help string, value func() float64) {
+	r.register(name, help, funcMetric{metricKind: "counter", value: value})
+}
+
+// NewHistogramVec registers an histogram with labels. Buckets are upper bounds, sorted
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	bounds := slices.Clone(buckets)
+	slices.Sort(bounds)
+	result := &HistogramVec{labels: labels, buckets: bounds, values: make(map[string]*histogramValue)}
+	r.register(name, help, result)
+	return result
+}
+
+// WriteText writes all metrics in the prometheus text exposition format, sorted by name
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mutex.Lock()
+	metrics := maps.Clone(r.metrics)
+	r.mutex.Unlock()
+
+	for _, name := range slices.Sorted(maps.Keys(metrics)) {
+		current := metrics[name]
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(current.help), name, current.value.kind()); err != nil {
+			return err
+		} else if err := current.value.writeSamples(w, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// labelledValue is a value and its label values
+type labelledValue struct {
+	labels []string
+	value  float64
+}
+
+// CounterVec counts events per label values
+type CounterVec struct {
+	mutex  sync.Mutex
+	labels []string
+	values map[string]*labelledValue
+}
+
+// Inc adds one for those label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds a positive value for those label values
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := strings.Join(labelValues, "\xff")
+	current, found := c.values[key]
+	if !found {
+		current = &labelledValue{labels: slices.Clone(labelValues)}
+		c.values[key] = current
+	}
+
+	current.value += value
+}
+
+// Value returns the current value for those label values
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if current, found := c.values[strings.Join(labelValues, "\xff")]; found {
+		return current.value
+	}
+
+	return 0
+}
+
+func (c *CounterVec) kind() string {
+	return "counter"
+}
+
+func (c *CounterVec) writeSamples(w io.Writer, name string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, key := range slices.Sorted(maps.Keys(c.values)) {
+		current := c.values[key]
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(c.labels, current.labels), formatValue(current.value)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Gauge is a value that goes up and down
+type Gauge struct {
+	mutex sync.Mutex
+	value float64
+}
+
+// Add adds value, possibly negative
+func (g *Gauge) Add(value float64) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.value += value
+}
+
+// Inc adds one
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+// Dec removes one
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+// Set forces value
+func (g *Gauge) Set(value float64) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.value = value
+}
+
+// Value returns the current value
+func (g *Gauge) Value() float64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.value
+}
+
+func (g *Gauge) kind() string {
+	return "gauge"
+}
+
+func (g *Gauge) writeSamples(w io.Writer, name string) error {
+	_, err := fmt.Fprintf(w, "%s %s\n", name, formatValue(g.Value()))
+	return err
+}
+
+// funcMetric reads its value when written
+type funcMetric struct {
+	metricKind string
+	value      func() float64
+}
+
+func (f funcMetric) kind() string {
+	return f.metricKind
+}
+
+func (f funcMetric) writeSamples(w io.Writer, name string) error {
+	_, err := fmt.Fprintf(w, "%s %s\n", name, formatValue(f.value()))
+	return err
+}
+
+// histogramValue is an histogram for label values. Counts are per bucket, not cumulative
+type histogramValue struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec counts observations per bucket, per label values
+type HistogramVec struct {
+	mutex   sync.Mutex
+	labels  []string
+	buckets []float64
+	values  map[string]*histogramValue
+}
+
+// Observe adds an observation for those label values
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	key := strings.Join(labelValues, "\xff")
+	current, found := h.values[key]
+	if !found {
+		current = &histogramValue{labels: slices.Clone(labelValues), counts: make([]uint64, len(h.buckets))}
+		h.values[key] = current
+	}
+
+	if index, _ := slices.BinarySearch(h.buckets, value); index < len(h.buckets) {
+		current.counts[index]++
+	}
+
+	current.count++
+	current.sum += value
+}
+
+// Count returns the number of observations for those label values
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if current, found := h.values[strings.Join(labelValues, "\xff")]; found {
+		return current.count
+	}
+
+	return 0
+}
+
+func (h *HistogramVec) kind() string {
+	return "histogram"
+}
+
+func (h *HistogramVec) writeSamples(w io.Writer, name string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	bucketLabels := append(slices.Clone(h.labels), "le")
+	for _, key := range slices.Sorted(maps.Keys(h.values)) {
+		current := h.values[key]
+		var cumulative uint64
+		for index, bound := range h.buckets {
+			cumulative += current.counts[index]
+			labels := formatLabels(bucketLabels, append(slices.Clone(current.labels), formatValue(bound)))
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels, cumulative); err != nil {
+				return err
+			}
+		}
+
+		labels := formatLabels(bucketLabels, append(slices.Clone(current.labels), "+Inf"))
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels, current.count); err != nil {
+			return err
+		}
+
+		labels = formatLabels(h.labels, current.labels)
+		if _, err := fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", name, labels, formatValue(current.sum), name, labels, current.count); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// formatLabels returns {name="value",...}, or empty for no label
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var builder strings.Builder
+	builder.WriteString("{")
+	for index, name := range names {
+		if index != 0 {
+			builder.WriteString(",")
+		}
+
+		var value string
+		if index < len(values) {
+			value = values[index]
+		}
+
+		builder.WriteString(name)
+		builder.WriteString(`="`)
+		builder.WriteString(escapeLabelValue(value))
+		builder.WriteString(`"`)
+	}
+
+	builder.WriteString("}")
+	return builder.String()
+}
+
+// formatValue returns the text value of a sample
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+// escapeLabelValue escapes backslash, double quote and line feed
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// escapeHelp escapes backslash and line feed
+func escapeHelp(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,89 @@
+package metrics_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zefrenchwan/patterns.git/metrics"
+)
+
+func TestCounterExposition(t *testing.T) {
+	registry := metrics.NewRegistry()
+	counter := registry.NewCounterVec("requests_total", "Requests", "route", "status")
+	counter.Inc("/graph/", "200")
+	counter.Inc("/graph/", "200")
+	counter.Add(3, "/elements/\"quoted\"", "404")
+	// negative values are ignored for counters
+	counter.Add(-1, "/graph/", "200")
+
+	if value := counter.Value("/graph/", "200"); value != 2 {
+		t.Errorf("expecting 2, got %f", value)
+	}
+
+	var builder strings.Builder
+	if err := registry.WriteText(&builder); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "# HELP requests_total Requests\n" +
+		"# TYPE requests_total counter\n" +
+		"requests_total{route=\"/elements/\\\"quoted\\\"\",status=\"404\"} 3\n" +
+		"requests_total{route=\"/graph/\",status=\"200\"} 2\n"
+	if result := builder.String(); result != expected {
+		t.Errorf("expecting\n%s\ngot\n%s", expected, result)
+	}
+}
+
+func TestHistogramExposition(t *testing.T) {
+	registry := metrics.NewRegistry()
+	histogram := registry.NewHistogramVec("duration_seconds", "Durations", []float64{1, 0.1}, "call")
+	histogram.Observe(0.05, "load")
+	histogram.Observe(0.1, "load")
+	histogram.Observe(0.5, "load")
+	histogram.Observe(20, "load")
+
+	if count := histogram.Count("load"); count != 4 {
+		t.Errorf("expecting 4 observations, got %d", count)
+	}
+
+	var builder strings.Builder
+	if err := registry.WriteText(&builder); err != nil {
+		t.Fatal(err)
+	}
+
+	// buckets are sorted, cumulative, and bounds are inclusive
+	expected := "# HELP duration_seconds Durations\n" +
+		"# TYPE duration_seconds histogram\n" +
+		"duration_seconds_bucket{call=\"load\",le=\"0.1\"} 2\n" +
+		"duration_seconds_bucket{call=\"load\",le=\"1\"} 3\n" +
+		"duration_seconds_bucket{call=\"load\",le=\"+Inf\"} 4\n" +
+		"duration_seconds_sum{call=\"load\"} 20.65\n" +
+		"duration_seconds_count{call=\"load\"} 4\n"
+	if result := builder.String(); result != expected {
+		t.Errorf("expecting\n%s\ngot\n%s", expected, result)
+	}
+}
+
+func TestGaugesExposition(t *testing.T) {
+	registry := metrics.NewRegistry()
+	gauge := registry.NewGauge("in_flight", "In flight")
+	gauge.Inc()
+	gauge.Inc()
+	gauge.Dec()
+	registry.NewGaugeFunc("connections", "Connections", func() float64 { return 7 })
+
+	var builder strings.Builder
+	if err := registry.WriteText(&builder); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "# HELP connections Connections\n" +
+		"# TYPE connections gauge\n" +
+		"connections 7\n" +
+		"# HELP in_flight In flight\n" +
+		"# TYPE in_flight gauge\n" +
+		"in_flight 1\n"
+	if result := builder.String(); result != expected {
+		t.Errorf("expecting\n%s\ngot\n%s", expected, result)
+	}
+}
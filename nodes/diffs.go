@@ -0,0 +1,52 @@
+package nodes
+
+// PartChange is a part of an element that changed, with its changed values before and after.
+// Values are the traits for traits, a single empty value for activity, the values (or operands) for an attribute (or role)
+type PartChange struct {
+	Part   string
+	Before map[string]Period
+	After  map[string]Period
+}
+
+// DiffElements returns the changes from before to after, sorted by part.
+// Only changed values are in changes. Nil before means creation, nil after means deletion
+func DiffElements(before, after Element) []PartChange {
+	var result []PartChange
+	for _, part := range syncParts(before, after) {
+		beforeValues, afterValues := diffPartValues(before, part), diffPartValues(after, part)
+		change := PartChange{Part: part, Before: make(map[string]Period), After: make(map[string]Period)}
+		for value, period := range beforeValues {
+			if other, found := afterValues[value]; !found || !other.IsSameAs(period) {
+				change.Before[value] = period
+			}
+		}
+
+		for value, period := range afterValues {
+			if other, found := beforeValues[value]; !found || !other.IsSameAs(period) {
+				change.After[value] = period
+			}
+		}
+
+		if len(change.Before) != 0 || len(change.After) != 0 {
+			result = append(result, change)
+		}
+	}
+
+	return result
+}
+
+// diffPartValues returns the values of a part, activity and traits included, nil for nil element
+func diffPartValues(element Element, part string) map[string]Period {
+	if element == nil {
+		return nil
+	}
+
+	switch part {
+	case SYNC_ACTIVITY_PART:
+		return map[string]Period{"": element.ActivePeriod()}
+	case SYNC_TRAITS_PART:
+		return traitsAsPeriods(element)
+	default:
+		return syncPartValues(element, part)
+	}
+}
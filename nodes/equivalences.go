@@ -1,6 +1,9 @@
 package nodes
 
-import "slices"
+import (
+	"errors"
+	"slices"
+)
 
 // AllSameElements returns true if each couple of elements are same, false otherwise
 func AllSameElements(elements []Element) bool {
@@ -113,3 +116,23 @@ func AreSameElements(a, b Element) bool {
 
 	return true
 }
+
+// CopyElementWithId returns a deep copy of element with another id
+func CopyElementWithId(element Element, id string) (Element, error) {
+	if element == nil {
+		return nil, errors.New("nil element")
+	}
+
+	result, errClone := cloneForSync(element, id)
+	if errClone != nil {
+		return nil, errClone
+	}
+
+	for _, part := range syncParts(element, element) {
+		if err := copySyncPart(element, result, part); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,335 @@
+package nodes
+
+import (
+	"math/rand/v2"
+	"slices"
+)
+
+// intervalTreeNode is a node of the interval tree.
+// All values linked to the same interval are in the same node
+type intervalTreeNode[T any, V comparable] struct {
+	// interval is the key of the node
+	interval Interval[T]
+	// values linked to the interval
+	values []V
+	// priority of the node, a max heap for the treap
+	priority uint64
+	// maxRight is the interval of the subtree with the greatest right boundary
+	maxRight Interval[T]
+	// left child, with lower intervals
+	left *intervalTreeNode[T, V]
+	// right child, with greater intervals
+	right *intervalTreeNode[T, V]
+}
+
+// IntervalTree links intervals to values, and finds values for a point or an interval in logarithmic time.
+// It is a treap ordered by left then right boundaries, each node knowing the greatest right boundary of its subtree.
+// Empty intervals are not stored
+type IntervalTree[T any, V comparable] struct {
+	// comparator to compare intervals and values
+	comparator TypedComparator[T]
+	// root of the tree, nil for an empty tree
+	root *intervalTreeNode[T, V]
+	// size is the number of (interval, value) couples
+	size int
+}
+
+// NewIntervalTree returns an empty interval tree based on comparator
+func NewIntervalTree[T any, V comparable](comparator TypedComparator[T]) *IntervalTree[T, V] {
+	return &IntervalTree[T, V]{comparator: comparator}
+}
+
+// Len returns the number of (interval, value) couples in the tree
+func (t *IntervalTree[T, V]) Len() int {
+	if t == nil {
+		return 0
+	}
+
+	return t.size
+}
+
+// Insert links value to interval, if not already linked
+func (t *IntervalTree[T, V]) Insert(interval Interval[T], value V) {
+	if t == nil || interval.IsEmpty() {
+		return
+	}
+
+	t.root = t.insert(t.root, interval, value)
+}
+
+// Remove unlinks value from interval, and returns true if it was linked
+func (t *IntervalTree[T, V]) Remove(interval Interval[T], value V) bool {
+	if t == nil || interval.IsEmpty() {
+		return false
+	}
+
+	var removed bool
+	t.root, removed = t.remove(t.root, interval, value)
+	return removed
+}
+
+// Stabbing returns the values linked to an interval containing point.
+// A value appears as many times as its intervals containing point
+func (t *IntervalTree[T, V]) Stabbing(point T) []V {
+	if t == nil {
+		return nil
+	}
+
+	var result []V
+	t.stabbing(t.root, point, &result)
+	return result
+}
+
+// Overlapping returns the values linked to an interval having a common point with interval.
+// A value appears as many times as its intervals overlapping interval
+func (t *IntervalTree[T, V]) Overlapping(interval Interval[T]) []V {
+	if t == nil || interval.IsEmpty() {
+		return nil
+	}
+
+	var result []V
+	t.overlapping(t.root, interval, &result)
+	return result
+}
+
+// compareLeftBoundaries compares the left boundaries of non empty intervals, lower meaning starting before
+func (t *IntervalTree[T, V]) compareLeftBoundaries(a, b Interval[T]) int {
+	switch {
+	case a.minInfinite && b.minInfinite:
+		return 0
+	case a.minInfinite:
+		return -1
+	case b.minInfinite:
+		return 1
+	}
+
+	if comparison := t.comparator.Compare(a.min, b.min); comparison != 0 {
+		return comparison
+	} else if a.minIncluded == b.minIncluded {
+		return 0
+	} else if a.minIncluded {
+		return -1
+	} else {
+		return 1
+	}
+}
+
+// compareIntervals orders non empty intervals by left boundaries, then right boundaries
+func (t *IntervalTree[T, V]) compareIntervals(a, b Interval[T]) int {
+	if comparison := t.compareLeftBoundaries(a, b); comparison != 0 {
+		return comparison
+	}
+
+	return t.compareRightBoundaries(a, b)
+}
+
+// compareRightBoundaries compares the right boundaries of non empty intervals
+func (t *IntervalTree[T, V]) compareRightBoundaries(a, b Interval[T]) int {
+	switch {
+	case a.maxInfinite && b.maxInfinite:
+		return 0
+	case a.maxInfinite:
+		return 1
+	case b.maxInfinite:
+		return -1
+	}
+
+	if comparison := t.comparator.Compare(a.max, b.max); comparison != 0 {
+		return comparison
+	} else if a.maxIncluded == b.maxIncluded {
+		return 0
+	} else if a.maxIncluded {
+		return 1
+	} else {
+		return -1
+	}
+}
+
+// endsBeforePoint returns true if all the points of a are strictly lower than point
+func (t *IntervalTree[T, V]) endsBeforePoint(a Interval[T], point T) bool {
+	if a.maxInfinite {
+		return false
+	}
+
+	comparison := t.comparator.Compare(a.max, point)
+	return comparison < 0 || (comparison == 0 && !a.maxIncluded)
+}
+
+// startsAfterPoint returns true if all the points of a are strictly greater than point
+func (t *IntervalTree[T, V]) startsAfterPoint(a Interval[T], point T) bool {
+	if a.minInfinite {
+		return false
+	}
+
+	comparison := t.comparator.Compare(a.min, point)
+	return comparison > 0 || (comparison == 0 && !a.minIncluded)
+}
+
+// endsBefore returns true if all points of a are strictly lower than all points of b
+func (t *IntervalTree[T, V]) endsBefore(a, b Interval[T]) bool {
+	if a.maxInfinite || b.minInfinite {
+		return false
+	}
+
+	comparison := t.comparator.Compare(a.max, b.min)
+	return comparison < 0 || (comparison == 0 && !(a.maxIncluded && b.minIncluded))
+}
+
+// update sets maxRight for n based on its children
+func (t *IntervalTree[T, V]) update(n *intervalTreeNode[T, V]) {
+	n.maxRight = n.interval
+	if n.left != nil && t.compareRightBoundaries(n.left.maxRight, n.maxRight) > 0 {
+		n.maxRight = n.left.maxRight
+	}
+
+	if n.right != nil && t.compareRightBoundaries(n.right.maxRight, n.maxRight) > 0 {
+		n.maxRight = n.right.maxRight
+	}
+}
+
+// rotateRight makes the left child of n the root of the subtree
+func (t *IntervalTree[T, V]) rotateRight(n *intervalTreeNode[T, V]) *intervalTreeNode[T, V] {
+	left := n.left
+	n.left = left.right
+	t.update(n)
+	left.right = n
+	t.update(left)
+	return left
+}
+
+// rotateLeft makes the right child of n the root of the subtree
+func (t *IntervalTree[T, V]) rotateLeft(n *intervalTreeNode[T, V]) *intervalTreeNode[T, V] {
+	right := n.right
+	n.right = right.left
+	t.update(n)
+	right.left = n
+	t.update(right)
+	return right
+}
+
+// insert adds value to the subtree, and returns the new root of the subtree
+func (t *IntervalTree[T, V]) insert(n *intervalTreeNode[T, V], interval Interval[T], value V) *intervalTreeNode[T, V] {
+	if n == nil {
+		t.size++
+		return &intervalTreeNode[T, V]{
+			interval: interval,
+			values:   []V{value},
+			priority: rand.Uint64(),
+			maxRight: interval,
+		}
+	}
+
+	comparison := t.compareIntervals(interval, n.interval)
+	switch {
+	case comparison == 0:
+		if !slices.Contains(n.values, value) {
+			n.values = append(n.values, value)
+			t.size++
+		}
+
+		return n
+	case comparison < 0:
+		n.left = t.insert(n.left, interval, value)
+		if n.left.priority > n.priority {
+			return t.rotateRight(n)
+		}
+	default:
+		n.right = t.insert(n.right, interval, value)
+		if n.right.priority > n.priority {
+			return t.rotateLeft(n)
+		}
+	}
+
+	t.update(n)
+	return n
+}
+
+// remove removes value from the subtree, and returns the new root of the subtree
+func (t *IntervalTree[T, V]) remove(n *intervalTreeNode[T, V], interval Interval[T], value V) (*intervalTreeNode[T, V], bool) {
+	if n == nil {
+		return nil, false
+	}
+
+	var removed bool
+	comparison := t.compareIntervals(interval, n.interval)
+	switch {
+	case comparison < 0:
+		n.left, removed = t.remove(n.left, interval, value)
+	case comparison > 0:
+		n.right, removed = t.remove(n.right, interval, value)
+	default:
+		index := slices.Index(n.values, value)
+		if index < 0 {
+			return n, false
+		}
+
+		t.size--
+		n.values = slices.Delete(n.values, index, index+1)
+		if len(n.values) == 0 {
+			return t.deleteNode(n), true
+		}
+
+		return n, true
+	}
+
+	t.update(n)
+	return n, removed
+}
+
+// deleteNode removes n from its subtree by pushing it down to a leaf
+func (t *IntervalTree[T, V]) deleteNode(n *intervalTreeNode[T, V]) *intervalTreeNode[T, V] {
+	var result *intervalTreeNode[T, V]
+	switch {
+	case n.left == nil:
+		return n.right
+	case n.right == nil:
+		return n.left
+	case n.left.priority > n.right.priority:
+		result = t.rotateRight(n)
+		result.right = t.deleteNode(n)
+	default:
+		result = t.rotateLeft(n)
+		result.left = t.deleteNode(n)
+	}
+
+	t.update(result)
+	return result
+}
+
+// stabbing appends to result the values of the subtree whose intervals contain point
+func (t *IntervalTree[T, V]) stabbing(n *intervalTreeNode[T, V], point T, result *[]V) {
+	if n == nil || t.endsBeforePoint(n.maxRight, point) {
+		return
+	}
+
+	t.stabbing(n.left, point, result)
+	if t.startsAfterPoint(n.interval, point) {
+		// intervals on the right start after this one, so after point
+		return
+	}
+
+	if t.comparator.ContainsInterval(n.interval, point) {
+		*result = append(*result, n.values...)
+	}
+
+	t.stabbing(n.right, point, result)
+}
+
+// overlapping appends to result the values of the subtree whose intervals overlap interval
+func (t *IntervalTree[T, V]) overlapping(n *intervalTreeNode[T, V], interval Interval[T], result *[]V) {
+	if n == nil || t.endsBefore(n.maxRight, interval) {
+		return
+	}
+
+	t.overlapping(n.left, interval, result)
+	if t.endsBefore(interval, n.interval) {
+		// intervals on the right start after this one, so after interval
+		return
+	}
+
+	if !t.comparator.Intersection(n.interval, interval).IsEmpty() {
+		*result = append(*result, n.values...)
+	}
+
+	t.overlapping(n.right, interval, result)
+}
@@ -526,8 +526,8 @@ func (t TypedComparator[T]) Remove(base Interval[T], elements ...Interval[T]) []
 	}
 
 	result := []Interval[T]{base}
-	newResult := make([]Interval[T], 0)
 	for _, elementToRemove := range elementsToRemove {
+		newResult := make([]Interval[T], 0)
 		for _, current := range result {
 			// deal with basic cases
 			basicCase := false
@@ -609,7 +609,7 @@ func (t TypedComparator[T]) ContainingIntervalFor(intervals []Interval[T]) Inter
 			return interval
 		case !found:
 			found = true
-			minInfinite := interval.minInfinite
+			minInfinite = interval.minInfinite
 			if !minInfinite {
 				min = interval.min
 				minIncluded = interval.minIncluded
@@ -0,0 +1,85 @@
+package nodes
+
+import (
+	"errors"
+	"slices"
+)
+
+// MergeEntities merges sources into target, that is:
+// target activity becomes the union of all activities,
+// target traits contain all traits,
+// and attribute values of sources are added to target.
+// When values conflict for an attribute at the same moment, target wins, then first sources win.
+// Sources are not changed
+func MergeEntities(target FormalInstance, sources ...FormalInstance) error {
+	if target == nil {
+		return errors.New("nil target")
+	}
+
+	for _, source := range sources {
+		if source == nil {
+			return errors.New("nil source")
+		} else if source.Id() == target.Id() {
+			return errors.New("cannot merge an entity with itself")
+		}
+
+		if err := target.AddActivePeriod(source.ActivePeriod()); err != nil {
+			return err
+		}
+
+		for _, trait := range source.Traits() {
+			if err := target.AddTrait(trait); err != nil {
+				return err
+			}
+		}
+
+		for _, attribute := range source.Attributes() {
+			if err := mergeAttribute(target, source, attribute); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// mergeAttribute adds values of attribute from source to target when target has no value for that attribute
+func mergeAttribute(target, source FormalInstance, attribute string) error {
+	sourceValues, errSource := source.PeriodValuesForAttribute(attribute)
+	if errSource != nil {
+		return errSource
+	} else if len(sourceValues) == 0 {
+		return nil
+	}
+
+	targetValues, errTarget := target.PeriodValuesForAttribute(attribute)
+	if errTarget != nil {
+		return errTarget
+	}
+
+	var targetPeriods []Period
+	for _, period := range targetValues {
+		targetPeriods = append(targetPeriods, period)
+	}
+
+	covered := NewPeriodUnion(targetPeriods...)
+
+	// sort values to get the same result no matter map order
+	values := make([]string, 0, len(sourceValues))
+	for value := range sourceValues {
+		values = append(values, value)
+	}
+
+	slices.Sort(values)
+	for _, value := range values {
+		remaining := NewPeriodCopy(sourceValues[value])
+		remaining.Remove(covered)
+		if remaining.IsEmptyPeriod() {
+			continue
+		} else if err := target.AddValue(attribute, value, remaining); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -75,6 +75,43 @@ func NewFullPeriod() Period {
 	return period
 }
 
+// NewPeriodUnion returns the union of periods, sorting boundaries once
+func NewPeriodUnion(periods ...Period) Period {
+	return NewPeriodCoveredAtLeast(1, periods...)
+}
+
+// NewPeriodIntersection returns the moments belonging to all periods.
+// No period means an empty result
+func NewPeriodIntersection(periods ...Period) Period {
+	if len(periods) == 0 {
+		return NewEmptyPeriod()
+	}
+
+	return NewPeriodCoveredAtLeast(len(periods), periods...)
+}
+
+// NewPeriodCoveredAtLeast returns the moments belonging to at least k periods.
+// Intervals of a period are separated, so each period counts once for a moment
+func NewPeriodCoveredAtLeast(k int, periods ...Period) Period {
+	var intervals []Interval[time.Time]
+	for _, period := range periods {
+		intervals = append(intervals, period.elements...)
+	}
+
+	return newPeriodFromSeparatedIntervals(periodComparator.CoveredAtLeast(k, intervals))
+}
+
+// newPeriodFromSeparatedIntervals builds a period from separated intervals, empty if only empty intervals
+func newPeriodFromSeparatedIntervals(intervals []Interval[time.Time]) Period {
+	if len(intervals) == 0 || intervals[0].IsEmpty() {
+		return NewEmptyPeriod()
+	}
+
+	var period Period
+	period.elements = intervals
+	return period
+}
+
 // IsEmptyPeriod returns true for an empty period or nil (assumed then to be empty)
 func (p *Period) IsEmptyPeriod() bool {
 	return p == nil || len(p.elements) == 0 || p.elements[0].IsEmpty()
@@ -133,7 +170,7 @@ func (p *Period) AddInterval(i Interval[time.Time]) error {
 	}
 
 	// period is not empty, interval to add is not empty
-	p.elements = periodComparator.Union(i, p.elements...)
+	p.elements = periodComparator.UnionAll(append(slices.Clone(p.elements), i))
 
 	return nil
 }
@@ -147,24 +184,7 @@ func (p *Period) Add(other Period) error {
 		return nil
 	}
 
-	currentSize := len(p.elements)
-	otherSize := len(other.elements)
-	unionOfElements := make([]Interval[time.Time], currentSize+otherSize)
-
-	for index := 0; index < currentSize; index++ {
-		unionOfElements[index] = p.elements[index]
-	}
-
-	for index := 0; index < otherSize; index++ {
-		unionOfElements[currentSize+index] = other.elements[index]
-	}
-
-	if len(unionOfElements) >= 2 {
-		p.elements = periodComparator.Union(unionOfElements[0], unionOfElements[1:]...)
-	} else {
-		p.elements = unionOfElements
-	}
-
+	p.elements = NewPeriodUnion(*p, other).elements
 	return nil
 }
 
@@ -176,26 +196,12 @@ func (p *Period) Intersection(other Period) {
 		return
 	}
 
-	var union []Interval[time.Time]
-	for _, currentInterval := range p.elements {
-		for _, otherInterval := range other.elements {
-			intersection := periodComparator.Intersection(currentInterval, otherInterval)
-			if !intersection.IsEmpty() {
-				union = append(union, intersection)
-			}
-		}
-	}
-
-	if len(union) <= 1 {
-		p.elements = union
-	} else {
-		p.elements = periodComparator.Union(union[0], union[1:]...)
-	}
+	p.elements = NewPeriodIntersection(*p, other).elements
 }
 
 // Remove starts with p and remove all the intervals from other.
 // Formally, let p_i be the content of p and o_j be the content of other
-// New content for p is Union over i of (intersections over j ( p_i minus o_j ))
+// New content for p is Union over i of (p_i minus union of o_j)
 func (p *Period) Remove(other Period) {
 	if p.IsEmptyPeriod() || other.IsEmptyPeriod() {
 		return
@@ -203,30 +209,17 @@ func (p *Period) Remove(other Period) {
 
 	var newElements []Interval[time.Time]
 	for _, interval := range p.elements {
-
-		var intersections []Interval[time.Time]
-		for _, otherInterval := range other.elements {
-			differences := periodComparator.Remove(interval, otherInterval)
-			if len(differences) != 0 {
-				intersections = append(intersections, differences...)
+		for _, remaining := range periodComparator.Remove(interval, other.elements...) {
+			if !remaining.IsEmpty() {
+				newElements = append(newElements, remaining)
 			}
 		}
-
-		// For a given interval, that is, for a given i,
-		// intersection is the intersection over j of all (p_i minus o_j)
-		intersection := periodComparator.Intersection(interval, intersections...)
-		if !intersection.IsEmpty() {
-			newElements = append(newElements, intersection)
-		}
 	}
 
-	switch len(newElements) {
-	case 0:
+	if len(newElements) == 0 {
 		p.elements = []Interval[time.Time]{periodComparator.NewEmptyInterval()}
-	case 1:
-		p.elements = newElements
-	default:
-		p.elements = periodComparator.Union(newElements[0], newElements[1:]...)
+	} else {
+		p.elements = periodComparator.UnionAll(newElements)
 	}
 }
 
@@ -339,17 +332,15 @@ func SerializePeriod(p Period, dateFormat string) []string {
 func DeserializePeriod(periodIntervals []string, dateFormat string) (Period, error) {
 	timeRead := func(s string) (time.Time, error) { return time.Parse(dateFormat, s) }
 
-	period := NewEmptyPeriod()
-	for index, intervalValue := range periodIntervals {
+	intervals := make([]Interval[time.Time], 0, len(periodIntervals))
+	for _, intervalValue := range periodIntervals {
 		interval, errInterval := periodComparator.DeserializeInterval(intervalValue, timeRead)
 		if errInterval != nil {
-			return period, errInterval
-		} else if index == 0 {
-			period = NewPeriod(interval)
-		} else {
-			period.AddInterval(interval)
+			return NewEmptyPeriod(), errInterval
 		}
+
+		intervals = append(intervals, interval)
 	}
 
-	return period, nil
+	return newPeriodFromSeparatedIntervals(periodComparator.UnionAll(intervals)), nil
 }
@@ -161,6 +161,8 @@ func (r *Relation) RemovePeriodValueForRole(role string, linkedId string, period
 		previous.Remove(period)
 		if previous.IsEmptyPeriod() {
 			delete(r.links[role], linkedId)
+		} else {
+			r.links[role][linkedId] = previous
 		}
 	}
 
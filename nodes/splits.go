@@ -0,0 +1,102 @@
+package nodes
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+// SplitEntity splits entity at moment, and returns a new entity with newId.
+// Entity keeps its activity and values strictly before moment,
+// new entity gets same traits, activity and values since moment.
+// It returns an error if entity is not active on both sides of moment
+func SplitEntity(entity FormalInstance, moment time.Time, newId string) (FormalInstance, error) {
+	if entity == nil {
+		return nil, errors.New("nil entity")
+	} else if len(newId) == 0 {
+		return nil, errors.New("empty id for split entity")
+	} else if newId == entity.Id() {
+		return nil, errors.New("split entity should have a different id")
+	}
+
+	before := NewPeriod(NewLeftInfiniteTimeInterval(moment, false))
+	after := NewPeriod(NewRightInfiniteTimeInterval(moment, true))
+
+	activityBefore := entity.ActivePeriod()
+	activityBefore.Intersection(before)
+	activityAfter := entity.ActivePeriod()
+	activityAfter.Intersection(after)
+	if activityBefore.IsEmptyPeriod() || activityAfter.IsEmptyPeriod() {
+		return nil, errors.New("entity should be active before and after split moment")
+	}
+
+	newEntity, errNew := NewEntityWithId(newId, entity.Traits(), activityAfter)
+	if errNew != nil {
+		return nil, errNew
+	}
+
+	for _, attribute := range entity.Attributes() {
+		values, errValues := entity.PeriodValuesForAttribute(attribute)
+		if errValues != nil {
+			return nil, errValues
+		}
+
+		for value, period := range values {
+			period.Intersection(after)
+			if period.IsEmptyPeriod() {
+				continue
+			} else if err := newEntity.AddValue(attribute, value, period); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := entity.RemovePeriodForAttribute(attribute, after); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := entity.SetActivePeriod(activityBefore); err != nil {
+		return nil, err
+	}
+
+	return &newEntity, nil
+}
+
+// SplitOperand links next instead of previous since moment, in all the roles of relation.
+// It returns true if relation changed
+func SplitOperand(relation FormalRelation, previous, next string, moment time.Time) (bool, error) {
+	if relation == nil {
+		return false, errors.New("nil relation")
+	}
+
+	after := NewPeriod(NewRightInfiniteTimeInterval(moment, true))
+	rewired := false
+
+	roles := relation.PeriodValuesPerRole()
+	// sort roles to get the same result no matter map order
+	sortedRoles := make([]string, 0, len(roles))
+	for role := range roles {
+		sortedRoles = append(sortedRoles, role)
+	}
+
+	slices.Sort(sortedRoles)
+	for _, role := range sortedRoles {
+		period, found := roles[role][previous]
+		if !found {
+			continue
+		}
+
+		period.Intersection(after)
+		if period.IsEmptyPeriod() {
+			continue
+		} else if err := relation.RemovePeriodValueForRole(role, previous, after); err != nil {
+			return rewired, err
+		} else if err := relation.AddPeriodValueForRole(role, next, period); err != nil {
+			return rewired, err
+		}
+
+		rewired = true
+	}
+
+	return rewired, nil
+}
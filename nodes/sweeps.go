@@ -0,0 +1,136 @@
+package nodes
+
+import "slices"
+
+// sweepPosition is a position on the line to sweep.
+// Each value v defines two positions: v itself, and just after v.
+// An interval is then a half open range of positions [start, end[
+type sweepPosition[T any] struct {
+	// infinite is -1 for -oo, 1 for +oo, 0 for a value
+	infinite int
+	// value of the position if not infinite
+	value T
+	// after is true for the position just after value
+	after bool
+}
+
+// sweepEvent is the start (delta = 1) or end (delta = -1) of an interval
+type sweepEvent[T any] struct {
+	// position of the event
+	position sweepPosition[T]
+	// delta is the change of coverage at position
+	delta int
+}
+
+// comparePositions compares positions on the line
+func (t TypedComparator[T]) comparePositions(a, b sweepPosition[T]) int {
+	if a.infinite != b.infinite {
+		return a.infinite - b.infinite
+	} else if a.infinite != 0 {
+		return 0
+	} else if comparison := t.Compare(a.value, b.value); comparison != 0 {
+		return comparison
+	} else if a.after == b.after {
+		return 0
+	} else if a.after {
+		return 1
+	} else {
+		return -1
+	}
+}
+
+// sweepEvents returns the sorted start and end events of non empty intervals
+func (t TypedComparator[T]) sweepEvents(intervals []Interval[T]) []sweepEvent[T] {
+	events := make([]sweepEvent[T], 0, 2*len(intervals))
+	for _, interval := range intervals {
+		if interval.IsEmpty() {
+			continue
+		}
+
+		var start, end sweepPosition[T]
+		if interval.minInfinite {
+			start.infinite = -1
+		} else {
+			start.value = interval.min
+			start.after = !interval.minIncluded
+		}
+
+		if interval.maxInfinite {
+			end.infinite = 1
+		} else {
+			end.value = interval.max
+			end.after = interval.maxIncluded
+		}
+
+		events = append(events, sweepEvent[T]{position: start, delta: 1}, sweepEvent[T]{position: end, delta: -1})
+	}
+
+	slices.SortFunc(events, func(a, b sweepEvent[T]) int {
+		return t.comparePositions(a.position, b.position)
+	})
+
+	return events
+}
+
+// intervalFromPositions builds the interval matching positions [start, end[
+func (t TypedComparator[T]) intervalFromPositions(start, end sweepPosition[T]) Interval[T] {
+	var result Interval[T]
+	if start.infinite != 0 {
+		result.minInfinite = true
+	} else {
+		result.min = start.value
+		result.minIncluded = !start.after
+	}
+
+	if end.infinite != 0 {
+		result.maxInfinite = true
+	} else {
+		result.max = end.value
+		result.maxIncluded = end.after
+	}
+
+	return result
+}
+
+// CoveredAtLeast returns the points belonging to at least k intervals, as sorted separated intervals.
+// It sorts boundaries once and sweeps them, so it runs in O(n log(n)).
+// If no point matches, result is just one empty interval (same as Union)
+func (t TypedComparator[T]) CoveredAtLeast(k int, intervals []Interval[T]) []Interval[T] {
+	if k <= 0 {
+		return []Interval[T]{t.NewFullInterval()}
+	}
+
+	var result []Interval[T]
+	events := t.sweepEvents(intervals)
+	coverage := 0
+	covered := false
+	var start sweepPosition[T]
+	for index := 0; index < len(events); {
+		// apply all the events at that position
+		position := events[index].position
+		for index < len(events) && t.comparePositions(events[index].position, position) == 0 {
+			coverage += events[index].delta
+			index++
+		}
+
+		if !covered && coverage >= k {
+			covered = true
+			start = position
+		} else if covered && coverage < k {
+			covered = false
+			result = append(result, t.intervalFromPositions(start, position))
+		}
+	}
+
+	if len(result) == 0 {
+		result = []Interval[T]{t.NewEmptyInterval()}
+	}
+
+	return result
+}
+
+// UnionAll returns the union of intervals as sorted separated intervals.
+// It is the same as Union, in O(n log(n)) instead of O(n^2)
+func (t TypedComparator[T]) UnionAll(intervals []Interval[T]) []Interval[T] {
+	return t.CoveredAtLeast(1, intervals)
+}
@@ -0,0 +1,424 @@
+package nodes
+
+import (
+	"errors"
+	"maps"
+	"slices"
+)
+
+const (
+	// SYNC_ACTIVITY_PART is the part of an element for its activity
+	SYNC_ACTIVITY_PART = "@activity"
+	// SYNC_TRAITS_PART is the part of an element for its traits
+	SYNC_TRAITS_PART = "@traits"
+)
+
+// SyncState is the state of a part of a copy compared to the same part of its equivalence parent
+type SyncState string
+
+const (
+	// SYNC_PARENT_CHANGED means that parent changed since last synchronisation
+	SYNC_PARENT_CHANGED SyncState = "parent_changed"
+	// SYNC_COPY_CHANGED means that copy changed since last synchronisation
+	SYNC_COPY_CHANGED SyncState = "copy_changed"
+	// SYNC_CONFLICT means that both changed since last synchronisation
+	SYNC_CONFLICT SyncState = "conflict"
+	// SYNC_DIFFERENT means that parts differ, with no synchronisation to compare with (copies made before synchronisations)
+	SYNC_DIFFERENT SyncState = "different"
+)
+
+// SyncDifference is a diverging part between a copy and its parent.
+// Part is either SYNC_ACTIVITY_PART, SYNC_TRAITS_PART, an attribute (for entities) or a role (for relations)
+type SyncDifference struct {
+	Part  string
+	State SyncState
+}
+
+// CompareEquivalentElements returns the diverging parts between parent and copy, sorted by part.
+// Base is parent as it was at last synchronisation, nil if unknown (then parts are just different)
+func CompareEquivalentElements(base, parent, copy Element) ([]SyncDifference, error) {
+	if err := checkSyncElements(base, parent, copy); err != nil {
+		return nil, err
+	}
+
+	var result []SyncDifference
+	for _, part := range syncParts(parent, copy) {
+		if sameSyncPart(parent, copy, part) {
+			continue
+		} else if base == nil {
+			result = append(result, SyncDifference{Part: part, State: SYNC_DIFFERENT})
+			continue
+		}
+
+		parentChanged := !sameSyncPart(base, parent, part)
+		copyChanged := !sameSyncPart(base, copy, part)
+		switch {
+		case parentChanged && copyChanged:
+			result = append(result, SyncDifference{Part: part, State: SYNC_CONFLICT})
+		case parentChanged:
+			result = append(result, SyncDifference{Part: part, State: SYNC_PARENT_CHANGED})
+		default:
+			result = append(result, SyncDifference{Part: part, State: SYNC_COPY_CHANGED})
+		}
+	}
+
+	return result, nil
+}
+
+// PullEquivalentElement updates copy with changes of parent since base.
+// Parts changed on both sides are not changed and returned as conflicts, unless force is set (then parent wins).
+// Without base, parent wins
+// It returns the new base to use for next synchronisation
+func PullEquivalentElement(base, parent, copy Element, force bool) (Element, []string, error) {
+	return synchronizeEquivalentElements(base, parent, copy, parent.Id(), force)
+}
+
+// PushEquivalentElement updates parent with changes of copy since base.
+// Parts changed on both sides are not changed and returned as conflicts, unless force is set (then copy wins).
+// Without base, copy wins
+// It returns the new base to use for next synchronisation
+func PushEquivalentElement(base, parent, copy Element, force bool) (Element, []string, error) {
+	return synchronizeEquivalentElements(base, copy, parent, parent.Id(), force)
+}
+
+// synchronizeEquivalentElements changes destination with changes of source since base.
+// New base gets parentId as id
+func synchronizeEquivalentElements(base, source, destination Element, parentId string, force bool) (Element, []string, error) {
+	if err := checkSyncElements(base, source, destination); err != nil {
+		return nil, nil, err
+	}
+
+	var conflicts []string
+	for _, part := range syncParts(source, destination) {
+		if sameSyncPart(source, destination, part) {
+			continue
+		}
+
+		// without base, compare is two-way and source wins
+		sourceChanged := base == nil || !sameSyncPart(base, source, part)
+		destinationChanged := base != nil && !sameSyncPart(base, destination, part)
+		if sourceChanged && destinationChanged && !force {
+			conflicts = append(conflicts, part)
+		} else if sourceChanged || force {
+			if err := copySyncPart(source, destination, part); err != nil {
+				return nil, conflicts, err
+			}
+		}
+	}
+
+	// new base is the synchronized content, but conflicts keep previous base
+	newBase, errBase := cloneForSync(source, parentId)
+	if errBase != nil {
+		return nil, conflicts, errBase
+	}
+
+	for _, part := range syncParts(source, destination) {
+		if slices.Contains(conflicts, part) {
+			if err := copySyncPart(base, newBase, part); err != nil {
+				return nil, conflicts, err
+			}
+		} else if err := copySyncPart(destination, newBase, part); err != nil {
+			return nil, conflicts, err
+		}
+	}
+
+	return newBase, conflicts, nil
+}
+
+// checkSyncElements returns an error if elements cannot be compared
+func checkSyncElements(base, parent, copy Element) error {
+	if parent == nil || copy == nil {
+		return errors.New("nil element")
+	}
+
+	_, parentInstance := parent.(FormalInstance)
+	_, copyInstance := copy.(FormalInstance)
+	if parentInstance != copyInstance {
+		return errors.New("parent and copy should have the same type")
+	} else if base == nil {
+		return nil
+	} else if _, baseInstance := base.(FormalInstance); baseInstance != parentInstance {
+		return errors.New("base and parent should have the same type")
+	}
+
+	return nil
+}
+
+// cloneForSync returns an element with the same type as model and that id, parts are set by caller
+func cloneForSync(model Element, id string) (Element, error) {
+	if _, ok := model.(FormalInstance); ok {
+		entity, err := NewEntityWithId(id, nil, NewFullPeriod())
+		return &entity, err
+	}
+
+	relation := NewRelationWithId(id, nil)
+	return &relation, nil
+}
+
+// syncParts returns the sorted parts of both elements
+func syncParts(a, b Element) []string {
+	parts := []string{SYNC_ACTIVITY_PART, SYNC_TRAITS_PART}
+	for _, element := range []Element{a, b} {
+		if instance, ok := element.(FormalInstance); ok {
+			parts = append(parts, instance.Attributes()...)
+		} else if relation, ok := element.(FormalRelation); ok {
+			for role := range relation.PeriodValuesPerRole() {
+				parts = append(parts, role)
+			}
+		}
+	}
+
+	slices.Sort(parts)
+	return slices.Compact(parts)
+}
+
+// syncPartValues returns the values and periods for a part that is not activity nor traits
+func syncPartValues(element Element, part string) map[string]Period {
+	if instance, ok := element.(FormalInstance); ok {
+		values, _ := instance.PeriodValuesForAttribute(part)
+		return values
+	} else if relation, ok := element.(FormalRelation); ok {
+		return relation.PeriodValuesPerRole()[part]
+	}
+
+	return nil
+}
+
+// sameSyncPart returns true if a and b have the same content for that part
+func sameSyncPart(a, b Element, part string) bool {
+	switch part {
+	case SYNC_ACTIVITY_PART:
+		aActivity := a.ActivePeriod()
+		return aActivity.IsSameAs(b.ActivePeriod())
+	case SYNC_TRAITS_PART:
+		aTraits, bTraits := a.Traits(), b.Traits()
+		slices.Sort(aTraits)
+		slices.Sort(bTraits)
+		return slices.Equal(aTraits, bTraits)
+	}
+
+	aValues, bValues := syncPartValues(a, part), syncPartValues(b, part)
+	if len(aValues) != len(bValues) {
+		return false
+	}
+
+	for value, period := range aValues {
+		if bPeriod, found := bValues[value]; !found || !bPeriod.IsSameAs(period) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// copySyncPart sets the part of destination to the part of source
+func copySyncPart(source, destination Element, part string) error {
+	switch part {
+	case SYNC_ACTIVITY_PART:
+		return destination.SetActivePeriod(source.ActivePeriod())
+	case SYNC_TRAITS_PART:
+		sourceTraits := source.Traits()
+		for _, trait := range destination.Traits() {
+			if !slices.Contains(sourceTraits, trait) {
+				destination.RemoveTrait(trait)
+			}
+		}
+
+		for _, trait := range sourceTraits {
+			if err := destination.AddTrait(trait); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return setSyncPartValues(destination, part, syncPartValues(source, part))
+}
+
+// setSyncPartValues sets values and periods of a part that is not activity nor traits
+func setSyncPartValues(destination Element, part string, values map[string]Period) error {
+	if instance, ok := destination.(FormalInstance); ok {
+		if err := instance.RemovePeriodForAttribute(part, NewFullPeriod()); err != nil {
+			return err
+		}
+
+		for value, period := range values {
+			if err := instance.AddValue(part, value, period); err != nil {
+				return err
+			}
+		}
+	} else if relation, ok := destination.(FormalRelation); ok {
+		relation.ClearRoleValues(part)
+		for value, period := range values {
+			if err := relation.AddPeriodValueForRole(part, value, period); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// MergeConflict is a value changed differently on both sides of a three-way merge.
+// Value is empty for activity, the trait for traits, and the value (or operand) for an attribute (or role).
+// For attributes, empty value means that merged values would overlap
+type MergeConflict struct {
+	Part  string
+	Value string
+}
+
+// MergeEquivalentElements applies to ours the changes of theirs since base, value per value.
+// Values changed on one side only are merged, values changed differently on both sides keep ours and are returned as conflicts.
+// Without base, any difference is a conflict
+func MergeEquivalentElements(base, ours, theirs Element) ([]MergeConflict, error) {
+	if err := checkSyncElements(base, ours, theirs); err != nil {
+		return nil, err
+	}
+
+	var conflicts []MergeConflict
+	for _, part := range syncParts(ours, theirs) {
+		switch part {
+		case SYNC_ACTIVITY_PART:
+			var baseActivity *Period
+			if base != nil {
+				activity := base.ActivePeriod()
+				baseActivity = &activity
+			}
+
+			merged, conflict := mergePeriods(baseActivity, ours.ActivePeriod(), theirs.ActivePeriod())
+			if conflict {
+				conflicts = append(conflicts, MergeConflict{Part: part})
+			} else if err := ours.SetActivePeriod(merged); err != nil {
+				return conflicts, err
+			}
+		case SYNC_TRAITS_PART:
+			merged, traitsConflicts := mergePartValues(traitsAsPeriods(base), traitsAsPeriods(ours), traitsAsPeriods(theirs), base == nil)
+			for _, trait := range traitsConflicts {
+				conflicts = append(conflicts, MergeConflict{Part: part, Value: trait})
+			}
+
+			for _, trait := range ours.Traits() {
+				if _, found := merged[trait]; !found {
+					ours.RemoveTrait(trait)
+				}
+			}
+
+			for _, trait := range slices.Sorted(maps.Keys(merged)) {
+				if err := ours.AddTrait(trait); err != nil {
+					return conflicts, err
+				}
+			}
+		default:
+			var baseValues map[string]Period
+			if base != nil {
+				baseValues = syncPartValues(base, part)
+			}
+
+			merged, valuesConflicts := mergePartValues(baseValues, syncPartValues(ours, part), syncPartValues(theirs, part), base == nil)
+			for _, value := range valuesConflicts {
+				conflicts = append(conflicts, MergeConflict{Part: part, Value: value})
+			}
+
+			if _, isInstance := ours.(FormalInstance); isInstance && haveOverlappingPeriods(merged) {
+				// an attribute has one value at a time, keep ours
+				conflicts = append(conflicts, MergeConflict{Part: part})
+			} else if err := setSyncPartValues(ours, part, merged); err != nil {
+				return conflicts, err
+			}
+		}
+	}
+
+	return conflicts, nil
+}
+
+// mergePeriods returns the three-way merge of a period, and true for a conflict (then ours is returned).
+// Nil base means no base
+func mergePeriods(base *Period, ours, theirs Period) (Period, bool) {
+	switch {
+	case ours.IsSameAs(theirs):
+		return ours, false
+	case base == nil:
+		return ours, true
+	case ours.IsSameAs(*base):
+		return theirs, false
+	case theirs.IsSameAs(*base):
+		return ours, false
+	default:
+		return ours, true
+	}
+}
+
+// mergePartValues merges values per value, and returns non empty merged values and sorted conflicting values.
+// Missing value means empty period
+func mergePartValues(base, ours, theirs map[string]Period, noBase bool) (map[string]Period, []string) {
+	values := slices.Sorted(maps.Keys(ours))
+	values = append(values, slices.Collect(maps.Keys(theirs))...)
+	values = append(values, slices.Collect(maps.Keys(base))...)
+	slices.Sort(values)
+	values = slices.Compact(values)
+
+	result := make(map[string]Period)
+	var conflicts []string
+	for _, value := range values {
+		var basePeriod *Period
+		if !noBase {
+			period := NewEmptyPeriod()
+			if previous, found := base[value]; found {
+				period = previous
+			}
+
+			basePeriod = &period
+		}
+
+		oursPeriod, theirsPeriod := NewEmptyPeriod(), NewEmptyPeriod()
+		if period, found := ours[value]; found {
+			oursPeriod = period
+		}
+
+		if period, found := theirs[value]; found {
+			theirsPeriod = period
+		}
+
+		merged, conflict := mergePeriods(basePeriod, oursPeriod, theirsPeriod)
+		if conflict {
+			conflicts = append(conflicts, value)
+		}
+
+		if !merged.IsEmptyPeriod() {
+			result[value] = merged
+		}
+	}
+
+	return result, conflicts
+}
+
+// traitsAsPeriods returns traits of element with full period, nil for nil element
+func traitsAsPeriods(element Element) map[string]Period {
+	if element == nil {
+		return nil
+	}
+
+	result := make(map[string]Period)
+	for _, trait := range element.Traits() {
+		result[trait] = NewFullPeriod()
+	}
+
+	return result
+}
+
+// haveOverlappingPeriods returns true if at least two values have periods in common
+func haveOverlappingPeriods(values map[string]Period) bool {
+	seen := NewEmptyPeriod()
+	for _, period := range values {
+		common := NewPeriodCopy(seen)
+		common.Intersection(period)
+		if !common.IsEmptyPeriod() {
+			return true
+		} else if err := seen.Add(period); err != nil {
+			return true
+		}
+	}
+
+	return false
+}
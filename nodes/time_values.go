@@ -239,7 +239,7 @@ func (a *ActiveTimeValues) SetPeriodForValue(attribute string, value string, per
 		return nil
 	}
 
-	return a.SetPeriodForValue(attribute, value, period)
+	return a.values.SetPeriodForValue(attribute, value, period)
 }
 
 // RemovePeriodForAttribute just removes period, no matter the value, for that attribute
@@ -277,7 +277,7 @@ func (a *ActiveTimeValues) RemovePeriodForAttribute(attribute string, period Per
 		return nil
 	}
 
-	return a.RemovePeriodForAttribute(attribute, period)
+	return a.values.RemovePeriodForAttribute(attribute, period)
 }
 
 // ValuesForAttribute returns the values for an attribute as a sorted slice
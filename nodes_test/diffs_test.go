@@ -0,0 +1,39 @@
+package nodes_test
+
+import (
+	"testing"
+
+	"github.com/zefrenchwan/patterns.git/nodes"
+)
+
+func TestDiffElements(t *testing.T) {
+	before := nodes.NewEntity([]string{"person"})
+	before.SetValue("name", "Jean")
+	before.SetValue("city", "Paris")
+
+	after, _ := nodes.NewEntityWithId(before.Id(), []string{"person", "employee"}, nodes.NewFullPeriod())
+	after.SetValue("name", "John")
+	after.SetValue("city", "Paris")
+
+	changes := nodes.DiffElements(&before, &after)
+	if len(changes) != 2 {
+		t.Fatalf("expecting traits and name changes, got %v", changes)
+	} else if changes[0].Part != nodes.SYNC_TRAITS_PART || len(changes[0].Before) != 0 || len(changes[0].After) != 1 {
+		t.Errorf("unexpected traits change %v", changes[0])
+	} else if _, found := changes[1].Before["Jean"]; changes[1].Part != "name" || !found {
+		t.Errorf("unexpected name change %v", changes[1])
+	} else if _, found := changes[1].After["John"]; !found || len(changes[1].After) != 1 {
+		t.Errorf("unexpected name change %v", changes[1])
+	}
+
+	// deletion has no value after
+	for _, change := range nodes.DiffElements(&before, nil) {
+		if len(change.After) != 0 || len(change.Before) == 0 {
+			t.Errorf("unexpected deletion change %v", change)
+		}
+	}
+
+	if changes := nodes.DiffElements(&before, &before); len(changes) != 0 {
+		t.Errorf("same element should have no change, got %v", changes)
+	}
+}
@@ -0,0 +1,97 @@
+package nodes_test
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/zefrenchwan/patterns.git/nodes"
+)
+
+func TestIntervalTreeStabbing(t *testing.T) {
+	comparator := nodes.NewIntComparator()
+	tree := nodes.NewIntervalTree[int, string](comparator)
+
+	first, _ := comparator.NewFiniteInterval(0, 10, true, false)
+	second, _ := comparator.NewFiniteInterval(5, 15, false, true)
+	tree.Insert(first, "first")
+	tree.Insert(second, "second")
+	tree.Insert(comparator.NewFullInterval(), "full")
+	tree.Insert(comparator.NewRightInfiniteInterval(20, true), "after")
+	tree.Insert(comparator.NewEmptyInterval(), "empty")
+
+	if tree.Len() != 4 {
+		t.Errorf("expecting 4 values, got %d", tree.Len())
+	}
+
+	values := tree.Stabbing(5)
+	slices.Sort(values)
+	if !slices.Equal(values, []string{"first", "full"}) {
+		t.Errorf("bad stabbing for 5: %v", values)
+	}
+
+	values = tree.Stabbing(20)
+	slices.Sort(values)
+	if !slices.Equal(values, []string{"after", "full"}) {
+		t.Errorf("bad stabbing for 20: %v", values)
+	}
+
+	query, _ := comparator.NewFiniteInterval(15, 20, true, false)
+	values = tree.Overlapping(query)
+	slices.Sort(values)
+	if !slices.Equal(values, []string{"full", "second"}) {
+		t.Errorf("bad overlapping: %v", values)
+	}
+
+	if !tree.Remove(comparator.NewFullInterval(), "full") {
+		t.Error("full interval should be removed")
+	} else if tree.Remove(comparator.NewFullInterval(), "full") {
+		t.Error("full interval was already removed")
+	} else if values := tree.Stabbing(-1); len(values) != 0 {
+		t.Errorf("no value expected, got %v", values)
+	}
+}
+
+func TestIntervalTreeMatchesLinearScan(t *testing.T) {
+	comparator := nodes.NewIntComparator()
+	tree := nodes.NewIntervalTree[int, int](comparator)
+	random := rand.New(rand.NewPCG(1, 2))
+
+	intervals := make(map[int]nodes.Interval[int])
+	for index := 0; index < 500; index++ {
+		left := random.IntN(1000)
+		right := left + random.IntN(50)
+		interval, err := comparator.NewFiniteInterval(left, right, random.IntN(2) == 0 || left == right, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		intervals[index] = interval
+		tree.Insert(interval, index)
+	}
+
+	// remove half of them to test tree after deletions
+	for index := 0; index < 500; index += 2 {
+		if !tree.Remove(intervals[index], index) {
+			t.Fatalf("failed to remove %d", index)
+		}
+
+		delete(intervals, index)
+	}
+
+	for point := -10; point < 1060; point += 7 {
+		var expected []int
+		for index, interval := range intervals {
+			if comparator.ContainsInterval(interval, point) {
+				expected = append(expected, index)
+			}
+		}
+
+		values := tree.Stabbing(point)
+		slices.Sort(expected)
+		slices.Sort(values)
+		if !slices.Equal(expected, values) {
+			t.Fatalf("stabbing for %d: expected %v, got %v", point, expected, values)
+		}
+	}
+}
@@ -672,3 +672,37 @@ func TestRemoveSplit(t *testing.T) {
 		t.Error("failed splitting right infinite")
 	}
 }
+
+func TestRemoveSeveralSeparatedElements(t *testing.T) {
+	comparator := nodes.NewIntComparator()
+
+	// each removal starts from the result of the previous one only
+	base, _ := comparator.NewFiniteInterval(0, 10, true, true)
+	first, _ := comparator.NewFiniteInterval(1, 2, true, true)
+	second, _ := comparator.NewFiniteInterval(5, 6, true, true)
+	expectedOne, _ := comparator.NewFiniteInterval(0, 1, true, false)
+	expectedTwo, _ := comparator.NewFiniteInterval(2, 5, false, false)
+	expectedThree, _ := comparator.NewFiniteInterval(6, 10, false, true)
+	result := comparator.Remove(base, first, second)
+	if !slicesIntervalCompare(comparator, []nodes.Interval[int]{expectedOne, expectedTwo, expectedThree}, result) {
+		t.Errorf("failed removing separated elements, got %v", result)
+	}
+}
+
+func TestContainingIntervalForInfiniteFirst(t *testing.T) {
+	comparator := nodes.NewIntComparator()
+
+	// infinite bound of the first interval should remain infinite
+	first := comparator.NewLeftInfiniteInterval(1, true)
+	second, _ := comparator.NewFiniteInterval(5, 6, true, true)
+	expected := comparator.NewLeftInfiniteInterval(6, true)
+	if result := comparator.ContainingIntervalFor([]nodes.Interval[int]{first, second}); comparator.CompareInterval(expected, result) != 0 {
+		t.Errorf("expecting left infinite interval, got %v", result)
+	}
+
+	first = comparator.NewRightInfiniteInterval(10, false)
+	expected = comparator.NewRightInfiniteInterval(5, true)
+	if result := comparator.ContainingIntervalFor([]nodes.Interval[int]{first, second}); comparator.CompareInterval(expected, result) != 0 {
+		t.Errorf("expecting right infinite interval, got %v", result)
+	}
+}
@@ -0,0 +1,68 @@
+package nodes_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/zefrenchwan/patterns.git/nodes"
+)
+
+func TestMergeEntities(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	before := now.AddDate(-10, 0, 0)
+	after := now.AddDate(10, 0, 0)
+
+	targetInterval, _ := nodes.NewFiniteTimeInterval(before, now, true, false)
+	target, _ := nodes.NewEntityDuring([]string{"person"}, nodes.NewPeriod(targetInterval))
+	target.AddValue("name", "Jean", nodes.NewPeriod(targetInterval))
+
+	source := nodes.NewEntity([]string{"employee"})
+	source.SetValue("name", "John")
+	source.SetValue("job", "developer")
+
+	if err := nodes.MergeEntities(&target, &source); err != nil {
+		t.Fatal(err)
+	}
+
+	activity := target.ActivePeriod()
+	if !activity.IsFullPeriod() {
+		t.Error("activity should be the union of activities")
+	}
+
+	traits := target.Traits()
+	if !slices.Contains(traits, "person") || !slices.Contains(traits, "employee") {
+		t.Error("traits should be the union of traits")
+	}
+
+	// target wins during its own period, source values complete it otherwise
+	names, _ := target.PeriodValuesForAttribute("name")
+	if jean := names["Jean"]; !jean.Contains(before) || jean.Contains(now) {
+		t.Error("target value should remain during its period")
+	} else if john := names["John"]; john.Contains(before) || !john.Contains(now) || !john.Contains(after) {
+		t.Error("source value should complete target value")
+	}
+
+	if jobs, _ := target.PeriodValuesForAttribute("job"); len(jobs) != 1 {
+		t.Error("new attributes should be added")
+	}
+
+	if err := nodes.MergeEntities(&target, &target); err == nil {
+		t.Error("merging an entity with itself should fail")
+	}
+}
+
+func TestPeriodRemoveSeparatedIntervals(t *testing.T) {
+	now := time.Now().UTC()
+	base, _ := nodes.NewFiniteTimeInterval(now, now.AddDate(0, 0, 100), true, true)
+	first, _ := nodes.NewFiniteTimeInterval(now.AddDate(0, 0, 10), now.AddDate(0, 0, 20), true, true)
+	second, _ := nodes.NewFiniteTimeInterval(now.AddDate(0, 0, 30), now.AddDate(0, 0, 40), true, true)
+
+	period := nodes.NewPeriod(base)
+	period.Remove(nodes.NewPeriodUnion(nodes.NewPeriod(first), nodes.NewPeriod(second)))
+	if size := len(period.AsIntervals()); size != 3 {
+		t.Errorf("expecting three intervals, got %d", size)
+	} else if period.Contains(now.AddDate(0, 0, 35)) || !period.Contains(now.AddDate(0, 0, 25)) {
+		t.Error("removal of separated intervals failed")
+	}
+}
@@ -0,0 +1,105 @@
+package nodes_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/zefrenchwan/patterns.git/nodes"
+)
+
+func TestSplitEntity(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	before := now.AddDate(-1, 0, 0)
+	after := now.AddDate(1, 0, 0)
+
+	company := nodes.NewEntity([]string{"company"})
+	company.SetValue("name", "Acme")
+	beforeInterval := nodes.NewLeftInfiniteTimeInterval(now, false)
+	company.AddValue("ceo", "Jean", nodes.NewPeriod(beforeInterval))
+
+	split, errSplit := nodes.SplitEntity(&company, now, "spin-off")
+	if errSplit != nil {
+		t.Fatal(errSplit)
+	} else if split.Id() != "spin-off" {
+		t.Error("bad id for split entity")
+	} else if !slices.Equal(split.Traits(), company.Traits()) {
+		t.Error("split entity should have same traits")
+	}
+
+	if activity := company.ActivePeriod(); !activity.Contains(before) || activity.Contains(now) {
+		t.Error("entity should remain active before split only")
+	} else if activity := split.ActivePeriod(); activity.Contains(before) || !activity.Contains(now) || !activity.Contains(after) {
+		t.Error("split entity should be active since split")
+	}
+
+	splitNames, _ := split.PeriodValuesForAttribute("name")
+	companyNames, _ := company.PeriodValuesForAttribute("name")
+	if name := splitNames["Acme"]; !name.Contains(after) || name.Contains(before) {
+		t.Error("split entity should get values since split")
+	} else if name := companyNames["Acme"]; !name.Contains(before) || name.Contains(after) {
+		t.Error("entity should keep values before split")
+	} else if ceos, _ := split.PeriodValuesForAttribute("ceo"); len(ceos) != 0 {
+		t.Error("values before split should not be copied")
+	}
+
+	if _, err := nodes.SplitEntity(&company, after, "other"); err == nil {
+		t.Error("inactive entity after moment should not be split")
+	}
+}
+
+func TestSplitEntitySkipsEmptyPeriods(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+
+	company := nodes.NewEntity([]string{"company"})
+	company.SetValue("name", "Acme")
+	company.AddValue("ceo", "Jean", nodes.NewPeriod(nodes.NewLeftInfiniteTimeInterval(now, false)))
+	company.AddValue("cfo", "Marie", nodes.NewPeriod(nodes.NewRightInfiniteTimeInterval(now, true)))
+
+	split, errSplit := nodes.SplitEntity(&company, now, "spin-off")
+	if errSplit != nil {
+		t.Fatal(errSplit)
+	} else if slices.Contains(split.Attributes(), "ceo") {
+		t.Error("values before split should not add attributes to split entity")
+	}
+
+	for _, instance := range []nodes.FormalInstance{&company, split} {
+		for _, attribute := range instance.Attributes() {
+			values, _ := instance.PeriodValuesForAttribute(attribute)
+			for value, period := range values {
+				if period.IsEmptyPeriod() {
+					t.Errorf("empty period for %s = %s in %s", attribute, value, instance.Id())
+				}
+			}
+		}
+	}
+}
+
+func TestSplitOperand(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	before := now.AddDate(-1, 0, 0)
+	after := now.AddDate(1, 0, 0)
+
+	relation := nodes.NewRelation([]string{"owns"})
+	relation.AddPeriodValueForRole("subject", "company", nodes.NewFullPeriod())
+	relation.AddPeriodValueForRole("object", "building", nodes.NewFullPeriod())
+
+	if rewired, err := nodes.SplitOperand(&relation, "company", "spin-off", now); err != nil {
+		t.Fatal(err)
+	} else if !rewired {
+		t.Error("relation should be rewired")
+	}
+
+	links := relation.PeriodValuesPerRole()
+	if previous := links["subject"]["company"]; !previous.Contains(before) || previous.Contains(after) {
+		t.Error("previous operand should remain before split")
+	} else if next := links["subject"]["spin-off"]; next.Contains(before) || !next.Contains(now) {
+		t.Error("next operand should be linked since split")
+	} else if object := links["object"]["building"]; !object.IsFullPeriod() {
+		t.Error("other operands should not change")
+	}
+
+	if rewired, _ := nodes.SplitOperand(&relation, "unknown", "spin-off", now); rewired {
+		t.Error("relation without operand should not change")
+	}
+}
@@ -0,0 +1,107 @@
+package nodes_test
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/zefrenchwan/patterns.git/nodes"
+)
+
+func TestUnionAllMatchesUnion(t *testing.T) {
+	comparator := nodes.NewIntComparator()
+	random := rand.New(rand.NewPCG(3, 4))
+
+	for round := 0; round < 50; round++ {
+		var intervals []nodes.Interval[int]
+		for index := 0; index < 20; index++ {
+			left := random.IntN(100)
+			right := left + random.IntN(10)
+			leftIn, rightIn := random.IntN(2) == 0, random.IntN(2) == 0
+			if left == right {
+				leftIn, rightIn = true, true
+			}
+
+			interval, _ := comparator.NewFiniteInterval(left, right, leftIn, rightIn)
+			intervals = append(intervals, interval)
+		}
+
+		expected := comparator.Union(intervals[0], intervals[1:]...)
+		slices.SortFunc(expected, comparator.CompareInterval)
+		result := comparator.UnionAll(intervals)
+		if !slices.EqualFunc(expected, result, func(a, b nodes.Interval[int]) bool {
+			return comparator.CompareInterval(a, b) == 0
+		}) {
+			t.Fatalf("union mismatch: expected %v, got %v", expected, result)
+		}
+	}
+}
+
+func TestCoveredAtLeast(t *testing.T) {
+	comparator := nodes.NewIntComparator()
+	first, _ := comparator.NewFiniteInterval(0, 10, true, true)
+	second, _ := comparator.NewFiniteInterval(5, 15, false, true)
+	third := comparator.NewRightInfiniteInterval(8, true)
+
+	result := comparator.CoveredAtLeast(2, []nodes.Interval[int]{first, second, third})
+	expected, _ := comparator.NewFiniteInterval(5, 15, false, true)
+	if len(result) != 1 || comparator.CompareInterval(result[0], expected) != 0 {
+		t.Errorf("expecting ]5, 15], got %v", result)
+	}
+
+	result = comparator.CoveredAtLeast(3, []nodes.Interval[int]{first, second, third})
+	expectedAll, _ := comparator.NewFiniteInterval(8, 10, true, true)
+	if len(result) != 1 || comparator.CompareInterval(result[0], expectedAll) != 0 {
+		t.Errorf("expecting [8, 10], got %v", result)
+	}
+
+	result = comparator.CoveredAtLeast(4, []nodes.Interval[int]{first, second, third})
+	if len(result) != 1 || !result[0].IsEmpty() {
+		t.Errorf("expecting empty, got %v", result)
+	}
+
+	// touching intervals are joined, open boundaries are not
+	touching, _ := comparator.NewFiniteInterval(10, 12, false, true)
+	result = comparator.UnionAll([]nodes.Interval[int]{first, touching})
+	if len(result) != 1 {
+		t.Errorf("expecting one interval, got %v", result)
+	}
+
+	separated, _ := comparator.NewFiniteInterval(10, 12, false, true)
+	openFirst, _ := comparator.NewFiniteInterval(0, 10, true, false)
+	result = comparator.UnionAll([]nodes.Interval[int]{openFirst, separated})
+	if len(result) != 2 {
+		t.Errorf("expecting two intervals, got %v", result)
+	}
+}
+
+func TestPeriodBulkOperations(t *testing.T) {
+	now := time.Now().UTC()
+	var periods []nodes.Period
+	for index := 0; index < 10; index++ {
+		start := now.AddDate(index, 0, 0)
+		interval, _ := nodes.NewFiniteTimeInterval(start, start.AddDate(2, 0, 0), true, false)
+		periods = append(periods, nodes.NewPeriod(interval))
+	}
+
+	union := nodes.NewPeriodUnion(periods...)
+	expectedUnion, _ := nodes.NewFiniteTimeInterval(now, now.AddDate(11, 0, 0), true, false)
+	if intervals := union.AsIntervals(); len(intervals) != 1 || nodes.TimeIntervalsCompare(intervals[0], expectedUnion) != 0 {
+		t.Errorf("bad union: %v", intervals)
+	}
+
+	twice := nodes.NewPeriodCoveredAtLeast(2, periods...)
+	expectedTwice, _ := nodes.NewFiniteTimeInterval(now.AddDate(1, 0, 0), now.AddDate(10, 0, 0), true, false)
+	if intervals := twice.AsIntervals(); len(intervals) != 1 || nodes.TimeIntervalsCompare(intervals[0], expectedTwice) != 0 {
+		t.Errorf("bad coverage: %v", intervals)
+	}
+
+	if intersection := nodes.NewPeriodIntersection(periods...); !intersection.IsEmptyPeriod() {
+		t.Error("intersection should be empty")
+	} else if intersection := nodes.NewPeriodIntersection(periods[0], periods[1]); intersection.IsEmptyPeriod() {
+		t.Error("intersection should not be empty")
+	} else if full := nodes.NewPeriodUnion(nodes.NewFullPeriod(), periods[0]); !full.IsFullPeriod() {
+		t.Error("union with full should be full")
+	}
+}
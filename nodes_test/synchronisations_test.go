@@ -0,0 +1,178 @@
+package nodes_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/zefrenchwan/patterns.git/nodes"
+)
+
+func TestPullEquivalentElement(t *testing.T) {
+	base := nodes.NewEntity([]string{"person"})
+	base.SetValue("name", "Jean")
+	base.SetValue("job", "developer")
+
+	parent, _ := nodes.NewEntityWithId(base.Id(), []string{"person"}, nodes.NewFullPeriod())
+	parent.SetValue("name", "John")
+	parent.SetValue("job", "manager")
+
+	copy := nodes.NewEntity([]string{"person"})
+	copy.SetValue("name", "Jean")
+	copy.SetValue("job", "architect")
+
+	differences, errCompare := nodes.CompareEquivalentElements(&base, &parent, &copy)
+	if errCompare != nil {
+		t.Fatal(errCompare)
+	} else if !slices.Equal(differences, []nodes.SyncDifference{
+		{Part: "job", State: nodes.SYNC_CONFLICT},
+		{Part: "name", State: nodes.SYNC_PARENT_CHANGED},
+	}) {
+		t.Errorf("unexpected differences %v", differences)
+	}
+
+	newBase, conflicts, errPull := nodes.PullEquivalentElement(&base, &parent, &copy, false)
+	if errPull != nil {
+		t.Fatal(errPull)
+	} else if !slices.Equal(conflicts, []string{"job"}) {
+		t.Errorf("expecting job conflict, got %v", conflicts)
+	} else if names, _ := copy.ValuesForAttribute("name"); !slices.Equal(names, []string{"John"}) {
+		t.Errorf("name should be pulled, got %v", names)
+	} else if jobs, _ := copy.ValuesForAttribute("job"); !slices.Equal(jobs, []string{"architect"}) {
+		t.Errorf("conflicting job should not change, got %v", jobs)
+	}
+
+	// conflict remains with new base, other parts are synchronized
+	differences, _ = nodes.CompareEquivalentElements(newBase, &parent, &copy)
+	if !slices.Equal(differences, []nodes.SyncDifference{{Part: "job", State: nodes.SYNC_CONFLICT}}) {
+		t.Errorf("unexpected differences after pull %v", differences)
+	}
+
+	// push with force makes copy win
+	if _, conflicts, err := nodes.PushEquivalentElement(newBase, &parent, &copy, true); err != nil {
+		t.Fatal(err)
+	} else if len(conflicts) != 0 {
+		t.Error("forced push should not report conflicts")
+	} else if !nodes.AreSameElements(&parent, &copy) {
+		t.Error("parent and copy should be the same after forced push")
+	}
+}
+
+func TestPushEquivalentRelation(t *testing.T) {
+	base := nodes.NewRelation([]string{"knows"})
+	base.SetValuesForRole("subject", []string{"a"})
+	base.SetValuesForRole("object", []string{"b"})
+
+	parent := nodes.NewRelationWithId(base.Id(), []string{"knows"})
+	parent.SetValuesForRole("subject", []string{"a"})
+	parent.SetValuesForRole("object", []string{"b"})
+
+	copy := nodes.NewRelation([]string{"knows", "likes"})
+	copy.SetValuesForRole("subject", []string{"a"})
+	copy.SetValuesForRole("object", []string{"c"})
+
+	newBase, conflicts, err := nodes.PushEquivalentElement(&base, &parent, &copy, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(conflicts) != 0 {
+		t.Errorf("unexpected conflicts %v", conflicts)
+	} else if !nodes.AreSameElements(&parent, &copy) {
+		t.Error("copy changes should be pushed")
+	} else if newBase.Id() != parent.Id() {
+		t.Error("base should have parent id")
+	} else if !nodes.AreSameElements(newBase, &parent) {
+		t.Error("base should be the synchronized parent")
+	}
+}
+
+func TestSynchronisationWithoutBase(t *testing.T) {
+	parent := nodes.NewEntity([]string{"person"})
+	parent.SetValue("name", "John")
+	parent.SetValue("job", "manager")
+
+	copy := nodes.NewEntity([]string{"person"})
+	copy.SetValue("name", "John")
+	copy.SetValue("job", "architect")
+
+	// copies made before synchronisations have no base, parts are only different
+	differences, errCompare := nodes.CompareEquivalentElements(nil, &parent, &copy)
+	if errCompare != nil {
+		t.Fatal(errCompare)
+	} else if !slices.Equal(differences, []nodes.SyncDifference{{Part: "job", State: nodes.SYNC_DIFFERENT}}) {
+		t.Errorf("unexpected differences %v", differences)
+	}
+
+	newBase, conflicts, errPull := nodes.PullEquivalentElement(nil, &parent, &copy, false)
+	if errPull != nil {
+		t.Fatal(errPull)
+	} else if len(conflicts) != 0 {
+		t.Errorf("no base should not report conflicts, got %v", conflicts)
+	} else if !nodes.AreSameElements(&parent, &copy) {
+		t.Error("parent should win without base")
+	} else if newBase == nil || newBase.Id() != parent.Id() {
+		t.Error("pull should set a base")
+	}
+}
+
+func TestMergeEquivalentElements(t *testing.T) {
+	base := nodes.NewEntity([]string{"person"})
+	base.SetValue("name", "Jean")
+	base.SetValue("job", "developer")
+	base.SetValue("city", "Paris")
+
+	ours, _ := nodes.NewEntityWithId(base.Id(), []string{"person"}, nodes.NewFullPeriod())
+	ours.SetValue("name", "Jean")
+	ours.SetValue("job", "manager")
+	ours.SetValue("city", "Paris")
+
+	theirs, _ := nodes.NewEntityWithId(base.Id(), []string{"person", "employee"}, nodes.NewFullPeriod())
+	theirs.SetValue("name", "John")
+	theirs.SetValue("job", "architect")
+	theirs.SetValue("city", "Paris")
+
+	conflicts, err := nodes.MergeEquivalentElements(&base, &ours, &theirs)
+	if err != nil {
+		t.Fatal(err)
+	} else if !slices.Equal(conflicts, []nodes.MergeConflict{{Part: "job"}}) {
+		t.Errorf("unexpected conflicts %v", conflicts)
+	} else if names, _ := ours.ValuesForAttribute("name"); !slices.Equal(names, []string{"John"}) {
+		t.Errorf("name should be merged, got %v", names)
+	} else if jobs, _ := ours.ValuesForAttribute("job"); !slices.Equal(jobs, []string{"manager"}) {
+		t.Errorf("conflicting job should keep ours, got %v", jobs)
+	} else if traits := ours.Traits(); !slices.Contains(traits, "employee") {
+		t.Errorf("new trait should be merged, got %v", traits)
+	}
+}
+
+func TestMergeEquivalentRelations(t *testing.T) {
+	base := nodes.NewRelation([]string{"knows"})
+	base.SetValuesForRole("subject", []string{"a"})
+	base.SetValuesForRole("object", []string{"b", "c"})
+
+	ours := nodes.NewRelationWithId(base.Id(), []string{"knows"})
+	ours.SetValuesForRole("subject", []string{"a"})
+	ours.SetValuesForRole("object", []string{"b", "d"})
+
+	theirs := nodes.NewRelationWithId(base.Id(), []string{"knows"})
+	theirs.SetValuesForRole("subject", []string{"a"})
+	theirs.SetValuesForRole("object", []string{"c", "e"})
+
+	conflicts, err := nodes.MergeEquivalentElements(&base, &ours, &theirs)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(conflicts) != 0 {
+		t.Errorf("operands changes should not conflict, got %v", conflicts)
+	}
+
+	objects := ours.ValuesPerRole()["object"]
+	slices.Sort(objects)
+	if !slices.Equal(objects, []string{"d", "e"}) {
+		t.Errorf("unexpected merged operands %v", objects)
+	}
+
+	// without base, differences are conflicts
+	other := nodes.NewRelationWithId(base.Id(), []string{"knows"})
+	other.SetValuesForRole("subject", []string{"a"})
+	if conflicts, _ := nodes.MergeEquivalentElements(nil, &other, &theirs); len(conflicts) != 2 {
+		t.Errorf("expecting conflicts for each different operand, got %v", conflicts)
+	}
+}
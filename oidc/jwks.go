@@ -0,0 +1,99 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+// JsonWebKey is a public key of a JSON web key set (RFC 7517)
+type JsonWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyId     string `json:"kid"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// JsonWebKeySet is the content of a jwks uri
+type JsonWebKeySet struct {
+	Keys []JsonWebKey `json:"keys"`
+}
+
+// PublicKey returns the public key of a RSA, EC or Ed25519 key
+func (k JsonWebKey) PublicKey() (crypto.PublicKey, error) {
+	encoding := base64.RawURLEncoding
+	decode := func(value string) (*big.Int, error) {
+		content, err := encoding.DecodeString(value)
+		if err != nil {
+			return nil, err
+		}
+
+		return new(big.Int).SetBytes(content), nil
+	}
+
+	switch k.KeyType {
+	case "RSA":
+		modulus, errN := decode(k.N)
+		if errN != nil {
+			return nil, errN
+		}
+
+		exponent, errE := decode(k.E)
+		if errE != nil {
+			return nil, errE
+		} else if !exponent.IsInt64() || exponent.Int64() > 1<<31 {
+			return nil, fmt.Errorf("invalid exponent for key %s", k.KeyId)
+		}
+
+		return &rsa.PublicKey{N: modulus, E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s for key %s", k.Curve, k.KeyId)
+		}
+
+		x, errX := decode(k.X)
+		if errX != nil {
+			return nil, errX
+		}
+
+		y, errY := decode(k.Y)
+		if errY != nil {
+			return nil, errY
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s for key %s", k.Curve, k.KeyId)
+		}
+
+		content, err := encoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		} else if len(content) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid size for key %s", k.KeyId)
+		}
+
+		return ed25519.PublicKey(content), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s for key %s", k.KeyType, k.KeyId)
+	}
+}
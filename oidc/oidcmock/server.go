@@ -0,0 +1,223 @@
+// Package oidcmock is a local OpenID Connect issuer, to test a relying party without an identity provider
+package oidcmock
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/zefrenchwan/patterns.git/oidc"
+)
+
+// TOKEN_DURATION is the validity of issued tokens
+const TOKEN_DURATION = 5 * time.Minute
+
+// pendingCode is an authorization code waiting for its exchange
+type pendingCode struct {
+	redirectUri string
+	nonce       string
+	challenge   string
+}
+
+// Server is a mock issuer. Any user that reaches the authorization endpoint is logged in as the current user
+type Server struct {
+	*httptest.Server
+	ClientId     string
+	ClientSecret string
+	keyId        string
+	key          *rsa.PrivateKey
+	// mutex protects subject, claims, codes and jwksCalls
+	mutex     sync.Mutex
+	subject   string
+	claims    map[string]any
+	codes     map[string]pendingCode
+	jwksCalls int
+}
+
+// NewServer starts a mock issuer for a client. Caller closes it
+func NewServer(clientId, clientSecret string) (*Server, error) {
+	key, errKey := rsa.GenerateKey(rand.Reader, 2048)
+	if errKey != nil {
+		return nil, errKey
+	}
+
+	server := &Server{
+		ClientId:     clientId,
+		ClientSecret: clientSecret,
+		keyId:        uuid.NewString(),
+		key:          key,
+		codes:        make(map[string]pendingCode),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidc.DISCOVERY_PATH, server.discoveryHandler)
+	mux.HandleFunc("/jwks", server.jwksHandler)
+	mux.HandleFunc("/authorize", server.authorizeHandler)
+	mux.HandleFunc("/token", server.tokenHandler)
+	server.Server = httptest.NewServer(mux)
+	return server, nil
+}
+
+// Issuer returns the issuer url
+func (s *Server) Issuer() string {
+	return s.URL
+}
+
+// SetUser sets the user logged in by the authorization endpoint, with its additional claims
+func (s *Server) SetUser(subject string, claims map[string]any) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.subject = subject
+	s.claims = claims
+}
+
+// IssueToken returns a token of the issuer for subject and audience, with additional claims.
+// Additional claims override the default ones
+func (s *Server) IssueToken(subject, audience string, claims map[string]any) (string, error) {
+	now := time.Now()
+	values := jwt.MapClaims{
+		"iss": s.Issuer(),
+		"sub": subject,
+		"aud": audience,
+		"iat": now.Unix(),
+		"exp": now.Add(TOKEN_DURATION).Unix(),
+	}
+
+	for name, value := range claims {
+		values[name] = value
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, values)
+	token.Header["kid"] = s.keyId
+	return token.SignedString(s.key)
+}
+
+// discoveryHandler returns the metadata of the issuer
+func (s *Server) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, oidc.ProviderMetadata{
+		Issuer:                s.Issuer(),
+		AuthorizationEndpoint: s.Issuer() + "/authorize",
+		TokenEndpoint:         s.Issuer() + "/token",
+		JwksUri:               s.Issuer() + "/jwks",
+	})
+}
+
+// JwksCalls returns the number of calls to the keys endpoint
+func (s *Server) JwksCalls() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.jwksCalls
+}
+
+// jwksHandler returns the public key of the issuer
+func (s *Server) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	s.jwksCalls++
+	s.mutex.Unlock()
+
+	encoding := base64.RawURLEncoding
+	public := s.key.PublicKey
+	writeJson(w, http.StatusOK, oidc.JsonWebKeySet{Keys: []oidc.JsonWebKey{{
+		KeyType:   "RSA",
+		KeyId:     s.keyId,
+		Use:       "sig",
+		Algorithm: "RS256",
+		N:         encoding.EncodeToString(public.N.Bytes()),
+		E:         encoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+	}}})
+}
+
+// authorizeHandler logs the current user in and redirects to the client with a code
+func (s *Server) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirect, errRedirect := url.Parse(query.Get("redirect_uri"))
+	switch {
+	case query.Get("client_id") != s.ClientId:
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	case errRedirect != nil || len(query.Get("redirect_uri")) == 0:
+		http.Error(w, "invalid redirect uri", http.StatusBadRequest)
+		return
+	case query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256":
+		http.Error(w, "expecting code flow with S256 challenge", http.StatusBadRequest)
+		return
+	}
+
+	code := uuid.NewString()
+	s.mutex.Lock()
+	s.codes[code] = pendingCode{
+		redirectUri: query.Get("redirect_uri"),
+		nonce:       query.Get("nonce"),
+		challenge:   query.Get("code_challenge"),
+	}
+	s.mutex.Unlock()
+
+	parameters := redirect.Query()
+	parameters.Set("code", code)
+	parameters.Set("state", query.Get("state"))
+	redirect.RawQuery = parameters.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// tokenHandler exchanges a code for an id token. Codes are single use
+func (s *Server) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJson(w, http.StatusMethodNotAllowed, map[string]string{"error": "invalid_request"})
+		return
+	} else if clientId, secret, ok := r.BasicAuth(); !ok || clientId != s.ClientId || secret != s.ClientSecret {
+		writeJson(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	} else if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	s.mutex.Lock()
+	code := r.PostForm.Get("code")
+	pending, found := s.codes[code]
+	delete(s.codes, code)
+	subject, claims := s.subject, s.claims
+	s.mutex.Unlock()
+
+	if !found || pending.redirectUri != r.PostForm.Get("redirect_uri") {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	} else if oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != pending.challenge {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "invalid code verifier"})
+		return
+	}
+
+	values := map[string]any{"nonce": pending.nonce}
+	for name, value := range claims {
+		values[name] = value
+	}
+
+	token, errToken := s.IssueToken(subject, s.ClientId, values)
+	if errToken != nil {
+		writeJson(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJson(w, http.StatusOK, map[string]any{
+		"id_token":     token,
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(TOKEN_DURATION.Seconds()),
+	})
+}
+
+// writeJson writes value with status
+func writeJson(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
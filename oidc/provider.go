@@ -0,0 +1,370 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// DISCOVERY_PATH is the path of the issuer metadata, relative to issuer
+	DISCOVERY_PATH = "/.well-known/openid-configuration"
+	// KEYS_REFRESH_PERIOD is the delay before keys of the issuer are loaded again
+	KEYS_REFRESH_PERIOD = time.Hour
+	// KEYS_MIN_RELOAD_PERIOD is the minimum delay between two loads for an unknown key id
+	KEYS_MIN_RELOAD_PERIOD = 10 * time.Second
+	// MAX_RESPONSE_SIZE is the maximum size of a response of the issuer
+	MAX_RESPONSE_SIZE = 1 << 20
+)
+
+// SIGNING_ALGORITHMS are the accepted algorithms for tokens of the issuer
+var SIGNING_ALGORITHMS = []string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384", "ES512", "EdDSA"}
+
+// ProviderMetadata is the part of the issuer metadata a relying party uses
+type ProviderMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+// Identity is the verified identity of a token
+type Identity struct {
+	Issuer   string
+	Subject  string
+	Username string
+	Groups   []string
+	IssuedAt time.Time
+}
+
+// Provider is the relying party of an issuer. Metadata and keys are loaded on first use
+type Provider struct {
+	settings Settings
+	client   *http.Client
+	// mutex protects metadata, keys, keysLoadedAt and keysLoading. Issuer is called without it
+	mutex        sync.Mutex
+	metadata     *ProviderMetadata
+	keys         map[string]crypto.PublicKey
+	keysLoadedAt time.Time
+	// keysLoading is closed when the current load of keys ends, nil if no load
+	keysLoading chan struct{}
+}
+
+// NewProvider returns a relying party for settings, calling the issuer with client
+func NewProvider(settings Settings, client *http.Client) *Provider {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &Provider{settings: settings, client: client}
+}
+
+// Settings returns the settings of the provider
+func (p *Provider) Settings() Settings {
+	return p.settings
+}
+
+// NewRandomValue returns a random value for a state, a nonce or a code verifier
+func NewRandomValue() (string, error) {
+	content := make([]byte, 32)
+	if _, err := rand.Read(content); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(content), nil
+}
+
+// CodeChallenge returns the S256 challenge of a code verifier (RFC 7636)
+func CodeChallenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// AuthorizationUrl returns the url to redirect an user to, for the authorization code flow with PKCE
+func (p *Provider) AuthorizationUrl(ctx context.Context, state, nonce, verifier string) (string, error) {
+	metadata, errMetadata := p.loadMetadata(ctx)
+	if errMetadata != nil {
+		return "", errMetadata
+	}
+
+	parameters := url.Values{}
+	parameters.Set("response_type", "code")
+	parameters.Set("client_id", p.settings.ClientId)
+	parameters.Set("redirect_uri", p.settings.RedirectUrl)
+	parameters.Set("scope", strings.Join(p.settings.RequestedScopes(), " "))
+	parameters.Set("state", state)
+	parameters.Set("nonce", nonce)
+	parameters.Set("code_challenge", CodeChallenge(verifier))
+	parameters.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return metadata.AuthorizationEndpoint + separator + parameters.Encode(), nil
+}
+
+// Exchange sends an authorization code to the token endpoint and returns the id token
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	metadata, errMetadata := p.loadMetadata(ctx)
+	if errMetadata != nil {
+		return "", errMetadata
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.settings.RedirectUrl)
+	form.Set("code_verifier", verifier)
+
+	request, errRequest := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if errRequest != nil {
+		return "", errRequest
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(url.QueryEscape(p.settings.ClientId), url.QueryEscape(p.settings.ClientSecret))
+
+	var response struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	if err := p.callJson(request, &response); err != nil {
+		return "", err
+	} else if len(response.Error) != 0 {
+		return "", fmt.Errorf("token endpoint refused code: %s %s", response.Error, response.ErrorDescription)
+	} else if len(response.IdToken) == 0 {
+		return "", errors.New("token endpoint returned no id token")
+	}
+
+	return response.IdToken, nil
+}
+
+// VerifyIdToken verifies an id token of the authorization code flow: audience is the client id, nonce should match
+func (p *Provider) VerifyIdToken(ctx context.Context, rawToken, nonce string) (Identity, error) {
+	claims, err := p.verify(ctx, rawToken, p.settings.ClientId)
+	if err != nil {
+		return Identity{}, err
+	} else if value, _ := claims["nonce"].(string); len(nonce) == 0 || value != nonce {
+		return Identity{}, errors.New("invalid nonce")
+	}
+
+	return p.identity(claims)
+}
+
+// VerifyBearerToken verifies a token sent as bearer: audience is the expected audience of settings
+func (p *Provider) VerifyBearerToken(ctx context.Context, rawToken string) (Identity, error) {
+	claims, err := p.verify(ctx, rawToken, p.settings.ExpectedAudience())
+	if err != nil {
+		return Identity{}, err
+	}
+
+	return p.identity(claims)
+}
+
+// verify checks signature, issuer, audience and period of a token, and returns its claims
+func (p *Provider) verify(ctx context.Context, rawToken, audience string) (jwt.MapClaims, error) {
+	publicKeyFunc := func(token *jwt.Token) (interface{}, error) {
+		keyId, _ := token.Header["kid"].(string)
+		return p.publicKey(ctx, keyId)
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawToken, claims, publicKeyFunc,
+		jwt.WithValidMethods(SIGNING_ALGORITHMS),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithIssuer(p.settings.Issuer),
+		jwt.WithAudience(audience),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// identity reads the identity in verified claims
+func (p *Provider) identity(claims jwt.MapClaims) (Identity, error) {
+	var result Identity
+	result.Issuer = p.settings.Issuer
+	if subject, err := claims.GetSubject(); err != nil || len(subject) == 0 {
+		return result, errors.New("missing subject")
+	} else {
+		result.Subject = subject
+	}
+
+	if issuedAt, err := claims.GetIssuedAt(); err == nil && issuedAt != nil {
+		result.IssuedAt = issuedAt.Time
+	}
+
+	result.Username, _ = claims[p.settings.UsernameClaim].(string)
+	if len(result.Username) == 0 {
+		result.Username = result.Subject
+	}
+
+	switch groups := claims[p.settings.GroupsClaim].(type) {
+	case string:
+		result.Groups = []string{groups}
+	case []any:
+		for _, group := range groups {
+			if value, ok := group.(string); ok {
+				result.Groups = append(result.Groups, value)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// loadMetadata returns the metadata of the issuer, loaded once.
+// Issuer is called without the lock, concurrent first calls may load it more than once
+func (p *Provider) loadMetadata(ctx context.Context) (ProviderMetadata, error) {
+	p.mutex.Lock()
+	loaded := p.metadata
+	p.mutex.Unlock()
+
+	if loaded != nil {
+		return *loaded, nil
+	}
+
+	discovery := strings.TrimSuffix(p.settings.Issuer, "/") + DISCOVERY_PATH
+	request, errRequest := http.NewRequestWithContext(ctx, http.MethodGet, discovery, nil)
+	if errRequest != nil {
+		return ProviderMetadata{}, errRequest
+	}
+
+	var metadata ProviderMetadata
+	if err := p.callJson(request, &metadata); err != nil {
+		return metadata, err
+	} else if metadata.Issuer != p.settings.Issuer {
+		return metadata, fmt.Errorf("issuer mismatch: expecting %s, got %s", p.settings.Issuer, metadata.Issuer)
+	} else if len(metadata.AuthorizationEndpoint) == 0 || len(metadata.TokenEndpoint) == 0 || len(metadata.JwksUri) == 0 {
+		return metadata, errors.New("incomplete issuer metadata")
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.metadata == nil {
+		p.metadata = &metadata
+	}
+
+	return *p.metadata, nil
+}
+
+// publicKey returns the key of the issuer for that id. Keys are loaded again for an unknown id.
+// One call loads keys at a time, without the lock, and other calls wait for its result
+func (p *Provider) publicKey(ctx context.Context, keyId string) (crypto.PublicKey, error) {
+	metadata, errMetadata := p.loadMetadata(ctx)
+	if errMetadata != nil {
+		return nil, errMetadata
+	}
+
+	for {
+		p.mutex.Lock()
+		stale := time.Since(p.keysLoadedAt) >= KEYS_REFRESH_PERIOD
+		if key, found := p.keys[keyId]; found && !stale {
+			p.mutex.Unlock()
+			return key, nil
+		} else if !stale && time.Since(p.keysLoadedAt) < KEYS_MIN_RELOAD_PERIOD {
+			p.mutex.Unlock()
+			return nil, fmt.Errorf("unknown key %s", keyId)
+		} else if loading := p.keysLoading; loading != nil {
+			// another call loads keys, test its result
+			p.mutex.Unlock()
+			select {
+			case <-loading:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		loading := make(chan struct{})
+		p.keysLoading = loading
+		p.mutex.Unlock()
+
+		keys, errLoad := p.loadKeys(ctx, metadata.JwksUri)
+
+		p.mutex.Lock()
+		if errLoad == nil {
+			p.keys = keys
+			p.keysLoadedAt = time.Now()
+		}
+
+		p.keysLoading = nil
+		close(loading)
+		key, found := p.keys[keyId]
+		p.mutex.Unlock()
+
+		if errLoad != nil {
+			return nil, errLoad
+		} else if found {
+			return key, nil
+		}
+
+		return nil, fmt.Errorf("unknown key %s", keyId)
+	}
+}
+
+// loadKeys returns the signing keys of the issuer per key id
+func (p *Provider) loadKeys(ctx context.Context, jwksUri string) (map[string]crypto.PublicKey, error) {
+	request, errRequest := http.NewRequestWithContext(ctx, http.MethodGet, jwksUri, nil)
+	if errRequest != nil {
+		return nil, errRequest
+	}
+
+	var keySet JsonWebKeySet
+	if err := p.callJson(request, &keySet); err != nil {
+		return nil, err
+	}
+
+	// keys with unsupported types are ignored, issuer may publish keys for other uses
+	keys := make(map[string]crypto.PublicKey)
+	for _, value := range keySet.Keys {
+		if len(value.Use) != 0 && value.Use != "sig" {
+			continue
+		} else if key, err := value.PublicKey(); err == nil {
+			keys[value.KeyId] = key
+		}
+	}
+
+	return keys, nil
+}
+
+// callJson sends request and decodes its json response in result.
+// Client errors of token endpoint are json too, so they are decoded
+func (p *Provider) callJson(request *http.Request, result any) error {
+	request.Header.Set("Accept", "application/json")
+	response, errCall := p.client.Do(request)
+	if errCall != nil {
+		return errCall
+	}
+
+	defer response.Body.Close()
+	content, errRead := io.ReadAll(io.LimitReader(response.Body, MAX_RESPONSE_SIZE))
+	if errRead != nil {
+		return errRead
+	} else if response.StatusCode >= http.StatusInternalServerError || (response.StatusCode >= http.StatusBadRequest && !json.Valid(content)) {
+		return fmt.Errorf("issuer returned %d for %s", response.StatusCode, request.URL.Path)
+	}
+
+	return json.Unmarshal(content, result)
+}
@@ -0,0 +1,85 @@
+package oidc
+
+import (
+	"slices"
+	"strings"
+)
+
+// DEFAULT_SCOPES are the scopes requested when settings define none
+var DEFAULT_SCOPES = []string{"openid", "profile", "email"}
+
+// Settings configure the relying party of an OpenID Connect issuer
+type Settings struct {
+	// Issuer is the url of the issuer, OIDC is off if empty
+	Issuer string
+	// ClientId and ClientSecret authenticate the server to the issuer
+	ClientId     string
+	ClientSecret string
+	// RedirectUrl is the callback url registered for the client
+	RedirectUrl string
+	// Scopes are the requested scopes, DEFAULT_SCOPES if empty
+	Scopes []string
+	// Audience is the expected aud claim of bearer tokens, client id if empty
+	Audience string
+	// UsernameClaim is the claim to name local users
+	UsernameClaim string
+	// GroupsClaim is the claim listing the groups of an user
+	GroupsClaim string
+	// AutoProvision creates local users for unknown subjects
+	AutoProvision bool
+	// GroupRoles are, per group, the roles of its members as class:role, for instance graph:observer
+	GroupRoles map[string][]string
+}
+
+// Enabled returns true if an issuer is set
+func (s Settings) Enabled() bool {
+	return len(s.Issuer) != 0
+}
+
+// RequestedScopes returns the scopes to request
+func (s Settings) RequestedScopes() []string {
+	if len(s.Scopes) == 0 {
+		return DEFAULT_SCOPES
+	}
+
+	return s.Scopes
+}
+
+// ExpectedAudience returns the expected aud claim of bearer tokens
+func (s Settings) ExpectedAudience() string {
+	if len(s.Audience) == 0 {
+		return s.ClientId
+	}
+
+	return s.Audience
+}
+
+// RolesForGroups returns the sorted roles of the groups, as class:role.
+// Result is nil if no mapping is set, so that roles are not managed by the issuer
+func (s Settings) RolesForGroups(groups []string) []string {
+	if len(s.GroupRoles) == 0 {
+		return nil
+	}
+
+	result := make([]string, 0)
+	for _, group := range groups {
+		for _, role := range s.GroupRoles[group] {
+			if !slices.Contains(result, role) {
+				result = append(result, role)
+			}
+		}
+	}
+
+	slices.Sort(result)
+	return result
+}
+
+// SplitRole returns the class and the role of a class:role value
+func SplitRole(value string) (string, string, bool) {
+	class, role, found := strings.Cut(value, ":")
+	if !found || len(class) == 0 || len(role) == 0 {
+		return "", "", false
+	}
+
+	return class, role, true
+}
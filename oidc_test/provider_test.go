@@ -0,0 +1,162 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/zefrenchwan/patterns.git/oidc"
+	"github.com/zefrenchwan/patterns.git/oidc/oidcmock"
+)
+
+// newProvider starts a mock issuer and returns a provider for it
+func newProvider(t *testing.T) (*oidcmock.Server, *oidc.Provider) {
+	server, err := oidcmock.NewServer("patterns", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(server.Close)
+	settings := oidc.Settings{
+		Issuer:        server.Issuer(),
+		ClientId:      "patterns",
+		ClientSecret:  "secret",
+		RedirectUrl:   "http://localhost:8080/oidc/callback/",
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+	}
+
+	return server, oidc.NewProvider(settings, server.Client())
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	server, provider := newProvider(t)
+	server.SetUser("subject-1", map[string]any{"preferred_username": "alice", "groups": []string{"analysts", "admins"}})
+	ctx := context.Background()
+
+	authorization, err := provider.AuthorizationUrl(ctx, "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// user is redirected to the callback with a code and the state
+	client := server.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	response, errCall := client.Get(authorization)
+	if errCall != nil {
+		t.Fatal(errCall)
+	}
+
+	response.Body.Close()
+	callback, errParse := url.Parse(response.Header.Get("Location"))
+	if errParse != nil {
+		t.Fatal(errParse)
+	} else if callback.Query().Get("state") != "state" {
+		t.Errorf("expecting state, got %s", callback.String())
+	}
+
+	code := callback.Query().Get("code")
+	if _, err := provider.Exchange(ctx, code, "another verifier"); err == nil {
+		t.Error("invalid verifier should fail")
+	}
+
+	// code was consumed by failed exchange, get a new one
+	response, _ = client.Get(authorization)
+	response.Body.Close()
+	callback, _ = url.Parse(response.Header.Get("Location"))
+	idToken, errExchange := provider.Exchange(ctx, callback.Query().Get("code"), "verifier")
+	if errExchange != nil {
+		t.Fatal(errExchange)
+	}
+
+	if _, err := provider.VerifyIdToken(ctx, idToken, "another nonce"); err == nil {
+		t.Error("invalid nonce should fail")
+	}
+
+	identity, errVerify := provider.VerifyIdToken(ctx, idToken, "nonce")
+	if errVerify != nil {
+		t.Fatal(errVerify)
+	} else if identity.Subject != "subject-1" || identity.Username != "alice" || identity.Issuer != server.Issuer() {
+		t.Errorf("invalid identity %v", identity)
+	} else if !slices.Equal(identity.Groups, []string{"analysts", "admins"}) {
+		t.Errorf("invalid groups %v", identity.Groups)
+	}
+}
+
+func TestVerifyBearerToken(t *testing.T) {
+	server, provider := newProvider(t)
+	ctx := context.Background()
+
+	token, _ := server.IssueToken("subject-2", "patterns", nil)
+	if identity, err := provider.VerifyBearerToken(ctx, token); err != nil {
+		t.Fatal(err)
+	} else if identity.Username != "subject-2" {
+		t.Errorf("username should default to subject, got %s", identity.Username)
+	}
+
+	invalid := map[string]map[string]any{
+		"audience": {"aud": "other"},
+		"issuer":   {"iss": "http://other"},
+		"expired":  {"exp": 1000},
+	}
+
+	for name, claims := range invalid {
+		token, _ := server.IssueToken("subject-2", "patterns", claims)
+		if _, err := provider.VerifyBearerToken(ctx, token); err == nil {
+			t.Errorf("invalid %s should fail", name)
+		}
+	}
+}
+
+func TestConcurrentVerificationsLoadKeysOnce(t *testing.T) {
+	server, provider := newProvider(t)
+	ctx := context.Background()
+
+	token, _ := server.IssueToken("subject-3", "patterns", nil)
+	var group sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			_, err := provider.VerifyBearerToken(ctx, token)
+			errs <- err
+		}()
+	}
+
+	group.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	if calls := server.JwksCalls(); calls != 1 {
+		t.Errorf("expecting keys loaded once, got %d", calls)
+	}
+}
+
+func TestRolesForGroups(t *testing.T) {
+	settings := oidc.Settings{}
+	if roles := settings.RolesForGroups([]string{"admins"}); roles != nil {
+		t.Errorf("no mapping means no roles management, got %v", roles)
+	}
+
+	settings.GroupRoles = map[string][]string{
+		"admins":   {"graph:manager", "graph:observer"},
+		"analysts": {"graph:observer"},
+	}
+
+	if roles := settings.RolesForGroups([]string{"analysts", "admins", "unknown"}); !slices.Equal(roles, []string{"graph:manager", "graph:observer"}) {
+		t.Errorf("invalid roles %v", roles)
+	} else if roles := settings.RolesForGroups(nil); roles == nil || len(roles) != 0 {
+		t.Errorf("expecting no role, got %v", roles)
+	}
+}
@@ -0,0 +1,76 @@
+package serving
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/zefrenchwan/patterns.git/graphs"
+	"github.com/zefrenchwan/patterns.git/nodes"
+	"github.com/zefrenchwan/patterns.git/storage"
+)
+
+// aggregateGraphHandler counts, per time bucket, elements with a given trait in a graph.
+// Query parameter attribute, if any, adds min, max, avg and sum of that numeric attribute
+func aggregateGraphHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	user, auth := wrapper.CurrentUser()
+	if !auth {
+		return NewServiceForbiddenError("should authenticate")
+	}
+
+	graphId := r.PathValue("graphId")
+	if len(graphId) == 0 {
+		return NewServiceHttpClientError("expecting graph id")
+	}
+
+	trait := r.PathValue("trait")
+	if len(trait) == 0 {
+		return NewServiceHttpClientError("expecting trait")
+	}
+
+	unit, errUnit := graphs.ParseBucketUnit(r.PathValue("bucket"))
+	if errUnit != nil {
+		return NewServiceHttpClientError(errUnit.Error())
+	}
+
+	attribute := r.URL.Query().Get("attribute")
+
+	var activePeriod nodes.Period
+	startValue, errStart := DeserializeTimeFromURL(r.PathValue("start"))
+	if errStart != nil {
+		return NewServiceHttpClientError(errStart.Error())
+	}
+
+	endValue, errEnd := DeserializeTimeFromURL(r.PathValue("end"))
+	if errEnd != nil {
+		return NewServiceHttpClientError(errEnd.Error())
+	}
+
+	if valuesInterval, err := nodes.NewFiniteTimeInterval(startValue, endValue, true, false); err != nil {
+		return NewServiceHttpClientError(err.Error())
+	} else {
+		activePeriod = nodes.NewPeriod(valuesInterval)
+	}
+
+	var rawGraph graphs.Graph
+	if raw, err := wrapper.Dao.LoadGraphForUserDuringPeriod(wrapper.Ctx, user, graphId, activePeriod); err != nil {
+		return BuildApiErrorFromStorageError(err)
+	} else {
+		rawGraph = raw
+	}
+
+	if rawGraph.Id == "" {
+		w.WriteHeader(404)
+		return nil
+	}
+
+	buckets, errAggregate := rawGraph.AggregateActivity(trait, startValue, endValue, unit, attribute)
+	if errAggregate != nil {
+		return NewServiceHttpClientError(errAggregate.Error())
+	} else if err := json.NewEncoder(w).Encode(storage.SerializeTimeBuckets(buckets)); err != nil {
+		return NewServiceInternalServerError(err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package serving
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/zefrenchwan/patterns.git/storage"
+)
+
+// ApiKeyInput is the input to create an api key
+type ApiKeyInput struct {
+	// Name describes the use of the key
+	Name string `json:"name"`
+	// ExpiresAt is the expiration of the key, no expiration if empty
+	ExpiresAt string `json:"expires_at,omitempty"`
+	// Graphs the key may access, no restriction if empty
+	Graphs []string `json:"graphs,omitempty"`
+	// Roles the key may use, no restriction if empty
+	Roles []string `json:"roles,omitempty"`
+}
+
+// ApiKeyCreationDTO is the created key. Key value is only sent once
+type ApiKeyCreationDTO struct {
+	Id  string `json:"id"`
+	Key string `json:"key"`
+}
+
+// createApiKeyHandler creates an api key for an user. Current user should modify that user
+func createApiKeyHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	user, auth := wrapper.CurrentUser()
+	if !auth {
+		return NewServiceForbiddenError("should authenticate")
+	}
+
+	login := r.PathValue("login")
+	if len(login) == 0 {
+		return NewServiceHttpClientError("expecting login")
+	}
+
+	var input ApiKeyInput
+	if body, errBody := io.ReadAll(r.Body); errBody != nil {
+		return NewServiceReadBodyError(errBody)
+	} else if err := json.Unmarshal(body, &input); err != nil {
+		return NewServiceDeserializationError(err)
+	} else if len(input.Name) == 0 {
+		return NewServiceValidationError(FieldErrorDTO{Field: "name", Message: "expecting name"})
+	}
+
+	var restriction storage.ApiKeyRestriction
+	if len(input.ExpiresAt) != 0 {
+		if expiration, err := time.Parse(storage.DATE_SERDE_FORMAT, input.ExpiresAt); err != nil {
+			return NewServiceValidationError(FieldErrorDTO{Field: "expires_at", Message: "invalid expiration, expecting " + storage.DATE_SERDE_FORMAT})
+		} else if !expiration.After(time.Now().UTC()) {
+			return NewServiceValidationError(FieldErrorDTO{Field: "expires_at", Message: "expiration should be in the future"})
+		} else {
+			restriction.ExpiresAt = &expiration
+		}
+	}
+
+	if len(input.Graphs) != 0 {
+		restriction.Graphs = input.Graphs
+	}
+
+	if len(input.Roles) != 0 {
+		restriction.Roles = input.Roles
+	}
+
+	keyId, key, errCreate := wrapper.Dao.CreateApiKey(wrapper.Ctx, user, login, input.Name, restriction)
+	if errCreate != nil {
+		return BuildApiErrorFromStorageError(errCreate)
+	}
+
+	if err := json.NewEncoder(w).Encode(ApiKeyCreationDTO{Id: keyId, Key: key}); err != nil {
+		return NewServiceInternalServerError(err.Error())
+	}
+
+	return nil
+}
+
+// listApiKeysHandler lists the api keys of an user, never their value. Current user should observe that user
+func listApiKeysHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	user, auth := wrapper.CurrentUser()
+	if !auth {
+		return NewServiceForbiddenError("should authenticate")
+	}
+
+	login := r.PathValue("login")
+	if len(login) == 0 {
+		return NewServiceHttpClientError("expecting login")
+	}
+
+	keys, errList := wrapper.Dao.ListApiKeys(wrapper.Ctx, user, login)
+	if errList != nil {
+		return BuildApiErrorFromStorageError(errList)
+	}
+
+	if err := json.NewEncoder(w).Encode(keys); err != nil {
+		return NewServiceInternalServerError(err.Error())
+	}
+
+	return nil
+}
+
+// revokeApiKeyHandler revokes an api key of an user. Current user should modify that user
+func revokeApiKeyHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	user, auth := wrapper.CurrentUser()
+	if !auth {
+		return NewServiceForbiddenError("should authenticate")
+	}
+
+	login := r.PathValue("login")
+	keyId := r.PathValue("keyId")
+	if len(login) == 0 || len(keyId) == 0 {
+		return NewServiceHttpClientError("expecting login and key id")
+	} else if err := wrapper.Dao.RevokeApiKey(wrapper.Ctx, user, login, keyId); err != nil {
+		return BuildApiErrorFromStorageError(err)
+	}
+
+	w.WriteHeader(200)
+	return nil
+}
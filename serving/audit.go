@@ -0,0 +1,64 @@
+package serving
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/zefrenchwan/patterns.git/storage"
+)
+
+// AUDIT_DEFAULT_LIMIT is the maximum number of audit entries when limit is not set
+const AUDIT_DEFAULT_LIMIT = 100
+
+// auditHandler returns the audit entries user manages, most recent first.
+// Query parameters actor, graph, element, start, end and limit filter entries
+func auditHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	user, auth := wrapper.CurrentUser()
+	if !auth {
+		return NewServiceForbiddenError("should authenticate")
+	}
+
+	query := r.URL.Query()
+	filter := storage.AuditFilter{
+		Actor:   query.Get("actor"),
+		Graph:   query.Get("graph"),
+		Element: query.Get("element"),
+		Limit:   AUDIT_DEFAULT_LIMIT,
+	}
+
+	if value := query.Get("start"); len(value) != 0 {
+		if start, err := DeserializeTimeFromURL(value); err != nil {
+			return NewServiceValidationError(FieldErrorDTO{Field: "start", Message: "invalid start: " + err.Error()})
+		} else {
+			filter.Start = start
+		}
+	}
+
+	if value := query.Get("end"); len(value) != 0 {
+		if end, err := DeserializeTimeFromURL(value); err != nil {
+			return NewServiceValidationError(FieldErrorDTO{Field: "end", Message: "invalid end: " + err.Error()})
+		} else {
+			filter.End = end
+		}
+	}
+
+	if value := query.Get("limit"); len(value) != 0 {
+		if limit, err := strconv.Atoi(value); err != nil || limit <= 0 {
+			return NewServiceValidationError(FieldErrorDTO{Field: "limit", Message: "limit should be a positive integer"})
+		} else {
+			filter.Limit = limit
+		}
+	}
+
+	entries, errList := wrapper.Dao.ListAuditForUser(wrapper.Ctx, user, filter)
+	if errList != nil {
+		return BuildApiErrorFromStorageError(errList)
+	} else if errResponse := json.NewEncoder(w).Encode(entries); errResponse != nil {
+		return NewServiceInternalServerError(errResponse.Error())
+	}
+
+	return nil
+}
@@ -1,15 +1,47 @@
 package serving
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
+	"strconv"
 
 	"github.com/google/uuid"
 	"github.com/zefrenchwan/patterns.git/storage"
 )
 
+// MergeDataDTO is the input to merge sources into target
+type MergeDataDTO struct {
+	Target  string   `json:"target"`
+	Sources []string `json:"sources"`
+}
+
+// SyncDifferenceDTO is a diverging part between a copy and its equivalence parent
+type SyncDifferenceDTO struct {
+	Part  string `json:"part"`
+	State string `json:"state"`
+}
+
+// EquivalenceStatusDTO is the synchronisation status of a copy with its equivalence parent
+type EquivalenceStatusDTO struct {
+	Parent      string              `json:"parent"`
+	Differences []SyncDifferenceDTO `json:"differences"`
+}
+
+// SyncResultDTO is the result of a pull or a push: parts that were not synchronized due to conflicts
+type SyncResultDTO struct {
+	Conflicts []string `json:"conflicts"`
+}
+
+// SplitResultDTO is the result of a split: the new element and the rewired relations
+type SplitResultDTO struct {
+	Id        string   `json:"id"`
+	Relations []string `json:"relations"`
+}
+
 // loadElementByIdHandler loads an element by id and returns matching JSON
 func loadElementByIdHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
 	defer r.Body.Close()
@@ -24,7 +56,17 @@ func loadElementByIdHandler(wrapper ServiceParameters, w http.ResponseWriter, r
 		return NewServiceHttpClientError("expecting element id")
 	}
 
-	element, errLoad := wrapper.Dao.LoadElementForUser(wrapper.Ctx, user, elementId)
+	// trash is optional, to load an element in trash
+	loader := wrapper.Dao.LoadElementForUser
+	if value := r.URL.Query().Get("trash"); len(value) != 0 {
+		if withTrash, err := strconv.ParseBool(value); err != nil {
+			return NewServiceHttpClientError("invalid trash parameter")
+		} else if withTrash {
+			loader = wrapper.Dao.LoadElementWithTrashForUser
+		}
+	}
+
+	element, errLoad := loader(wrapper.Ctx, user, elementId)
 	if errLoad != nil {
 		return BuildApiErrorFromStorageError(errLoad)
 	} else if element == nil {
@@ -55,17 +97,17 @@ func upsertElementInGraphHandler(wrapper ServiceParameters, w http.ResponseWrite
 
 	var input storage.ElementDTO
 	if body, err := io.ReadAll(r.Body); err != nil {
-		return NewServiceInternalServerError(err.Error())
+		return NewServiceReadBodyError(err)
 	} else if errM := json.Unmarshal(body, &input); errM != nil {
-		return NewServiceInternalServerError(errM.Error())
+		return NewServiceDeserializationError(errM)
 	} else if len(input.Id) == 0 {
-		return NewServiceHttpClientError("expecting element id")
+		return NewServiceValidationError(FieldErrorDTO{Field: "id", Message: "expecting element id"})
 	}
 
 	element, errElement := storage.DeserializeElement(input)
 	if errElement != nil {
 		message := fmt.Sprintf("invalid json: %s", errElement.Error())
-		return NewServiceHttpClientError(message)
+		return NewServiceHttpClientError(message).WithCode(ERROR_CODE_MALFORMED_BODY)
 	}
 
 	if err := wrapper.Dao.UpsertElement(wrapper.Ctx, user, graphId, element); err != nil {
@@ -127,3 +169,194 @@ func createEquivalenceElementHandler(wrapper ServiceParameters, w http.ResponseW
 
 	return nil
 }
+
+// mergeElementsHandler merges sources into target and returns the merge id
+func mergeElementsHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	user, auth := wrapper.CurrentUser()
+	if !auth {
+		return NewServiceForbiddenError("should authenticate")
+	}
+
+	var input MergeDataDTO
+	if body, err := io.ReadAll(r.Body); err != nil {
+		return NewServiceReadBodyError(err)
+	} else if errM := json.Unmarshal(body, &input); errM != nil {
+		return NewServiceDeserializationError(errM)
+	} else if len(input.Target) == 0 {
+		return NewServiceValidationError(FieldErrorDTO{Field: "target", Message: "expecting target id"})
+	} else if len(input.Sources) == 0 {
+		return NewServiceValidationError(FieldErrorDTO{Field: "sources", Message: "expecting sources ids"})
+	} else if slices.Contains(input.Sources, input.Target) {
+		return NewServiceValidationError(FieldErrorDTO{Field: "sources", Message: "target cannot be a source"})
+	}
+
+	mergeId, errMerge := wrapper.Dao.MergeElements(wrapper.Ctx, user, input.Target, input.Sources)
+	if errMerge != nil {
+		return BuildApiErrorFromStorageError(errMerge)
+	} else if err := json.NewEncoder(w).Encode(mergeId); err != nil {
+		return NewServiceInternalServerError(err.Error())
+	}
+
+	return nil
+}
+
+// undoMergeHandler restores elements of a merge as they were before the merge
+func undoMergeHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	user, auth := wrapper.CurrentUser()
+	if !auth {
+		return NewServiceForbiddenError("should authenticate")
+	}
+
+	mergeId := r.PathValue("mergeId")
+	if len(mergeId) == 0 {
+		return NewServiceHttpClientError("expecting merge id")
+	}
+
+	if err := wrapper.Dao.UndoMerge(wrapper.Ctx, user, mergeId); err != nil {
+		return BuildApiErrorFromStorageError(err)
+	}
+
+	w.WriteHeader(200)
+	return nil
+}
+
+// splitElementHandler splits an entity at a given moment and returns the new entity and rewired relations
+func splitElementHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	user, auth := wrapper.CurrentUser()
+	if !auth {
+		return NewServiceForbiddenError("should authenticate")
+	}
+
+	elementId := r.PathValue("elementId")
+	if len(elementId) == 0 {
+		return NewServiceHttpClientError("expecting element id")
+	}
+
+	moment, errMoment := DeserializeTimeFromURL(r.PathValue("moment"))
+	if errMoment != nil {
+		return NewServiceHttpClientError(errMoment.Error())
+	}
+
+	newId, relations, errSplit := wrapper.Dao.SplitElement(wrapper.Ctx, user, elementId, moment)
+	if errSplit != nil {
+		return BuildApiErrorFromStorageError(errSplit)
+	}
+
+	response := SplitResultDTO{Id: newId, Relations: relations}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		return NewServiceInternalServerError(err.Error())
+	}
+
+	return nil
+}
+
+// equivalenceStatusHandler returns the diverging parts between a copy and its equivalence parent
+func equivalenceStatusHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	user, auth := wrapper.CurrentUser()
+	if !auth {
+		return NewServiceForbiddenError("should authenticate")
+	}
+
+	elementId := r.PathValue("elementId")
+	if len(elementId) == 0 {
+		return NewServiceHttpClientError("expecting element id")
+	}
+
+	parentId, differences, errStatus := wrapper.Dao.EquivalenceStatus(wrapper.Ctx, user, elementId)
+	if errStatus != nil {
+		return BuildApiErrorFromStorageError(errStatus)
+	}
+
+	response := EquivalenceStatusDTO{Parent: parentId, Differences: make([]SyncDifferenceDTO, 0, len(differences))}
+	for _, difference := range differences {
+		response.Differences = append(response.Differences, SyncDifferenceDTO{Part: difference.Part, State: string(difference.State)})
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		return NewServiceInternalServerError(err.Error())
+	}
+
+	return nil
+}
+
+// pullEquivalenceHandler updates a copy from its equivalence parent and returns conflicts
+func pullEquivalenceHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	return synchronizeEquivalenceHandler(wrapper, w, r, wrapper.Dao.PullEquivalence)
+}
+
+// pushEquivalenceHandler updates the equivalence parent of a copy and returns conflicts
+func pushEquivalenceHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	return synchronizeEquivalenceHandler(wrapper, w, r, wrapper.Dao.PushEquivalence)
+}
+
+// synchronizeEquivalenceHandler runs a synchronisation, with optional force parameter
+func synchronizeEquivalenceHandler(
+	wrapper ServiceParameters, w http.ResponseWriter, r *http.Request,
+	synchronize func(ctx context.Context, user string, elementId string, force bool) ([]string, error),
+) error {
+	defer r.Body.Close()
+
+	user, auth := wrapper.CurrentUser()
+	if !auth {
+		return NewServiceForbiddenError("should authenticate")
+	}
+
+	elementId := r.PathValue("elementId")
+	if len(elementId) == 0 {
+		return NewServiceHttpClientError("expecting element id")
+	}
+
+	var force bool
+	if value := r.URL.Query().Get("force"); len(value) != 0 {
+		if parsed, err := strconv.ParseBool(value); err != nil {
+			return NewServiceHttpClientError("invalid force parameter")
+		} else {
+			force = parsed
+		}
+	}
+
+	conflicts, errSync := synchronize(wrapper.Ctx, user, elementId, force)
+	if errSync != nil {
+		return BuildApiErrorFromStorageError(errSync)
+	}
+
+	response := SyncResultDTO{Conflicts: make([]string, 0, len(conflicts))}
+	response.Conflicts = append(response.Conflicts, conflicts...)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		return NewServiceInternalServerError(err.Error())
+	}
+
+	return nil
+}
+
+// lineageHandler returns the ancestors and the copies of an element, transitively
+func lineageHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	user, auth := wrapper.CurrentUser()
+	if !auth {
+		return NewServiceForbiddenError("should authenticate")
+	}
+
+	elementId := r.PathValue("elementId")
+	if len(elementId) == 0 {
+		return NewServiceHttpClientError("expecting element id")
+	}
+
+	lineage, errLineage := wrapper.Dao.LoadLineageForUser(wrapper.Ctx, user, elementId)
+	if errLineage != nil {
+		return BuildApiErrorFromStorageError(errLineage)
+	} else if err := json.NewEncoder(w).Encode(storage.SerializeLineage(lineage)); err != nil {
+		return NewServiceInternalServerError(err.Error())
+	}
+
+	return nil
+}
@@ -1,16 +1,64 @@
 package serving
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/zefrenchwan/patterns.git/storage"
 )
 
+// PROBLEM_CONTENT_TYPE is the content type of error responses (RFC 7807)
+const PROBLEM_CONTENT_TYPE = "application/problem+json"
+
+// PROBLEM_TYPE_PREFIX prefixes the code of an error to build its problem type
+const PROBLEM_TYPE_PREFIX = "urn:patterns:problem:"
+
+// Stable error codes, clients test them instead of messages
+const (
+	ERROR_CODE_BAD_REQUEST        = "bad_request"
+	ERROR_CODE_VALIDATION_FAILED  = "validation_failed"
+	ERROR_CODE_MALFORMED_BODY     = "malformed_body"
+	ERROR_CODE_INVALID_METHOD     = "invalid_method"
+	ERROR_CODE_CYCLE              = "cycle"
+	ERROR_CODE_UNAUTHENTICATED    = "unauthenticated"
+	ERROR_CODE_ACCESS_DENIED      = "access_denied"
+	ERROR_CODE_FORBIDDEN          = "forbidden"
+	ERROR_CODE_INCONSISTENT_STATE = "inconsistent_state"
+	ERROR_CODE_NOT_FOUND          = "not_found"
+	ERROR_CODE_ALREADY_EXISTS     = "already_exists"
+	ERROR_CODE_UNPROCESSABLE      = "unprocessable_entity"
+	ERROR_CODE_BODY_TOO_LARGE     = "body_too_large"
+	ERROR_CODE_RATE_LIMITED       = "rate_limited"
+	ERROR_CODE_LOGIN_LOCKED       = "login_locked"
+	ERROR_CODE_INTERNAL           = "internal_error"
+)
+
+// FieldErrorDTO is the error of a field of an input
+type FieldErrorDTO struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ProblemDTO is the body of error responses, as problem details (RFC 7807)
+type ProblemDTO struct {
+	Type      string          `json:"type"`
+	Title     string          `json:"title"`
+	Status    int             `json:"status"`
+	Detail    string          `json:"detail,omitempty"`
+	Instance  string          `json:"instance,omitempty"`
+	Code      string          `json:"code"`
+	RequestId string          `json:"request_id,omitempty"`
+	Errors    []FieldErrorDTO `json:"errors,omitempty"`
+}
+
 // ServiceHttpError is a custom error with an http code to return
 type ServiceHttpError struct {
 	httpCode int
+	code     string
 	message  string
+	fields   []FieldErrorDTO
 }
 
 // Error to implement error interface
@@ -23,6 +71,57 @@ func (e ServiceHttpError) HttpCode() int {
 	return e.httpCode
 }
 
+// Code returns the stable code of the error
+func (e ServiceHttpError) Code() string {
+	return e.code
+}
+
+// FieldErrors returns the errors per field, if any
+func (e ServiceHttpError) FieldErrors() []FieldErrorDTO {
+	return e.fields
+}
+
+// WithCode returns the same error with another code
+func (e ServiceHttpError) WithCode(code string) ServiceHttpError {
+	e.code = code
+	return e
+}
+
+// Problem returns the problem details of the error
+func (e ServiceHttpError) Problem() ProblemDTO {
+	return ProblemDTO{
+		Type:   PROBLEM_TYPE_PREFIX + e.code,
+		Title:  http.StatusText(e.httpCode),
+		Status: e.httpCode,
+		Detail: e.message,
+		Code:   e.code,
+		Errors: e.fields,
+	}
+}
+
+// writeProblem writes error as problem details for a request
+func writeProblem(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request, serviceError ServiceHttpError) {
+	problem := serviceError.Problem()
+	problem.Instance = r.URL.Path
+	problem.RequestId, _ = wrapper.RequestId()
+
+	w.Header().Set("Content-Type", PROBLEM_CONTENT_TYPE)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(serviceError.httpCode)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// asServiceError returns error as a service error, a 500 for any other error
+func asServiceError(sourceError error) ServiceHttpError {
+	var serviceError ServiceHttpError
+	if errors.As(sourceError, &serviceError) {
+		return serviceError
+	}
+
+	return NewServiceInternalServerError("Internal error: " + sourceError.Error())
+}
+
+// BuildApiErrorFromStorageError maps the code of a storage error to a service error
 func BuildApiErrorFromStorageError(sourceError error) error {
 	if sourceError == nil {
 		return sourceError
@@ -32,11 +131,15 @@ func BuildApiErrorFromStorageError(sourceError error) error {
 
 	switch storage.FindCodeInPSQLException(sourceError) {
 	case storage.INCONSISTENCY_CODE:
-		return NewServiceForbiddenError(message)
+		return NewServiceForbiddenError(message).WithCode(ERROR_CODE_INCONSISTENT_STATE)
 	case storage.AUTH_CODE:
-		return NewServiceUnauthorizedError(message)
+		return NewServiceUnauthorizedError(message).WithCode(ERROR_CODE_ACCESS_DENIED)
 	case storage.RESOURCE_CODE:
 		return NewServiceNotFoundError(message)
+	case storage.CYCLE_CODE:
+		return NewServiceHttpClientError(message).WithCode(ERROR_CODE_CYCLE)
+	case storage.DUPLICATE_CODE:
+		return NewServiceConflictError(message)
 	default:
 		return NewServiceInternalServerError(message)
 	}
@@ -46,14 +149,63 @@ func BuildApiErrorFromStorageError(sourceError error) error {
 func NewServiceHttpClientError(message string) ServiceHttpError {
 	return ServiceHttpError{
 		httpCode: http.StatusBadRequest,
+		code:     ERROR_CODE_BAD_REQUEST,
 		message:  message,
 	}
 }
 
+// NewServiceValidationError returns a 400 error listing invalid fields
+func NewServiceValidationError(fields ...FieldErrorDTO) ServiceHttpError {
+	messages := make([]string, 0, len(fields))
+	for _, field := range fields {
+		messages = append(messages, field.Message)
+	}
+
+	return ServiceHttpError{
+		httpCode: http.StatusBadRequest,
+		code:     ERROR_CODE_VALIDATION_FAILED,
+		message:  strings.Join(messages, ", "),
+		fields:   fields,
+	}
+}
+
+// NewServiceFieldError returns a 400 error for an invalid field.
+// Joined errors are as many errors for that field
+func NewServiceFieldError(field string, fieldError error) ServiceHttpError {
+	causes := []error{fieldError}
+	if joined, ok := fieldError.(interface{ Unwrap() []error }); ok {
+		causes = joined.Unwrap()
+	}
+
+	fields := make([]FieldErrorDTO, 0, len(causes))
+	for _, cause := range causes {
+		fields = append(fields, FieldErrorDTO{Field: field, Message: cause.Error()})
+	}
+
+	return NewServiceValidationError(fields...)
+}
+
+// NewServiceDeserializationError returns a 422 error for a body that does not match its DTO
+func NewServiceDeserializationError(sourceError error) ServiceHttpError {
+	result := ServiceHttpError{
+		httpCode: http.StatusUnprocessableEntity,
+		code:     ERROR_CODE_MALFORMED_BODY,
+		message:  sourceError.Error(),
+	}
+
+	var typeError *json.UnmarshalTypeError
+	if errors.As(sourceError, &typeError) && len(typeError.Field) != 0 {
+		result.fields = []FieldErrorDTO{{Field: typeError.Field, Message: "expecting " + typeError.Type.String()}}
+	}
+
+	return result
+}
+
 // NewServiceUnauthorizedError returns a new 401 (unauthorized) error
 func NewServiceUnauthorizedError(message string) ServiceHttpError {
 	return ServiceHttpError{
 		httpCode: http.StatusUnauthorized,
+		code:     ERROR_CODE_UNAUTHENTICATED,
 		message:  message,
 	}
 }
@@ -62,6 +214,16 @@ func NewServiceUnauthorizedError(message string) ServiceHttpError {
 func NewServiceForbiddenError(message string) ServiceHttpError {
 	return ServiceHttpError{
 		httpCode: http.StatusForbidden,
+		code:     ERROR_CODE_FORBIDDEN,
+		message:  message,
+	}
+}
+
+// NewServiceConflictError returns a 409 error, for a value that already exists
+func NewServiceConflictError(message string) ServiceHttpError {
+	return ServiceHttpError{
+		httpCode: http.StatusConflict,
+		code:     ERROR_CODE_ALREADY_EXISTS,
 		message:  message,
 	}
 }
@@ -70,14 +232,44 @@ func NewServiceForbiddenError(message string) ServiceHttpError {
 func NewServiceUnprocessableEntityError(message string) ServiceHttpError {
 	return ServiceHttpError{
 		httpCode: http.StatusUnprocessableEntity,
+		code:     ERROR_CODE_UNPROCESSABLE,
+		message:  message,
+	}
+}
+
+// NewServiceBodyTooLargeError returns a 413 error, for a body over the size limit
+func NewServiceBodyTooLargeError(message string) ServiceHttpError {
+	return ServiceHttpError{
+		httpCode: http.StatusRequestEntityTooLarge,
+		code:     ERROR_CODE_BODY_TOO_LARGE,
 		message:  message,
 	}
 }
 
+// NewServiceReadBodyError returns the error to read a body: a 413 if body is over the size limit, a 422 otherwise
+func NewServiceReadBodyError(sourceError error) ServiceHttpError {
+	var sizeError *http.MaxBytesError
+	if errors.As(sourceError, &sizeError) {
+		return NewServiceBodyTooLargeError(sourceError.Error())
+	}
+
+	return NewServiceUnprocessableEntityError(sourceError.Error())
+}
+
 // NewServiceNotFoundError returns a 404 error with a specific message
 func NewServiceNotFoundError(message string) ServiceHttpError {
 	return ServiceHttpError{
 		httpCode: http.StatusNotFound,
+		code:     ERROR_CODE_NOT_FOUND,
+		message:  message,
+	}
+}
+
+// NewServiceTooManyRequestsError returns a 429 error with a specific message
+func NewServiceTooManyRequestsError(message string) ServiceHttpError {
+	return ServiceHttpError{
+		httpCode: http.StatusTooManyRequests,
+		code:     ERROR_CODE_RATE_LIMITED,
 		message:  message,
 	}
 }
@@ -86,6 +278,7 @@ func NewServiceNotFoundError(message string) ServiceHttpError {
 func NewServiceInternalServerError(message string) ServiceHttpError {
 	return ServiceHttpError{
 		httpCode: http.StatusInternalServerError,
+		code:     ERROR_CODE_INTERNAL,
 		message:  message,
 	}
 }
@@ -1,9 +1,13 @@
 package serving
 
 import (
+	"context"
 	"encoding/json"
 	"io"
+	"maps"
 	"net/http"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/zefrenchwan/patterns.git/graphs"
@@ -19,6 +23,48 @@ type GraphDataDTO struct {
 	Sources     []string            `json:"sources,omitempty"`
 }
 
+// ImportedGraphDTO is a graph imported directly (depth 1) or transitively
+type ImportedGraphDTO struct {
+	Id    string `json:"id"`
+	Depth int    `json:"depth"`
+}
+
+// GraphImportsDTO contains direct and transitive imports of a graph
+type GraphImportsDTO struct {
+	Direct     []string           `json:"direct"`
+	Transitive []ImportedGraphDTO `json:"transitive"`
+}
+
+// GraphForkDTO is the input to fork a graph
+type GraphForkDTO struct {
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Flatten     bool   `json:"flatten,omitempty"`
+}
+
+// GraphForkResultDTO is the fork id and, for each copied element, the id of its copy
+type GraphForkResultDTO struct {
+	Id       string            `json:"id"`
+	Elements map[string]string `json:"elements"`
+}
+
+// MergeConflictDTO is a value changed differently in graph and fork
+type MergeConflictDTO struct {
+	ElementId string `json:"element"`
+	Part      string `json:"part"`
+	Value     string `json:"value,omitempty"`
+}
+
+// ForkMergeDTO is the merge of a fork into a graph
+type ForkMergeDTO struct {
+	// Changed are the elements of the graph after merge
+	Changed []storage.ElementDTO `json:"changed"`
+	// Added links fork elements to their copies in the graph
+	Added map[string]string `json:"added"`
+	// Conflicts are the values changed differently in graph and fork, not merged
+	Conflicts []MergeConflictDTO `json:"conflicts"`
+}
+
 // createGraphHandler creates a graph: name, description, and metadata
 func createGraphHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
 	defer r.Body.Close()
@@ -30,11 +76,11 @@ func createGraphHandler(wrapper ServiceParameters, w http.ResponseWriter, r *htt
 
 	var input GraphDataDTO
 	if body, err := io.ReadAll(r.Body); err != nil {
-		return NewServiceInternalServerError(err.Error())
+		return NewServiceReadBodyError(err)
 	} else if errM := json.Unmarshal(body, &input); errM != nil {
-		return NewServiceInternalServerError(errM.Error())
+		return NewServiceDeserializationError(errM)
 	} else if len(input.Name) == 0 {
-		return NewServiceHttpClientError("expecting graph name")
+		return NewServiceValidationError(FieldErrorDTO{Field: "name", Message: "expecting graph name"})
 	}
 
 	newId, errCreate := wrapper.Dao.CreateGraph(wrapper.Ctx, user, input.Name, input.Description, input.Metadata, input.Sources)
@@ -47,6 +93,101 @@ func createGraphHandler(wrapper ServiceParameters, w http.ResponseWriter, r *htt
 	return nil
 }
 
+// forkGraphHandler copies a graph into a new graph the user may modify
+func forkGraphHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	user, auth := wrapper.CurrentUser()
+	if !auth {
+		return NewServiceForbiddenError("should authenticate")
+	}
+
+	graphId := r.PathValue("graphId")
+
+	// empty body means default values
+	var input GraphForkDTO
+	if body, err := io.ReadAll(r.Body); err != nil {
+		return NewServiceReadBodyError(err)
+	} else if len(body) != 0 {
+		if errM := json.Unmarshal(body, &input); errM != nil {
+			return NewServiceDeserializationError(errM)
+		}
+	}
+
+	newId, copies, errFork := wrapper.Dao.ForkGraph(wrapper.Ctx, user, graphId, input.Name, input.Description, input.Flatten)
+	if errFork != nil {
+		return BuildApiErrorFromStorageError(errFork)
+	}
+
+	result := GraphForkResultDTO{Id: newId, Elements: copies}
+	if errResponse := json.NewEncoder(w).Encode(result); errResponse != nil {
+		return NewServiceInternalServerError(errResponse.Error())
+	}
+
+	return nil
+}
+
+// previewForkMergeHandler returns the merge of a fork into a graph, without changing them
+func previewForkMergeHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	return forkMergeHandler(wrapper, w, r, wrapper.Dao.PreviewForkMerge)
+}
+
+// applyForkMergeHandler merges a fork into a graph, and returns the merge
+func applyForkMergeHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	return forkMergeHandler(wrapper, w, r, wrapper.Dao.ApplyForkMerge)
+}
+
+// forkMergeHandler runs merge of fork into graph and returns its result
+func forkMergeHandler(
+	wrapper ServiceParameters, w http.ResponseWriter, r *http.Request,
+	merger func(context.Context, string, string, string) (graphs.ForkMerge, error),
+) error {
+	defer r.Body.Close()
+
+	user, auth := wrapper.CurrentUser()
+	if !auth {
+		return NewServiceForbiddenError("should authenticate")
+	}
+
+	graphId := r.PathValue("graphId")
+	forkId := r.PathValue("forkId")
+
+	merge, errMerge := merger(wrapper.Ctx, user, graphId, forkId)
+	if errMerge != nil {
+		return BuildApiErrorFromStorageError(errMerge)
+	}
+
+	result := ForkMergeDTO{
+		Changed:   make([]storage.ElementDTO, 0, len(merge.Changed)),
+		Added:     make(map[string]string),
+		Conflicts: make([]MergeConflictDTO, 0),
+	}
+
+	for _, id := range slices.Sorted(maps.Keys(merge.Changed)) {
+		if dto, err := storage.SerializeElement(merge.Changed[id]); err != nil {
+			return NewServiceInternalServerError(err.Error())
+		} else {
+			result.Changed = append(result.Changed, dto)
+		}
+	}
+
+	for forkElementId, element := range merge.Added {
+		result.Added[forkElementId] = element.Id()
+	}
+
+	for _, id := range slices.Sorted(maps.Keys(merge.Conflicts)) {
+		for _, conflict := range merge.Conflicts[id] {
+			result.Conflicts = append(result.Conflicts, MergeConflictDTO{ElementId: id, Part: conflict.Part, Value: conflict.Value})
+		}
+	}
+
+	if errResponse := json.NewEncoder(w).Encode(result); errResponse != nil {
+		return NewServiceInternalServerError(errResponse.Error())
+	}
+
+	return nil
+}
+
 // addImportToExistingGraphHandler adds an import into an existing graph
 func addImportToExistingGraphHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
 	defer r.Body.Close()
@@ -67,6 +208,68 @@ func addImportToExistingGraphHandler(wrapper ServiceParameters, w http.ResponseW
 	return nil
 }
 
+// removeImportFromGraphHandler removes a direct import of a graph
+func removeImportFromGraphHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	user, auth := wrapper.CurrentUser()
+	if !auth {
+		return NewServiceForbiddenError("should authenticate")
+	}
+
+	baseGraphId := r.PathValue("baseGraph")
+	importGraphId := r.PathValue("importGraph")
+
+	if err := wrapper.Dao.RemoveImportFromGraph(wrapper.Ctx, user, baseGraphId, importGraphId); err != nil {
+		return BuildApiErrorFromStorageError(err)
+	}
+
+	w.WriteHeader(200)
+	return nil
+}
+
+// listImportsHandler returns the direct and transitive imports of a graph the user may see
+func listImportsHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	user, auth := wrapper.CurrentUser()
+	if !auth {
+		return NewServiceForbiddenError("should authenticate")
+	}
+
+	graphId := r.PathValue("graphId")
+	if len(graphId) == 0 {
+		return NewServiceHttpClientError("expecting graph id")
+	}
+
+	availableGraphs, errList := wrapper.Dao.ListGraphsForUser(wrapper.Ctx, user)
+	if errList != nil {
+		return BuildApiErrorFromStorageError(errList)
+	} else if !slices.ContainsFunc(availableGraphs, func(g storage.AuthGraphDTO) bool { return g.Id == graphId }) {
+		return NewServiceNotFoundError("no graph matching id " + graphId)
+	}
+
+	imports, errImports := wrapper.Dao.ListImportsForUser(wrapper.Ctx, user)
+	if errImports != nil {
+		return BuildApiErrorFromStorageError(errImports)
+	}
+
+	response := GraphImportsDTO{Direct: imports.DirectImports(graphId), Transitive: make([]ImportedGraphDTO, 0)}
+	if response.Direct == nil {
+		response.Direct = make([]string, 0)
+	}
+
+	for _, imported := range imports.TransitiveImports(graphId) {
+		response.Transitive = append(response.Transitive, ImportedGraphDTO{Id: imported.Id, Depth: imported.Depth})
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		return NewServiceInternalServerError(err.Error())
+	}
+
+	return nil
+}
+
 // upsertElementInGraphHandler loads an element dto and then saves it to database
 func deleteGraphHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
 	defer r.Body.Close()
@@ -122,13 +325,44 @@ func loadGraphHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.
 		return NewServiceHttpClientError("expecting graph id")
 	}
 
+	// collapse is optional, to see equivalent elements as one
+	var collapse bool
+	if value := r.URL.Query().Get("collapse"); len(value) != 0 {
+		if parsed, err := strconv.ParseBool(value); err != nil {
+			return NewServiceHttpClientError("invalid collapse parameter")
+		} else {
+			collapse = parsed
+		}
+	}
+
+	// trash is optional, to include elements in trash
+	var withTrash bool
+	if value := r.URL.Query().Get("trash"); len(value) != 0 {
+		if parsed, err := strconv.ParseBool(value); err != nil {
+			return NewServiceHttpClientError("invalid trash parameter")
+		} else {
+			withTrash = parsed
+		}
+	}
+
+	loader := wrapper.Dao.LoadGraphForUser
+	if withTrash {
+		loader = wrapper.Dao.LoadGraphWithTrashForUser
+	}
+
 	var rawGraph graphs.Graph
-	if raw, err := wrapper.Dao.LoadGraphForUser(wrapper.Ctx, user, graphId); err != nil {
+	if raw, err := loader(wrapper.Ctx, user, graphId); err != nil {
 		return BuildApiErrorFromStorageError(err)
 	} else {
 		rawGraph = raw
 	}
 
+	if collapse {
+		if _, err := rawGraph.CollapseEquivalences(); err != nil {
+			return NewServiceInternalServerError(err.Error())
+		}
+	}
+
 	switch rawGraph.Id {
 	case "":
 		w.WriteHeader(404)
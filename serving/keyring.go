@@ -0,0 +1,328 @@
+package serving
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zefrenchwan/patterns.git/storage"
+)
+
+const (
+	// SIGNING_ALGORITHM_EDDSA signs tokens with Ed25519 keys
+	SIGNING_ALGORITHM_EDDSA = "EdDSA"
+	// SIGNING_ALGORITHM_RS256 signs tokens with RSA keys and SHA-256
+	SIGNING_ALGORITHM_RS256 = "RS256"
+	// RSA_KEY_SIZE is the size in bits of generated RSA keys
+	RSA_KEY_SIZE = 2048
+	// KEYRING_REFRESH_PERIOD is the delay before keys are loaded again, to see keys of other servers
+	KEYRING_REFRESH_PERIOD = time.Minute
+	// KEYRING_MIN_RELOAD_PERIOD is the minimum delay between two loads for an unknown key id
+	KEYRING_MIN_RELOAD_PERIOD = 5 * time.Second
+)
+
+// SIGNING_ALGORITHMS are the accepted algorithms for access tokens
+var SIGNING_ALGORITHMS = []string{SIGNING_ALGORITHM_EDDSA, SIGNING_ALGORITHM_RS256}
+
+// signingKey is a loaded key to sign access tokens
+type signingKey struct {
+	id        string
+	algorithm string
+	createdAt time.Time
+	private   crypto.Signer
+}
+
+// Keyring manages the keys signing access tokens, stored in database and shared by all servers.
+// Newest key of the algorithm signs, it is replaced after rotation.
+// Previous keys validate tokens until they expire, then they are deleted.
+// Private keys are encrypted in database with a secret from configuration
+type Keyring struct {
+	dao       storage.Dao
+	secret    []byte
+	algorithm string
+	rotation  time.Duration
+	// retention is how long a key is kept once replaced
+	retention time.Duration
+	// mutex protects keys and loadedAt
+	mutex    sync.Mutex
+	keys     []signingKey
+	loadedAt time.Time
+}
+
+// NewKeyring returns a keyring signing with algorithm, keys are encrypted with secret. Keys are loaded on first use
+func NewKeyring(dao storage.Dao, secret []byte, algorithm string, rotation time.Duration, retention time.Duration) *Keyring {
+	return &Keyring{
+		dao:       dao,
+		secret:    secret,
+		algorithm: algorithm,
+		rotation:  rotation,
+		retention: retention,
+	}
+}
+
+// activeKey returns the key to sign new tokens, a new key is created if needed
+func (k *Keyring) activeKey(ctx context.Context) (signingKey, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if time.Since(k.loadedAt) >= KEYRING_REFRESH_PERIOD {
+		if err := k.load(ctx); err != nil {
+			return signingKey{}, err
+		}
+	}
+
+	if key, found := k.newestKey(); found {
+		return key, nil
+	} else if err := k.rotate(ctx); err != nil {
+		return signingKey{}, err
+	} else if key, found := k.newestKey(); found {
+		return key, nil
+	}
+
+	return signingKey{}, errors.New("no signing key")
+}
+
+// VerificationKey returns the public key and the algorithm of a key id.
+// Keys are loaded again for an unknown id, another server may have created it
+func (k *Keyring) VerificationKey(ctx context.Context, keyId string) (crypto.PublicKey, string, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	stale := time.Since(k.loadedAt) >= KEYRING_REFRESH_PERIOD
+	if key, found := k.findKey(keyId); found && !stale {
+		return key.private.Public(), key.algorithm, nil
+	} else if !stale && time.Since(k.loadedAt) < KEYRING_MIN_RELOAD_PERIOD {
+		return nil, "", fmt.Errorf("unknown key %s", keyId)
+	} else if err := k.load(ctx); err != nil {
+		return nil, "", err
+	} else if key, found := k.findKey(keyId); found {
+		return key.private.Public(), key.algorithm, nil
+	}
+
+	return nil, "", fmt.Errorf("unknown key %s", keyId)
+}
+
+// PublicKeys returns the public keys of all valid keys, as a JSON web key set
+func (k *Keyring) PublicKeys(ctx context.Context) (JsonWebKeySetDTO, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	result := JsonWebKeySetDTO{Keys: make([]JsonWebKeyDTO, 0)}
+	if time.Since(k.loadedAt) >= KEYRING_REFRESH_PERIOD {
+		if err := k.load(ctx); err != nil {
+			return result, err
+		}
+	}
+
+	for _, key := range k.keys {
+		if value, err := serializePublicKey(key); err != nil {
+			return result, err
+		} else {
+			result.Keys = append(result.Keys, value)
+		}
+	}
+
+	return result, nil
+}
+
+// load reads keys from the database. Caller holds the lock
+func (k *Keyring) load(ctx context.Context) error {
+	values, errLoad := k.dao.LoadSigningKeys(ctx)
+	if errLoad != nil {
+		return errLoad
+	}
+
+	keys := make([]signingKey, 0, len(values))
+	for _, value := range values {
+		content, errOpen := OpenSigningKey(k.secret, value.Id, value.PrivateKey)
+		if errOpen != nil {
+			return fmt.Errorf("cannot decrypt signing key %s: %s", value.Id, errOpen.Error())
+		}
+
+		parsed, errParse := x509.ParsePKCS8PrivateKey(content)
+		if errParse != nil {
+			return fmt.Errorf("invalid signing key %s: %s", value.Id, errParse.Error())
+		}
+
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return fmt.Errorf("invalid signing key %s: not a signer", value.Id)
+		}
+
+		keys = append(keys, signingKey{
+			id:        value.Id,
+			algorithm: value.Algorithm,
+			createdAt: value.CreatedAt,
+			private:   signer,
+		})
+	}
+
+	k.keys = keys
+	k.loadedAt = time.Now()
+	return nil
+}
+
+// newestKey returns the newest key of the algorithm, if not older than rotation. Caller holds the lock
+func (k *Keyring) newestKey() (signingKey, bool) {
+	for _, key := range k.keys {
+		if key.algorithm != k.algorithm {
+			continue
+		} else if time.Since(key.createdAt) < k.rotation {
+			return key, true
+		} else {
+			return signingKey{}, false
+		}
+	}
+
+	return signingKey{}, false
+}
+
+// findKey returns the key with that id, if any. Caller holds the lock
+func (k *Keyring) findKey(keyId string) (signingKey, bool) {
+	for _, key := range k.keys {
+		if key.id == keyId {
+			return key, true
+		}
+	}
+
+	return signingKey{}, false
+}
+
+// rotate creates a new key, deletes keys that signed no valid token and loads keys. Caller holds the lock
+func (k *Keyring) rotate(ctx context.Context) error {
+	private, errGenerate := generatePrivateKey(k.algorithm)
+	if errGenerate != nil {
+		return errGenerate
+	}
+
+	content, errMarshal := x509.MarshalPKCS8PrivateKey(private)
+	if errMarshal != nil {
+		return errMarshal
+	}
+
+	keyId := uuid.NewString()
+	sealed, errSeal := SealSigningKey(k.secret, keyId, content)
+	if errSeal != nil {
+		return errSeal
+	}
+
+	key := storage.SigningKeyDTO{Id: keyId, Algorithm: k.algorithm, PrivateKey: sealed}
+	if err := k.dao.InsertSigningKey(ctx, key); err != nil {
+		return err
+	}
+
+	// a key signs during rotation, its last tokens are valid during retention
+	limit := time.Now().Add(-k.rotation - k.retention)
+	if err := k.dao.DeleteSigningKeysBefore(ctx, limit); err != nil {
+		return err
+	}
+
+	return k.load(ctx)
+}
+
+// generatePrivateKey returns a new private key for algorithm
+func generatePrivateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case SIGNING_ALGORITHM_EDDSA:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	case SIGNING_ALGORITHM_RS256:
+		return rsa.GenerateKey(rand.Reader, RSA_KEY_SIZE)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %s", algorithm)
+	}
+}
+
+// signingKeyCipher returns the AES-GCM cipher of secret
+func signingKeyCipher(secret []byte) (cipher.AEAD, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("no secret to encrypt signing keys")
+	}
+
+	block, errBlock := aes.NewCipher(secret)
+	if errBlock != nil {
+		return nil, errBlock
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// SealSigningKey encrypts the content of a private key with secret, as nonce then ciphertext.
+// Key id is authenticated too, so that a value cannot be copied to another key
+func SealSigningKey(secret []byte, keyId string, content []byte) ([]byte, error) {
+	aead, errCipher := signingKeyCipher(secret)
+	if errCipher != nil {
+		return nil, errCipher
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(content)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, content, []byte(keyId)), nil
+}
+
+// OpenSigningKey decrypts a private key encrypted by SealSigningKey with the same secret and key id
+func OpenSigningKey(secret []byte, keyId string, sealed []byte) ([]byte, error) {
+	aead, errCipher := signingKeyCipher(secret)
+	if errCipher != nil {
+		return nil, errCipher
+	} else if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted key is too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, []byte(keyId))
+}
+
+// JsonWebKeyDTO is a public key in the JSON web key format (RFC 7517)
+type JsonWebKeyDTO struct {
+	KeyType   string `json:"kty"`
+	KeyId     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// Curve and X are set for Ed25519 keys
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	// N and E are set for RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+}
+
+// JsonWebKeySetDTO is a set of public keys (RFC 7517)
+type JsonWebKeySetDTO struct {
+	Keys []JsonWebKeyDTO `json:"keys"`
+}
+
+// serializePublicKey returns the public part of a key as a JSON web key
+func serializePublicKey(key signingKey) (JsonWebKeyDTO, error) {
+	result := JsonWebKeyDTO{KeyId: key.id, Use: "sig", Algorithm: key.algorithm}
+	encoding := base64.RawURLEncoding
+	switch public := key.private.Public().(type) {
+	case ed25519.PublicKey:
+		result.KeyType = "OKP"
+		result.Curve = "Ed25519"
+		result.X = encoding.EncodeToString(public)
+	case *rsa.PublicKey:
+		result.KeyType = "RSA"
+		result.N = encoding.EncodeToString(public.N.Bytes())
+		result.E = encoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	default:
+		return result, fmt.Errorf("unsupported key type for key %s", key.id)
+	}
+
+	return result, nil
+}
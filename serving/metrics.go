@@ -0,0 +1,101 @@
+package serving
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zefrenchwan/patterns.git/metrics"
+)
+
+// METRICS_CONTENT_TYPE is the content type of the prometheus text exposition format
+const METRICS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	// httpRequests counts requests per route, method and status
+	httpRequests = metrics.Default.NewCounterVec(
+		"patterns_http_requests_total",
+		"Handled requests per route, method and status",
+		"route", "method", "status",
+	)
+
+	// httpDurations measures requests per route and method
+	httpDurations = metrics.Default.NewHistogramVec(
+		"patterns_http_request_duration_seconds",
+		"Duration of requests in seconds, per route and method",
+		metrics.DefaultBuckets, "route", "method",
+	)
+
+	// httpThrottled counts requests rejected by rate limits, per route, method and class
+	httpThrottled = metrics.Default.NewCounterVec(
+		"patterns_http_requests_throttled_total",
+		"Requests rejected by rate limits, per route, method and class of route",
+		"route", "method", "class",
+	)
+
+	// httpInFlight is the number of requests being served
+	httpInFlight = metrics.Default.NewGauge(
+		"patterns_http_requests_in_flight",
+		"Requests currently served",
+	)
+)
+
+// statusRecorder keeps the status code written by an handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader keeps status code and writes it
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Write sets status to 200 if no status was written
+func (s *statusRecorder) Write(content []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+
+	return s.ResponseWriter.Write(content)
+}
+
+// Status returns the written status code, 200 if handler wrote no status
+func (s *statusRecorder) Status() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+
+	return s.status
+}
+
+// instrumentHandler measures the requests of route for method
+func instrumentHandler(route string, method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpInFlight.Inc()
+		defer httpInFlight.Dec()
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		handler(recorder, r)
+
+		httpDurations.Observe(time.Since(start).Seconds(), route, method)
+		httpRequests.Inc(route, method, strconv.Itoa(recorder.Status()))
+	}
+}
+
+// metricsHandler exposes metrics in the prometheus text format
+func metricsHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	w.Header().Set("Content-Type", METRICS_CONTENT_TYPE)
+	if err := metrics.Default.WriteText(w); err != nil {
+		return NewServiceInternalServerError(err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,220 @@
+package serving
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/zefrenchwan/patterns.git/oidc"
+	"github.com/zefrenchwan/patterns.git/storage"
+)
+
+const (
+	// OIDC_STATE_COOKIE keeps the state of a login between redirection and callback
+	OIDC_STATE_COOKIE = "patterns_oidc_state"
+	// OIDC_STATE_DURATION is the maximum duration of a login at the identity provider
+	OIDC_STATE_DURATION = 10 * time.Minute
+	// OIDC_STATE_AUDIENCE is the audience of state tokens, so that they are not access tokens
+	OIDC_STATE_AUDIENCE = "oidc_state"
+	// OIDC_HTTP_TIMEOUT is the maximum duration of a call to the identity provider
+	OIDC_HTTP_TIMEOUT = 10 * time.Second
+)
+
+// oidcStateClaims is the content of the state cookie, signed by keyring. Id is the state
+type oidcStateClaims struct {
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.RegisteredClaims
+}
+
+// ExternalIdentityInput is the input to link an external subject to an user
+type ExternalIdentityInput struct {
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
+}
+
+// loginExternalIdentity returns the local user of a verified identity, provisioned and with roles of its groups if settings say so
+func loginExternalIdentity(wrapper ServiceParameters, identity oidc.Identity) (string, error) {
+	settings := wrapper.Identity.Settings()
+	return wrapper.Dao.LoginExternalIdentity(wrapper.Ctx, storage.ExternalIdentityDTO{
+		Issuer:        identity.Issuer,
+		Subject:       identity.Subject,
+		Login:         identity.Username,
+		AutoProvision: settings.AutoProvision,
+		Roles:         settings.RolesForGroups(identity.Groups),
+	})
+}
+
+// validateExternalToken validates a bearer token of the identity provider, and returns its local user.
+// Roles of its groups are synchronized too, at most once per period unless they change
+func validateExternalToken(wrapper ServiceParameters, rawToken string) (string, string, bool, error) {
+	identity, errVerify := wrapper.Identity.VerifyBearerToken(wrapper.Ctx, rawToken)
+	if errVerify != nil {
+		return "", "", false, fmt.Errorf("invalid external token: %s", errVerify.Error())
+	}
+
+	// provisioning synchronizes roles
+	syncKey := identity.Issuer + " " + identity.Subject
+	roles := wrapper.Identity.Settings().RolesForGroups(identity.Groups)
+	login, notBefore, errFind := wrapper.Dao.FindUserForExternalIdentity(wrapper.Ctx, identity.Issuer, identity.Subject)
+	if errors.Is(errFind, storage.ErrUnknownExternalIdentity) && wrapper.Identity.Settings().AutoProvision {
+		if login, errFind = loginExternalIdentity(wrapper, identity); errFind == nil {
+			wrapper.RoleSyncer.Synced(syncKey, roles)
+		}
+	}
+
+	if errFind != nil {
+		return "", "", false, errFind
+	} else if IsRevokedToken(identity.IssuedAt, notBefore) {
+		return login, "", false, fmt.Errorf("revoked token")
+	} else if roles == nil || !wrapper.RoleSyncer.ShouldSync(syncKey, roles) {
+		return login, "", true, nil
+	}
+
+	if _, err := loginExternalIdentity(wrapper, identity); err != nil {
+		return login, "", false, err
+	}
+
+	wrapper.RoleSyncer.Synced(syncKey, roles)
+	return login, "", true, nil
+}
+
+// oidcLoginHandler redirects to the identity provider, state of the login is kept in a signed cookie
+func oidcLoginHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	if wrapper.Identity == nil {
+		return NewServiceNotFoundError("no identity provider")
+	}
+
+	var values []string
+	for range 3 {
+		if value, err := oidc.NewRandomValue(); err != nil {
+			return NewServiceInternalServerError(err.Error())
+		} else {
+			values = append(values, value)
+		}
+	}
+
+	state, nonce, verifier := values[0], values[1], values[2]
+	authorization, errUrl := wrapper.Identity.AuthorizationUrl(wrapper.Ctx, state, nonce, verifier)
+	if errUrl != nil {
+		return NewServiceInternalServerError(errUrl.Error())
+	}
+
+	now := time.Now()
+	signed, errSign := signClaims(wrapper, oidcStateClaims{
+		Nonce:    nonce,
+		Verifier: verifier,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        state,
+			Issuer:    wrapper.Settings.Issuer,
+			Audience:  jwt.ClaimStrings{OIDC_STATE_AUDIENCE},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(OIDC_STATE_DURATION)),
+		},
+	})
+
+	if errSign != nil {
+		return BuildApiErrorFromStorageError(errSign)
+	}
+
+	http.SetCookie(w, oidcStateCookie(r, signed, int(OIDC_STATE_DURATION.Seconds())))
+	http.Redirect(w, r, authorization, http.StatusFound)
+	return nil
+}
+
+// oidcCallbackHandler ends the login at the identity provider and returns tokens of the matching local user
+func oidcCallbackHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	if wrapper.Identity == nil {
+		return NewServiceNotFoundError("no identity provider")
+	}
+
+	query := r.URL.Query()
+	if value := query.Get("error"); len(value) != 0 {
+		return NewServiceUnauthorizedError("identity provider refused login: " + value)
+	}
+
+	var claims oidcStateClaims
+	if cookie, err := r.Cookie(OIDC_STATE_COOKIE); err != nil {
+		return NewServiceHttpClientError("missing login state")
+	} else if _, err := parseSignedClaims(wrapper, cookie.Value, &claims,
+		jwt.WithIssuer(wrapper.Settings.Issuer),
+		jwt.WithAudience(OIDC_STATE_AUDIENCE),
+	); err != nil {
+		return NewServiceHttpClientError("invalid login state")
+	} else if subtle.ConstantTimeCompare([]byte(claims.ID), []byte(query.Get("state"))) != 1 {
+		return NewServiceHttpClientError("state mismatch")
+	}
+
+	// state is single use
+	http.SetCookie(w, oidcStateCookie(r, "", -1))
+
+	idToken, errExchange := wrapper.Identity.Exchange(wrapper.Ctx, query.Get("code"), claims.Verifier)
+	if errExchange != nil {
+		return NewServiceUnauthorizedError(errExchange.Error())
+	}
+
+	identity, errVerify := wrapper.Identity.VerifyIdToken(wrapper.Ctx, idToken, claims.Nonce)
+	if errVerify != nil {
+		return NewServiceUnauthorizedError(errVerify.Error())
+	}
+
+	login, errLogin := loginExternalIdentity(wrapper, identity)
+	if errLogin != nil {
+		return BuildApiErrorFromStorageError(errLogin)
+	}
+
+	return writeTokens(wrapper, w, login, "")
+}
+
+// oidcStateCookie returns the state cookie with that value, deleted if max age is negative
+func oidcStateCookie(r *http.Request, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     OIDC_STATE_COOKIE,
+		Value:    value,
+		Path:     "/oidc/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// linkExternalIdentityHandler maps a subject of an external issuer to an user. Current user should manage that user
+func linkExternalIdentityHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	user, auth := wrapper.CurrentUser()
+	if !auth {
+		return NewServiceForbiddenError("should authenticate")
+	}
+
+	login := r.PathValue("login")
+	if len(login) == 0 {
+		return NewServiceHttpClientError("expecting login")
+	}
+
+	var input ExternalIdentityInput
+	if body, errBody := io.ReadAll(r.Body); errBody != nil {
+		return NewServiceReadBodyError(errBody)
+	} else if err := json.Unmarshal(body, &input); err != nil {
+		return NewServiceDeserializationError(err)
+	} else if len(input.Issuer) == 0 {
+		return NewServiceValidationError(FieldErrorDTO{Field: "issuer", Message: "expecting issuer"})
+	} else if len(input.Subject) == 0 {
+		return NewServiceValidationError(FieldErrorDTO{Field: "subject", Message: "expecting subject"})
+	} else if err := wrapper.Dao.LinkExternalIdentity(wrapper.Ctx, user, login, input.Issuer, input.Subject); err != nil {
+		return BuildApiErrorFromStorageError(err)
+	}
+
+	w.WriteHeader(200)
+	return nil
+}
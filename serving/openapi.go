@@ -0,0 +1,458 @@
+package serving
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zefrenchwan/patterns.git/storage"
+)
+
+const (
+	// OPENAPI_VERSION is the version of the OpenAPI specification the document follows
+	OPENAPI_VERSION = "3.0.3"
+	// OPENAPI_TITLE is the title of the api in the document
+	OPENAPI_TITLE = "patterns"
+	// OPENAPI_SCHEMAS_PREFIX prefixes references to schemas
+	OPENAPI_SCHEMAS_PREFIX = "#/components/schemas/"
+	// OPENAPI_PROBLEM_RESPONSE is the response of errors, shared by all operations
+	OPENAPI_PROBLEM_RESPONSE = "Problem"
+)
+
+// RouteDTO is a route registered in a mux
+type RouteDTO struct {
+	Method        string `json:"method"`
+	Pattern       string `json:"pattern"`
+	Authenticated bool   `json:"authenticated"`
+}
+
+// RouteRegistry keeps the routes of a mux, in registration order
+type RouteRegistry struct {
+	mutex  sync.Mutex
+	routes []RouteDTO
+}
+
+// NewRouteRegistry returns an empty registry
+func NewRouteRegistry() *RouteRegistry {
+	return &RouteRegistry{}
+}
+
+// add registers a route. Nil registry registers nothing
+func (rr *RouteRegistry) add(route RouteDTO) {
+	if rr == nil {
+		return
+	}
+
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+	rr.routes = append(rr.routes, route)
+}
+
+// Routes returns a copy of registered routes
+func (rr *RouteRegistry) Routes() []RouteDTO {
+	if rr == nil {
+		return nil
+	}
+
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+	return append([]RouteDTO(nil), rr.routes...)
+}
+
+// OpenApiDTO is an OpenAPI 3 document
+type OpenApiDTO struct {
+	OpenApi    string                                    `json:"openapi"`
+	Info       OpenApiInfoDTO                            `json:"info"`
+	Paths      map[string]map[string]OpenApiOperationDTO `json:"paths"`
+	Components OpenApiComponentsDTO                      `json:"components"`
+}
+
+// OpenApiInfoDTO describes the api
+type OpenApiInfoDTO struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// OpenApiOperationDTO is a method on a path
+type OpenApiOperationDTO struct {
+	Summary     string                        `json:"summary,omitempty"`
+	Tags        []string                      `json:"tags,omitempty"`
+	Parameters  []OpenApiParameterDTO         `json:"parameters,omitempty"`
+	RequestBody *OpenApiRequestBodyDTO        `json:"requestBody,omitempty"`
+	Responses   map[string]OpenApiResponseDTO `json:"responses"`
+	// Security lists alternative schemes, empty for public operations
+	Security []map[string][]string `json:"security,omitempty"`
+}
+
+// OpenApiParameterDTO is a parameter in path or in query
+type OpenApiParameterDTO struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required"`
+	Schema      map[string]any `json:"schema"`
+}
+
+// OpenApiRequestBodyDTO is the body of a request
+type OpenApiRequestBodyDTO struct {
+	Required bool                           `json:"required"`
+	Content  map[string]OpenApiMediaTypeDTO `json:"content"`
+}
+
+// OpenApiMediaTypeDTO is the schema of a content
+type OpenApiMediaTypeDTO struct {
+	Schema map[string]any `json:"schema"`
+}
+
+// OpenApiResponseDTO is a response, or a reference to a shared response
+type OpenApiResponseDTO struct {
+	Ref         string                         `json:"$ref,omitempty"`
+	Description string                         `json:"description,omitempty"`
+	Headers     map[string]OpenApiHeaderDTO    `json:"headers,omitempty"`
+	Content     map[string]OpenApiMediaTypeDTO `json:"content,omitempty"`
+}
+
+// OpenApiHeaderDTO is a header of a response
+type OpenApiHeaderDTO struct {
+	Description string         `json:"description,omitempty"`
+	Schema      map[string]any `json:"schema"`
+}
+
+// OpenApiComponentsDTO contains values shared by operations
+type OpenApiComponentsDTO struct {
+	Schemas         map[string]map[string]any     `json:"schemas"`
+	Responses       map[string]OpenApiResponseDTO `json:"responses"`
+	SecuritySchemes map[string]map[string]any     `json:"securitySchemes"`
+}
+
+// queryParameter is an optional parameter in query
+type queryParameter struct {
+	name        string
+	description string
+	kind        string
+}
+
+// routeDocumentation documents a route, body and response are values of their types (nil for none)
+type routeDocumentation struct {
+	summary  string
+	query    []queryParameter
+	request  any
+	response any
+	// status is the success status, 200 if not set
+	status int
+	// contentType is the content type of response, json if not set
+	contentType string
+}
+
+// routeDocumentations documents routes per method and pattern.
+// A registered route with no documentation is in the document, with no summary
+var routeDocumentations = map[string]routeDocumentation{
+	"GET /status/":               {summary: "Status of the server and its database", response: CheckStatusResponse{}},
+	"GET /status/live/":          {summary: "Liveness probe, does not depend on database", response: HealthDTO{}},
+	"GET /status/ready/":         {summary: "Readiness probe, 503 when a component is down", response: HealthDTO{}},
+	"GET /metrics":               {summary: "Metrics in prometheus text format", response: "", contentType: METRICS_CONTENT_TYPE},
+	"GET /.well-known/jwks.json": {summary: "Public keys validating access tokens", response: JsonWebKeySetDTO{}},
+	"GET /openapi.json":          {summary: "This document", response: map[string]any{}},
+	"POST /token/":               {summary: "Log in, returns an access token and a refresh token", request: UserInformationInput{}, response: TokensDTO{}},
+	"POST /token/refresh/":       {summary: "Exchange a refresh token for new tokens, refresh tokens are single use", request: RefreshTokenInput{}, response: TokensDTO{}},
+	"POST /token/logout/":        {summary: "End the session of a refresh token", request: RefreshTokenInput{}},
+	"GET /oidc/login/":           {summary: "Redirect to the OpenID Connect issuer", status: http.StatusFound},
+	"GET /oidc/callback/": {
+		summary: "End a login at the OpenID Connect issuer, returns tokens of the local user",
+		query: []queryParameter{
+			{name: "code", description: "authorization code"},
+			{name: "state", description: "state of the login"},
+			{name: "error", description: "error of the issuer"},
+		},
+		response: TokensDTO{},
+	},
+	"PUT /user/identities/link/{login}/":                   {summary: "Map an external subject to an user", request: ExternalIdentityInput{}},
+	"POST /user/upsert/":                                   {summary: "Create an user or change its password", request: UserInformationInput{}},
+	"DELETE /user/sessions/{login}/":                       {summary: "End all sessions of an user"},
+	"PUT /user/activate/{login}/":                          {summary: "Activate an user and end its lockout"},
+	"PUT /user/deactivate/{login}/":                        {summary: "Deactivate an user and end its sessions"},
+	"POST /user/apikeys/create/{login}/":                   {summary: "Create an api key, its value is only sent once", request: ApiKeyInput{}, response: ApiKeyCreationDTO{}},
+	"GET /user/apikeys/list/{login}/":                      {summary: "List api keys of an user", response: []storage.ApiKeyDTO{}},
+	"DELETE /user/apikeys/revoke/{login}/{keyId}/":         {summary: "Revoke an api key"},
+	"POST /graph/create/":                                  {summary: "Create a graph, returns its id", request: GraphDataDTO{}, response: ""},
+	"POST /graph/fork/{graphId}/":                          {summary: "Fork a graph", request: GraphForkDTO{}, response: GraphForkResultDTO{}},
+	"GET /graph/merge/preview/{forkId}/into/{graphId}/":    {summary: "Changes and conflicts of merging a fork", response: ForkMergeDTO{}},
+	"PUT /graph/merge/apply/{forkId}/into/{graphId}/":      {summary: "Merge a fork into its graph", response: ForkMergeDTO{}},
+	"PUT /graph/import/{importGraph}/into/{baseGraph}/":    {summary: "Import a graph into another graph"},
+	"DELETE /graph/import/{importGraph}/from/{baseGraph}/": {summary: "Remove an import of a graph"},
+	"GET /graph/imports/{graphId}/":                        {summary: "Imports of a graph", response: GraphImportsDTO{}},
+	"DELETE /graph/delete/{graphId}/":                      {summary: "Move a graph to trash"},
+	"GET /graph/trash/{graphId}/":                          {summary: "Graph and elements in trash", response: []storage.TrashItemDTO{}},
+	"PUT /graph/restore/{graphId}/":                        {summary: "Restore a graph from trash"},
+	"GET /graph/list/":                                     {summary: "Graphs the user has access to", response: []storage.AuthGraphDTO{}},
+	"GET /graph/load/{graphId}/": {
+		summary: "Load a graph and its elements",
+		query: []queryParameter{
+			{name: "collapse", description: "see equivalent elements as one", kind: "boolean"},
+			{name: "trash", description: "include elements in trash", kind: "boolean"},
+		},
+		response: storage.GraphWithElementsDTO{},
+	},
+	"GET /graph/slice/{graphId}/since/{moment}/":            {summary: "Graph restricted to a period starting at moment", response: storage.GraphWithElementsDTO{}},
+	"GET /graph/slice/{graphId}/between/{start}/and/{end}/": {summary: "Graph restricted to a period", response: storage.GraphWithElementsDTO{}},
+	"GET /graph/snapshot/{graphId}/at/{moment}/":            {summary: "Graph as it was at moment", response: storage.GraphWithElementsDTO{}},
+	"DELETE /graph/all/clear/":                              {summary: "Delete all graphs the user may modify"},
+	"GET /graph/aggregate/{graphId}/trait/{trait}/between/{start}/and/{end}/per/{bucket}/": {
+		summary:  "Count elements of a trait, and aggregate an attribute, per time bucket",
+		query:    []queryParameter{{name: "attribute", description: "numeric attribute to aggregate"}},
+		response: []storage.TimeBucketDTO{},
+	},
+	"PUT /elements/copy/{elementId}/to/{graphId}/": {summary: "Copy an element as an equivalent element, returns its id", response: ""},
+	"GET /elements/load/{elementId}/": {
+		summary:  "Load an element",
+		query:    []queryParameter{{name: "trash", description: "load element in trash", kind: "boolean"}},
+		response: storage.ElementDTO{},
+	},
+	"POST /elements/upsert/graph/{graphId}/":        {summary: "Create or update an element in a graph", request: storage.ElementDTO{}},
+	"DELETE /elements/delete/{elementId}/":          {summary: "Move an element to trash"},
+	"PUT /elements/restore/{elementId}/":            {summary: "Restore an element from trash"},
+	"POST /elements/merge/":                         {summary: "Merge elements into a target, returns the id of the merge", request: MergeDataDTO{}, response: ""},
+	"PUT /elements/merge/undo/{mergeId}/":           {summary: "Undo a merge"},
+	"PUT /elements/split/{elementId}/at/{moment}/":  {summary: "Split an element at moment", response: SplitResultDTO{}},
+	"GET /elements/{elementId}/equivalence/status/": {summary: "Differences between an element and its equivalence parent", response: EquivalenceStatusDTO{}},
+	"PUT /elements/{elementId}/equivalence/pull/": {
+		summary:  "Update an element from its equivalence parent",
+		query:    []queryParameter{{name: "force", description: "overwrite local changes", kind: "boolean"}},
+		response: SyncResultDTO{},
+	},
+	"PUT /elements/{elementId}/equivalence/push/": {
+		summary:  "Update the equivalence parent of an element from that element",
+		query:    []queryParameter{{name: "force", description: "overwrite changes of parent", kind: "boolean"}},
+		response: SyncResultDTO{},
+	},
+	"GET /elements/lineage/{elementId}/": {summary: "Ancestors and copies of an element", response: storage.LineageDTO{}},
+	"GET /audit/": {
+		summary: "Audit entries, most recent first",
+		query: []queryParameter{
+			{name: "actor", description: "user that made changes"},
+			{name: "graph", description: "changed graph"},
+			{name: "element", description: "changed element"},
+			{name: "start", description: "minimum moment, as " + URL_DATE_FORMAT},
+			{name: "end", description: "maximum moment, as " + URL_DATE_FORMAT},
+			{name: "limit", description: "maximum number of entries", kind: "integer"},
+		},
+		response: []storage.AuditEntryDTO{},
+	},
+	"GET /find/neighbors/of/entities/for/trait/{trait}/":                           {summary: "Entities of a trait and their neighbors, query parameters match attributes", response: storage.GraphWithElementsDTO{}},
+	"GET /find/neighbors/of/entities/for/trait/{trait}/since/{start}/":             {summary: "Entities of a trait and their neighbors since start, query parameters match attributes", response: storage.GraphWithElementsDTO{}},
+	"GET /find/neighbors/of/entities/for/trait/{trait}/until/{end}/":               {summary: "Entities of a trait and their neighbors until end, query parameters match attributes", response: storage.GraphWithElementsDTO{}},
+	"GET /find/neighbors/of/entities/for/trait/{trait}/between/{start}/and/{end}/": {summary: "Entities of a trait and their neighbors between start and end, query parameters match attributes", response: storage.GraphWithElementsDTO{}},
+}
+
+// momentParameters are path parameters that are dates
+var momentParameters = map[string]bool{"moment": true, "start": true, "end": true}
+
+// BuildOpenApi returns the OpenAPI document of routes
+func BuildOpenApi(routes []RouteDTO) OpenApiDTO {
+	schemas := schemaBuilder{schemas: make(map[string]map[string]any)}
+	problem := schemas.schema(reflect.TypeOf(ProblemDTO{}))
+
+	result := OpenApiDTO{
+		OpenApi: OPENAPI_VERSION,
+		Info:    OpenApiInfoDTO{Title: OPENAPI_TITLE, Version: strconv.Itoa(storage.SCHEMA_VERSION)},
+		Paths:   make(map[string]map[string]OpenApiOperationDTO),
+		Components: OpenApiComponentsDTO{
+			Schemas: schemas.schemas,
+			Responses: map[string]OpenApiResponseDTO{
+				OPENAPI_PROBLEM_RESPONSE: {
+					Description: "Error as problem details, code is stable",
+					Content:     map[string]OpenApiMediaTypeDTO{PROBLEM_CONTENT_TYPE: {Schema: problem}},
+				},
+			},
+			SecuritySchemes: map[string]map[string]any{
+				"bearer": {"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"apiKey": {"type": "apiKey", "in": "header", "name": "Authorization", "description": "Authorization: " + API_KEY_SCHEME + "<key>"},
+			},
+		},
+	}
+
+	for _, route := range routes {
+		operations, found := result.Paths[route.Pattern]
+		if !found {
+			operations = make(map[string]OpenApiOperationDTO)
+			result.Paths[route.Pattern] = operations
+		}
+
+		operations[strings.ToLower(route.Method)] = buildOperation(route, &schemas)
+	}
+
+	return result
+}
+
+// buildOperation returns the operation of a route, from its documentation
+func buildOperation(route RouteDTO, schemas *schemaBuilder) OpenApiOperationDTO {
+	documentation := routeDocumentations[route.Method+" "+route.Pattern]
+	operation := OpenApiOperationDTO{
+		Summary:   documentation.summary,
+		Responses: make(map[string]OpenApiResponseDTO),
+	}
+
+	// tag is the first part of the path, as graph or elements
+	if tag := strings.Split(strings.Trim(route.Pattern, "/"), "/")[0]; len(tag) != 0 {
+		operation.Tags = []string{tag}
+	}
+
+	for _, part := range strings.Split(route.Pattern, "/") {
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			continue
+		}
+
+		name := part[1 : len(part)-1]
+		parameter := OpenApiParameterDTO{Name: name, In: "path", Required: true, Schema: map[string]any{"type": "string"}}
+		if momentParameters[name] {
+			parameter.Description = "date as " + URL_DATE_FORMAT
+		}
+
+		operation.Parameters = append(operation.Parameters, parameter)
+	}
+
+	for _, query := range documentation.query {
+		kind := query.kind
+		if len(kind) == 0 {
+			kind = "string"
+		}
+
+		operation.Parameters = append(operation.Parameters, OpenApiParameterDTO{
+			Name:        query.name,
+			In:          "query",
+			Description: query.description,
+			Schema:      map[string]any{"type": kind},
+		})
+	}
+
+	if documentation.request != nil {
+		operation.RequestBody = &OpenApiRequestBodyDTO{
+			Required: true,
+			Content:  map[string]OpenApiMediaTypeDTO{"application/json": {Schema: schemas.schema(reflect.TypeOf(documentation.request))}},
+		}
+	}
+
+	status := documentation.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	success := OpenApiResponseDTO{Description: http.StatusText(status)}
+	if documentation.response != nil {
+		contentType := documentation.contentType
+		if len(contentType) == 0 {
+			contentType = "application/json"
+		}
+
+		success.Content = map[string]OpenApiMediaTypeDTO{contentType: {Schema: schemas.schema(reflect.TypeOf(documentation.response))}}
+	}
+
+	operation.Responses[strconv.Itoa(status)] = success
+	operation.Responses["default"] = OpenApiResponseDTO{Ref: "#/components/responses/" + OPENAPI_PROBLEM_RESPONSE}
+
+	if route.Authenticated {
+		operation.Security = []map[string][]string{{"bearer": {}}, {"apiKey": {}}}
+		operation.Responses[strconv.Itoa(http.StatusTooManyRequests)] = OpenApiResponseDTO{
+			Description: "Rate limit exceeded, problem details with code " + ERROR_CODE_RATE_LIMITED,
+			Headers: map[string]OpenApiHeaderDTO{
+				"Retry-After": {Description: "seconds to wait", Schema: map[string]any{"type": "integer"}},
+			},
+			Content: map[string]OpenApiMediaTypeDTO{PROBLEM_CONTENT_TYPE: {Schema: map[string]any{"$ref": OPENAPI_SCHEMAS_PREFIX + "ProblemDTO"}}},
+		}
+	}
+
+	return operation
+}
+
+// schemaBuilder builds schemas of go types, named structs are components referenced by name
+type schemaBuilder struct {
+	schemas map[string]map[string]any
+}
+
+// schema returns the schema of a type, following json serialization
+func (sb *schemaBuilder) schema(valueType reflect.Type) map[string]any {
+	switch valueType.Kind() {
+	case reflect.Pointer:
+		return sb.schema(valueType.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if valueType.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+
+		return map[string]any{"type": "array", "items": sb.schema(valueType.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": sb.schema(valueType.Elem())}
+	case reflect.Struct:
+		if valueType == reflect.TypeOf(time.Time{}) {
+			return map[string]any{"type": "string", "format": "date-time"}
+		} else if len(valueType.Name()) == 0 {
+			return sb.structSchema(valueType)
+		}
+
+		// placeholder first, recursive types reference themselves
+		name := valueType.Name()
+		if _, found := sb.schemas[name]; !found {
+			sb.schemas[name] = nil
+			sb.schemas[name] = sb.structSchema(valueType)
+		}
+
+		return map[string]any{"$ref": OPENAPI_SCHEMAS_PREFIX + name}
+	default:
+		// any value
+		return map[string]any{}
+	}
+}
+
+// structSchema returns the object schema of a struct, embedded structs are flattened
+func (sb *schemaBuilder) structSchema(valueType reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	sb.addProperties(valueType, properties)
+	return map[string]any{"type": "object", "properties": properties}
+}
+
+// addProperties adds serialized fields of a struct to properties
+func (sb *schemaBuilder) addProperties(valueType reflect.Type, properties map[string]any) {
+	for index := range valueType.NumField() {
+		field := valueType.Field(index)
+		tag := field.Tag.Get("json")
+		name, _, _ := strings.Cut(tag, ",")
+		switch {
+		case name == "-":
+			continue
+		case field.Anonymous && len(name) == 0 && field.Type.Kind() == reflect.Struct:
+			sb.addProperties(field.Type, properties)
+			continue
+		case !field.IsExported():
+			continue
+		case len(name) == 0:
+			name = field.Name
+		}
+
+		properties[name] = sb.schema(field.Type)
+	}
+}
+
+// openApiHandler returns the OpenAPI document of the routes of the service
+func openApiHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(BuildOpenApi(wrapper.Routes.Routes())); err != nil {
+		return NewServiceInternalServerError(err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package serving
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PASSWORD_MAX_LENGTH is the maximum length of a password, in characters
+const PASSWORD_MAX_LENGTH = 256
+
+// PasswordPolicy are the rules new passwords follow
+type PasswordPolicy struct {
+	// MinLength is the minimum length, in characters
+	MinLength int
+	// RequireMixedCase needs an upper case and a lower case letter
+	RequireMixedCase bool
+	// RequireDigit needs a digit
+	RequireDigit bool
+	// RequireSymbol needs a character that is neither a letter nor a digit
+	RequireSymbol bool
+}
+
+// Validate returns an error listing the rules password breaks for login, nil if password is valid
+func (p PasswordPolicy) Validate(login, password string) error {
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, character := range password {
+		switch {
+		case unicode.IsUpper(character):
+			hasUpper = true
+		case unicode.IsLower(character):
+			hasLower = true
+		case unicode.IsDigit(character):
+			hasDigit = true
+		case !unicode.IsLetter(character) && !unicode.IsSpace(character):
+			hasSymbol = true
+		}
+	}
+
+	var globalErr error
+	if size := utf8.RuneCountInString(password); size < p.MinLength {
+		globalErr = errors.Join(globalErr, fmt.Errorf("password should have at least %d characters", p.MinLength))
+	} else if size > PASSWORD_MAX_LENGTH {
+		globalErr = errors.Join(globalErr, fmt.Errorf("password should have at most %d characters", PASSWORD_MAX_LENGTH))
+	}
+
+	if p.RequireMixedCase && (!hasUpper || !hasLower) {
+		globalErr = errors.Join(globalErr, errors.New("password should have upper and lower case letters"))
+	}
+
+	if p.RequireDigit && !hasDigit {
+		globalErr = errors.Join(globalErr, errors.New("password should have a digit"))
+	}
+
+	if p.RequireSymbol && !hasSymbol {
+		globalErr = errors.Join(globalErr, errors.New("password should have a symbol"))
+	}
+
+	if len(login) != 0 && strings.Contains(strings.ToLower(password), strings.ToLower(login)) {
+		globalErr = errors.Join(globalErr, errors.New("password should not contain login"))
+	}
+
+	return globalErr
+}
@@ -0,0 +1,133 @@
+package serving
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Classes of routes, each class has its own limit
+const (
+	// RATE_CLASS_READ are GET routes, except finds
+	RATE_CLASS_READ = "read"
+	// RATE_CLASS_WRITE are routes changing data
+	RATE_CLASS_WRITE = "write"
+	// RATE_CLASS_FIND are the find routes, the heaviest for the database
+	RATE_CLASS_FIND = "find"
+)
+
+// RATE_LIMIT_SWEEP_PERIOD is the delay between two deletions of full buckets
+const RATE_LIMIT_SWEEP_PERIOD = time.Minute
+
+// RateLimit is a token bucket: Burst calls at once, then Rate calls per second. Rate 0 means no limit
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimits are the limits per class of route
+type RateLimits struct {
+	Read  RateLimit
+	Write RateLimit
+	Find  RateLimit
+}
+
+// ForClass returns the limit of a class of route
+func (r RateLimits) ForClass(class string) RateLimit {
+	switch class {
+	case RATE_CLASS_FIND:
+		return r.Find
+	case RATE_CLASS_WRITE:
+		return r.Write
+	default:
+		return r.Read
+	}
+}
+
+// routeClass returns the class of a route for a method
+func routeClass(method, urlPattern string) string {
+	switch {
+	case strings.HasPrefix(urlPattern, "/find/"):
+		return RATE_CLASS_FIND
+	case strings.EqualFold(method, "GET"):
+		return RATE_CLASS_READ
+	default:
+		return RATE_CLASS_WRITE
+	}
+}
+
+// tokenBucket is the bucket of a key, refilled at the rate of its limit
+type tokenBucket struct {
+	limit     RateLimit
+	tokens    float64
+	updatedAt time.Time
+}
+
+// refill adds the tokens earned since last update, up to burst
+func (b *tokenBucket) refill(now time.Time) {
+	earned := now.Sub(b.updatedAt).Seconds() * b.limit.Rate
+	b.tokens = min(float64(max(b.limit.Burst, 1)), b.tokens+earned)
+	b.updatedAt = now
+}
+
+// RateLimiter keeps a token bucket per key. Buckets are local to the server
+type RateLimiter struct {
+	limits RateLimits
+	// mutex protects buckets and sweptAt
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+	sweptAt time.Time
+}
+
+// NewRateLimiter returns a limiter with no bucket
+func NewRateLimiter(limits RateLimits) *RateLimiter {
+	return &RateLimiter{limits: limits, buckets: make(map[string]*tokenBucket), sweptAt: time.Now()}
+}
+
+// Allow takes a token for key in a route of that class.
+// It returns true if call is allowed, or false and the delay before next token
+func (l *RateLimiter) Allow(class, key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	limit := l.limits.ForClass(class)
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+	bucket, found := l.buckets[key]
+	if !found {
+		bucket = &tokenBucket{limit: limit, tokens: float64(max(limit.Burst, 1)), updatedAt: now}
+		l.buckets[key] = bucket
+	} else {
+		bucket.refill(now)
+	}
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - bucket.tokens) / limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep deletes full buckets, a new bucket would be the same. Caller holds mutex
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.sweptAt) < RATE_LIMIT_SWEEP_PERIOD {
+		return
+	}
+
+	l.sweptAt = now
+	for key, bucket := range l.buckets {
+		if bucket.refill(now); bucket.tokens >= float64(max(bucket.limit.Burst, 1)) {
+			delete(l.buckets, key)
+		}
+	}
+}
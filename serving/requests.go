@@ -0,0 +1,82 @@
+package serving
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// REQUEST_ID_HEADER is the header to propagate the id of a request
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+// REQUEST_ID_MAX_SIZE is the maximum size of a request id sent by a client
+const REQUEST_ID_MAX_SIZE = 128
+
+// requestIdForRequest returns the request id sent by the client if valid, or a new one
+func requestIdForRequest(r *http.Request) string {
+	if value := r.Header.Get(REQUEST_ID_HEADER); isValidRequestId(value) {
+		return value
+	}
+
+	return uuid.NewString()
+}
+
+// isValidRequestId accepts non empty ids made of letters, digits, and - _ . :
+func isValidRequestId(value string) bool {
+	if len(value) == 0 || len(value) > REQUEST_ID_MAX_SIZE {
+		return false
+	}
+
+	for _, character := range value {
+		switch {
+		case 'a' <= character && character <= 'z':
+		case 'A' <= character && character <= 'Z':
+		case '0' <= character && character <= '9':
+		case character == '-' || character == '_' || character == '.' || character == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// clientAddress returns the ip address of the client of a request
+func clientAddress(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+
+	return r.RemoteAddr
+}
+
+// logRequest writes one structured entry for a served request.
+// Server errors are logged as errors, other requests as info
+func logRequest(wrapper ServiceParameters, route, method string, status int, latency time.Duration, errRequest error) {
+	if wrapper.Logger == nil {
+		return
+	}
+
+	requestId, _ := wrapper.RequestId()
+	user, _ := wrapper.CurrentUser()
+	values := []any{
+		"request_id", requestId,
+		"user", user,
+		"route", route,
+		"method", method,
+		"status", status,
+		"latency", latency,
+	}
+
+	if errRequest != nil {
+		values = append(values, "error", errRequest.Error())
+	}
+
+	if status >= http.StatusInternalServerError {
+		wrapper.Logger.Errorw("request", values...)
+	} else {
+		wrapper.Logger.Infow("request", values...)
+	}
+}
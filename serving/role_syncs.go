@@ -0,0 +1,72 @@
+package serving
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// EXTERNAL_ROLES_SYNC_PERIOD is the minimum delay between two synchronizations of the roles of a subject on its bearer tokens
+const EXTERNAL_ROLES_SYNC_PERIOD = time.Minute
+
+// roleSync is the last synchronization of the roles of a subject
+type roleSync struct {
+	roles    []string
+	syncedAt time.Time
+}
+
+// RoleSyncer throttles synchronizations of roles of external subjects on bearer tokens.
+// Roles of a subject are synchronized at most once per period, unless issuer grants other roles. Syncs are local to the server
+type RoleSyncer struct {
+	period time.Duration
+	// mutex protects syncs and sweptAt
+	mutex   sync.Mutex
+	syncs   map[string]roleSync
+	sweptAt time.Time
+}
+
+// NewRoleSyncer returns a syncer with no synchronization yet
+func NewRoleSyncer(period time.Duration) *RoleSyncer {
+	return &RoleSyncer{period: period, syncs: make(map[string]roleSync), sweptAt: time.Now()}
+}
+
+// ShouldSync returns true if roles of key were not synchronized within period, or were synchronized with other roles
+func (s *RoleSyncer) ShouldSync(key string, roles []string) bool {
+	if s == nil {
+		return true
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	last, found := s.syncs[key]
+	return !found || time.Since(last.syncedAt) >= s.period || slices.Compare(last.roles, roles) != 0
+}
+
+// Synced records a synchronization of roles for key
+func (s *RoleSyncer) Synced(key string, roles []string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+	s.syncs[key] = roleSync{roles: slices.Clone(roles), syncedAt: now}
+}
+
+// sweep deletes synchronizations older than period, caller holds mutex
+func (s *RoleSyncer) sweep(now time.Time) {
+	if now.Sub(s.sweptAt) < s.period {
+		return
+	}
+
+	s.sweptAt = now
+	for key, last := range s.syncs {
+		if now.Sub(last.syncedAt) >= s.period {
+			delete(s.syncs, key)
+		}
+	}
+}
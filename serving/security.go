@@ -2,9 +2,15 @@ package serving
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"net/http"
-	"strings"
+	"strconv"
+	"time"
+
+	"github.com/zefrenchwan/patterns.git/storage"
 )
 
 // UserInformationInput is input for /token endpoint
@@ -13,33 +19,164 @@ type UserInformationInput struct {
 	Password string `json:"password"`
 }
 
-// checkUserAndGenerateTokenHandler reads user data, and, if authentication matches, returns a token for this user
+// checkUserAndGenerateTokenHandler reads user data, and, if authentication matches, returns an access token and a refresh token for this user.
+// Failures are counted per login and per address, too many failures lock them for a while
 func checkUserAndGenerateTokenHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
 	defer r.Body.Close()
 
 	var userInput UserInformationInput
 	if body, errBody := io.ReadAll(r.Body); errBody != nil {
-		return NewServiceUnprocessableEntityError(errBody.Error())
+		return NewServiceReadBodyError(errBody)
 	} else if err := json.Unmarshal(body, &userInput); err != nil {
-		return NewServiceUnprocessableEntityError(err.Error())
-	} else if found, err := wrapper.Dao.CheckUser(wrapper.Ctx, userInput.Username, userInput.Password); err != nil {
+		return NewServiceDeserializationError(err)
+	}
+
+	// attempt is counted before its password is checked, so that concurrent attempts cannot go over the limits.
+	// Locked login or address is not tested, even with a valid password
+	address := clientAddress(r)
+	if lockedUntil, err := wrapper.Dao.StartLoginAttempt(wrapper.Ctx, userInput.Username, address, wrapper.Settings.Lockout); err != nil {
+		return NewServiceInternalServerError(err.Error())
+	} else if time.Now().Before(lockedUntil) {
+		return lockedLoginError(w, lockedUntil)
+	}
+
+	if found, err := wrapper.Dao.CheckUser(wrapper.Ctx, userInput.Username, userInput.Password); err != nil {
+		return NewServiceInternalServerError(err.Error())
+	} else if found {
+		if err := wrapper.Dao.ClearLoginFailures(wrapper.Ctx, userInput.Username, address); err != nil {
+			return NewServiceInternalServerError(err.Error())
+		}
+
+		return writeTokens(wrapper, w, userInput.Username, "")
+	}
+
+	if err := wrapper.Dao.RecordLoginFailure(wrapper.Ctx, userInput.Username, address); err != nil {
 		return NewServiceInternalServerError(err.Error())
-	} else if !found {
-		return NewServiceForbiddenError("invalid user")
 	}
 
-	// generate token
-	var newToken string
-	if secret, errLoad := wrapper.Dao.FindSecretForActiveUser(wrapper.Ctx, userInput.Username); errLoad != nil {
-		return NewServiceInternalServerError(errLoad.Error())
-	} else if token, err := createToken(userInput.Username, secret); err != nil {
+	return NewServiceForbiddenError("invalid user")
+}
+
+// lockedLoginError returns the error for a locked login, and tells client when to retry
+func lockedLoginError(w http.ResponseWriter, lockedUntil time.Time) error {
+	retryAfter := int(math.Ceil(time.Until(lockedUntil).Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+	return NewServiceTooManyRequestsError("too many failed logins, retry later").WithCode(ERROR_CODE_LOGIN_LOCKED)
+}
+
+// TokensDTO is the response of token endpoints
+type TokensDTO struct {
+	// Token is the access token to use as bearer
+	Token string `json:"token"`
+	// Duration is the validity of the access token
+	Duration string `json:"duration"`
+	// RefreshToken is the token to get new tokens once access token expired
+	RefreshToken string `json:"refresh_token"`
+	// RefreshDuration is the validity of the refresh token
+	RefreshDuration string `json:"refresh_duration"`
+}
+
+// RefreshTokenInput is the input of refresh and logout endpoints
+type RefreshTokenInput struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// writeTokens writes a new access token for login, and the refresh token if set or a new session otherwise
+func writeTokens(wrapper ServiceParameters, w http.ResponseWriter, login string, refreshToken string) error {
+	settings := wrapper.Settings
+	if len(refreshToken) == 0 {
+		if token, err := wrapper.Dao.CreateRefreshToken(wrapper.Ctx, login, settings.RefreshTokenDuration); err != nil {
+			return BuildApiErrorFromStorageError(err)
+		} else {
+			refreshToken = token
+		}
+	}
+
+	accessToken, errToken := createToken(wrapper, login)
+	if errToken != nil {
+		return NewServiceInternalServerError(errToken.Error())
+	}
+
+	result := TokensDTO{
+		Token:           accessToken,
+		Duration:        settings.AccessTokenDuration.String(),
+		RefreshToken:    refreshToken,
+		RefreshDuration: settings.RefreshTokenDuration.String(),
+	}
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
 		return NewServiceInternalServerError(err.Error())
-	} else {
-		newToken = token
 	}
 
-	result := map[string]string{"token": newToken, "duration": TokenDuration.String()}
-	json.NewEncoder(w).Encode(result)
+	return nil
+}
+
+// readRefreshToken reads the refresh token in the body of a request
+func readRefreshToken(r *http.Request) (string, error) {
+	var input RefreshTokenInput
+	if body, errBody := io.ReadAll(r.Body); errBody != nil {
+		return "", NewServiceReadBodyError(errBody)
+	} else if err := json.Unmarshal(body, &input); err != nil {
+		return "", NewServiceDeserializationError(err)
+	} else if len(input.RefreshToken) == 0 {
+		return "", NewServiceValidationError(FieldErrorDTO{Field: "refresh_token", Message: "expecting refresh token"})
+	}
+
+	return input.RefreshToken, nil
+}
+
+// refreshTokenHandler exchanges a refresh token for a new access token and a new refresh token.
+// Refresh token is single use: using it again revokes its session
+func refreshTokenHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	refreshToken, errInput := readRefreshToken(r)
+	if errInput != nil {
+		return errInput
+	}
+
+	login, newRefreshToken, errRotate := wrapper.Dao.RotateRefreshToken(wrapper.Ctx, refreshToken, wrapper.Settings.RefreshTokenDuration)
+	if errors.Is(errRotate, storage.ErrInvalidRefreshToken) || errors.Is(errRotate, storage.ErrRefreshTokenReuse) {
+		return NewServiceUnauthorizedError(errRotate.Error())
+	} else if errRotate != nil {
+		return BuildApiErrorFromStorageError(errRotate)
+	}
+
+	return writeTokens(wrapper, w, login, newRefreshToken)
+}
+
+// logoutHandler ends the session of a refresh token
+func logoutHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	refreshToken, errInput := readRefreshToken(r)
+	if errInput != nil {
+		return errInput
+	} else if err := wrapper.Dao.RevokeRefreshToken(wrapper.Ctx, refreshToken); err != nil {
+		return BuildApiErrorFromStorageError(err)
+	}
+
+	w.WriteHeader(200)
+	return nil
+}
+
+// revokeUserSessionsHandler ends all sessions of an user, current user should manage that user
+func revokeUserSessionsHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	user, auth := wrapper.CurrentUser()
+	if !auth {
+		return NewServiceForbiddenError("should authenticate")
+	}
+
+	login := r.PathValue("login")
+	if len(login) == 0 {
+		return NewServiceHttpClientError("expecting login")
+	} else if err := wrapper.Dao.RevokeUserSessions(wrapper.Ctx, user, login); err != nil {
+		return BuildApiErrorFromStorageError(err)
+	}
+
+	w.WriteHeader(200)
 	return nil
 }
 
@@ -55,18 +192,64 @@ func upsertUserHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http
 
 	var userInput UserInformationInput
 	if body, errBody := io.ReadAll(r.Body); errBody != nil {
-		return NewServiceUnprocessableEntityError(errBody.Error())
+		return NewServiceReadBodyError(errBody)
 	} else if err := json.Unmarshal(body, &userInput); err != nil {
-		return NewServiceUnprocessableEntityError(err.Error())
-	} else if err := wrapper.Dao.UpsertUser(wrapper.Ctx, currentUser, userInput.Username, userInput.Password); err == nil {
-		return nil
-	} else {
-		// No out parameter, so deal with error message
-		message := err.Error()
-		if strings.Contains(message, "unauthorized") {
-			return NewServiceForbiddenError(message)
-		}
+		return NewServiceDeserializationError(err)
+	} else if len(userInput.Username) == 0 {
+		return NewServiceValidationError(FieldErrorDTO{Field: "username", Message: "expecting username"})
+	} else if err := wrapper.Settings.PasswordPolicy.Validate(userInput.Username, userInput.Password); err != nil {
+		return NewServiceFieldError("password", err)
+	} else if err := wrapper.Dao.UpsertUser(wrapper.Ctx, currentUser, userInput.Username, userInput.Password); err != nil {
+		return BuildApiErrorFromStorageError(err)
+	}
+
+	return nil
+}
 
-		return NewServiceInternalServerError(message)
+// activateUserHandler activates an user, current user should manage that user
+func activateUserHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	return setUserActive(wrapper, w, r, true)
+}
+
+// deactivateUserHandler deactivates an user and ends its sessions, current user should manage that user
+func deactivateUserHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	return setUserActive(wrapper, w, r, false)
+}
+
+// setUserActive changes the activity of the user in path
+func setUserActive(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request, active bool) error {
+	defer r.Body.Close()
+
+	user, auth := wrapper.CurrentUser()
+	if !auth {
+		return NewServiceForbiddenError("should authenticate")
+	}
+
+	login := r.PathValue("login")
+	if len(login) == 0 {
+		return NewServiceHttpClientError("expecting login")
+	} else if err := wrapper.Dao.SetUserActive(wrapper.Ctx, user, login, active); err != nil {
+		return BuildApiErrorFromStorageError(err)
+	}
+
+	w.WriteHeader(200)
+	return nil
+}
+
+// jwksHandler publishes the public keys that validate access tokens, as a JSON web key set
+func jwksHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	keys, errKeys := wrapper.Keyring.PublicKeys(wrapper.Ctx)
+	if errKeys != nil {
+		return BuildApiErrorFromStorageError(errKeys)
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(KEYRING_REFRESH_PERIOD.Seconds())))
+	if err := json.NewEncoder(w).Encode(keys); err != nil {
+		return NewServiceInternalServerError(err.Error())
+	}
+
+	return nil
 }
@@ -3,10 +3,13 @@ package serving
 import (
 	"context"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/zefrenchwan/patterns.git/oidc"
 	"github.com/zefrenchwan/patterns.git/storage"
 	"go.uber.org/zap"
 )
@@ -30,35 +33,89 @@ func DeserializeTimeFromURL(value string) (time.Time, error) {
 type RequestContextKey string
 
 // InitService returns a new valid servemux to launch
-func InitService(dao storage.Dao, initialContext context.Context, logger *zap.SugaredLogger) *http.ServeMux {
+func InitService(dao storage.Dao, initialContext context.Context, logger *zap.SugaredLogger, settings ServiceSettings) *http.ServeMux {
+	mux, _ := InitServiceWithRoutes(dao, initialContext, logger, settings)
+	return mux
+}
+
+// InitServiceWithRoutes returns a new valid servemux to launch, and the routes it serves
+func InitServiceWithRoutes(dao storage.Dao, initialContext context.Context, logger *zap.SugaredLogger, settings ServiceSettings) (*http.ServeMux, *RouteRegistry) {
 	mux := http.NewServeMux()
 
+	// keys signing tokens are kept until last token they signed expires
+	keyring := NewKeyring(dao, settings.SigningKeySecret, settings.SigningAlgorithm, settings.SigningKeyRotation, settings.AccessTokenDuration)
 	parameters := ServiceParameters{
-		Dao:    dao,
-		Ctx:    initialContext,
-		Logger: logger,
+		Dao:      dao,
+		Ctx:      initialContext,
+		Logger:   logger,
+		Settings: settings,
+		Keyring:  keyring,
+		// limits are shared by all routes, buckets are per user and route
+		RateLimiter: NewRateLimiter(settings.RateLimits),
+		// routes are documented at /openapi.json
+		Routes: NewRouteRegistry(),
+	}
+
+	// external identity provider is optional
+	if settings.Oidc.Enabled() {
+		parameters.Identity = oidc.NewProvider(settings.Oidc, &http.Client{Timeout: OIDC_HTTP_TIMEOUT})
+		parameters.RoleSyncer = NewRoleSyncer(EXTERNAL_ROLES_SYNC_PERIOD)
 	}
 
 	// TODO: add in here your own handlers
 	// ADMIN PART
 	AddGetServiceHandlerToMux(mux, "/status/", checkStatusHandler, parameters)
+	AddGetServiceHandlerToMux(mux, "/status/live/", livenessHandler, parameters)
+	AddGetServiceHandlerToMux(mux, "/status/ready/", readinessHandler, parameters)
+	AddGetServiceHandlerToMux(mux, "/metrics", metricsHandler, parameters)
+	AddGetServiceHandlerToMux(mux, "/.well-known/jwks.json", jwksHandler, parameters)
+	AddGetServiceHandlerToMux(mux, "/openapi.json", openApiHandler, parameters)
 	AddPostServiceHandlerToMux(mux, "/token/", checkUserAndGenerateTokenHandler, parameters)
+	AddPostServiceHandlerToMux(mux, "/token/refresh/", refreshTokenHandler, parameters)
+	AddPostServiceHandlerToMux(mux, "/token/logout/", logoutHandler, parameters)
+	AddGetServiceHandlerToMux(mux, "/oidc/login/", oidcLoginHandler, parameters)
+	AddGetServiceHandlerToMux(mux, "/oidc/callback/", oidcCallbackHandler, parameters)
+	AddAuthenticatedPutServiceHandlerToMux(mux, "/user/identities/link/{login}/", linkExternalIdentityHandler, parameters)
 	AddAuthenticatedPostServiceHandlerToMux(mux, "/user/upsert/", upsertUserHandler, parameters)
+	AddAuthenticatedDeleteServiceHandlerToMux(mux, "/user/sessions/{login}/", revokeUserSessionsHandler, parameters)
+	AddAuthenticatedPutServiceHandlerToMux(mux, "/user/activate/{login}/", activateUserHandler, parameters)
+	AddAuthenticatedPutServiceHandlerToMux(mux, "/user/deactivate/{login}/", deactivateUserHandler, parameters)
+	AddAuthenticatedPostServiceHandlerToMux(mux, "/user/apikeys/create/{login}/", createApiKeyHandler, parameters)
+	AddAuthenticatedGetServiceHandlerToMux(mux, "/user/apikeys/list/{login}/", listApiKeysHandler, parameters)
+	AddAuthenticatedDeleteServiceHandlerToMux(mux, "/user/apikeys/revoke/{login}/{keyId}/", revokeApiKeyHandler, parameters)
 	// GRAPHS OPERATIONS
 	AddAuthenticatedPostServiceHandlerToMux(mux, "/graph/create/", createGraphHandler, parameters)
+	AddAuthenticatedPostServiceHandlerToMux(mux, "/graph/fork/{graphId}/", forkGraphHandler, parameters)
+	AddAuthenticatedGetServiceHandlerToMux(mux, "/graph/merge/preview/{forkId}/into/{graphId}/", previewForkMergeHandler, parameters)
+	AddAuthenticatedPutServiceHandlerToMux(mux, "/graph/merge/apply/{forkId}/into/{graphId}/", applyForkMergeHandler, parameters)
 	AddAuthenticatedPutServiceHandlerToMux(mux, "/graph/import/{importGraph}/into/{baseGraph}/", addImportToExistingGraphHandler, parameters)
+	AddAuthenticatedDeleteServiceHandlerToMux(mux, "/graph/import/{importGraph}/from/{baseGraph}/", removeImportFromGraphHandler, parameters)
+	AddAuthenticatedGetServiceHandlerToMux(mux, "/graph/imports/{graphId}/", listImportsHandler, parameters)
 	AddAuthenticatedDeleteServiceHandlerToMux(mux, "/graph/delete/{graphId}/", deleteGraphHandler, parameters)
+	AddAuthenticatedGetServiceHandlerToMux(mux, "/graph/trash/{graphId}/", listTrashHandler, parameters)
+	AddAuthenticatedPutServiceHandlerToMux(mux, "/graph/restore/{graphId}/", restoreGraphHandler, parameters)
 	AddAuthenticatedGetServiceHandlerToMux(mux, "/graph/list/", listGraphHandler, parameters)
 	AddAuthenticatedGetServiceHandlerToMux(mux, "/graph/load/{graphId}/", loadGraphHandler, parameters)
 	AddAuthenticatedGetServiceHandlerToMux(mux, "/graph/slice/{graphId}/since/{moment}/", loadGraphSinceHandler, parameters)
 	AddAuthenticatedGetServiceHandlerToMux(mux, "/graph/slice/{graphId}/between/{start}/and/{end}/", loadGraphBetweenHandler, parameters)
 	AddAuthenticatedGetServiceHandlerToMux(mux, "/graph/snapshot/{graphId}/at/{moment}/", snapshotGraphHandler, parameters)
 	AddAuthenticatedDeleteServiceHandlerToMux(mux, "/graph/all/clear/", clearGraphsHandler, parameters)
+	AddAuthenticatedGetServiceHandlerToMux(mux, "/graph/aggregate/{graphId}/trait/{trait}/between/{start}/and/{end}/per/{bucket}/", aggregateGraphHandler, parameters)
 	// ELEMENTS OPERATIONS
 	AddAuthenticatedPutServiceHandlerToMux(mux, "/elements/copy/{elementId}/to/{graphId}/", createEquivalenceElementHandler, parameters)
 	AddAuthenticatedGetServiceHandlerToMux(mux, "/elements/load/{elementId}/", loadElementByIdHandler, parameters)
 	AddAuthenticatedPostServiceHandlerToMux(mux, "/elements/upsert/graph/{graphId}/", upsertElementInGraphHandler, parameters)
 	AddAuthenticatedDeleteServiceHandlerToMux(mux, "/elements/delete/{elementId}/", deleteElementHandler, parameters)
+	AddAuthenticatedPutServiceHandlerToMux(mux, "/elements/restore/{elementId}/", restoreElementHandler, parameters)
+	AddAuthenticatedPostServiceHandlerToMux(mux, "/elements/merge/", mergeElementsHandler, parameters)
+	AddAuthenticatedPutServiceHandlerToMux(mux, "/elements/merge/undo/{mergeId}/", undoMergeHandler, parameters)
+	AddAuthenticatedPutServiceHandlerToMux(mux, "/elements/split/{elementId}/at/{moment}/", splitElementHandler, parameters)
+	AddAuthenticatedGetServiceHandlerToMux(mux, "/elements/{elementId}/equivalence/status/", equivalenceStatusHandler, parameters)
+	AddAuthenticatedPutServiceHandlerToMux(mux, "/elements/{elementId}/equivalence/pull/", pullEquivalenceHandler, parameters)
+	AddAuthenticatedPutServiceHandlerToMux(mux, "/elements/{elementId}/equivalence/push/", pushEquivalenceHandler, parameters)
+	AddAuthenticatedGetServiceHandlerToMux(mux, "/elements/lineage/{elementId}/", lineageHandler, parameters)
+	// AUDIT
+	AddAuthenticatedGetServiceHandlerToMux(mux, "/audit/", auditHandler, parameters)
 	// LOCAL FIND OPERATIONS
 	AddAuthenticatedGetServiceHandlerToMux(mux, "/find/neighbors/of/entities/for/trait/{trait}/", findElementFullPeriodHandler, parameters)
 	AddAuthenticatedGetServiceHandlerToMux(mux, "/find/neighbors/of/entities/for/trait/{trait}/since/{start}/", findElementSinceHandler, parameters)
@@ -66,7 +123,7 @@ func InitService(dao storage.Dao, initialContext context.Context, logger *zap.Su
 	AddAuthenticatedGetServiceHandlerToMux(mux, "/find/neighbors/of/entities/for/trait/{trait}/between/{start}/and/{end}/", findElementBetweenHandler, parameters)
 	// END OF HANDLERS MODIFICATION
 	// mux is complete, all handlers are set
-	return mux
+	return mux, parameters.Routes
 }
 
 // AddGetServiceHandlerToMux adds an handler to to the current mux for a GET
@@ -101,58 +158,104 @@ func AddAuthenticatedPutServiceHandlerToMux(mux *http.ServeMux, urlPattern strin
 
 // AddServiceHandlerToMux adds an handler to current mux
 func AddServiceHandlerToMux(mux *http.ServeMux, method string, urlPattern string, testAuth bool, handler ServiceHandler, parameters ServiceParameters) {
+	class := routeClass(method, urlPattern)
 	handlerFunction := func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestId := requestIdForRequest(r)
+		w.Header().Set(REQUEST_ID_HEADER, requestId)
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		// parameters of this request only, shared parameters should not change
+		current := parameters
+		current.Ctx = storage.ContextWithRequestId(parameters.Ctx, requestId)
+
+		var errRequest error
+		defer func() {
+			logRequest(current, urlPattern, method, recorder.Status(), time.Since(start), errRequest)
+		}()
+
 		if !strings.EqualFold(r.Method, method) {
-			http.Error(w, "Expecting "+method, http.StatusBadRequest)
+			errRequest = NewServiceHttpClientError("Expecting " + method).WithCode(ERROR_CODE_INVALID_METHOD)
+			writeProblem(current, recorder, r, asServiceError(errRequest))
 			return
 		}
 
 		// test if user is valid
 		if testAuth {
-			if login, auth, err := validateAuthentication(parameters, r); err != nil {
-				http.Error(w, err.Error(), http.StatusUnauthorized)
+			if login, keyId, auth, err := validateAuthentication(current, r); err != nil {
+				errRequest = NewServiceUnauthorizedError(err.Error())
+				writeProblem(current, recorder, r, asServiceError(errRequest))
 				return
 			} else if !auth {
-				http.Error(w, "should authenticate", http.StatusUnauthorized)
+				errRequest = NewServiceUnauthorizedError("should authenticate")
+				writeProblem(current, recorder, r, asServiceError(errRequest))
 				return
 			} else {
-				parameters.Ctx = context.WithValue(parameters.Ctx, RequestContextKey("user"), login)
+				current.Ctx = context.WithValue(current.Ctx, RequestContextKey("user"), login)
+				if len(keyId) != 0 {
+					// dao applies the restrictions of the key
+					current.Ctx = storage.ContextWithApiKey(current.Ctx, keyId)
+				}
 			}
-		}
 
-		// isolated call, to include time monitoring later
-		errHandler := handler(parameters, w, r)
-		if errHandler != nil {
-			switch customError, ok := errHandler.(ServiceHttpError); ok {
-			case true:
-				http.Error(w, customError.Error(), customError.HttpCode())
-			default:
-				http.Error(w, "Internal error: "+errHandler.Error(), http.StatusInternalServerError)
+			// authenticated calls are limited per user and route
+			user, _ := current.CurrentUser()
+			if allowed, wait := current.RateLimiter.Allow(class, user+" "+method+" "+urlPattern); !allowed {
+				errRequest = NewServiceTooManyRequestsError("rate limit exceeded")
+				httpThrottled.Inc(urlPattern, method, class)
+				recorder.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				writeProblem(current, recorder, r, asServiceError(errRequest))
+				return
 			}
 		}
+
+		// isolated call, monitored by instrumentHandler
+		errRequest = handler(current, recorder, r)
+		if errRequest != nil {
+			writeProblem(current, recorder, r, asServiceError(errRequest))
+		}
 	}
 
-	// register url matching
-	mux.HandleFunc(urlPattern, handlerFunction)
-	// deal with /value/ <=> /value
+	// register url matching, with metrics per registered route
+	parameters.Routes.add(RouteDTO{Method: method, Pattern: urlPattern, Authenticated: testAuth})
+	handlerFunction = instrumentHandler(urlPattern, method, handlerFunction)
+	// patterns match exact paths, not subtrees, so that /a/{id}/b/ and /a/b/{id}/ do not conflict.
+	// Deal with /value/ <=> /value
 	size := len(urlPattern)
 	if strings.HasSuffix(urlPattern, "/") {
+		mux.HandleFunc(urlPattern+"{$}", handlerFunction)
 		mux.HandleFunc(urlPattern[0:size-1], handlerFunction)
 	} else {
-		mux.HandleFunc(urlPattern+"/", handlerFunction)
+		mux.HandleFunc(urlPattern, handlerFunction)
+		mux.HandleFunc(urlPattern+"/{$}", handlerFunction)
 	}
 }
 
 // ServiceParameters contains all parameters to use for a service
 type ServiceParameters struct {
-	Dao    storage.Dao
-	Ctx    context.Context
-	Logger *zap.SugaredLogger
+	Dao      storage.Dao
+	Ctx      context.Context
+	Logger   *zap.SugaredLogger
+	Settings ServiceSettings
+	Keyring  *Keyring
+	// Identity is the external identity provider, nil if none
+	Identity *oidc.Provider
+	// RateLimiter limits authenticated calls, nil for no limit
+	RateLimiter *RateLimiter
+	// RoleSyncer throttles synchronizations of external roles on bearer tokens, nil to synchronize on each token
+	RoleSyncer *RoleSyncer
+	// Routes registers routes for documentation, nil to register none
+	Routes *RouteRegistry
 }
 
 // ServiceHandler adds more parameters than usual handler function
 type ServiceHandler func(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error
 
+// RequestId returns the id of the current request if any, and a boolean to explicit if found
+func (sp ServiceParameters) RequestId() (string, bool) {
+	return storage.RequestIdFromContext(sp.Ctx)
+}
+
 // CurrentUser returns the current user if any, and a boolean to explicit if found
 func (sp ServiceParameters) CurrentUser() (string, bool) {
 	switch userValue := sp.Ctx.Value(RequestContextKey("user")); userValue {
@@ -0,0 +1,58 @@
+package serving
+
+import (
+	"time"
+
+	"github.com/zefrenchwan/patterns.git/oidc"
+	"github.com/zefrenchwan/patterns.git/storage"
+)
+
+// ServiceSettings are the settings of the services
+type ServiceSettings struct {
+	// AccessTokenDuration is the validity of access tokens
+	AccessTokenDuration time.Duration
+	// RefreshTokenDuration is the validity of refresh tokens
+	RefreshTokenDuration time.Duration
+	// SigningAlgorithm signs access tokens, EdDSA or RS256
+	SigningAlgorithm string
+	// SigningKeyRotation is the duration a signing key signs before a new key replaces it
+	SigningKeyRotation time.Duration
+	// SigningKeySecret encrypts signing keys in database, 32 bytes for AES-256
+	SigningKeySecret []byte
+	// Issuer is the iss claim of access tokens
+	Issuer string
+	// Audience is the aud claim of access tokens
+	Audience string
+	// PasswordPolicy are the rules of new passwords
+	PasswordPolicy PasswordPolicy
+	// Lockout limits failed logins
+	Lockout storage.LockoutPolicy
+	// RateLimits are the limits of authenticated calls per user and route, per class of route
+	RateLimits RateLimits
+	// Oidc is the external identity provider, disabled if no issuer
+	Oidc oidc.Settings
+}
+
+// DefaultServiceSettings returns settings with default values
+func DefaultServiceSettings() ServiceSettings {
+	return ServiceSettings{
+		AccessTokenDuration:  15 * time.Minute,
+		RefreshTokenDuration: 30 * 24 * time.Hour,
+		SigningAlgorithm:     SIGNING_ALGORITHM_EDDSA,
+		SigningKeyRotation:   7 * 24 * time.Hour,
+		Issuer:               "patterns",
+		Audience:             "patterns",
+		PasswordPolicy:       PasswordPolicy{MinLength: 12},
+		Lockout: storage.LockoutPolicy{
+			MaxLoginFailures:   5,
+			MaxAddressFailures: 50,
+			FailureWindow:      15 * time.Minute,
+			LockoutDuration:    15 * time.Minute,
+		},
+		RateLimits: RateLimits{
+			Read:  RateLimit{Rate: 20, Burst: 40},
+			Write: RateLimit{Rate: 10, Burst: 20},
+			Find:  RateLimit{Rate: 2, Burst: 5},
+		},
+	}
+}
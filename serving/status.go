@@ -1,8 +1,12 @@
 package serving
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"time"
+
+	"github.com/zefrenchwan/patterns.git/storage"
 )
 
 // CheckStatusResponse defines json to display when asking for status
@@ -25,3 +29,140 @@ func checkStatusHandler(wrapper ServiceParameters, w http.ResponseWriter, r *htt
 	json.NewEncoder(w).Encode(result)
 	return nil
 }
+
+// Health status of a component or of the server
+const (
+	HEALTH_UP       = "up"
+	HEALTH_DEGRADED = "degraded"
+	HEALTH_DOWN     = "down"
+)
+
+// READINESS_TIMEOUT is the maximum duration of readiness checks
+const READINESS_TIMEOUT = 2 * time.Second
+
+// POOL_SATURATION_THRESHOLD is the part of the pool in use from which the pool is degraded
+const POOL_SATURATION_THRESHOLD = 0.9
+
+// ComponentHealthDTO is the health of a component
+type ComponentHealthDTO struct {
+	// Status is up, degraded or down
+	Status string `json:"status"`
+	// Error explains why component is down, if it is
+	Error string `json:"error,omitempty"`
+	// Details are component specific values
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// HealthDTO is the health of the server and its components.
+// Server is down if a component is down, degraded if a component is degraded
+type HealthDTO struct {
+	Status     string                        `json:"status"`
+	Components map[string]ComponentHealthDTO `json:"components,omitempty"`
+}
+
+// livenessHandler answers as long as the server runs
+func livenessHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(HealthDTO{Status: HEALTH_UP}); err != nil {
+		return NewServiceInternalServerError(err.Error())
+	}
+
+	return nil
+}
+
+// readinessHandler checks database, schema and pool. It answers 503 if a component is down
+func readinessHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	ctx, cancel := context.WithTimeout(wrapper.Ctx, READINESS_TIMEOUT)
+	defer cancel()
+
+	components := map[string]ComponentHealthDTO{
+		"database": databaseHealth(ctx, wrapper.Dao),
+		"schema":   schemaHealth(ctx, wrapper.Dao),
+		"pool":     poolHealth(wrapper.Dao),
+	}
+
+	result := HealthDTO{Status: HEALTH_UP, Components: components}
+	for _, component := range components {
+		if component.Status == HEALTH_DOWN {
+			result.Status = HEALTH_DOWN
+		} else if component.Status == HEALTH_DEGRADED && result.Status == HEALTH_UP {
+			result.Status = HEALTH_DEGRADED
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if result.Status == HEALTH_DOWN {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		return NewServiceInternalServerError(err.Error())
+	}
+
+	return nil
+}
+
+// databaseHealth pings the database
+func databaseHealth(ctx context.Context, dao storage.Dao) ComponentHealthDTO {
+	latency, err := dao.Ping(ctx)
+	if err != nil {
+		return ComponentHealthDTO{Status: HEALTH_DOWN, Error: err.Error()}
+	}
+
+	return ComponentHealthDTO{
+		Status:  HEALTH_UP,
+		Details: map[string]any{"latency_ms": float64(latency.Microseconds()) / 1000},
+	}
+}
+
+// schemaHealth checks schema version and required procedures
+func schemaHealth(ctx context.Context, dao storage.Dao) ComponentHealthDTO {
+	details := map[string]any{"expected_version": storage.SCHEMA_VERSION}
+	version, errVersion := dao.SchemaVersion(ctx)
+	if errVersion != nil {
+		return ComponentHealthDTO{Status: HEALTH_DOWN, Error: errVersion.Error(), Details: details}
+	}
+
+	details["version"] = version
+	if version != storage.SCHEMA_VERSION {
+		return ComponentHealthDTO{Status: HEALTH_DOWN, Error: "unexpected schema version", Details: details}
+	}
+
+	missing, errMissing := dao.MissingProcedures(ctx, storage.REQUIRED_PROCEDURES)
+	if errMissing != nil {
+		return ComponentHealthDTO{Status: HEALTH_DOWN, Error: errMissing.Error(), Details: details}
+	} else if len(missing) != 0 {
+		details["missing_procedures"] = missing
+		return ComponentHealthDTO{Status: HEALTH_DOWN, Error: "missing procedures", Details: details}
+	}
+
+	return ComponentHealthDTO{Status: HEALTH_UP, Details: details}
+}
+
+// poolHealth reports pool use, degraded when pool is almost saturated
+func poolHealth(dao storage.Dao) ComponentHealthDTO {
+	status := dao.PoolStatus()
+	result := ComponentHealthDTO{
+		Status: HEALTH_UP,
+		Details: map[string]any{
+			"max":        status.MaxConns,
+			"total":      status.TotalConns,
+			"acquired":   status.AcquiredConns,
+			"idle":       status.IdleConns,
+			"saturation": status.Saturation(),
+		},
+	}
+
+	if status.MaxConns <= 0 {
+		result.Status = HEALTH_DOWN
+		result.Error = "no pool"
+	} else if status.Saturation() >= POOL_SATURATION_THRESHOLD {
+		result.Status = HEALTH_DEGRADED
+	}
+
+	return result
+}
@@ -1,8 +1,6 @@
 package serving
 
 import (
-	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -12,86 +10,141 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-const TokenDuration = time.Hour * 24
+// createToken builds a new access token for a given login, signed by the active key of keyring.
+// Claims are the standard sub, iss, aud, iat and exp
+func createToken(wrapper ServiceParameters, login string) (string, error) {
+	settings := wrapper.Settings
+	now := time.Now()
+	return signClaims(wrapper, jwt.RegisteredClaims{
+		Subject:   login,
+		Issuer:    settings.Issuer,
+		Audience:  jwt.ClaimStrings{settings.Audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(settings.AccessTokenDuration)),
+	})
+}
 
-// TokenPayload to parse from the payload part of the token
-type TokenPayload struct {
-	User       string `json:"user"`
-	Expiration int64  `json:"expiration"`
+// IsRevokedToken returns true if a token issued at issuedAt was revoked at notBefore, zero for no revocation.
+// Issue dates of tokens are in seconds, so tokens issued during the second of the revocation are revoked too
+func IsRevokedToken(issuedAt, notBefore time.Time) bool {
+	return !notBefore.IsZero() && !issuedAt.After(notBefore)
 }
 
-// createToken builds a new token for a given login using its secret
-func createToken(userName string, userSecret string) (string, error) {
-	// Source for JWT implementation: https://medium.com/@cheickzida/golang-implementing-jwt-token-authentication-bba9bfd84d60
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256,
-		jwt.MapClaims{
-			"user":       userName,
-			"expiration": time.Now().Add(TokenDuration).Unix(),
-		})
-
-	if token, err := token.SignedString([]byte(userSecret)); err != nil {
-		return "", err
-	} else {
-		return token, nil
+// signClaims signs claims with the active key of keyring, header contains the key id as kid
+func signClaims(wrapper ServiceParameters, claims jwt.Claims) (string, error) {
+	key, errKey := wrapper.Keyring.activeKey(wrapper.Ctx)
+	if errKey != nil {
+		return "", errKey
 	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.algorithm), claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.private)
+}
+
+// parseSignedClaims parses a token signed by a key of keyring into claims, and validates it with options
+func parseSignedClaims(wrapper ServiceParameters, rawToken string, claims jwt.Claims, options ...jwt.ParserOption) (*jwt.Token, error) {
+	// signature is checked with the public key of the kid of the token
+	publicKeyFunc := func(token *jwt.Token) (interface{}, error) {
+		keyId, _ := token.Header["kid"].(string)
+		if publicKey, algorithm, err := wrapper.Keyring.VerificationKey(wrapper.Ctx, keyId); err != nil {
+			return nil, err
+		} else if algorithm != token.Method.Alg() {
+			return nil, fmt.Errorf("unexpected algorithm %s for key %s", token.Method.Alg(), keyId)
+		} else {
+			return publicKey, nil
+		}
+	}
+
+	options = append(options, jwt.WithValidMethods(SIGNING_ALGORITHMS), jwt.WithExpirationRequired())
+	return jwt.ParseWithClaims(rawToken, claims, publicKeyFunc, options...)
 }
 
-// validateAuthentication reads header and then test if login matches its expected secret.
-// Result is login coming from request, true for auth success, the detailed error otherwise
-func validateAuthentication(wrapper ServiceParameters, r *http.Request) (string, bool, error) {
+// API_KEY_SCHEME is the authorization scheme of api keys, header is Authorization: ApiKey <key>
+const API_KEY_SCHEME = "ApiKey "
+
+// validateAuthentication reads header and then validates the signed token of an active user, the token of the identity provider, or the api key.
+// Result is login coming from request, the id of the api key if any, true for auth success, the detailed error otherwise
+func validateAuthentication(wrapper ServiceParameters, r *http.Request) (string, string, bool, error) {
 	// Explanation are there: https://jwt.io/introduction
 	// header should contain Authorization: Bearer <token>
 	if r == nil {
-		return "", false, fmt.Errorf("empty request")
+		return "", "", false, fmt.Errorf("empty request")
 	}
 
 	var header string
 	if values, found := r.Header["Authorization"]; !found {
-		return "", false, nil
+		return "", "", false, nil
 	} else if len(values) != 1 {
-		return "", false, nil
+		return "", "", false, nil
 	} else {
 		header = strings.Trim(values[0], " ")
 	}
 
-	if !strings.HasPrefix(header, "Bearer ") {
-		return "", false, nil
+	if strings.HasPrefix(header, API_KEY_SCHEME) {
+		return validateApiKey(wrapper, strings.Trim(header[len(API_KEY_SCHEME):], " "))
+	} else if !strings.HasPrefix(header, "Bearer ") {
+		return "", "", false, nil
 	}
 
-	var payload TokenPayload
-	// parse token to find payload
+	// tokens of the identity provider, if any, are validated by the provider
 	tokenValue := header[7:]
-	payloadEncoded := strings.Split(tokenValue, ".")[1]
-	if raw, err := base64.StdEncoding.DecodeString(payloadEncoded); err != nil {
-		return "", false, errors.Join(err, errors.New("invalid value: "+payloadEncoded+"\t"+string([]byte(payloadEncoded)[92])))
-	} else if err := json.Unmarshal(raw, &payload); err != nil {
-		return "", false, err
-	}
-
-	login := payload.User
-	var secret string
-	if s, err := wrapper.Dao.FindSecretForActiveUser(wrapper.Ctx, login); err != nil {
-		return "", false, err
-	} else {
-		secret = s
+	if wrapper.Identity != nil && isIssuedBy(tokenValue, wrapper.Identity.Settings().Issuer) {
+		return validateExternalToken(wrapper, tokenValue)
 	}
 
-	// details on why are here: https://pkg.go.dev/github.com/golang-jwt/jwt/v5#Keyfunc
-	expectedSecretFunc := func(token *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	}
+	var claims jwt.RegisteredClaims
+	token, err := parseSignedClaims(wrapper, tokenValue, &claims,
+		jwt.WithIssuedAt(),
+		jwt.WithIssuer(wrapper.Settings.Issuer),
+		jwt.WithAudience(wrapper.Settings.Audience),
+	)
 
-	token, err := jwt.Parse(tokenValue, expectedSecretFunc)
 	switch {
-	case token.Valid:
-		return login, true, nil
+	case err == nil && token.Valid:
 	case errors.Is(err, jwt.ErrTokenMalformed):
-		return login, false, fmt.Errorf("malformed token")
-	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
-		return login, false, fmt.Errorf("invalid signature")
-	case errors.Is(err, jwt.ErrTokenExpired) || errors.Is(err, jwt.ErrTokenNotValidYet):
-		return login, false, fmt.Errorf("invalid token period")
+		return "", "", false, fmt.Errorf("malformed token")
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid) || errors.Is(err, jwt.ErrTokenUnverifiable):
+		return "", "", false, fmt.Errorf("invalid signature")
+	case errors.Is(err, jwt.ErrTokenExpired) || errors.Is(err, jwt.ErrTokenNotValidYet) || errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return "", "", false, fmt.Errorf("invalid token period")
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer) || errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return "", "", false, fmt.Errorf("invalid token claims")
 	default:
-		return login, false, err
+		return "", "", false, err
 	}
+
+	// user should be active, and its sessions not revoked since token was issued
+	login := claims.Subject
+	if notBefore, err := wrapper.Dao.FindTokensNotBeforeForActiveUser(wrapper.Ctx, login); err != nil {
+		return login, "", false, err
+	} else if claims.IssuedAt == nil || IsRevokedToken(claims.IssuedAt.Time, notBefore) {
+		return login, "", false, fmt.Errorf("revoked token")
+	}
+
+	return login, "", true, nil
+}
+
+// validateApiKey returns the login and the id of a valid api key
+func validateApiKey(wrapper ServiceParameters, key string) (string, string, bool, error) {
+	if len(key) == 0 {
+		return "", "", false, nil
+	}
+
+	login, keyId, err := wrapper.Dao.FindApiKey(wrapper.Ctx, key)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	return login, keyId, true, nil
+}
+
+// isIssuedBy returns true if the unverified iss claim of a token is issuer
+func isIssuedBy(rawToken string, issuer string) bool {
+	var claims jwt.RegisteredClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(rawToken, &claims); err != nil {
+		return false
+	}
+
+	return claims.Issuer == issuer
 }
@@ -0,0 +1,94 @@
+package serving
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/zefrenchwan/patterns.git/storage"
+	"go.uber.org/zap"
+)
+
+// listTrashHandler returns the elements of a graph in trash, and the graph itself if it is in trash
+func listTrashHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	user, auth := wrapper.CurrentUser()
+	if !auth {
+		return NewServiceForbiddenError("should authenticate")
+	}
+
+	graphId := r.PathValue("graphId")
+	if len(graphId) == 0 {
+		return NewServiceHttpClientError("expecting graph id")
+	}
+
+	items, errList := wrapper.Dao.ListTrashForUser(wrapper.Ctx, user, graphId)
+	if errList != nil {
+		return BuildApiErrorFromStorageError(errList)
+	} else if errResponse := json.NewEncoder(w).Encode(items); errResponse != nil {
+		return NewServiceInternalServerError(errResponse.Error())
+	}
+
+	return nil
+}
+
+// restoreGraphHandler restores a graph from the trash
+func restoreGraphHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	user, auth := wrapper.CurrentUser()
+	if !auth {
+		return NewServiceForbiddenError("should authenticate")
+	}
+
+	graphId := r.PathValue("graphId")
+	if err := wrapper.Dao.RestoreGraph(wrapper.Ctx, user, graphId); err != nil {
+		return BuildApiErrorFromStorageError(err)
+	}
+
+	w.WriteHeader(200)
+	return nil
+}
+
+// restoreElementHandler restores an element from the trash
+func restoreElementHandler(wrapper ServiceParameters, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	user, auth := wrapper.CurrentUser()
+	if !auth {
+		return NewServiceForbiddenError("should authenticate")
+	}
+
+	elementId := r.PathValue("elementId")
+	if err := wrapper.Dao.RestoreElement(wrapper.Ctx, user, elementId); err != nil {
+		return BuildApiErrorFromStorageError(err)
+	}
+
+	w.WriteHeader(200)
+	return nil
+}
+
+// StartTrashPurge purges, every period, graphs and elements in trash for longer than retention.
+// It runs in background until context is done
+func StartTrashPurge(ctx context.Context, dao storage.Dao, retention, period time.Duration, logger *zap.SugaredLogger) {
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				elements, graphs, err := dao.PurgeTrash(ctx, retention)
+				if err != nil {
+					logger.Errorf("trash purge failed: %s", err.Error())
+				} else if elements != 0 || graphs != 0 {
+					logger.Infof("trash purge deleted %d elements and %d graphs", elements, graphs)
+				}
+			}
+		}
+	}()
+}
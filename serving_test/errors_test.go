@@ -0,0 +1,106 @@
+package serving_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/zefrenchwan/patterns.git/serving"
+	"github.com/zefrenchwan/patterns.git/storage"
+)
+
+func TestErrorsAreProblemDetails(t *testing.T) {
+	mux := serving.InitService(storage.Dao{}, context.Background(), nil, serving.DefaultServiceSettings())
+
+	expected := map[string]struct {
+		method string
+		status int
+		code   string
+	}{
+		"/graph/list/": {http.MethodGet, http.StatusUnauthorized, serving.ERROR_CODE_UNAUTHENTICATED},
+		"/token/":      {http.MethodGet, http.StatusBadRequest, serving.ERROR_CODE_INVALID_METHOD},
+		"/oidc/login/": {http.MethodGet, http.StatusNotFound, serving.ERROR_CODE_NOT_FOUND},
+	}
+
+	for path, value := range expected {
+		request := httptest.NewRequest(value.method, path, nil)
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+
+		var problem serving.ProblemDTO
+		if contentType := recorder.Header().Get("Content-Type"); contentType != serving.PROBLEM_CONTENT_TYPE {
+			t.Errorf("expecting problem for %s, got %s", path, contentType)
+		} else if err := json.Unmarshal(recorder.Body.Bytes(), &problem); err != nil {
+			t.Errorf("invalid problem for %s: %s", path, err.Error())
+		} else if problem.Status != value.status || recorder.Code != value.status || problem.Code != value.code {
+			t.Errorf("expecting %d %s for %s, got %v", value.status, value.code, path, problem)
+		} else if problem.Instance != path || problem.RequestId != recorder.Header().Get(serving.REQUEST_ID_HEADER) {
+			t.Errorf("expecting instance and request id for %s, got %v", path, problem)
+		}
+	}
+}
+
+func TestStorageErrorCodes(t *testing.T) {
+	expected := map[string]string{
+		storage.AUTH_CODE:          serving.ERROR_CODE_ACCESS_DENIED,
+		storage.RESOURCE_CODE:      serving.ERROR_CODE_NOT_FOUND,
+		storage.INCONSISTENCY_CODE: serving.ERROR_CODE_INCONSISTENT_STATE,
+		storage.CYCLE_CODE:         serving.ERROR_CODE_CYCLE,
+		storage.DUPLICATE_CODE:     serving.ERROR_CODE_ALREADY_EXISTS,
+		"XX000":                    serving.ERROR_CODE_INTERNAL,
+	}
+
+	for sqlCode, code := range expected {
+		source := &pgconn.PgError{Code: sqlCode, Message: "failure"}
+		var serviceError serving.ServiceHttpError
+		if err := serving.BuildApiErrorFromStorageError(source); !errors.As(err, &serviceError) {
+			t.Errorf("expecting service error for %s", sqlCode)
+		} else if serviceError.Code() != code {
+			t.Errorf("expecting %s for %s, got %s", code, sqlCode, serviceError.Code())
+		}
+	}
+}
+
+func TestFieldErrors(t *testing.T) {
+	var input serving.UserInformationInput
+	errDecode := json.Unmarshal([]byte(`{"username": 42}`), &input)
+	deserialization := serving.NewServiceDeserializationError(errDecode)
+	if deserialization.HttpCode() != http.StatusUnprocessableEntity || deserialization.Code() != serving.ERROR_CODE_MALFORMED_BODY {
+		t.Errorf("invalid deserialization error %v", deserialization.Problem())
+	} else if fields := deserialization.FieldErrors(); len(fields) != 1 || fields[0].Field != "username" {
+		t.Errorf("expecting username field, got %v", fields)
+	}
+
+	policy := serving.PasswordPolicy{MinLength: 20, RequireDigit: true}
+	validation := serving.NewServiceFieldError("password", policy.Validate("alice", "short"))
+	if validation.HttpCode() != http.StatusBadRequest || validation.Code() != serving.ERROR_CODE_VALIDATION_FAILED {
+		t.Errorf("invalid validation error %v", validation.Problem())
+	} else if fields := validation.FieldErrors(); len(fields) != 2 || fields[0].Field != "password" || fields[1].Field != "password" {
+		t.Errorf("expecting an error per rule, got %v", fields)
+	}
+}
+
+func TestBodyTooLarge(t *testing.T) {
+	mux := serving.InitService(storage.Dao{}, context.Background(), nil, serving.DefaultServiceSettings())
+	handler := http.MaxBytesHandler(mux, 16)
+
+	body := strings.NewReader(`{"username": "alice", "password": "a very long password"}`)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/token/", body))
+
+	var problem serving.ProblemDTO
+	if err := json.Unmarshal(recorder.Body.Bytes(), &problem); err != nil {
+		t.Fatal(err)
+	} else if recorder.Code != http.StatusRequestEntityTooLarge || problem.Code != serving.ERROR_CODE_BODY_TOO_LARGE {
+		t.Errorf("expecting 413 %s, got %d %v", serving.ERROR_CODE_BODY_TOO_LARGE, recorder.Code, problem)
+	}
+
+	if other := serving.NewServiceReadBodyError(errors.New("failure")); other.HttpCode() != http.StatusUnprocessableEntity {
+		t.Errorf("expecting 422 for other read errors, got %d", other.HttpCode())
+	}
+}
@@ -0,0 +1,53 @@
+package serving_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zefrenchwan/patterns.git/serving"
+	"github.com/zefrenchwan/patterns.git/storage"
+)
+
+func TestInvalidAuthorizationIsRejected(t *testing.T) {
+	mux := serving.InitService(storage.Dao{}, context.Background(), nil, serving.DefaultServiceSettings())
+
+	for _, header := range []string{"", "Bearer not.a.token", "ApiKey ", "Basic dXNlcjpwYXNz"} {
+		request := httptest.NewRequest(http.MethodGet, "/graph/list/", nil)
+		if len(header) != 0 {
+			request.Header.Set("Authorization", header)
+		}
+
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("expecting unauthorized for %s, got %d", header, recorder.Code)
+		}
+	}
+}
+
+func TestJwksNeedsKeys(t *testing.T) {
+	mux := serving.InitService(storage.Dao{}, context.Background(), nil, serving.DefaultServiceSettings())
+
+	// no database means no key to publish
+	request := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("expecting server error, got %d", recorder.Code)
+	}
+}
+
+func TestOidcNeedsIssuer(t *testing.T) {
+	mux := serving.InitService(storage.Dao{}, context.Background(), nil, serving.DefaultServiceSettings())
+
+	for _, path := range []string{"/oidc/login/", "/oidc/callback/?code=code&state=state"} {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("expecting not found for %s, got %d", path, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package serving_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/zefrenchwan/patterns.git/serving"
+)
+
+func TestSigningKeysAreEncrypted(t *testing.T) {
+	secret := bytes.Repeat([]byte{7}, 32)
+	content := []byte("private key content")
+
+	sealed, errSeal := serving.SealSigningKey(secret, "key", content)
+	if errSeal != nil {
+		t.Fatal(errSeal)
+	} else if bytes.Contains(sealed, content) {
+		t.Error("private key should not be stored in clear")
+	}
+
+	if opened, err := serving.OpenSigningKey(secret, "key", sealed); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(opened, content) {
+		t.Error("decrypted key should match")
+	}
+
+	if _, err := serving.OpenSigningKey(bytes.Repeat([]byte{8}, 32), "key", sealed); err == nil {
+		t.Error("another secret should not decrypt key")
+	} else if _, err := serving.OpenSigningKey(secret, "other", sealed); err == nil {
+		t.Error("encrypted key should not be valid for another key id")
+	} else if _, err := serving.SealSigningKey(nil, "key", content); err == nil {
+		t.Error("missing secret should fail")
+	}
+}
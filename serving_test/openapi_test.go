@@ -0,0 +1,82 @@
+package serving_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zefrenchwan/patterns.git/serving"
+	"github.com/zefrenchwan/patterns.git/storage"
+)
+
+func TestOpenApiDocumentsRegisteredRoutes(t *testing.T) {
+	mux, registry := serving.InitServiceWithRoutes(storage.Dao{}, context.Background(), nil, serving.DefaultServiceSettings())
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expecting 200, got %d", recorder.Code)
+	}
+
+	var document serving.OpenApiDTO
+	if err := json.NewDecoder(recorder.Body).Decode(&document); err != nil {
+		t.Fatal(err)
+	} else if document.OpenApi != serving.OPENAPI_VERSION {
+		t.Errorf("expecting version %s, got %s", serving.OPENAPI_VERSION, document.OpenApi)
+	}
+
+	routes := registry.Routes()
+	if len(routes) == 0 {
+		t.Fatal("no registered route")
+	}
+
+	for _, route := range routes {
+		operation, found := document.Paths[route.Pattern][strings.ToLower(route.Method)]
+		if !found {
+			t.Errorf("missing route %s %s", route.Method, route.Pattern)
+			continue
+		} else if len(operation.Summary) == 0 {
+			t.Errorf("undocumented route %s %s", route.Method, route.Pattern)
+		} else if _, found := operation.Responses["default"]; !found {
+			t.Errorf("missing error response for %s %s", route.Method, route.Pattern)
+		} else if route.Authenticated != (len(operation.Security) != 0) {
+			t.Errorf("invalid security for %s %s", route.Method, route.Pattern)
+		}
+
+		parameters := make(map[string]bool)
+		for _, parameter := range operation.Parameters {
+			if parameter.In == "path" {
+				parameters[parameter.Name] = true
+			}
+		}
+
+		for _, part := range strings.Split(route.Pattern, "/") {
+			if name, found := strings.CutPrefix(part, "{"); found && !parameters[strings.TrimSuffix(name, "}")] {
+				t.Errorf("missing path parameter %s for %s %s", part, route.Method, route.Pattern)
+			}
+		}
+	}
+}
+
+func TestOpenApiSchemas(t *testing.T) {
+	document := serving.BuildOpenApi([]serving.RouteDTO{
+		{Method: http.MethodGet, Pattern: "/elements/lineage/{elementId}/", Authenticated: true},
+	})
+
+	lineage, found := document.Components.Schemas["LineageTreeDTO"]
+	if !found {
+		t.Fatal("missing recursive schema")
+	}
+
+	properties := lineage["properties"].(map[string]any)
+	if _, found := properties["graph"]; !found {
+		t.Error("embedded fields should be flattened")
+	} else if _, found := properties["copies"]; !found {
+		t.Error("missing copies")
+	} else if _, found := document.Components.Schemas["ProblemDTO"]; !found {
+		t.Error("missing error schema")
+	}
+}
@@ -0,0 +1,37 @@
+package serving_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zefrenchwan/patterns.git/serving"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := serving.PasswordPolicy{MinLength: 8, RequireMixedCase: true, RequireDigit: true, RequireSymbol: true}
+	if err := policy.Validate("alice", "Correct-Horse-9"); err != nil {
+		t.Errorf("expecting valid password, got %s", err.Error())
+	}
+
+	invalid := map[string]string{
+		"Sh0rt!":                    "at least 8",
+		"lower-case-only-9":         "upper and lower",
+		"No-Digit-Here":             "digit",
+		"NoSymbol99":                "symbol",
+		"My-Alice-Pass-1":           "login",
+		strings.Repeat("Aa1-", 100): "at most",
+	}
+
+	for password, expected := range invalid {
+		if err := policy.Validate("alice", password); err == nil {
+			t.Errorf("expecting invalid password %s", password)
+		} else if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expecting %s in error, got %s", expected, err.Error())
+		}
+	}
+
+	// default policy checks length only
+	if err := serving.DefaultServiceSettings().PasswordPolicy.Validate("bob", "long enough password"); err != nil {
+		t.Errorf("expecting valid password, got %s", err.Error())
+	}
+}
@@ -0,0 +1,38 @@
+package serving_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zefrenchwan/patterns.git/serving"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := serving.NewRateLimiter(serving.RateLimits{
+		Read: serving.RateLimit{Rate: 0.01, Burst: 2},
+		Find: serving.RateLimit{Rate: 0, Burst: 1},
+	})
+
+	for index := range 2 {
+		if allowed, _ := limiter.Allow(serving.RATE_CLASS_READ, "alice"); !allowed {
+			t.Errorf("call %d should be in burst", index)
+		}
+	}
+
+	if allowed, wait := limiter.Allow(serving.RATE_CLASS_READ, "alice"); allowed {
+		t.Error("call after burst should be rejected")
+	} else if wait <= 90*time.Second || wait > 100*time.Second {
+		t.Errorf("expecting next token in about 100s, got %s", wait)
+	}
+
+	if allowed, _ := limiter.Allow(serving.RATE_CLASS_READ, "bob"); !allowed {
+		t.Error("keys should have their own bucket")
+	}
+
+	// no rate means no limit
+	for range 10 {
+		if allowed, _ := limiter.Allow(serving.RATE_CLASS_FIND, "alice"); !allowed {
+			t.Error("find has no limit")
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package serving_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zefrenchwan/patterns.git/serving"
+	"github.com/zefrenchwan/patterns.git/storage"
+)
+
+func TestRequestIdPropagation(t *testing.T) {
+	mux := serving.InitService(storage.Dao{}, context.Background(), nil, serving.DefaultServiceSettings())
+
+	// valid id from client is kept
+	request := httptest.NewRequest(http.MethodGet, "/status/", nil)
+	request.Header.Set(serving.REQUEST_ID_HEADER, "client-id:42")
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, request)
+	if value := recorder.Header().Get(serving.REQUEST_ID_HEADER); value != "client-id:42" {
+		t.Errorf("expecting client id, got %s", value)
+	}
+
+	// no id, or invalid id, means a new id
+	generated := make(map[string]bool)
+	for _, value := range []string{"", "invalid id\nwith line feed"} {
+		request := httptest.NewRequest(http.MethodGet, "/status/", nil)
+		if len(value) != 0 {
+			request.Header.Set(serving.REQUEST_ID_HEADER, value)
+		}
+
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		if id := recorder.Header().Get(serving.REQUEST_ID_HEADER); len(id) == 0 || id == value {
+			t.Errorf("expecting a new id, got %s", id)
+		} else {
+			generated[id] = true
+		}
+	}
+
+	if len(generated) != 2 {
+		t.Errorf("expecting different ids, got %v", generated)
+	}
+}
+
+func TestRoutesMatchExactPaths(t *testing.T) {
+	mux := serving.InitService(storage.Dao{}, context.Background(), nil, serving.DefaultServiceSettings())
+
+	expected := map[string]int{
+		// authenticated routes, with or without trailing slash
+		"/elements/element/equivalence/status/": http.StatusUnauthorized,
+		"/elements/element/equivalence/status":  http.StatusUnauthorized,
+		"/elements/load/element/":               http.StatusUnauthorized,
+		// not a sub path of a route
+		"/elements/load/element/other/": http.StatusNotFound,
+		"/status/unknown/":              http.StatusNotFound,
+	}
+
+	for path, status := range expected {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if recorder.Code != status {
+			t.Errorf("%s: expecting %d, got %d", path, status, recorder.Code)
+		}
+	}
+}
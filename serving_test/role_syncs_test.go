@@ -0,0 +1,37 @@
+package serving_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zefrenchwan/patterns.git/serving"
+)
+
+func TestRoleSyncer(t *testing.T) {
+	syncer := serving.NewRoleSyncer(time.Hour)
+	roles := []string{"graph:modifier", "graph:observer"}
+	if !syncer.ShouldSync("issuer alice", roles) {
+		t.Error("first token should synchronize")
+	}
+
+	syncer.Synced("issuer alice", roles)
+	if syncer.ShouldSync("issuer alice", roles) {
+		t.Error("same roles within period should not synchronize")
+	} else if !syncer.ShouldSync("issuer alice", []string{"graph:observer"}) {
+		t.Error("other roles should synchronize at once")
+	} else if !syncer.ShouldSync("issuer bob", roles) {
+		t.Error("subjects should have their own synchronization")
+	}
+
+	// no period means each token
+	syncer = serving.NewRoleSyncer(0)
+	syncer.Synced("issuer alice", roles)
+	if !syncer.ShouldSync("issuer alice", roles) {
+		t.Error("no period should synchronize each token")
+	}
+
+	var none *serving.RoleSyncer
+	if !none.ShouldSync("issuer alice", roles) {
+		t.Error("nil syncer should synchronize each token")
+	}
+}
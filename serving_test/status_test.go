@@ -0,0 +1,148 @@
+package serving_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/zefrenchwan/patterns.git/serving"
+	"github.com/zefrenchwan/patterns.git/storage"
+)
+
+func TestProbesWithoutDatabase(t *testing.T) {
+	mux := serving.InitService(storage.Dao{}, context.Background(), nil, serving.DefaultServiceSettings())
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status/live/", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("liveness should not depend on database, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status/ready/", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("expecting 503 with no database, got %d", recorder.Code)
+	}
+
+	var health serving.HealthDTO
+	if err := json.NewDecoder(recorder.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	} else if health.Status != serving.HEALTH_DOWN {
+		t.Errorf("expecting down, got %s", health.Status)
+	} else if component, found := health.Components["database"]; !found || component.Status != serving.HEALTH_DOWN {
+		t.Errorf("expecting database down, got %v", health.Components)
+	} else if _, found := health.Components["schema"]; !found {
+		t.Error("missing schema component")
+	} else if _, found := health.Components["pool"]; !found {
+		t.Error("missing pool component")
+	}
+}
+
+// TEST_DB_URL_VARIABLE is the url of a disposable database for tests that need one.
+// Those tests are skipped if it is not set
+const TEST_DB_URL_VARIABLE = "PATTERNS_TEST_DB_URL"
+
+// readiness calls the readiness probe and returns its status code and its health
+func readiness(t *testing.T, mux *http.ServeMux) (int, serving.HealthDTO) {
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status/ready/", nil))
+
+	var health serving.HealthDTO
+	if err := json.NewDecoder(recorder.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+
+	return recorder.Code, health
+}
+
+func TestReadinessWithDatabase(t *testing.T) {
+	url := os.Getenv(TEST_DB_URL_VARIABLE)
+	if len(url) == 0 {
+		t.Skipf("no test database, set %s to run this test", TEST_DB_URL_VARIABLE)
+	}
+
+	ctx := context.Background()
+	dao, errDao := storage.NewDao(ctx, url, storage.PoolSettings{MaxConns: 10}, nil)
+	if errDao != nil {
+		t.Fatal(errDao)
+	}
+
+	defer dao.Close()
+	mux := serving.InitService(dao, ctx, nil, serving.DefaultServiceSettings())
+
+	// case 1: expected schema
+	if code, health := readiness(t, mux); code != http.StatusOK || health.Status != serving.HEALTH_UP {
+		t.Errorf("expecting up, got %d and %v", code, health)
+	} else if version := health.Components["schema"].Details["version"]; version != float64(storage.SCHEMA_VERSION) {
+		t.Errorf("expecting schema version %d, got %v", storage.SCHEMA_VERSION, version)
+	}
+
+	// case 2: a procedure is missing
+	required := storage.REQUIRED_PROCEDURES
+	storage.REQUIRED_PROCEDURES = append(slices.Clone(required), "susers.missing_for_test")
+	code, health := readiness(t, mux)
+	storage.REQUIRED_PROCEDURES = required
+	if code != http.StatusServiceUnavailable || health.Components["schema"].Status != serving.HEALTH_DOWN {
+		t.Errorf("expecting schema down, got %d and %v", code, health)
+	} else if missing, ok := health.Components["schema"].Details["missing_procedures"].([]any); !ok || !slices.Contains(missing, any("susers.missing_for_test")) {
+		t.Errorf("expecting missing procedure, got %v", health.Components["schema"].Details)
+	}
+
+	// case 3: pool is almost saturated. Rotations of a locked refresh token keep their connections
+	conn, errConn := pgx.Connect(ctx, url)
+	if errConn != nil {
+		t.Fatal(errConn)
+	}
+
+	defer conn.Close(ctx)
+	login := "test_" + uuid.NewString()
+	if _, err := conn.Exec(ctx, "call susers.insert_user($1, $2)", login, uuid.NewString()); err != nil {
+		t.Fatal(err)
+	}
+
+	token, errToken := dao.CreateRefreshToken(ctx, login, time.Hour)
+	if errToken != nil {
+		t.Fatal(errToken)
+	}
+
+	lock, errLock := conn.Begin(ctx)
+	if errLock != nil {
+		t.Fatal(errLock)
+	} else if _, err := lock.Exec(ctx,
+		"select 1 from susers.refresh_tokens RTO join susers.users USR on USR.user_id = RTO.user_id where USR.user_login = $1 for update of RTO",
+		login,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	var group sync.WaitGroup
+	for range 9 {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			dao.RotateRefreshToken(ctx, token, time.Hour)
+		}()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for dao.PoolStatus().AcquiredConns < 9 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	code, health = readiness(t, mux)
+	lock.Rollback(ctx)
+	group.Wait()
+	if code != http.StatusOK || health.Status != serving.HEALTH_DEGRADED {
+		t.Errorf("expecting degraded, got %d and %v", code, health)
+	} else if health.Components["pool"].Status != serving.HEALTH_DEGRADED {
+		t.Errorf("expecting pool degraded, got %v", health.Components["pool"])
+	}
+}
@@ -0,0 +1,23 @@
+package serving_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zefrenchwan/patterns.git/serving"
+)
+
+func TestRevokedTokens(t *testing.T) {
+	revocation := time.Date(2024, 1, 1, 12, 0, 0, 500_000_000, time.UTC)
+	sameSecond := revocation.Truncate(time.Second)
+
+	if serving.IsRevokedToken(sameSecond, time.Time{}) {
+		t.Error("no revocation should accept tokens")
+	} else if !serving.IsRevokedToken(sameSecond, revocation) {
+		t.Error("token issued during the second of the revocation should be revoked")
+	} else if !serving.IsRevokedToken(sameSecond.Add(-time.Second), revocation) {
+		t.Error("token issued before revocation should be revoked")
+	} else if serving.IsRevokedToken(sameSecond.Add(time.Second), revocation) {
+		t.Error("token issued after revocation should be valid")
+	}
+}
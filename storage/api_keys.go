@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// API_KEY_SETTING is the session setting of the api key in use, read by authorization checks
+const API_KEY_SETTING = "patterns.api_key"
+
+// API_KEY_RESET_TIMEOUT is the maximum duration to reset a connection once released
+const API_KEY_RESET_TIMEOUT = 5 * time.Second
+
+// ErrInvalidApiKey is raised for an unknown, expired or revoked api key
+var ErrInvalidApiKey = errors.New("invalid api key")
+
+// ApiKeyDTO describes an api key, never its value
+type ApiKeyDTO struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+	RevokedAt string `json:"revoked_at,omitempty"`
+	// Graphs the key may access, empty for no restriction
+	Graphs []string `json:"graphs,omitempty"`
+	// Roles the key may use, empty for no restriction
+	Roles []string `json:"roles,omitempty"`
+}
+
+// ApiKeyRestriction restricts an api key. Nil slices mean no restriction, nil expiration means no expiration
+type ApiKeyRestriction struct {
+	ExpiresAt *time.Time
+	Graphs    []string
+	Roles     []string
+}
+
+// apiKeyIdKey is the context key of the api key in use
+type apiKeyIdKey struct{}
+
+// ContextWithApiKey returns a context for a request authenticated by an api key.
+// Dao calls with that context apply the restrictions of that key
+func ContextWithApiKey(ctx context.Context, keyId string) context.Context {
+	return context.WithValue(ctx, apiKeyIdKey{}, keyId)
+}
+
+// ApiKeyFromContext returns the id of the api key in the context, if any
+func ApiKeyFromContext(ctx context.Context) (string, bool) {
+	keyId, found := ctx.Value(apiKeyIdKey{}).(string)
+	return keyId, found && len(keyId) != 0
+}
+
+// apiKeySessions sets the api key of a request on the connections it acquires, and resets it on release
+type apiKeySessions struct {
+	// conns are the connections with an api key set
+	conns sync.Map
+}
+
+// beforeAcquire sets the api key of the context, if any. Connection is destroyed on failure
+func (a *apiKeySessions) beforeAcquire(ctx context.Context, conn *pgx.Conn) bool {
+	keyId, found := ApiKeyFromContext(ctx)
+	if !found {
+		return true
+	} else if _, err := conn.Exec(ctx, "select set_config($1, $2, false)", API_KEY_SETTING, keyId); err != nil {
+		return false
+	}
+
+	a.conns.Store(conn, keyId)
+	return true
+}
+
+// afterRelease resets the api key of a connection, if set. Connection is destroyed on failure
+func (a *apiKeySessions) afterRelease(conn *pgx.Conn) bool {
+	if _, found := a.conns.LoadAndDelete(conn); !found {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), API_KEY_RESET_TIMEOUT)
+	defer cancel()
+	_, err := conn.Exec(ctx, "select set_config($1, '', false)", API_KEY_SETTING)
+	return err == nil
+}
+
+// beforeClose forgets a closed connection
+func (a *apiKeySessions) beforeClose(conn *pgx.Conn) {
+	a.conns.Delete(conn)
+}
+
+// CreateApiKey creates a key for login and returns its id and its value. Value is not stored, only its hash.
+// Actor should modify that user
+func (d *Dao) CreateApiKey(ctx context.Context, actor, login, name string, restriction ApiKeyRestriction) (string, string, error) {
+	defer observeDaoCall("CreateApiKey", time.Now())
+	if d == nil || d.pool == nil {
+		return "", "", errors.New("nil value")
+	}
+
+	key, hash, errKey := newSecretToken()
+	if errKey != nil {
+		return "", "", errKey
+	}
+
+	var expiration *time.Time
+	if restriction.ExpiresAt != nil {
+		value := restriction.ExpiresAt.UTC()
+		expiration = &value
+	}
+
+	keyId := uuid.NewString()
+	entry := auditEntry{
+		operation:  AUDIT_API_KEY_CREATE,
+		targetUser: login,
+		changes: map[string]AuditChangeDTO{
+			"api_key": {After: map[string][]string{
+				"id":     {keyId},
+				"name":   {name},
+				"graphs": restriction.Graphs,
+				"roles":  restriction.Roles,
+			}},
+		},
+	}
+
+	if err := d.execAudited(ctx, actor, entry,
+		"call susers.create_api_key($1, $2, $3, $4, $5, $6, $7, $8)",
+		actor, login, keyId, hash, name, expiration, restriction.Graphs, restriction.Roles,
+	); err != nil {
+		return "", "", err
+	}
+
+	return keyId, key, nil
+}
+
+// ListApiKeys returns the keys of login, revoked and expired keys included. Actor should observe that user
+func (d *Dao) ListApiKeys(ctx context.Context, actor, login string) ([]ApiKeyDTO, error) {
+	defer observeDaoCall("ListApiKeys", time.Now())
+	if d == nil || d.pool == nil {
+		return nil, errors.New("nil value")
+	}
+
+	rows, errLoad := d.pool.Query(ctx, "select * from susers.list_api_keys_for_user($1, $2)", actor, login)
+	if errLoad != nil {
+		return nil, errLoad
+	}
+
+	defer rows.Close()
+	result := make([]ApiKeyDTO, 0)
+	for rows.Next() {
+		var key ApiKeyDTO
+		var created time.Time
+		var expires, revoked *time.Time
+		if err := rows.Scan(&key.Id, &key.Name, &created, &expires, &revoked, &key.Graphs, &key.Roles); err != nil {
+			return nil, err
+		}
+
+		key.CreatedAt = created.Format(DATE_SERDE_FORMAT)
+		if expires != nil {
+			key.ExpiresAt = expires.Format(DATE_SERDE_FORMAT)
+		}
+
+		if revoked != nil {
+			key.RevokedAt = revoked.Format(DATE_SERDE_FORMAT)
+		}
+
+		result = append(result, key)
+	}
+
+	return result, rows.Err()
+}
+
+// RevokeApiKey revokes a key of login. Actor should modify that user
+func (d *Dao) RevokeApiKey(ctx context.Context, actor, login, keyId string) error {
+	defer observeDaoCall("RevokeApiKey", time.Now())
+	if d == nil || d.pool == nil {
+		return errors.New("nil value")
+	}
+
+	entry := auditEntry{
+		operation:  AUDIT_API_KEY_REVOKE,
+		targetUser: login,
+		changes: map[string]AuditChangeDTO{
+			"api_key": {Before: map[string][]string{"id": {keyId}}},
+		},
+	}
+
+	return d.execAudited(ctx, actor, entry, "call susers.revoke_api_key($1, $2, $3)", actor, login, keyId)
+}
+
+// FindApiKey returns the login and the id of a valid api key, or ErrInvalidApiKey
+func (d *Dao) FindApiKey(ctx context.Context, key string) (string, string, error) {
+	defer observeDaoCall("FindApiKey", time.Now())
+	if d == nil || d.pool == nil {
+		return "", "", errors.New("nil value")
+	}
+
+	var login, keyId string
+	row := d.pool.QueryRow(ctx, "select * from susers.find_api_key($1)", hashSecretToken(key))
+	if err := row.Scan(&login, &keyId); errors.Is(err, pgx.ErrNoRows) {
+		return "", "", ErrInvalidApiKey
+	} else if err != nil {
+		return "", "", err
+	}
+
+	return login, keyId, nil
+}
@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/zefrenchwan/patterns.git/nodes"
+)
+
+// Operations in the audit log
+const (
+	AUDIT_USER_UPSERT            = "user_upsert"
+	AUDIT_USER_SESSIONS_REVOKE   = "user_sessions_revoke"
+	AUDIT_API_KEY_CREATE         = "api_key_create"
+	AUDIT_API_KEY_REVOKE         = "api_key_revoke"
+	AUDIT_USER_PROVISION         = "user_provision"
+	AUDIT_EXTERNAL_IDENTITY_LINK = "external_identity_link"
+	AUDIT_LOGIN_FAILURE          = "login_failure"
+	AUDIT_USER_ACTIVATE          = "user_activate"
+	AUDIT_USER_DEACTIVATE        = "user_deactivate"
+	AUDIT_GRAPH_CREATE           = "graph_create"
+	AUDIT_GRAPH_FORK             = "graph_fork"
+	AUDIT_GRAPH_METADATA         = "graph_metadata"
+	AUDIT_GRAPH_DELETE           = "graph_delete"
+	AUDIT_GRAPH_RESTORE          = "graph_restore"
+	AUDIT_GRAPH_IMPORT_ADD       = "graph_import_add"
+	AUDIT_GRAPH_IMPORT_REMOVE    = "graph_import_remove"
+	AUDIT_GRAPH_CLEAR            = "graph_clear"
+	AUDIT_FORK_MERGE             = "fork_merge"
+	AUDIT_ELEMENT_UPSERT         = "element_upsert"
+	AUDIT_ELEMENT_DELETE         = "element_delete"
+	AUDIT_ELEMENT_RESTORE        = "element_restore"
+	AUDIT_ELEMENT_COPY           = "element_copy"
+	AUDIT_ELEMENT_MERGE          = "element_merge"
+	AUDIT_ELEMENT_MERGE_UNDO     = "element_merge_undo"
+	AUDIT_ELEMENT_SPLIT          = "element_split"
+	AUDIT_EQUIVALENCE_PULL       = "equivalence_pull"
+	AUDIT_EQUIVALENCE_PUSH       = "equivalence_push"
+	AUDIT_TRASH_PURGE            = "trash_purge"
+)
+
+// AUDIT_SYSTEM_ACTOR is the actor for operations not triggered by an user
+const AUDIT_SYSTEM_ACTOR = "system"
+
+// AuditChangeDTO is the change of a part: changed values before and after, with their periods
+type AuditChangeDTO struct {
+	Before map[string][]string `json:"before,omitempty"`
+	After  map[string][]string `json:"after,omitempty"`
+}
+
+// AuditEntryDTO is an entry of the audit log
+type AuditEntryDTO struct {
+	Id        int64                     `json:"id"`
+	Date      string                    `json:"date"`
+	Actor     string                    `json:"actor"`
+	Operation string                    `json:"operation"`
+	Graph     string                    `json:"graph,omitempty"`
+	User      string                    `json:"user,omitempty"`
+	Elements  []string                  `json:"elements,omitempty"`
+	Changes   map[string]AuditChangeDTO `json:"changes,omitempty"`
+}
+
+// AuditFilter restricts audit entries. Empty values mean no restriction
+type AuditFilter struct {
+	Actor   string
+	Graph   string
+	Element string
+	Start   time.Time
+	End     time.Time
+	// Limit is the maximum number of entries, 0 for no limit
+	Limit int
+}
+
+// auditEntry is an entry to append to the audit log
+type auditEntry struct {
+	operation  string
+	graphId    string
+	targetUser string
+	elementIds []string
+	changes    map[string]AuditChangeDTO
+}
+
+// SerializeElementChanges returns, per changed part, the changed values from before to after.
+// Nil before means creation, nil after means deletion
+func SerializeElementChanges(before, after nodes.Element) map[string]AuditChangeDTO {
+	result := make(map[string]AuditChangeDTO)
+	for _, change := range nodes.DiffElements(before, after) {
+		dto := AuditChangeDTO{}
+		if len(change.Before) != 0 {
+			dto.Before = serializePartValues(change.Part, change.Before)
+		}
+
+		if len(change.After) != 0 {
+			dto.After = serializePartValues(change.Part, change.After)
+		}
+
+		result[change.Part] = dto
+	}
+
+	return result
+}
+
+// serializePartValues returns values and their periods. Activity has no value, key is "active"
+func serializePartValues(part string, values map[string]nodes.Period) map[string][]string {
+	result := make(map[string][]string)
+	for value, period := range values {
+		if part == nodes.SYNC_ACTIVITY_PART {
+			value = "active"
+		}
+
+		result[value] = SerializePeriodsForDTO(period)
+	}
+
+	return result
+}
+
+// beginAudited starts a transaction for actor. Authorization changes in that transaction are audited with that actor
+func (d *Dao) beginAudited(ctx context.Context, actor string) (pgx.Tx, error) {
+	transaction, errTransaction := d.pool.Begin(ctx)
+	if errTransaction != nil {
+		return nil, errTransaction
+	}
+
+	if _, err := transaction.Exec(ctx, "call susers.set_audit_actor($1)", actor); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return nil, errors.Join(err, errRollback)
+	}
+
+	return transaction, nil
+}
+
+// recordAudit appends an entry to the audit log within a transaction, caller deals with commit or rollback
+func recordAudit(ctx context.Context, transaction pgx.Tx, actor string, entry auditEntry) error {
+	var changes, targetUser, graphId *string
+	if len(entry.changes) != 0 {
+		if value, err := json.Marshal(entry.changes); err != nil {
+			return err
+		} else {
+			serialized := string(value)
+			changes = &serialized
+		}
+	}
+
+	if len(entry.targetUser) != 0 {
+		targetUser = &entry.targetUser
+	}
+
+	if len(entry.graphId) != 0 {
+		graphId = &entry.graphId
+	}
+
+	_, errExec := transaction.Exec(ctx,
+		"call susers.insert_audit_entry($1, $2, $3, $4, $5, $6::jsonb)",
+		actor, entry.operation, graphId, targetUser, entry.elementIds, changes,
+	)
+
+	return errExec
+}
+
+// recordElementAudit appends an entry for the change of an element from before to after.
+// Nil before means creation, nil after means deletion
+func recordElementAudit(ctx context.Context, transaction pgx.Tx, actor, operation string, before, after nodes.Element) error {
+	var elementId string
+	if after != nil {
+		elementId = after.Id()
+	} else if before != nil {
+		elementId = before.Id()
+	} else {
+		return errors.New("no element to audit")
+	}
+
+	entry := auditEntry{
+		operation:  operation,
+		elementIds: []string{elementId},
+		changes:    SerializeElementChanges(before, after),
+	}
+
+	return recordAudit(ctx, transaction, actor, entry)
+}
+
+// execAudited runs a query and appends entry to the audit log in the same transaction
+func (d *Dao) execAudited(ctx context.Context, actor string, entry auditEntry, query string, args ...any) error {
+	transaction, errTransaction := d.beginAudited(ctx, actor)
+	if errTransaction != nil {
+		return errTransaction
+	}
+
+	if _, err := transaction.Exec(ctx, query, args...); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(err, errRollback)
+	} else if err := recordAudit(ctx, transaction, actor, entry); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(err, errRollback)
+	}
+
+	return transaction.Commit(ctx)
+}
+
+// ListAuditForUser returns the audit entries matching filter that user manages, most recent first
+func (d *Dao) ListAuditForUser(ctx context.Context, user string, filter AuditFilter) ([]AuditEntryDTO, error) {
+	defer observeDaoCall("ListAuditForUser", time.Now())
+	if d == nil || d.pool == nil {
+		return nil, errors.New("nil value")
+	}
+
+	// nil for no filter
+	nullable := func(value string) *string {
+		if len(value) == 0 {
+			return nil
+		}
+
+		return &value
+	}
+
+	var start, end *time.Time
+	if !filter.Start.IsZero() {
+		start = &filter.Start
+	}
+
+	if !filter.End.IsZero() {
+		end = &filter.End
+	}
+
+	var limit *int
+	if filter.Limit > 0 {
+		limit = &filter.Limit
+	}
+
+	rows, errLoad := d.pool.Query(ctx,
+		"select * from susers.list_audit_for_user($1, $2, $3, $4, $5, $6, $7)",
+		user, nullable(filter.Actor), nullable(filter.Graph), nullable(filter.Element), start, end, limit,
+	)
+
+	if errLoad != nil {
+		return nil, errLoad
+	}
+
+	defer rows.Close()
+	result := make([]AuditEntryDTO, 0)
+	for rows.Next() {
+		var entry AuditEntryDTO
+		var date time.Time
+		var graphId, userLogin, changes *string
+		if err := rows.Scan(
+			&entry.Id, &date, &entry.Actor, &entry.Operation,
+			&graphId, &userLogin, &entry.Elements, &changes,
+		); err != nil {
+			return nil, err
+		}
+
+		entry.Date = date.Format(DATE_SERDE_FORMAT)
+		if graphId != nil {
+			entry.Graph = *graphId
+		}
+
+		if userLogin != nil {
+			entry.User = *userLogin
+		}
+
+		if changes != nil {
+			if err := json.Unmarshal([]byte(*changes), &entry.Changes); err != nil {
+				return nil, err
+			}
+		}
+
+		result = append(result, entry)
+	}
+
+	return result, rows.Err()
+}
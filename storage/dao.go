@@ -2,8 +2,11 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
 	"strings"
 	"time"
 
@@ -12,6 +15,7 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/zefrenchwan/patterns.git/graphs"
 	"github.com/zefrenchwan/patterns.git/nodes"
+	"go.uber.org/zap"
 )
 
 const (
@@ -25,13 +29,50 @@ type Dao struct {
 	pool *pgxpool.Pool
 }
 
-// NewDao builds a new dao to connect a database via its url
-func NewDao(ctx context.Context, url string) (Dao, error) {
+// PoolSettings sizes the connections pool. Zero values mean pgx defaults
+type PoolSettings struct {
+	MaxConns        int32
+	MinConns        int32
+	MaxConnLifetime time.Duration
+	MaxConnIdleTime time.Duration
+}
+
+// NewDao builds a new dao to connect a database via its url.
+// Failing queries are logged with their request id, if logger is not nil
+func NewDao(ctx context.Context, url string, settings PoolSettings, logger *zap.SugaredLogger) (Dao, error) {
 	var dao Dao
-	if pool, errPool := pgxpool.New(ctx, url); errPool != nil {
+	config, errConfig := pgxpool.ParseConfig(url)
+	if errConfig != nil {
+		return dao, fmt.Errorf("dao creation failed: %s", errConfig.Error())
+	}
+
+	if settings.MaxConns > 0 {
+		config.MaxConns = settings.MaxConns
+	}
+
+	if settings.MinConns > 0 {
+		config.MinConns = settings.MinConns
+	}
+
+	if settings.MaxConnLifetime > 0 {
+		config.MaxConnLifetime = settings.MaxConnLifetime
+	}
+
+	if settings.MaxConnIdleTime > 0 {
+		config.MaxConnIdleTime = settings.MaxConnIdleTime
+	}
+
+	config.ConnConfig.Tracer = sqlErrorTracer{logger: logger}
+	// requests authenticated by an api key set that key on their connections
+	sessions := &apiKeySessions{}
+	config.BeforeAcquire = sessions.beforeAcquire
+	config.AfterRelease = sessions.afterRelease
+	config.BeforeClose = sessions.beforeClose
+	if pool, errPool := pgxpool.NewWithConfig(ctx, config); errPool != nil {
 		return dao, fmt.Errorf("dao creation failed: %s", errPool.Error())
 	} else {
 		dao.pool = pool
+		registerPoolMetrics(pool)
 	}
 
 	return dao, nil
@@ -39,6 +80,7 @@ func NewDao(ctx context.Context, url string) (Dao, error) {
 
 // CheckUser returns true if login and password match
 func (d *Dao) CheckUser(ctx context.Context, login, password string) (bool, error) {
+	defer observeDaoCall("CheckUser", time.Now())
 	if d == nil || d.pool == nil {
 		return false, errors.New("nil value")
 	}
@@ -61,33 +103,9 @@ func (d *Dao) CheckUser(ctx context.Context, login, password string) (bool, erro
 	return result, nil
 }
 
-// FindSecretForActiveUser returns the secret for an active user
-func (d *Dao) FindSecretForActiveUser(ctx context.Context, login string) (string, error) {
-	if d == nil || d.pool == nil {
-		return "", errors.New("nil value")
-	}
-
-	var rows pgx.Rows
-	if r, err := d.pool.Query(ctx, "select susers.find_secret_for_user($1)", login); err != nil {
-		return "", err
-	} else {
-		rows = r
-	}
-
-	defer rows.Close()
-
-	var result string
-	if !rows.Next() {
-		return result, nil
-	} else if err := rows.Scan(&result); err != nil {
-		return result, err
-	} else {
-		return result, nil
-	}
-}
-
 // ListUserDataAndSupervisedUsers provides all visible data and supervised errors
 func (d *Dao) ListUserDataAndSupervisedUsers(ctx context.Context, login string) ([]UserAuthsDTO, error) {
+	defer observeDaoCall("ListUserDataAndSupervisedUsers", time.Now())
 	if d == nil || d.pool == nil {
 		return nil, errors.New("nil value")
 	}
@@ -162,28 +180,47 @@ func (d *Dao) ListUserDataAndSupervisedUsers(ctx context.Context, login string)
 
 // UpsertUser changes user authentication if it exists, or insert user
 func (d *Dao) UpsertUser(ctx context.Context, creator, login, password string) error {
+	defer observeDaoCall("UpsertUser", time.Now())
 	if d == nil || d.pool == nil {
 		return errors.New("nil value")
 	}
 
-	_, errExec := d.pool.Exec(ctx, "call susers.upsert_user($1,$2,$3)", creator, login, password)
-	return errExec
+	entry := auditEntry{operation: AUDIT_USER_UPSERT, targetUser: login}
+	return d.execAudited(ctx, creator, entry, "call susers.upsert_user($1,$2,$3)", creator, login, password)
 }
 
 // CreateGraph returns the id of built graph, or an error.
 func (d *Dao) CreateGraph(ctx context.Context, creator, name, description string, metadata map[string][]string, sources []string) (string, error) {
+	defer observeDaoCall("CreateGraph", time.Now())
 	if d == nil || d.pool == nil {
 		return "", errors.New("nil value")
 	}
 
-	transaction, errTransaction := d.pool.Begin(ctx)
+	transaction, errTransaction := d.beginAudited(ctx, creator)
 	if errTransaction != nil {
+		return "", errTransaction
+	}
+
+	newId := uuid.NewString()
+	if err := createGraphInTransaction(ctx, transaction, creator, newId, name, description, metadata, sources); err != nil {
 		errRollback := transaction.Rollback(ctx)
-		return "", errors.Join(errTransaction, errRollback)
+		return "", errors.Join(err, errRollback)
+	} else if err := recordAudit(ctx, transaction, creator, auditEntry{operation: AUDIT_GRAPH_CREATE, graphId: newId}); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return "", errors.Join(err, errRollback)
 	}
 
+	errCommit := transaction.Commit(ctx)
+	return newId, errCommit
+}
+
+// createGraphInTransaction creates a graph within a transaction, caller deals with commit or rollback
+func createGraphInTransaction(
+	ctx context.Context, transaction pgx.Tx,
+	creator, newId, name, description string,
+	metadata map[string][]string, sources []string,
+) error {
 	var errExec error
-	newId := uuid.NewString()
 	if len(sources) != 0 {
 		_, errExec = transaction.Exec(ctx,
 			"call susers.create_graph_from_imports($1,$2,$3,$4,$5)",
@@ -196,60 +233,173 @@ func (d *Dao) CreateGraph(ctx context.Context, creator, name, description string
 	}
 
 	if errExec != nil {
-		errRollback := transaction.Rollback(ctx)
-		return "", errors.Join(errExec, errRollback)
+		return errExec
 	}
 
 	_, errExec = transaction.Exec(ctx, "call susers.clear_graph_metadata($1, $2)", creator, newId)
 	if errExec != nil {
-		errRollback := transaction.Rollback(ctx)
-		return "", errors.Join(errExec, errRollback)
+		return errExec
 	}
 
 	for key, values := range metadata {
 		_, errExec := transaction.Exec(ctx, "call susers.upsert_graph_metadata_entry($1, $2, $3, $4)", creator, newId, key, values)
 		if errExec != nil {
-			transaction.Rollback(ctx)
-			return "", errExec
+			return errExec
 		}
 	}
 
-	errCommit := transaction.Commit(ctx)
-	return newId, errCommit
+	return nil
+}
+
+// ForkGraph copies a graph into a new graph that user may modify, and returns its id and, for each copied element, the id of its copy.
+// Elements owned by the graph are copied, and elements of imported graphs too if flatten is set.
+// Otherwise, fork imports the same graphs.
+// Empty name or description means same value as the forked graph.
+// It runs in a single transaction
+func (d *Dao) ForkGraph(ctx context.Context, user string, graphId string, name, description string, flatten bool) (string, map[string]string, error) {
+	defer observeDaoCall("ForkGraph", time.Now())
+	if d == nil || d.pool == nil {
+		return "", nil, errors.New("nil value")
+	}
+
+	graph, errLoad := d.LoadGraphForUser(ctx, user, graphId)
+	if errLoad != nil {
+		return "", nil, errLoad
+	} else if graph.Id != graphId {
+		return "", nil, fmt.Errorf("no graph matching id %s", graphId)
+	}
+
+	var sources []string
+	if !flatten {
+		if imports, err := d.ListImportsForUser(ctx, user); err != nil {
+			return "", nil, err
+		} else {
+			sources = imports.DirectImports(graphId)
+		}
+	}
+
+	newId := uuid.NewString()
+	fork, copies, errFork := graph.Fork(newId, flatten)
+	if errFork != nil {
+		return "", nil, errFork
+	}
+
+	if len(name) != 0 {
+		fork.Name = name
+	}
+
+	if len(description) != 0 {
+		fork.Description = description
+	}
+
+	var copiedNodes []graphs.Node
+	for _, node := range fork.Nodes() {
+		if node.SourceGraph == newId {
+			copiedNodes = append(copiedNodes, node)
+		}
+	}
+
+	// entities first, relations may link them
+	slices.SortStableFunc(copiedNodes, func(a, b graphs.Node) int {
+		return compareEntitiesFirst(a.Value, b.Value)
+	})
+
+	parents := make(map[string]nodes.Element)
+	for _, node := range graph.Nodes() {
+		parents[node.Value.Id()] = node.Value
+	}
+
+	transaction, errTransaction := d.beginAudited(ctx, user)
+	if errTransaction != nil {
+		return "", nil, errTransaction
+	}
+
+	if err := createGraphInTransaction(ctx, transaction, user, newId, fork.Name, fork.Description, fork.Metadata, sources); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return "", nil, errors.Join(err, errRollback)
+	} else if err := recordAudit(ctx, transaction, user, auditEntry{
+		operation: AUDIT_GRAPH_FORK,
+		graphId:   newId,
+		changes:   map[string]AuditChangeDTO{"source": {After: map[string][]string{graphId: nil}}},
+	}); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return "", nil, errors.Join(err, errRollback)
+	}
+
+	for _, node := range copiedNodes {
+		if err := upsertElementInTransaction(ctx, transaction, user, newId, node.Value); err != nil {
+			errRollback := transaction.Rollback(ctx)
+			return "", nil, errors.Join(err, errRollback)
+		}
+	}
+
+	for _, node := range copiedNodes {
+		snapshot, errSnapshot := serializeElementSnapshot(parents[node.EquivalenceParent])
+		if errSnapshot != nil {
+			errRollback := transaction.Rollback(ctx)
+			return "", nil, errors.Join(errSnapshot, errRollback)
+		}
+
+		if _, err := transaction.Exec(ctx,
+			"call susers.add_equivalence_link($1, $2, $3)",
+			user, node.EquivalenceParent, node.Value.Id(),
+		); err != nil {
+			errRollback := transaction.Rollback(ctx)
+			return "", nil, errors.Join(err, errRollback)
+		} else if _, err := transaction.Exec(ctx,
+			"call sgraphs.upsert_equivalence_base($1, $2::jsonb)",
+			node.Value.Id(), snapshot,
+		); err != nil {
+			errRollback := transaction.Rollback(ctx)
+			return "", nil, errors.Join(err, errRollback)
+		}
+	}
+
+	if err := transaction.Commit(ctx); err != nil {
+		return "", nil, err
+	}
+
+	return newId, copies, nil
 }
 
 // UpsertMetadataForGraph clears metadata and forces new values
 func (d *Dao) UpsertMetadataForGraph(ctx context.Context, creator string, graphId string, metadata map[string][]string) error {
+	defer observeDaoCall("UpsertMetadataForGraph", time.Now())
 	if d == nil || d.pool == nil {
 		return errors.New("nil value")
 	}
 
-	transaction, errTransaction := d.pool.Begin(ctx)
+	transaction, errTransaction := d.beginAudited(ctx, creator)
 	if errTransaction != nil {
-		errRollback := transaction.Rollback(ctx)
-		return errors.Join(errTransaction, errRollback)
+		return errTransaction
 	}
 
 	_, errExec := transaction.Exec(ctx, "call susers.clear_graph_metadata($1, $2)", creator, graphId)
-	if errExec != nil || len(metadata) == 0 {
+	if errExec != nil {
 		errRollback := transaction.Rollback(ctx)
 		return errors.Join(errExec, errRollback)
 	}
 
 	for key, values := range metadata {
-		_, errExec := d.pool.Exec(ctx, "call susers.upsert_graph_metadata_entry($1, $2, $3, $4)", creator, graphId, key, values)
-		if errExec != nil || len(metadata) == 0 {
+		_, errExec := transaction.Exec(ctx, "call susers.upsert_graph_metadata_entry($1, $2, $3, $4)", creator, graphId, key, values)
+		if errExec != nil {
 			errRollback := transaction.Rollback(ctx)
 			return errors.Join(errExec, errRollback)
 		}
 	}
 
+	if err := recordAudit(ctx, transaction, creator, auditEntry{operation: AUDIT_GRAPH_METADATA, graphId: graphId}); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(err, errRollback)
+	}
+
 	errCommit := transaction.Commit(ctx)
 	return errCommit
 }
 
 // ListGraphsForUser returns the graphs an user has access to
 func (d *Dao) ListGraphsForUser(ctx context.Context, user string) ([]AuthGraphDTO, error) {
+	defer observeDaoCall("ListGraphsForUser", time.Now())
 	var result []AuthGraphDTO
 	if d == nil || d.pool == nil {
 		return result, errors.New("nil value")
@@ -294,44 +444,248 @@ func (d *Dao) ListGraphsForUser(ctx context.Context, user string) ([]AuthGraphDT
 		values[graphId] = currentData
 	}
 
+	if globalErr != nil {
+		return result, globalErr
+	}
+
+	// add the import DAG
+	imports, errImports := d.ListImportsForUser(ctx, user)
+	if errImports != nil {
+		return result, errImports
+	}
+
 	for _, value := range values {
+		value.Imports = imports.DirectImports(value.Id)
 		result = append(result, value)
 	}
 
-	return result, globalErr
+	return result, nil
+}
+
+// ListImportsForUser returns the imports between graphs an user has access to
+func (d *Dao) ListImportsForUser(ctx context.Context, user string) (graphs.ImportsDAG, error) {
+	defer observeDaoCall("ListImportsForUser", time.Now())
+	result := graphs.NewImportsDAG()
+	if d == nil || d.pool == nil {
+		return result, errors.New("nil value")
+	}
+
+	rows, errLoad := d.pool.Query(ctx, "select * from susers.list_graph_imports_for_user($1)", user)
+	if errLoad != nil {
+		return result, errLoad
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var graphId, importedId string
+		if err := rows.Scan(&graphId, &importedId); err != nil {
+			return result, err
+		}
+
+		result.AddImport(graphId, importedId)
+	}
+
+	return result, rows.Err()
 }
 
-// DeleteElement deletes an element from an user. May raise error on auth
+// DeleteElement moves an element to the trash. May raise error on auth
 func (d *Dao) DeleteElement(ctx context.Context, user, elementId string) error {
+	defer observeDaoCall("DeleteElement", time.Now())
 	if d == nil || d.pool == nil {
 		return errors.New("nil value")
 	}
 
-	_, errExec := d.pool.Exec(ctx, "call susers.delete_element($1, $2)", user, elementId)
-	return errExec
+	transaction, errTransaction := d.beginAudited(ctx, user)
+	if errTransaction != nil {
+		return errTransaction
+	}
+
+	previous, errPrevious := loadElement(ctx, transaction, user, elementId, false)
+	if errPrevious != nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(errPrevious, errRollback)
+	} else if previous == nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(fmt.Errorf("no element matching id %s", elementId), errRollback)
+	}
+
+	if _, err := transaction.Exec(ctx, "call susers.delete_element($1, $2)", user, elementId); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(err, errRollback)
+	} else if err := recordElementAudit(ctx, transaction, user, AUDIT_ELEMENT_DELETE, previous, nil); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(err, errRollback)
+	}
+
+	return transaction.Commit(ctx)
 }
 
-// DeleteGraph deletes an element from an user. May raise error on auth
+// DeleteGraph moves a graph to the trash. May raise error on auth
 func (d *Dao) DeleteGraph(ctx context.Context, user, graphId string) error {
+	defer observeDaoCall("DeleteGraph", time.Now())
+	if d == nil || d.pool == nil {
+		return errors.New("nil value")
+	}
+
+	entry := auditEntry{operation: AUDIT_GRAPH_DELETE, graphId: graphId}
+	return d.execAudited(ctx, user, entry, "call susers.delete_graph($1, $2)", user, graphId)
+}
+
+// ListTrashForUser returns the elements of a graph in trash, and the graph itself if it is in trash
+func (d *Dao) ListTrashForUser(ctx context.Context, user, graphId string) ([]TrashItemDTO, error) {
+	defer observeDaoCall("ListTrashForUser", time.Now())
+	if d == nil || d.pool == nil {
+		return nil, errors.New("nil value")
+	}
+
+	rows, errLoad := d.pool.Query(ctx, "select * from susers.list_trash_for_user($1, $2) order by 3, 1", user, graphId)
+	if errLoad != nil {
+		return nil, errLoad
+	}
+
+	defer rows.Close()
+	result := make([]TrashItemDTO, 0)
+	for rows.Next() {
+		var id string
+		var elementType *int
+		var deletedAt time.Time
+		if err := rows.Scan(&id, &elementType, &deletedAt); err != nil {
+			return nil, err
+		}
+
+		item := TrashItemDTO{Id: id, Kind: "entity", DeletedAt: deletedAt.Format(DATE_SERDE_FORMAT)}
+		if elementType == nil {
+			item.Kind = "graph"
+		} else if *elementType == 2 {
+			item.Kind = "relation"
+		}
+
+		result = append(result, item)
+	}
+
+	return result, rows.Err()
+}
+
+// RestoreElement restores an element from the trash.
+// It fails if its graph or, for a relation, its operands are in trash
+func (d *Dao) RestoreElement(ctx context.Context, user, elementId string) error {
+	defer observeDaoCall("RestoreElement", time.Now())
+	if d == nil || d.pool == nil {
+		return errors.New("nil value")
+	}
+
+	transaction, errTransaction := d.beginAudited(ctx, user)
+	if errTransaction != nil {
+		return errTransaction
+	}
+
+	if _, err := transaction.Exec(ctx, "call susers.restore_element($1, $2)", user, elementId); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(err, errRollback)
+	}
+
+	restored, errRestored := loadElement(ctx, transaction, user, elementId, false)
+	if errRestored != nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(errRestored, errRollback)
+	} else if restored == nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(fmt.Errorf("no element matching id %s", elementId), errRollback)
+	} else if err := recordElementAudit(ctx, transaction, user, AUDIT_ELEMENT_RESTORE, nil, restored); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(err, errRollback)
+	}
+
+	return transaction.Commit(ctx)
+}
+
+// RestoreGraph restores a graph from the trash
+func (d *Dao) RestoreGraph(ctx context.Context, user, graphId string) error {
+	defer observeDaoCall("RestoreGraph", time.Now())
 	if d == nil || d.pool == nil {
 		return errors.New("nil value")
 	}
 
-	_, errExec := d.pool.Exec(ctx, "call susers.delete_graph($1, $2)", user, graphId)
-	return errExec
+	entry := auditEntry{operation: AUDIT_GRAPH_RESTORE, graphId: graphId}
+	return d.execAudited(ctx, user, entry, "call susers.restore_graph($1, $2)", user, graphId)
+}
+
+// PurgeTrash deletes graphs and elements in trash for longer than retention.
+// It returns the number of deleted elements and graphs
+func (d *Dao) PurgeTrash(ctx context.Context, retention time.Duration) (int64, int64, error) {
+	defer observeDaoCall("PurgeTrash", time.Now())
+	if d == nil || d.pool == nil {
+		return 0, 0, errors.New("nil value")
+	}
+
+	transaction, errTransaction := d.beginAudited(ctx, AUDIT_SYSTEM_ACTOR)
+	if errTransaction != nil {
+		return 0, 0, errTransaction
+	}
+
+	var purgedElements, purgedGraphs int64
+	interval := fmt.Sprintf("%d seconds", int64(retention.Seconds()))
+	row := transaction.QueryRow(ctx, "select * from susers.purge_trash($1::interval)", interval)
+	if err := row.Scan(&purgedElements, &purgedGraphs); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return 0, 0, errors.Join(err, errRollback)
+	} else if purgedElements+purgedGraphs == 0 {
+		// nothing to audit
+		return 0, 0, transaction.Commit(ctx)
+	}
+
+	entry := auditEntry{operation: AUDIT_TRASH_PURGE}
+	if err := recordAudit(ctx, transaction, AUDIT_SYSTEM_ACTOR, entry); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return 0, 0, errors.Join(err, errRollback)
+	}
+
+	if err := transaction.Commit(ctx); err != nil {
+		return 0, 0, err
+	}
+
+	return purgedElements, purgedGraphs, nil
 }
 
-// LoadElementForUser returns an element, if any, matching that id
+// LoadElementForUser returns an element, if any, matching that id. Elements in trash are ignored
 func (d *Dao) LoadElementForUser(ctx context.Context, user string, elementId string) (nodes.Element, error) {
+	defer observeDaoCall("LoadElementForUser", time.Now())
+	if d == nil || d.pool == nil {
+		return nil, errors.New("nil value")
+	}
+
+	return d.loadElementForUser(ctx, user, elementId, false)
+}
+
+// LoadElementWithTrashForUser returns an element, if any, matching that id, even if it is in trash
+func (d *Dao) LoadElementWithTrashForUser(ctx context.Context, user string, elementId string) (nodes.Element, error) {
+	defer observeDaoCall("LoadElementWithTrashForUser", time.Now())
 	if d == nil || d.pool == nil {
 		return nil, errors.New("nil value")
 	}
 
-	rows, errLoad := d.pool.Query(ctx, "select * from susers.load_element_by_id($1, $2)", user, elementId)
+	return d.loadElementForUser(ctx, user, elementId, true)
+}
+
+// loadElementForUser returns an element, if any, matching that id. Elements in trash are loaded if withTrash is set
+func (d *Dao) loadElementForUser(ctx context.Context, user string, elementId string, withTrash bool) (nodes.Element, error) {
+	return loadElement(ctx, d.pool, user, elementId, withTrash)
+}
+
+// querier runs queries, either from the pool or within a transaction
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// loadElement returns an element, if any, matching that id. Elements in trash are loaded if withTrash is set
+func loadElement(ctx context.Context, source querier, user string, elementId string, withTrash bool) (nodes.Element, error) {
+	rows, errLoad := source.Query(ctx, "select * from susers.load_element_by_id($1, $2, $3)", user, elementId, withTrash)
 	if errLoad != nil {
 		return nil, errLoad
 	}
 
+	defer rows.Close()
+
 	var entity nodes.FormalInstance
 	var relation nodes.FormalRelation
 	var elementType = -1
@@ -377,7 +731,7 @@ func (d *Dao) LoadElementForUser(ctx context.Context, user string, elementId str
 		var attributeValues []string
 		var attributePeriods []nodes.Period
 
-		if elementType == 1 {
+		if elementType == 1 && rawValues[6] != nil {
 			attributeName = rawValues[6].(string)
 			attributeValues = mapAnyToStringSlice(rawValues[7])
 			rawPeriods := mapAnyToStringSlice(rawValues[8])
@@ -435,6 +789,9 @@ func (d *Dao) LoadElementForUser(ctx context.Context, user string, elementId str
 			if relation == nil {
 				relationValue := nodes.NewRelationWithId(id, traits)
 				relation = &relationValue
+				if err := relation.SetActivePeriod(activity); err != nil {
+					return nil, errors.Join(globalErr, err)
+				}
 			}
 
 			for index := 0; index < len(roleValues); index++ {
@@ -462,8 +819,44 @@ func (d *Dao) LoadElementForUser(ctx context.Context, user string, elementId str
 	}
 }
 
+// LoadLineageForUser returns the equivalence lineage of an element, restricted to graphs user may see
+func (d *Dao) LoadLineageForUser(ctx context.Context, user string, elementId string) (graphs.Lineage, error) {
+	defer observeDaoCall("LoadLineageForUser", time.Now())
+	var empty graphs.Lineage
+	if d == nil || d.pool == nil {
+		return empty, errors.New("nil value")
+	}
+
+	rows, errLoad := d.pool.Query(ctx, "select * from susers.element_lineage_for_user($1, $2)", user, elementId)
+	if errLoad != nil {
+		return empty, errLoad
+	}
+
+	defer rows.Close()
+
+	var values []graphs.LineageNode
+	for rows.Next() {
+		var value graphs.LineageNode
+		var parentId *string
+		if err := rows.Scan(&value.ElementId, &value.GraphId, &parentId, &value.Depth); err != nil {
+			return empty, err
+		} else if parentId != nil {
+			value.ParentId = *parentId
+		}
+
+		values = append(values, value)
+	}
+
+	if err := rows.Err(); err != nil {
+		return empty, err
+	}
+
+	return graphs.NewLineage(elementId, values)
+}
+
 // LoadGraphForUser loads a graph and dependencies given base id for a given user
 func (d *Dao) LoadGraphForUser(ctx context.Context, user string, graphId string) (graphs.Graph, error) {
+	defer observeDaoCall("LoadGraphForUser", time.Now())
 	var empty graphs.Graph
 	if d == nil || d.pool == nil {
 		return empty, errors.New("nil value")
@@ -472,17 +865,35 @@ func (d *Dao) LoadGraphForUser(ctx context.Context, user string, graphId string)
 	return d.LoadGraphForUserDuringPeriod(ctx, user, graphId, nodes.NewFullPeriod())
 }
 
+// LoadGraphWithTrashForUser loads graph, including elements in trash
+func (d *Dao) LoadGraphWithTrashForUser(ctx context.Context, user string, graphId string) (graphs.Graph, error) {
+	defer observeDaoCall("LoadGraphWithTrashForUser", time.Now())
+	var empty graphs.Graph
+	if d == nil || d.pool == nil {
+		return empty, errors.New("nil value")
+	}
+
+	return d.loadGraphForUser(ctx, user, graphId, nodes.NewFullPeriod(), true)
+}
+
 // LoadGraphForUserDuringPeriod loads graph during a given period
 func (d *Dao) LoadGraphForUserDuringPeriod(ctx context.Context, user string, graphId string, period nodes.Period) (graphs.Graph, error) {
+	defer observeDaoCall("LoadGraphForUserDuringPeriod", time.Now())
 	var empty graphs.Graph
 	if d == nil || d.pool == nil {
 		return empty, errors.New("nil value")
 	}
 
+	return d.loadGraphForUser(ctx, user, graphId, period, false)
+}
+
+// loadGraphForUser loads graph during a given period. Graphs and elements in trash are loaded if withTrash is set
+func (d *Dao) loadGraphForUser(ctx context.Context, user string, graphId string, period nodes.Period, withTrash bool) (graphs.Graph, error) {
+	var empty graphs.Graph
 	result := graphs.NewEmptyGraph()
 
 	// STEP ONE: LOAD METADATA
-	rows, errMetadata := d.pool.Query(ctx, "select * from susers.load_graph_metadata($1, $2)", user, graphId)
+	rows, errMetadata := d.pool.Query(ctx, "select * from susers.load_graph_metadata($1, $2, $3)", user, graphId, withTrash)
 	if errMetadata != nil {
 		return empty, errMetadata
 	}
@@ -529,8 +940,8 @@ func (d *Dao) LoadGraphForUserDuringPeriod(ctx context.Context, user string, gra
 	periodValue := serializePeriod(period)
 	// globalErr is nil, proceed to entities
 	// STEP TWO: ENTITIES
-	const queryEntities = "select * from susers.transitive_load_entities_in_graph($1, $2, $3) order by element_id, attribute_key asc"
-	rowsEntities, errRowsEntities := d.pool.Query(ctx, queryEntities, user, graphId, periodValue)
+	const queryEntities = "select * from susers.transitive_load_entities_in_graph($1, $2, $3, $4) order by element_id, attribute_key asc"
+	rowsEntities, errRowsEntities := d.pool.Query(ctx, queryEntities, user, graphId, periodValue, withTrash)
 	if errRowsEntities != nil {
 		return empty, errRowsEntities
 	}
@@ -542,8 +953,8 @@ func (d *Dao) LoadGraphForUserDuringPeriod(ctx context.Context, user string, gra
 
 	// globalErr is nil, proceed to relations
 	// STEP THREE: RELATIONS
-	const queryRelations = "select * from susers.transitive_load_relations_in_graph($1, $2, $3) order by element_id asc"
-	rowsRelations, errRowsRelations := d.pool.Query(ctx, queryRelations, user, graphId, periodValue)
+	const queryRelations = "select * from susers.transitive_load_relations_in_graph($1, $2, $3, $4) order by element_id asc"
+	rowsRelations, errRowsRelations := d.pool.Query(ctx, queryRelations, user, graphId, periodValue, withTrash)
 	if errRowsRelations != nil {
 		return empty, errRowsRelations
 	}
@@ -558,6 +969,7 @@ func (d *Dao) LoadGraphForUserDuringPeriod(ctx context.Context, user string, gra
 
 // FindNeighborsOfMatchingEntities
 func (d *Dao) FindNeighborsOfMatchingEntities(ctx context.Context, user string, period nodes.Period, trait string, parameters map[string]string) (graphs.Graph, error) {
+	defer observeDaoCall("FindNeighborsOfMatchingEntities", time.Now())
 	var empty graphs.Graph
 	if d == nil || d.pool == nil {
 		return empty, errors.New("nil value")
@@ -616,17 +1028,35 @@ func (d *Dao) FindNeighborsOfMatchingEntities(ctx context.Context, user string,
 
 // UpsertElement adds an element to a given graph
 func (d *Dao) UpsertElement(ctx context.Context, user string, graphId string, element nodes.Element) error {
+	defer observeDaoCall("UpsertElement", time.Now())
 	if d == nil || d.pool == nil {
 		return errors.New("nil value")
 	} else if element == nil {
 		return nil
 	}
 
-	transaction, errTransaction := d.pool.Begin(ctx)
+	transaction, errTransaction := d.beginAudited(ctx, user)
 	if errTransaction != nil {
 		return errTransaction
 	}
 
+	if err := upsertElementInTransaction(ctx, transaction, user, graphId, element); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(err, errRollback)
+	}
+
+	errCommit := transaction.Commit(ctx)
+	return errCommit
+}
+
+// upsertElementInTransaction saves an element within a transaction, caller deals with commit or rollback
+func upsertElementInTransaction(ctx context.Context, transaction pgx.Tx, user string, graphId string, element nodes.Element) error {
+	// previous value, if any, for the audit log
+	previous, errPrevious := loadElement(ctx, transaction, user, element.Id(), true)
+	if errPrevious != nil {
+		return errPrevious
+	}
+
 	var elementType int
 	var entity nodes.FormalInstance
 	var relation nodes.FormalRelation
@@ -647,8 +1077,7 @@ func (d *Dao) UpsertElement(ctx context.Context, user string, graphId string, el
 	)
 
 	if errUpsertElement != nil {
-		errRollback := transaction.Rollback(ctx)
-		return errors.Join(errUpsertElement, errRollback)
+		return errUpsertElement
 	}
 
 	// all checks performed before, so direct access to this function
@@ -658,8 +1087,7 @@ func (d *Dao) UpsertElement(ctx context.Context, user string, graphId string, el
 	)
 
 	if errClearElement != nil {
-		errRollback := transaction.Rollback(ctx)
-		return errors.Join(errClearElement, errRollback)
+		return errClearElement
 	}
 
 	var globalErr error
@@ -722,58 +1150,729 @@ func (d *Dao) UpsertElement(ctx context.Context, user string, graphId string, el
 	}
 
 	if globalErr != nil {
-		errRollback := transaction.Rollback(ctx)
-		if errRollback != nil {
-			globalErr = errors.Join(globalErr, errRollback)
-		}
-
 		return globalErr
 	}
 
-	errCommit := transaction.Commit(ctx)
-	return errCommit
+	return recordElementAudit(ctx, transaction, user, AUDIT_ELEMENT_UPSERT, previous, element)
 }
 
-// CreateEquivalentElement copies an element to a given graph.
-// NewElementId is a parameter to return to the caller
-func (d *Dao) CreateEquivalentElement(ctx context.Context, user string, elementSourceId, graphId, newElementId string) error {
+// MergeElements merges sources entities into target entity and returns the id of the merge.
+// Relations linking sources then link target, and sources are moved to trash.
+// Target and sources are kept as they were before the merge, to undo it
+func (d *Dao) MergeElements(ctx context.Context, user string, targetId string, sourceIds []string) (string, error) {
+	defer observeDaoCall("MergeElements", time.Now())
 	if d == nil || d.pool == nil {
-		return errors.New("nil value")
+		return "", errors.New("nil value")
+	} else if len(sourceIds) == 0 {
+		return "", errors.New("no source to merge")
 	}
 
-	_, errUpsertElement := d.pool.Exec(
-		ctx,
-		"call susers.create_equivalent_element_into_graph($1, $2, $3, $4)",
-		user, elementSourceId, graphId, newElementId,
-	)
+	// same source twice would be merged twice
+	sourceIds = slices.Compact(slices.Sorted(slices.Values(sourceIds)))
 
-	return errUpsertElement
-}
+	transaction, errTransaction := d.beginAudited(ctx, user)
+	if errTransaction != nil {
+		return "", errTransaction
+	}
+
+	// elements are read in the transaction, so that they do not change before the merge
+	target, targetSnapshot, sourcesSnapshots, errPrepare := prepareMerge(ctx, transaction, user, targetId, sourceIds)
+	if errPrepare != nil {
+		errRollback := transaction.Rollback(ctx)
+		return "", errors.Join(errPrepare, errRollback)
+	}
+
+	mergeId := uuid.NewString()
+	var targetGraph string
+	row := transaction.QueryRow(ctx,
+		"select susers.merge_elements($1, $2, $3, $4, $5::jsonb, $6::jsonb[])",
+		user, mergeId, targetId, sourceIds, targetSnapshot, sourcesSnapshots,
+	)
+
+	if err := row.Scan(&targetGraph); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return "", errors.Join(err, errRollback)
+	} else if err := upsertElementInTransaction(ctx, transaction, user, targetGraph, target); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return "", errors.Join(err, errRollback)
+	} else if err := recordAudit(ctx, transaction, user, auditEntry{
+		operation:  AUDIT_ELEMENT_MERGE,
+		graphId:    targetGraph,
+		elementIds: append([]string{targetId}, sourceIds...),
+	}); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return "", errors.Join(err, errRollback)
+	}
+
+	errCommit := transaction.Commit(ctx)
+	return mergeId, errCommit
+}
+
+// prepareMerge loads target and sources entities, it tests that user may see them.
+// It returns the merged target, and the snapshots of target and sources before the merge
+func prepareMerge(ctx context.Context, transaction pgx.Tx, user string, targetId string, sourceIds []string) (nodes.FormalInstance, string, []string, error) {
+	var target nodes.FormalInstance
+	sources := make([]nodes.FormalInstance, 0, len(sourceIds))
+	for index, elementId := range append([]string{targetId}, sourceIds...) {
+		element, errLoad := loadElement(ctx, transaction, user, elementId, false)
+		if errLoad != nil {
+			return nil, "", nil, errLoad
+		} else if element == nil {
+			return nil, "", nil, fmt.Errorf("no element matching id %s", elementId)
+		} else if instance, ok := element.(nodes.FormalInstance); !ok {
+			return nil, "", nil, fmt.Errorf("element %s is not an entity", elementId)
+		} else if index == 0 {
+			target = instance
+		} else {
+			sources = append(sources, instance)
+		}
+	}
+
+	// snapshots before the merge
+	targetSnapshot, errTarget := serializeElementSnapshot(target)
+	if errTarget != nil {
+		return nil, "", nil, errTarget
+	}
+
+	sourcesSnapshots := make([]string, len(sources))
+	for index, source := range sources {
+		if snapshot, err := serializeElementSnapshot(source); err != nil {
+			return nil, "", nil, err
+		} else {
+			sourcesSnapshots[index] = snapshot
+		}
+	}
+
+	if err := nodes.MergeEntities(target, sources...); err != nil {
+		return nil, "", nil, err
+	}
+
+	return target, targetSnapshot, sourcesSnapshots, nil
+}
+
+// UndoMerge restores target and sources of a merge as they were before the merge.
+// Relations linking target instead of sources link sources again, it fails if a link changed since
+func (d *Dao) UndoMerge(ctx context.Context, user string, mergeId string) error {
+	defer observeDaoCall("UndoMerge", time.Now())
+	if d == nil || d.pool == nil {
+		return errors.New("nil value")
+	}
+
+	transaction, errTransaction := d.beginAudited(ctx, user)
+	if errTransaction != nil {
+		return errTransaction
+	}
+
+	target, targetGraph, sources, sourcesGraphs, errLoad := loadMerge(ctx, transaction, user, mergeId)
+	if errLoad != nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(errLoad, errRollback)
+	}
+
+	// sources first, so that links may be restored
+	for sourceId, source := range sources {
+		if err := upsertElementInTransaction(ctx, transaction, user, sourcesGraphs[sourceId], source); err != nil {
+			errRollback := transaction.Rollback(ctx)
+			return errors.Join(err, errRollback)
+		}
+	}
+
+	if _, err := transaction.Exec(ctx, "call susers.undo_merge($1, $2)", user, mergeId); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(err, errRollback)
+	} else if err := upsertElementInTransaction(ctx, transaction, user, targetGraph, target); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(err, errRollback)
+	} else if err := recordAudit(ctx, transaction, user, auditEntry{
+		operation:  AUDIT_ELEMENT_MERGE_UNDO,
+		graphId:    targetGraph,
+		elementIds: append([]string{target.Id()}, slices.Sorted(maps.Keys(sources))...),
+	}); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(err, errRollback)
+	}
+
+	errCommit := transaction.Commit(ctx)
+	return errCommit
+}
+
+// loadMerge returns target and sources of a merge, as they were before the merge, with their graphs
+func loadMerge(ctx context.Context, transaction pgx.Tx, user string, mergeId string) (nodes.Element, string, map[string]nodes.Element, map[string]string, error) {
+	rows, errLoad := transaction.Query(ctx, "select * from susers.load_merge_for_user($1, $2)", user, mergeId)
+	if errLoad != nil {
+		return nil, "", nil, nil, errLoad
+	}
+
+	var target nodes.Element
+	var targetGraph string
+	sources := make(map[string]nodes.Element)
+	sourcesGraphs := make(map[string]string)
+	var globalErr error
+	for rows.Next() {
+		var elementId, graphId, snapshot string
+		var isTarget bool
+		if err := rows.Scan(&elementId, &graphId, &snapshot, &isTarget); err != nil {
+			globalErr = errors.Join(globalErr, err)
+			continue
+		}
+
+		element, errElement := deserializeElementSnapshot(snapshot)
+		if errElement != nil {
+			globalErr = errors.Join(globalErr, errElement)
+		} else if isTarget {
+			target = element
+			targetGraph = graphId
+		} else {
+			sources[elementId] = element
+			sourcesGraphs[elementId] = graphId
+		}
+	}
+
+	rows.Close()
+	if globalErr != nil {
+		return nil, "", nil, nil, globalErr
+	} else if err := rows.Err(); err != nil {
+		return nil, "", nil, nil, err
+	} else if target == nil {
+		return nil, "", nil, nil, fmt.Errorf("no target for merge %s", mergeId)
+	}
+
+	return target, targetGraph, sources, sourcesGraphs, nil
+}
+
+// SplitElement splits an entity at moment: entity remains before moment, and a new entity in the same graph continues it since moment.
+// Relations linking entity link the new entity since moment, and new entity is an equivalence child of entity in lineage.
+// It returns the id of the new entity and the ids of the rewired relations
+func (d *Dao) SplitElement(ctx context.Context, user string, elementId string, moment time.Time) (string, []string, error) {
+	defer observeDaoCall("SplitElement", time.Now())
+	if d == nil || d.pool == nil {
+		return "", nil, errors.New("nil value")
+	}
+
+	transaction, errTransaction := d.beginAudited(ctx, user)
+	if errTransaction != nil {
+		return "", nil, errTransaction
+	}
+
+	// elements are read and locked in the transaction, so that they do not change before the split
+	newId := uuid.NewString()
+	entity, newEntity, elementGraph, relationsGraphs, rewired, errPrepare := prepareSplit(ctx, transaction, user, elementId, newId, moment)
+	if errPrepare != nil {
+		errRollback := transaction.Rollback(ctx)
+		return "", nil, errors.Join(errPrepare, errRollback)
+	}
+
+	rewiredIds := make([]string, 0, len(rewired))
+	for _, relation := range rewired {
+		rewiredIds = append(rewiredIds, relation.Id())
+	}
+
+	// new entity should exist before relations link it
+	for _, current := range []nodes.Element{entity, newEntity} {
+		if err := upsertElementInTransaction(ctx, transaction, user, elementGraph, current); err != nil {
+			errRollback := transaction.Rollback(ctx)
+			return "", nil, errors.Join(err, errRollback)
+		}
+	}
+
+	// split entity keeps the original as lineage parent
+	if _, err := transaction.Exec(ctx,
+		"call susers.add_equivalence_link($1, $2, $3)",
+		user, elementId, newId,
+	); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return "", nil, errors.Join(err, errRollback)
+	}
+
+	for _, relation := range rewired {
+		if err := upsertElementInTransaction(ctx, transaction, user, relationsGraphs[relation.Id()], relation); err != nil {
+			errRollback := transaction.Rollback(ctx)
+			return "", nil, errors.Join(err, errRollback)
+		}
+	}
+
+	if err := recordAudit(ctx, transaction, user, auditEntry{
+		operation:  AUDIT_ELEMENT_SPLIT,
+		graphId:    elementGraph,
+		elementIds: append([]string{elementId, newId}, rewiredIds...),
+	}); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return "", nil, errors.Join(err, errRollback)
+	}
+
+	if err := transaction.Commit(ctx); err != nil {
+		return "", nil, err
+	}
+
+	return newId, rewiredIds, nil
+}
+
+// prepareSplit locks and loads the entity to split and the relations linking it, it tests that user may modify them.
+// It returns entity before moment, new entity since moment, graph of the entity, graphs of relations and the rewired relations
+func prepareSplit(ctx context.Context, transaction pgx.Tx, user string, elementId string, newId string, moment time.Time) (
+	nodes.FormalInstance, nodes.FormalInstance, string, map[string]string, []nodes.FormalRelation, error,
+) {
+	rows, errLoad := transaction.Query(ctx, "select * from susers.relations_to_split($1, $2)", user, elementId)
+	if errLoad != nil {
+		return nil, nil, "", nil, nil, errLoad
+	}
+
+	var elementGraph string
+	relationsGraphs := make(map[string]string)
+	var globalErr error
+	for rows.Next() {
+		var id, graphId string
+		if err := rows.Scan(&id, &graphId); err != nil {
+			globalErr = errors.Join(globalErr, err)
+		} else if id == elementId {
+			elementGraph = graphId
+		} else {
+			relationsGraphs[id] = graphId
+		}
+	}
+
+	rows.Close()
+	if globalErr != nil {
+		return nil, nil, "", nil, nil, globalErr
+	} else if err := rows.Err(); err != nil {
+		return nil, nil, "", nil, nil, err
+	}
+
+	element, errElement := loadElement(ctx, transaction, user, elementId, false)
+	if errElement != nil {
+		return nil, nil, "", nil, nil, errElement
+	} else if element == nil {
+		return nil, nil, "", nil, nil, fmt.Errorf("no element matching id %s", elementId)
+	}
+
+	entity, ok := element.(nodes.FormalInstance)
+	if !ok {
+		return nil, nil, "", nil, nil, fmt.Errorf("element %s is not an entity", elementId)
+	}
+
+	newEntity, errSplit := nodes.SplitEntity(entity, moment, newId)
+	if errSplit != nil {
+		return nil, nil, "", nil, nil, errSplit
+	}
+
+	relationIds := slices.Sorted(maps.Keys(relationsGraphs))
+	rewired := make([]nodes.FormalRelation, 0, len(relationIds))
+	for _, relationId := range relationIds {
+		loaded, errRelation := loadElement(ctx, transaction, user, relationId, false)
+		if errRelation != nil {
+			return nil, nil, "", nil, nil, errRelation
+		} else if relation, ok := loaded.(nodes.FormalRelation); !ok {
+			return nil, nil, "", nil, nil, fmt.Errorf("element %s is not a relation", relationId)
+		} else if changed, err := nodes.SplitOperand(relation, elementId, newId, moment); err != nil {
+			return nil, nil, "", nil, nil, err
+		} else if changed {
+			rewired = append(rewired, relation)
+		}
+	}
+
+	return entity, newEntity, elementGraph, relationsGraphs, rewired, nil
+}
+
+// deserializeElementSnapshot returns the element from its json value
+func deserializeElementSnapshot(snapshot string) (nodes.Element, error) {
+	var dto ElementDTO
+	if err := json.Unmarshal([]byte(snapshot), &dto); err != nil {
+		return nil, err
+	}
+
+	return DeserializeElement(dto)
+}
+
+// serializeElementSnapshot returns the json value of the element
+func serializeElementSnapshot(element nodes.Element) (string, error) {
+	if dto, err := SerializeElement(element); err != nil {
+		return "", err
+	} else if value, err := json.Marshal(dto); err != nil {
+		return "", err
+	} else {
+		return string(value), nil
+	}
+}
+
+// CreateEquivalentElement copies an element to a given graph.
+// NewElementId is a parameter to return to the caller.
+// Source is kept as the base of the copy for next synchronisations
+func (d *Dao) CreateEquivalentElement(ctx context.Context, user string, elementSourceId, graphId, newElementId string) error {
+	defer observeDaoCall("CreateEquivalentElement", time.Now())
+	if d == nil || d.pool == nil {
+		return errors.New("nil value")
+	}
+
+	source, errSource := d.LoadElementForUser(ctx, user, elementSourceId)
+	if errSource != nil {
+		return errSource
+	} else if source == nil {
+		return fmt.Errorf("no element matching id %s", elementSourceId)
+	}
+
+	snapshot, errSnapshot := serializeElementSnapshot(source)
+	if errSnapshot != nil {
+		return errSnapshot
+	}
+
+	transaction, errTransaction := d.beginAudited(ctx, user)
+	if errTransaction != nil {
+		return errTransaction
+	}
+
+	if _, err := transaction.Exec(
+		ctx,
+		"call susers.create_equivalent_element_into_graph($1, $2, $3, $4)",
+		user, elementSourceId, graphId, newElementId,
+	); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(err, errRollback)
+	}
+
+	// all checks performed before, so direct access to this procedure
+	if _, err := transaction.Exec(ctx, "call sgraphs.upsert_equivalence_base($1, $2::jsonb)", newElementId, snapshot); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(err, errRollback)
+	}
+
+	if copied, err := loadElement(ctx, transaction, user, newElementId, false); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(err, errRollback)
+	} else if copied == nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(fmt.Errorf("no copy matching id %s", newElementId), errRollback)
+	} else if err := recordElementAudit(ctx, transaction, user, AUDIT_ELEMENT_COPY, nil, copied); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(err, errRollback)
+	}
+
+	errCommit := transaction.Commit(ctx)
+	return errCommit
+}
+
+// equivalenceData contains a copy, its equivalence parent, and the parent at last synchronisation (if any)
+type equivalenceData struct {
+	child       nodes.Element
+	childGraph  string
+	parent      nodes.Element
+	parentGraph string
+	base        nodes.Element
+}
+
+// loadEquivalenceForUser loads a copy and its equivalence parent
+func (d *Dao) loadEquivalenceForUser(ctx context.Context, user string, elementId string) (equivalenceData, error) {
+	var result equivalenceData
+	var parentId string
+	var baseSnapshot *string
+	row := d.pool.QueryRow(ctx, "select * from susers.equivalence_parent_for_user($1, $2)", user, elementId)
+	if err := row.Scan(&result.childGraph, &parentId, &result.parentGraph, &baseSnapshot); err != nil {
+		return result, err
+	}
+
+	if baseSnapshot != nil {
+		if base, err := deserializeElementSnapshot(*baseSnapshot); err != nil {
+			return result, err
+		} else {
+			result.base = base
+		}
+	}
+
+	for _, id := range []string{elementId, parentId} {
+		element, errLoad := d.LoadElementForUser(ctx, user, id)
+		if errLoad != nil {
+			return result, errLoad
+		} else if element == nil {
+			return result, fmt.Errorf("no element matching id %s", id)
+		} else if id == elementId {
+			result.child = element
+		} else {
+			result.parent = element
+		}
+	}
+
+	return result, nil
+}
+
+// EquivalenceStatus returns the equivalence parent of a copy and the diverging parts between them
+func (d *Dao) EquivalenceStatus(ctx context.Context, user string, elementId string) (string, []nodes.SyncDifference, error) {
+	defer observeDaoCall("EquivalenceStatus", time.Now())
+	if d == nil || d.pool == nil {
+		return "", nil, errors.New("nil value")
+	}
+
+	data, errLoad := d.loadEquivalenceForUser(ctx, user, elementId)
+	if errLoad != nil {
+		return "", nil, errLoad
+	}
+
+	differences, errCompare := nodes.CompareEquivalentElements(data.base, data.parent, data.child)
+	return data.parent.Id(), differences, errCompare
+}
+
+// PullEquivalence updates a copy with the changes of its equivalence parent, and returns the conflicting parts.
+// If force is set, parent wins on conflicts
+func (d *Dao) PullEquivalence(ctx context.Context, user string, elementId string, force bool) ([]string, error) {
+	defer observeDaoCall("PullEquivalence", time.Now())
+	if d == nil || d.pool == nil {
+		return nil, errors.New("nil value")
+	}
+
+	data, errLoad := d.loadEquivalenceForUser(ctx, user, elementId)
+	if errLoad != nil {
+		return nil, errLoad
+	}
+
+	newBase, conflicts, errPull := nodes.PullEquivalentElement(data.base, data.parent, data.child, force)
+	if errPull != nil {
+		return nil, errPull
+	}
+
+	return conflicts, d.saveSynchronisation(ctx, user, AUDIT_EQUIVALENCE_PULL, elementId, data.childGraph, data.child, newBase)
+}
+
+// PushEquivalence updates the equivalence parent of a copy with the changes of the copy, and returns the conflicting parts.
+// If force is set, copy wins on conflicts
+func (d *Dao) PushEquivalence(ctx context.Context, user string, elementId string, force bool) ([]string, error) {
+	defer observeDaoCall("PushEquivalence", time.Now())
+	if d == nil || d.pool == nil {
+		return nil, errors.New("nil value")
+	}
+
+	data, errLoad := d.loadEquivalenceForUser(ctx, user, elementId)
+	if errLoad != nil {
+		return nil, errLoad
+	}
+
+	newBase, conflicts, errPush := nodes.PushEquivalentElement(data.base, data.parent, data.child, force)
+	if errPush != nil {
+		return nil, errPush
+	}
+
+	return conflicts, d.saveSynchronisation(ctx, user, AUDIT_EQUIVALENCE_PUSH, elementId, data.parentGraph, data.parent, newBase)
+}
+
+// saveSynchronisation saves the changed element and the new base of the copy, if any.
+// Operation is the synchronisation for the audit log
+func (d *Dao) saveSynchronisation(ctx context.Context, user string, operation string, childId string, graphId string, changed nodes.Element, newBase nodes.Element) error {
+	transaction, errTransaction := d.beginAudited(ctx, user)
+	if errTransaction != nil {
+		return errTransaction
+	}
+
+	if err := upsertElementInTransaction(ctx, transaction, user, graphId, changed); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(err, errRollback)
+	}
+
+	if newBase != nil {
+		snapshot, errSnapshot := serializeElementSnapshot(newBase)
+		if errSnapshot != nil {
+			errRollback := transaction.Rollback(ctx)
+			return errors.Join(errSnapshot, errRollback)
+		}
+
+		// upsert checked that user may change the element
+		if _, err := transaction.Exec(ctx, "call sgraphs.upsert_equivalence_base($1, $2::jsonb)", childId, snapshot); err != nil {
+			errRollback := transaction.Rollback(ctx)
+			return errors.Join(err, errRollback)
+		}
+	}
+
+	elementIds := []string{childId}
+	if changed.Id() != childId {
+		elementIds = append(elementIds, changed.Id())
+	}
+
+	if err := recordAudit(ctx, transaction, user, auditEntry{operation: operation, elementIds: elementIds}); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(err, errRollback)
+	}
+
+	errCommit := transaction.Commit(ctx)
+	return errCommit
+}
+
+// compareEntitiesFirst sorts entities before relations, to insert operands before relations
+func compareEntitiesFirst(a, b nodes.Element) int {
+	_, aRelation := a.(nodes.FormalRelation)
+	_, bRelation := b.(nodes.FormalRelation)
+	switch {
+	case aRelation == bRelation:
+		return 0
+	case aRelation:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// loadForkMergeForUser computes the three-way merge of fork into graph
+func (d *Dao) loadForkMergeForUser(ctx context.Context, user string, graphId, forkId string) (graphs.ForkMerge, error) {
+	var empty graphs.ForkMerge
+	graph, errGraph := d.LoadGraphForUser(ctx, user, graphId)
+	if errGraph != nil {
+		return empty, errGraph
+	} else if graph.Id != graphId {
+		return empty, fmt.Errorf("no graph matching id %s", graphId)
+	}
+
+	fork, errFork := d.LoadGraphForUser(ctx, user, forkId)
+	if errFork != nil {
+		return empty, errFork
+	} else if fork.Id != forkId {
+		return empty, fmt.Errorf("no graph matching id %s", forkId)
+	}
+
+	rows, errBases := d.pool.Query(ctx, "select * from susers.equivalence_bases_for_graph($1, $2)", user, forkId)
+	if errBases != nil {
+		return empty, errBases
+	}
+
+	defer rows.Close()
+	bases := make(map[string]nodes.Element)
+	for rows.Next() {
+		var elementId, snapshot string
+		if err := rows.Scan(&elementId, &snapshot); err != nil {
+			return empty, err
+		} else if base, err := deserializeElementSnapshot(snapshot); err != nil {
+			return empty, err
+		} else {
+			bases[elementId] = base
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return empty, err
+	}
+
+	return graph.MergeFork(fork, bases)
+}
+
+// PreviewForkMerge returns the three-way merge of a fork into graph, without changing anything
+func (d *Dao) PreviewForkMerge(ctx context.Context, user string, graphId, forkId string) (graphs.ForkMerge, error) {
+	defer observeDaoCall("PreviewForkMerge", time.Now())
+	if d == nil || d.pool == nil {
+		return graphs.ForkMerge{}, errors.New("nil value")
+	}
+
+	return d.loadForkMergeForUser(ctx, user, graphId, forkId)
+}
+
+// ApplyForkMerge merges a fork into graph and returns the merge.
+// Non conflicting changes are saved, new elements of the fork are copied into graph and become their equivalence parents.
+// Conflicting values are not changed and are part of the result.
+// It runs in a single transaction
+func (d *Dao) ApplyForkMerge(ctx context.Context, user string, graphId, forkId string) (graphs.ForkMerge, error) {
+	defer observeDaoCall("ApplyForkMerge", time.Now())
+	if d == nil || d.pool == nil {
+		return graphs.ForkMerge{}, errors.New("nil value")
+	}
+
+	merge, errMerge := d.loadForkMergeForUser(ctx, user, graphId, forkId)
+	if errMerge != nil {
+		return merge, errMerge
+	}
+
+	elements := slices.Collect(maps.Values(merge.Added))
+	elements = append(elements, slices.Collect(maps.Values(merge.Changed))...)
+	slices.SortStableFunc(elements, compareEntitiesFirst)
+
+	transaction, errTransaction := d.beginAudited(ctx, user)
+	if errTransaction != nil {
+		return merge, errTransaction
+	}
+
+	for _, element := range elements {
+		if err := upsertElementInTransaction(ctx, transaction, user, graphId, element); err != nil {
+			errRollback := transaction.Rollback(ctx)
+			return merge, errors.Join(err, errRollback)
+		}
+	}
+
+	for forkElementId, added := range merge.Added {
+		if _, err := transaction.Exec(ctx,
+			"call susers.add_equivalence_link($1, $2, $3)",
+			user, added.Id(), forkElementId,
+		); err != nil {
+			errRollback := transaction.Rollback(ctx)
+			return merge, errors.Join(err, errRollback)
+		}
+	}
+
+	for forkElementId, base := range merge.Bases {
+		snapshot, errSnapshot := serializeElementSnapshot(base)
+		if errSnapshot != nil {
+			errRollback := transaction.Rollback(ctx)
+			return merge, errors.Join(errSnapshot, errRollback)
+		}
+
+		// user may see fork elements, and modify their parents
+		if _, err := transaction.Exec(ctx, "call sgraphs.upsert_equivalence_base($1, $2::jsonb)", forkElementId, snapshot); err != nil {
+			errRollback := transaction.Rollback(ctx)
+			return merge, errors.Join(err, errRollback)
+		}
+	}
+
+	mergedIds := make([]string, 0, len(elements))
+	for _, element := range elements {
+		mergedIds = append(mergedIds, element.Id())
+	}
+
+	if err := recordAudit(ctx, transaction, user, auditEntry{
+		operation:  AUDIT_FORK_MERGE,
+		graphId:    graphId,
+		elementIds: mergedIds,
+		changes:    map[string]AuditChangeDTO{"fork": {After: map[string][]string{forkId: nil}}},
+	}); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return merge, errors.Join(err, errRollback)
+	}
+
+	return merge, transaction.Commit(ctx)
+}
 
 // AddNewImportForGraph adds a new imported graph to an existing graph.
 // For instance, user creates an empty graph, then needs to import a graph in it
 func (d *Dao) AddNewImportForGraph(ctx context.Context, user string, baseGraph, newImportGraph string) error {
+	defer observeDaoCall("AddNewImportForGraph", time.Now())
 	if d == nil || d.pool == nil {
 		return errors.New("nil value")
 	}
 
-	_, errUpsertElement := d.pool.Exec(
-		ctx,
-		"call susers.graphs_dynamic_import($1, $2, $3)",
-		user, baseGraph, newImportGraph,
-	)
+	entry := auditEntry{
+		operation: AUDIT_GRAPH_IMPORT_ADD,
+		graphId:   baseGraph,
+		changes:   map[string]AuditChangeDTO{"imports": {After: map[string][]string{newImportGraph: nil}}},
+	}
+
+	return d.execAudited(ctx, user, entry, "call susers.graphs_dynamic_import($1, $2, $3)", user, baseGraph, newImportGraph)
+}
+
+// RemoveImportFromGraph removes a direct import of a graph.
+// It fails if relations of the graph, or of graphs importing it, link elements that would no longer be visible
+func (d *Dao) RemoveImportFromGraph(ctx context.Context, user string, baseGraph, importedGraph string) error {
+	defer observeDaoCall("RemoveImportFromGraph", time.Now())
+	if d == nil || d.pool == nil {
+		return errors.New("nil value")
+	}
+
+	entry := auditEntry{
+		operation: AUDIT_GRAPH_IMPORT_REMOVE,
+		graphId:   baseGraph,
+		changes:   map[string]AuditChangeDTO{"imports": {Before: map[string][]string{importedGraph: nil}}},
+	}
 
-	return errUpsertElement
+	return d.execAudited(ctx, user, entry, "call susers.remove_graph_import($1, $2, $3)", user, baseGraph, importedGraph)
 }
 
 // ClearGraph clear the whole graphs schema
 func (d *Dao) ClearGraph(ctx context.Context, user string) error {
+	defer observeDaoCall("ClearGraph", time.Now())
 	if d == nil || d.pool == nil {
 		return errors.New("nil value")
 	}
 
-	_, errExec := d.pool.Exec(ctx, "call susers.clear_graphs($1)", user)
-	return errExec
+	return d.execAudited(ctx, user, auditEntry{operation: AUDIT_GRAPH_CLEAR}, "call susers.clear_graphs($1)", user)
 }
 
 // Close closes the dao and the underlying pool
@@ -35,6 +35,7 @@ type AuthGraphDTO struct {
 	Roles       []string            `json:"roles"`
 	Description string              `json:"description"`
 	Metadata    map[string][]string `json:"metadata"`
+	Imports     []string            `json:"imports,omitempty"`
 }
 
 // GraphWithElementsDTO is a full graph representation
@@ -86,6 +87,45 @@ type RelationRoleValueDTO struct {
 	Periods []string `json:"validity,omitempty"`
 }
 
+// TimeBucketDTO is the DTO for aggregated values during a time bucket
+type TimeBucketDTO struct {
+	Start   string   `json:"start"`
+	End     string   `json:"end"`
+	Count   int      `json:"count"`
+	Values  int      `json:"values,omitempty"`
+	Min     *float64 `json:"min,omitempty"`
+	Max     *float64 `json:"max,omitempty"`
+	Sum     *float64 `json:"sum,omitempty"`
+	Average *float64 `json:"avg,omitempty"`
+}
+
+// LineageElementDTO is an element of a lineage with its graph
+type LineageElementDTO struct {
+	Id    string `json:"id"`
+	Graph string `json:"graph"`
+}
+
+// LineageTreeDTO is an element of a lineage with its copies
+type LineageTreeDTO struct {
+	LineageElementDTO
+	Copies []LineageTreeDTO `json:"copies,omitempty"`
+}
+
+// LineageDTO is the equivalence lineage of an element:
+// ancestors from parent to original, and the tree of copies starting from the element
+type LineageDTO struct {
+	Ancestors []LineageElementDTO `json:"ancestors"`
+	Tree      LineageTreeDTO      `json:"tree"`
+}
+
+// TrashItemDTO is a graph or an element in trash
+type TrashItemDTO struct {
+	Id string `json:"id"`
+	// Kind is graph, entity or relation
+	Kind      string `json:"kind"`
+	DeletedAt string `json:"deleted_at"`
+}
+
 // SerializePeriodsForDTO returns the serialized period as a slice, one value per interval
 func SerializePeriodsForDTO(p nodes.Period) []string {
 	return nodes.SerializePeriod(p, DATE_SERDE_FORMAT)
@@ -329,3 +369,47 @@ func SerializeFullGraph(g *graphs.Graph, nodeSerializer ElementDTOSerializer) (*
 
 	return result, globalErr
 }
+
+// SerializeTimeBuckets maps buckets to their DTO.
+// Numeric aggregations are set only if bucket contains numeric values
+func SerializeTimeBuckets(buckets []graphs.TimeBucket) []TimeBucketDTO {
+	result := make([]TimeBucketDTO, len(buckets))
+	for index, bucket := range buckets {
+		dto := TimeBucketDTO{
+			Start: bucket.Start.Format(DATE_SERDE_FORMAT),
+			End:   bucket.End.Format(DATE_SERDE_FORMAT),
+			Count: bucket.Count,
+		}
+
+		if bucket.ValuesCount != 0 {
+			min, max, sum, avg := bucket.Min, bucket.Max, bucket.Sum, bucket.Average
+			dto.Values = bucket.ValuesCount
+			dto.Min, dto.Max, dto.Sum, dto.Average = &min, &max, &sum, &avg
+		}
+
+		result[index] = dto
+	}
+
+	return result
+}
+
+// SerializeLineage maps a lineage to its DTO
+func SerializeLineage(lineage graphs.Lineage) LineageDTO {
+	result := LineageDTO{Ancestors: make([]LineageElementDTO, 0, len(lineage.Ancestors))}
+	for _, ancestor := range lineage.Ancestors {
+		result.Ancestors = append(result.Ancestors, LineageElementDTO{Id: ancestor.ElementId, Graph: ancestor.GraphId})
+	}
+
+	result.Tree = serializeLineageTree(lineage, lineage.Element)
+	return result
+}
+
+// serializeLineageTree returns the tree of copies starting at node
+func serializeLineageTree(lineage graphs.Lineage, node graphs.LineageNode) LineageTreeDTO {
+	result := LineageTreeDTO{LineageElementDTO: LineageElementDTO{Id: node.ElementId, Graph: node.GraphId}}
+	for _, child := range lineage.Children(node.ElementId) {
+		result.Copies = append(result.Copies, serializeLineageTree(lineage, child))
+	}
+
+	return result
+}
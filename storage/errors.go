@@ -8,11 +8,15 @@ import (
 
 //  P0002	no_data_found
 // 42501	insufficient_privilege
+// 42P19	invalid_recursion
+// 42710	duplicate_object
 
 const (
 	AUTH_CODE          = "42501"
 	RESOURCE_CODE      = "P0002"
 	INCONSISTENCY_CODE = "23503"
+	CYCLE_CODE         = "42P19"
+	DUPLICATE_CODE     = "42710"
 )
 
 func FindCodeInPSQLException(sourceError error) string {
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// SCHEMA_VERSION is the version of the sql schema this code expects, see susers.schema_version
+const SCHEMA_VERSION = 7
+
+// REQUIRED_PROCEDURES are the procedures and functions the dao calls
+var REQUIRED_PROCEDURES = []string{
+	"sgraphs.clear_element_data_in_dependent_tables", "sgraphs.upsert_equivalence_base",
+	"susers.add_equivalence_link", "susers.clear_graph_metadata", "susers.clear_graphs", "susers.clear_login_failures",
+	"susers.create_api_key", "susers.create_equivalent_element_into_graph", "susers.create_graph_from_imports", "susers.create_graph_from_scratch",
+	"susers.delete_element", "susers.delete_graph", "susers.delete_signing_keys_before", "susers.delete_values_for_walkthrough",
+	"susers.element_lineage_for_user", "susers.equivalence_bases_for_graph", "susers.equivalence_parent_for_user",
+	"susers.find_api_key", "susers.find_neighbors_of_matching_entities", "susers.find_user_for_external_identity", "susers.graphs_dynamic_import",
+	"susers.insert_audit_entry", "susers.insert_signing_key", "susers.link_external_identity", "susers.list_api_keys_for_user", "susers.list_audit_for_user", "susers.list_graph_imports_for_user",
+	"susers.list_graphs_for_user", "susers.list_signing_keys", "susers.list_trash_for_user",
+	"susers.load_element_by_id", "susers.load_entities_from_walkthrough", "susers.login_external_identity", "susers.load_graph_metadata",
+	"susers.load_merge_for_user", "susers.load_relations_from_walkthrough", "susers.merge_elements",
+	"susers.purge_trash", "susers.relations_to_split", "susers.remove_graph_import",
+	"susers.restore_element", "susers.restore_graph", "susers.revoke_api_key", "susers.set_audit_actor", "susers.set_user_active", "susers.start_login_attempt", "susers.test_user_password", "susers.tokens_not_before_for_user",
+	"susers.insert_refresh_token", "susers.rotate_refresh_token", "susers.revoke_refresh_token_family", "susers.revoke_user_sessions",
+	"susers.transitive_load_base_elements_in_graph", "susers.transitive_load_entities_in_graph",
+	"susers.transitive_load_relations_in_graph", "susers.undo_merge", "susers.upsert_attributes",
+	"susers.upsert_element_in_graph", "susers.upsert_graph_metadata_entry", "susers.upsert_links", "susers.upsert_user",
+}
+
+// PoolStatus describes the use of the connections pool
+type PoolStatus struct {
+	MaxConns      int32
+	TotalConns    int32
+	AcquiredConns int32
+	IdleConns     int32
+}
+
+// Saturation is the part of the pool in use, from 0 to 1
+func (p PoolStatus) Saturation() float64 {
+	if p.MaxConns <= 0 {
+		return 0
+	}
+
+	return float64(p.AcquiredConns) / float64(p.MaxConns)
+}
+
+// Ping tests that the database answers and returns its latency
+func (d *Dao) Ping(ctx context.Context) (time.Duration, error) {
+	if d == nil || d.pool == nil {
+		return 0, errors.New("nil value")
+	}
+
+	start := time.Now()
+	err := d.pool.Ping(ctx)
+	return time.Since(start), err
+}
+
+// SchemaVersion returns the version of the sql schema
+func (d *Dao) SchemaVersion(ctx context.Context) (int, error) {
+	if d == nil || d.pool == nil {
+		return 0, errors.New("nil value")
+	}
+
+	var version int
+	row := d.pool.QueryRow(ctx, "select max(SCV.schema_version) from susers.schema_version SCV")
+	if err := row.Scan(&version); err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// MissingProcedures returns the procedures or functions, as schema.name, not in database
+func (d *Dao) MissingProcedures(ctx context.Context, names []string) ([]string, error) {
+	if d == nil || d.pool == nil {
+		return nil, errors.New("nil value")
+	}
+
+	rows, errQuery := d.pool.Query(ctx,
+		`select EXP.name from unnest($1::text[]) EXP(name) 
+		where not exists (
+			select 1 from pg_catalog.pg_proc PRO 
+			join pg_catalog.pg_namespace NSP on NSP.oid = PRO.pronamespace 
+			where NSP.nspname || '.' || PRO.proname = EXP.name
+		) order by 1`,
+		names,
+	)
+
+	if errQuery != nil {
+		return nil, errQuery
+	}
+
+	defer rows.Close()
+	result := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+
+		result = append(result, name)
+	}
+
+	return result, rows.Err()
+}
+
+// PoolStatus returns the current use of the connections pool
+func (d *Dao) PoolStatus() PoolStatus {
+	if d == nil || d.pool == nil {
+		return PoolStatus{}
+	}
+
+	stat := d.pool.Stat()
+	return PoolStatus{
+		MaxConns:      stat.MaxConns(),
+		TotalConns:    stat.TotalConns(),
+		AcquiredConns: stat.AcquiredConns(),
+		IdleConns:     stat.IdleConns(),
+	}
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrUnknownExternalIdentity is raised when an external subject has no active local user
+var ErrUnknownExternalIdentity = errors.New("no local user for external identity")
+
+// ExternalIdentityDTO is a verified subject of an external issuer, to log in as a local user
+type ExternalIdentityDTO struct {
+	Issuer  string
+	Subject string
+	// Login is the login of the user to create, if provisioning applies
+	Login string
+	// AutoProvision creates the user of an unknown subject
+	AutoProvision bool
+	// Roles are the roles the issuer grants, as class:role. Nil means issuer does not manage roles
+	Roles []string
+}
+
+// FindUserForExternalIdentity returns the login of the active user of an external subject,
+// and the moment its access tokens should be issued after (zero for no restriction).
+// It returns ErrUnknownExternalIdentity if subject has no active user
+func (d *Dao) FindUserForExternalIdentity(ctx context.Context, issuer, subject string) (string, time.Time, error) {
+	defer observeDaoCall("FindUserForExternalIdentity", time.Now())
+	if d == nil || d.pool == nil {
+		return "", time.Time{}, errors.New("nil value")
+	}
+
+	var login string
+	var notBefore *time.Time
+	row := d.pool.QueryRow(ctx, "select * from susers.find_user_for_external_identity($1, $2)", issuer, subject)
+	if err := row.Scan(&login, &notBefore); errors.Is(err, pgx.ErrNoRows) {
+		return "", time.Time{}, ErrUnknownExternalIdentity
+	} else if err != nil {
+		return "", time.Time{}, err
+	} else if notBefore == nil {
+		return login, time.Time{}, nil
+	}
+
+	return login, *notBefore, nil
+}
+
+// LoginExternalIdentity returns the login of the local user of an external subject.
+// User is created if unknown and auto provisioning is on, and its roles are synchronized if set
+func (d *Dao) LoginExternalIdentity(ctx context.Context, identity ExternalIdentityDTO) (string, error) {
+	defer observeDaoCall("LoginExternalIdentity", time.Now())
+	if d == nil || d.pool == nil {
+		return "", errors.New("nil value")
+	}
+
+	transaction, errTransaction := d.beginAudited(ctx, AUDIT_SYSTEM_ACTOR)
+	if errTransaction != nil {
+		return "", errTransaction
+	}
+
+	var login string
+	var created bool
+	row := transaction.QueryRow(ctx,
+		"select * from susers.login_external_identity($1, $2, $3, $4, $5)",
+		identity.Issuer, identity.Subject, identity.Login, identity.AutoProvision, identity.Roles,
+	)
+
+	if err := row.Scan(&login, &created); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return "", errors.Join(err, errRollback)
+	}
+
+	if created {
+		entry := auditEntry{
+			operation:  AUDIT_USER_PROVISION,
+			targetUser: login,
+			changes: map[string]AuditChangeDTO{
+				"external_identity": {After: map[string][]string{identity.Issuer: {identity.Subject}}},
+			},
+		}
+
+		if err := recordAudit(ctx, transaction, AUDIT_SYSTEM_ACTOR, entry); err != nil {
+			errRollback := transaction.Rollback(ctx)
+			return "", errors.Join(err, errRollback)
+		}
+	}
+
+	return login, transaction.Commit(ctx)
+}
+
+// LinkExternalIdentity maps an external subject to an existing user. Actor should manage that user
+func (d *Dao) LinkExternalIdentity(ctx context.Context, actor, login, issuer, subject string) error {
+	defer observeDaoCall("LinkExternalIdentity", time.Now())
+	if d == nil || d.pool == nil {
+		return errors.New("nil value")
+	}
+
+	entry := auditEntry{
+		operation:  AUDIT_EXTERNAL_IDENTITY_LINK,
+		targetUser: login,
+		changes: map[string]AuditChangeDTO{
+			"external_identity": {After: map[string][]string{issuer: {subject}}},
+		},
+	}
+
+	return d.execAudited(ctx, actor, entry, "call susers.link_external_identity($1, $2, $3, $4)", actor, login, issuer, subject)
+}
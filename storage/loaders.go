@@ -164,16 +164,28 @@ func completeGraphWithRelationsRows(rowsRelation pgx.Rows, result *graphs.Graph)
 			continue
 		}
 
+		// group periods per operand to merge them at once
+		var operands []string
+		periodsPerOperand := make(map[string][]nodes.Period)
 		for index := 0; index < len(roleValues); index++ {
 			switch rolePeriod, errPeriod := deserializePeriod(rolePeriods[index]); errPeriod {
 			case nil:
-				result.AddToFormalRelation(currentGraphId, currentGraphEditable,
-					elementId, equivalenceParent, equivalenceParentGraph,
-					traits, activity, roleName, roleValues[index], rolePeriod)
+				operand := roleValues[index]
+				if _, found := periodsPerOperand[operand]; !found {
+					operands = append(operands, operand)
+				}
+
+				periodsPerOperand[operand] = append(periodsPerOperand[operand], rolePeriod)
 			default:
 				globalErr = errors.Join(globalErr, errPeriod)
 			}
 		}
+
+		for _, operand := range operands {
+			result.AddToFormalRelation(currentGraphId, currentGraphEditable,
+				elementId, equivalenceParent, equivalenceParentGraph,
+				traits, activity, roleName, operand, nodes.NewPeriodUnion(periodsPerOperand[operand]...))
+		}
 	}
 
 	return globalErr
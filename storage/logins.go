@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// LockoutPolicy limits failed logins per login and per address within a window. 0 as a maximum means no limit
+type LockoutPolicy struct {
+	// MaxLoginFailures is the number of failures that locks a login
+	MaxLoginFailures int
+	// MaxAddressFailures is the number of failures that locks an address, for any login
+	MaxAddressFailures int
+	// FailureWindow is the period failures are counted in
+	FailureWindow time.Duration
+	// LockoutDuration is the duration of a lockout
+	LockoutDuration time.Duration
+}
+
+// StartLoginAttempt counts a login attempt for login and address, before its password is checked.
+// Lockout is checked and attempt is counted in a single call, so that concurrent attempts cannot go over the limits.
+// It returns the end of the lockout of login or address, zero if attempt may check its password
+func (d *Dao) StartLoginAttempt(ctx context.Context, login, address string, policy LockoutPolicy) (time.Time, error) {
+	defer observeDaoCall("StartLoginAttempt", time.Now())
+	if d == nil || d.pool == nil {
+		return time.Time{}, errors.New("nil value")
+	}
+
+	var lockedUntil *time.Time
+	row := d.pool.QueryRow(ctx,
+		"select susers.start_login_attempt($1, $2, $3, $4, $5, $6)",
+		login, address, policy.MaxLoginFailures, policy.MaxAddressFailures,
+		int(policy.FailureWindow.Seconds()), int(policy.LockoutDuration.Seconds()),
+	)
+
+	if err := row.Scan(&lockedUntil); err != nil {
+		return time.Time{}, err
+	} else if lockedUntil == nil {
+		return time.Time{}, nil
+	}
+
+	return *lockedUntil, nil
+}
+
+// RecordLoginFailure audits a failed login for login and address. Attempt was counted when it started
+func (d *Dao) RecordLoginFailure(ctx context.Context, login, address string) error {
+	defer observeDaoCall("RecordLoginFailure", time.Now())
+	if d == nil || d.pool == nil {
+		return errors.New("nil value")
+	}
+
+	transaction, errTransaction := d.beginAudited(ctx, AUDIT_SYSTEM_ACTOR)
+	if errTransaction != nil {
+		return errTransaction
+	}
+
+	// login may match no user, so it is in changes too
+	entry := auditEntry{
+		operation:  AUDIT_LOGIN_FAILURE,
+		targetUser: login,
+		changes: map[string]AuditChangeDTO{
+			"attempt": {After: map[string][]string{"login": {login}, "address": {address}}},
+		},
+	}
+
+	if err := recordAudit(ctx, transaction, AUDIT_SYSTEM_ACTOR, entry); err != nil {
+		errRollback := transaction.Rollback(ctx)
+		return errors.Join(err, errRollback)
+	}
+
+	return transaction.Commit(ctx)
+}
+
+// ClearLoginFailures forgets failures of a login after a successful login, and that attempt for its address
+func (d *Dao) ClearLoginFailures(ctx context.Context, login, address string) error {
+	defer observeDaoCall("ClearLoginFailures", time.Now())
+	if d == nil || d.pool == nil {
+		return errors.New("nil value")
+	}
+
+	_, errExec := d.pool.Exec(ctx, "call susers.clear_login_failures($1, $2)", login, address)
+	return errExec
+}
+
+// SetUserActive activates or deactivates an user. Actor should manage that user.
+// Deactivation revokes sessions of the user, activation ends its lockout
+func (d *Dao) SetUserActive(ctx context.Context, actor, login string, active bool) error {
+	defer observeDaoCall("SetUserActive", time.Now())
+	if d == nil || d.pool == nil {
+		return errors.New("nil value")
+	}
+
+	entry := auditEntry{operation: AUDIT_USER_DEACTIVATE, targetUser: login}
+	if active {
+		entry.operation = AUDIT_USER_ACTIVATE
+	}
+
+	return d.execAudited(ctx, actor, entry, "call susers.set_user_active($1, $2, $3)", actor, login, active)
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/zefrenchwan/patterns.git/metrics"
+)
+
+// daoCallDurations measures dao calls per method
+var daoCallDurations = metrics.Default.NewHistogramVec(
+	"patterns_dao_call_duration_seconds",
+	"Duration of dao calls in seconds, per method",
+	metrics.DefaultBuckets, "call",
+)
+
+// observeDaoCall records the duration of a dao call started at start
+func observeDaoCall(call string, start time.Time) {
+	daoCallDurations.Observe(time.Since(start).Seconds(), call)
+}
+
+// registerPoolMetrics exposes the statistics of the connections pool
+func registerPoolMetrics(pool *pgxpool.Pool) {
+	stat := func(value func(*pgxpool.Stat) float64) func() float64 {
+		return func() float64 {
+			return value(pool.Stat())
+		}
+	}
+
+	metrics.Default.NewGaugeFunc("patterns_db_pool_max_connections", "Maximum size of the connections pool",
+		stat(func(s *pgxpool.Stat) float64 { return float64(s.MaxConns()) }))
+	metrics.Default.NewGaugeFunc("patterns_db_pool_total_connections", "Connections in the pool",
+		stat(func(s *pgxpool.Stat) float64 { return float64(s.TotalConns()) }))
+	metrics.Default.NewGaugeFunc("patterns_db_pool_acquired_connections", "Connections currently in use",
+		stat(func(s *pgxpool.Stat) float64 { return float64(s.AcquiredConns()) }))
+	metrics.Default.NewGaugeFunc("patterns_db_pool_idle_connections", "Idle connections in the pool",
+		stat(func(s *pgxpool.Stat) float64 { return float64(s.IdleConns()) }))
+	metrics.Default.NewCounterFunc("patterns_db_pool_acquires_total", "Successful acquires of a connection",
+		stat(func(s *pgxpool.Stat) float64 { return float64(s.AcquireCount()) }))
+	metrics.Default.NewCounterFunc("patterns_db_pool_empty_acquires_total", "Acquires that waited for a connection",
+		stat(func(s *pgxpool.Stat) float64 { return float64(s.EmptyAcquireCount()) }))
+	metrics.Default.NewCounterFunc("patterns_db_pool_canceled_acquires_total", "Acquires canceled by their context",
+		stat(func(s *pgxpool.Stat) float64 { return float64(s.CanceledAcquireCount()) }))
+	metrics.Default.NewCounterFunc("patterns_db_pool_acquire_duration_seconds_total", "Total time spent acquiring connections",
+		stat(func(s *pgxpool.Stat) float64 { return s.AcquireDuration().Seconds() }))
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// requestIdKey is the context key of the request id
+type requestIdKey struct{}
+
+// ContextWithRequestId returns a context carrying the id of the request that uses the dao
+func ContextWithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, requestId)
+}
+
+// RequestIdFromContext returns the request id in the context, if any
+func RequestIdFromContext(ctx context.Context) (string, bool) {
+	requestId, found := ctx.Value(requestIdKey{}).(string)
+	return requestId, found
+}
+
+// querySqlKey is the context key of the current query, from start to end of query
+type querySqlKey struct{}
+
+// sqlErrorTracer logs failing queries with their request id.
+// Arguments are not logged, they may contain passwords
+type sqlErrorTracer struct {
+	logger *zap.SugaredLogger
+}
+
+// TraceQueryStart keeps the query for its end
+func (s sqlErrorTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, querySqlKey{}, data.SQL)
+}
+
+// TraceQueryEnd logs the query if it failed
+func (s sqlErrorTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	if data.Err == nil || s.logger == nil {
+		return
+	}
+
+	query, _ := ctx.Value(querySqlKey{}).(string)
+	requestId, _ := RequestIdFromContext(ctx)
+	s.logger.Warnw("query failed", "request_id", requestId, "sql", query, "error", data.Err.Error())
+}
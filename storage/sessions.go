@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidRefreshToken is raised for an unknown, expired or revoked refresh token
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// ErrRefreshTokenReuse is raised when a used refresh token is used again. Its family is then revoked
+var ErrRefreshTokenReuse = errors.New("refresh token reuse, session revoked")
+
+// newSecretToken returns a random token and its hash. Only hash is stored
+func newSecretToken() (string, string, error) {
+	content := make([]byte, 32)
+	if _, err := rand.Read(content); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(content)
+	return token, hashSecretToken(token), nil
+}
+
+// hashSecretToken returns the stored value of a refresh token or an api key
+func hashSecretToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// CreateRefreshToken starts a new session for an active user and returns its refresh token, valid for duration
+func (d *Dao) CreateRefreshToken(ctx context.Context, login string, duration time.Duration) (string, error) {
+	defer observeDaoCall("CreateRefreshToken", time.Now())
+	if d == nil || d.pool == nil {
+		return "", errors.New("nil value")
+	}
+
+	token, hash, errToken := newSecretToken()
+	if errToken != nil {
+		return "", errToken
+	}
+
+	expiration := time.Now().UTC().Add(duration)
+	if _, err := d.pool.Exec(ctx,
+		"call susers.insert_refresh_token($1, $2, $3, $4)",
+		login, hash, uuid.NewString(), expiration,
+	); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// RotateRefreshToken uses a refresh token and returns its user and the refresh token replacing it, valid for duration.
+// Reuse of a used token revokes its session
+func (d *Dao) RotateRefreshToken(ctx context.Context, token string, duration time.Duration) (string, string, error) {
+	defer observeDaoCall("RotateRefreshToken", time.Now())
+	if d == nil || d.pool == nil {
+		return "", "", errors.New("nil value")
+	}
+
+	newToken, newHash, errToken := newSecretToken()
+	if errToken != nil {
+		return "", "", errToken
+	}
+
+	var login *string
+	var status string
+	expiration := time.Now().UTC().Add(duration)
+	row := d.pool.QueryRow(ctx,
+		"select * from susers.rotate_refresh_token($1, $2, $3)",
+		hashSecretToken(token), newHash, expiration,
+	)
+
+	if err := row.Scan(&login, &status); err != nil {
+		return "", "", err
+	}
+
+	switch status {
+	case "valid":
+		return *login, newToken, nil
+	case "reused":
+		return "", "", ErrRefreshTokenReuse
+	default:
+		return "", "", ErrInvalidRefreshToken
+	}
+}
+
+// RevokeRefreshToken ends the session of a refresh token, that is revokes its family
+func (d *Dao) RevokeRefreshToken(ctx context.Context, token string) error {
+	defer observeDaoCall("RevokeRefreshToken", time.Now())
+	if d == nil || d.pool == nil {
+		return errors.New("nil value")
+	}
+
+	_, errExec := d.pool.Exec(ctx, "call susers.revoke_refresh_token_family($1)", hashSecretToken(token))
+	return errExec
+}
+
+// RevokeUserSessions revokes all refresh tokens of an user and invalidates its access tokens. Actor should manage user
+func (d *Dao) RevokeUserSessions(ctx context.Context, actor, login string) error {
+	defer observeDaoCall("RevokeUserSessions", time.Now())
+	if d == nil || d.pool == nil {
+		return errors.New("nil value")
+	}
+
+	entry := auditEntry{operation: AUDIT_USER_SESSIONS_REVOKE, targetUser: login}
+	return d.execAudited(ctx, actor, entry, "call susers.revoke_user_sessions($1, $2)", actor, login)
+}
+
+// FindTokensNotBeforeForActiveUser returns the moment access tokens of an active user should be issued after, zero for no restriction.
+// It raises an error if user is not active
+func (d *Dao) FindTokensNotBeforeForActiveUser(ctx context.Context, login string) (time.Time, error) {
+	defer observeDaoCall("FindTokensNotBeforeForActiveUser", time.Now())
+	if d == nil || d.pool == nil {
+		return time.Time{}, errors.New("nil value")
+	}
+
+	var notBefore *time.Time
+	if err := d.pool.QueryRow(ctx, "select susers.tokens_not_before_for_user($1)", login).Scan(&notBefore); err != nil {
+		return time.Time{}, err
+	} else if notBefore == nil {
+		return time.Time{}, nil
+	}
+
+	return *notBefore, nil
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// SigningKeyDTO is a key to sign access tokens
+type SigningKeyDTO struct {
+	Id        string
+	Algorithm string
+	// PrivateKey is the PKCS #8 DER form of the private key, encrypted by the keyring
+	PrivateKey []byte
+	CreatedAt  time.Time
+}
+
+// LoadSigningKeys returns all the keys to sign access tokens, newest first
+func (d *Dao) LoadSigningKeys(ctx context.Context) ([]SigningKeyDTO, error) {
+	defer observeDaoCall("LoadSigningKeys", time.Now())
+	if d == nil || d.pool == nil {
+		return nil, errors.New("nil value")
+	}
+
+	rows, errLoad := d.pool.Query(ctx, "select * from susers.list_signing_keys()")
+	if errLoad != nil {
+		return nil, errLoad
+	}
+
+	defer rows.Close()
+	result := make([]SigningKeyDTO, 0)
+	for rows.Next() {
+		var key SigningKeyDTO
+		if err := rows.Scan(&key.Id, &key.Algorithm, &key.PrivateKey, &key.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		result = append(result, key)
+	}
+
+	return result, rows.Err()
+}
+
+// InsertSigningKey stores a new key to sign access tokens
+func (d *Dao) InsertSigningKey(ctx context.Context, key SigningKeyDTO) error {
+	defer observeDaoCall("InsertSigningKey", time.Now())
+	if d == nil || d.pool == nil {
+		return errors.New("nil value")
+	}
+
+	_, errExec := d.pool.Exec(ctx, "call susers.insert_signing_key($1, $2, $3)", key.Id, key.Algorithm, key.PrivateKey)
+	return errExec
+}
+
+// DeleteSigningKeysBefore deletes the keys created before moment
+func (d *Dao) DeleteSigningKeysBefore(ctx context.Context, moment time.Time) error {
+	defer observeDaoCall("DeleteSigningKeysBefore", time.Now())
+	if d == nil || d.pool == nil {
+		return errors.New("nil value")
+	}
+
+	_, errExec := d.pool.Exec(ctx, "call susers.delete_signing_keys_before($1)", moment)
+	return errExec
+}
@@ -10,7 +10,9 @@ alter schema sgraphs owner to upa;
 create table sgraphs.graphs (
 	graph_id text primary key,
 	graph_name text not null, 
-	graph_description text
+	graph_description text,
+	-- not null for graphs in trash
+	graph_deleted_at timestamp without time zone
 );
 
 alter table sgraphs.graphs owner to upa;
@@ -70,7 +72,9 @@ create table sgraphs.elements (
 	element_id text primary key,
 	graph_id text not null references sgraphs.graphs(graph_id) on delete cascade,
 	element_type int not null references sgraphs.reftypes(reftype_id),
-	element_period bigint references sgraphs.periods(period_id) on delete cascade
+	element_period bigint references sgraphs.periods(period_id) on delete cascade,
+	-- not null for elements in trash
+	element_deleted_at timestamp without time zone
 );
 
 alter table sgraphs.elements owner to upa;
@@ -131,6 +135,56 @@ create table sgraphs.nodes (
 
 alter table sgraphs.nodes owner to upa;
 
+-- sgraphs.equivalence_bases keeps the parent of a copy as it was at last synchronisation
+create table sgraphs.equivalence_bases (
+	child_element_id text primary key references sgraphs.elements(element_id) on delete cascade,
+	base_snapshot jsonb not null,
+	sync_date timestamp without time zone not null default now()
+);
+
+alter table sgraphs.equivalence_bases owner to upa;
+
+
+-----------------------
+-- MERGES DEFINITION --
+-----------------------
+
+-- sgraphs.merges keeps the merges of elements into a target, to undo them
+create table sgraphs.merges (
+	merge_id text primary key, 
+	target_id text not null references sgraphs.elements(element_id) on delete cascade,
+	target_graph_id text not null,
+	-- target as it was before the merge
+	target_snapshot jsonb not null,
+	merge_date timestamp without time zone not null default now()
+);
+
+alter table sgraphs.merges owner to upa;
+
+-- sgraphs.merge_sources keeps the merged (and then deleted) elements 
+create table sgraphs.merge_sources (
+	merge_id text not null references sgraphs.merges(merge_id) on delete cascade,
+	source_id text not null,
+	source_graph_id text not null,
+	source_snapshot jsonb not null,
+	-- equivalence parent of the source, if any
+	source_parent text,
+	-- equivalent copies of the source, now linked to target
+	source_children text[]
+);
+
+alter table sgraphs.merge_sources owner to upa;
+
+-- sgraphs.merge_links keeps the relation operands that were redirected from a source to the target
+create table sgraphs.merge_links (
+	merge_id text not null references sgraphs.merges(merge_id) on delete cascade,
+	relation_id text not null references sgraphs.elements(element_id) on delete cascade,
+	role_in_relation text not null,
+	source_id text not null,
+	period_value text not null
+);
+
+alter table sgraphs.merge_links owner to upa;
 
 -------------------------------
 -- AND FINALLY, GRANT ACCESS --
@@ -505,7 +505,7 @@ begin
 		call sgraphs.copy_period(l_relation_period, l_new_relation_period);
 		-- insert new relation role and then new relation role value. 
 		-- Because a relation role may contain many values, be sure to insert role once.  
-		select ROL.role_in_relation into l_new_relation_role_id
+		select ROL.relation_role_id into l_new_relation_role_id
 		from sgraphs.relation_role ROL
 		where ROL.relation_id = p_destination_id 
 		and ROL.role_in_relation = l_role_in_relation;
@@ -545,4 +545,170 @@ begin
 	select p_source_id, p_destination_id;
 end; $$;
 
-alter procedure sgraphs.create_copy_node owner to upa;
\ No newline at end of file
+alter procedure sgraphs.create_copy_node owner to upa;
+-- sgraphs.merge_elements merges sources into target: 
+-- relations operands and copies of sources are redirected to target, and then sources are moved to trash.
+-- Content of the target is not changed, caller should upsert the merged target. 
+-- Merge is kept with snapshots to undo it
+create or replace procedure sgraphs.merge_elements(
+	p_merge_id text, p_target_id text, p_sources text[], 
+	p_target_snapshot jsonb, p_sources_snapshots jsonb[]
+) language plpgsql as $$
+declare 
+	l_index int;
+	l_source text;
+begin 
+	if exists (select 1 from sgraphs.merges where merge_id = p_merge_id) then 
+		raise exception 'merge already exists' using errcode = '42710';
+	end if;
+
+	insert into sgraphs.merges(merge_id, target_id, target_graph_id, target_snapshot)
+	select p_merge_id, p_target_id, ELT.graph_id, p_target_snapshot
+	from sgraphs.elements ELT 
+	where ELT.element_id = p_target_id;
+
+	for l_index in 1..array_length(p_sources, 1) loop 
+		l_source = p_sources[l_index];
+		insert into sgraphs.merge_sources(merge_id, source_id, source_graph_id, source_snapshot, source_parent, source_children)
+		select p_merge_id, l_source, ELT.graph_id, p_sources_snapshots[l_index], 
+		(select NOD.source_element_id from sgraphs.nodes NOD where NOD.child_element_id = l_source limit 1),
+		(select array_agg(NOD.child_element_id) from sgraphs.nodes NOD where NOD.source_element_id = l_source)
+		from sgraphs.elements ELT 
+		where ELT.element_id = l_source;
+	end loop;
+
+	-- keep redirected operands to undo merge. 
+	-- Relations in trash are not redirected, they link sources in trash
+	insert into sgraphs.merge_links(merge_id, relation_id, role_in_relation, source_id, period_value)
+	select p_merge_id, RRO.relation_id, RRO.role_in_relation, RRV.relation_value, PER.period_value
+	from sgraphs.relation_role_values RRV 
+	join sgraphs.relation_role RRO on RRO.relation_role_id = RRV.relation_role_id
+	join sgraphs.periods PER on PER.period_id = RRV.relation_period_id
+	join sgraphs.elements REL on REL.element_id = RRO.relation_id
+	join sgraphs.graphs GRA on GRA.graph_id = REL.graph_id
+	where RRV.relation_value = any(p_sources)
+	and REL.element_deleted_at is null 
+	and GRA.graph_deleted_at is null;
+
+	-- redirect operands and copies to target
+	update sgraphs.relation_role_values 
+	set relation_value = p_target_id 
+	where relation_value = any(p_sources)
+	and relation_role_id in (
+		select RRO.relation_role_id 
+		from sgraphs.relation_role RRO 
+		join sgraphs.elements REL on REL.element_id = RRO.relation_id
+		join sgraphs.graphs GRA on GRA.graph_id = REL.graph_id
+		where REL.element_deleted_at is null 
+		and GRA.graph_deleted_at is null
+	);
+
+	update sgraphs.nodes
+	set source_element_id = p_target_id
+	where source_element_id = any(p_sources);
+
+	-- and finally move sources to trash, undo restores them. 
+	-- Their equivalence parents are kept in merge sources
+	delete from sgraphs.nodes where child_element_id = any(p_sources);
+	update sgraphs.elements set element_deleted_at = now() where element_id = any(p_sources);
+end; $$;
+
+alter procedure sgraphs.merge_elements owner to upa;
+
+-- sgraphs.undo_merge restores sources from trash, their operands and copies, then deletes the merge.
+-- Sources should exist when called (in trash since the merge, or upserted again). 
+-- It raises an exception if a relation no longer links target with the same period
+create or replace procedure sgraphs.undo_merge(p_merge_id text) 
+language plpgsql as $$
+declare 
+	l_target_id text;
+	l_link record;
+	l_source record;
+begin 
+	select target_id into l_target_id
+	from sgraphs.merges 
+	where merge_id = p_merge_id;
+
+	if l_target_id is null then 
+		raise exception 'no merge matching id %', p_merge_id using errcode = 'P0002';
+	end if;
+
+	update sgraphs.elements 
+	set element_deleted_at = null 
+	where element_id in (select MSO.source_id from sgraphs.merge_sources MSO where MSO.merge_id = p_merge_id);
+
+	for l_link in 
+		select relation_id, role_in_relation, source_id, period_value
+		from sgraphs.merge_links 
+		where merge_id = p_merge_id
+	loop 
+		update sgraphs.relation_role_values 
+		set relation_value = l_link.source_id
+		where ctid in (
+			select RRV.ctid 
+			from sgraphs.relation_role_values RRV 
+			join sgraphs.relation_role RRO on RRO.relation_role_id = RRV.relation_role_id
+			join sgraphs.periods PER on PER.period_id = RRV.relation_period_id
+			where RRO.relation_id = l_link.relation_id
+			and RRO.role_in_relation = l_link.role_in_relation
+			and RRV.relation_value = l_target_id
+			and PER.period_value = l_link.period_value
+			limit 1
+		);
+
+		-- link changed since merge, restoring other links only would be a partial undo
+		if not found then 
+			raise exception 'relation % changed since merge, cannot link % again', l_link.relation_id, l_link.source_id using errcode = '23503';
+		end if;
+	end loop;
+
+	for l_source in 
+		select source_id, source_parent, source_children
+		from sgraphs.merge_sources 
+		where merge_id = p_merge_id
+	loop 
+		update sgraphs.nodes 
+		set source_element_id = l_source.source_id
+		where source_element_id = l_target_id 
+		and child_element_id = any(l_source.source_children);
+
+		if l_source.source_parent is not null and exists (
+			select 1 from sgraphs.elements where element_id = l_source.source_parent
+		) then 
+			insert into sgraphs.nodes(source_element_id, child_element_id) 
+			values (l_source.source_parent, l_source.source_id);
+		end if;
+	end loop;
+
+	delete from sgraphs.merges where merge_id = p_merge_id;
+end; $$;
+
+alter procedure sgraphs.undo_merge owner to upa;
+
+-- sgraphs.upsert_equivalence_base sets the parent snapshot of a copy at synchronisation
+create or replace procedure sgraphs.upsert_equivalence_base(p_child_id text, p_snapshot jsonb) 
+language plpgsql as $$
+declare 
+begin 
+	insert into sgraphs.equivalence_bases(child_element_id, base_snapshot)
+	values (p_child_id, p_snapshot)
+	on conflict (child_element_id) do update 
+	set base_snapshot = excluded.base_snapshot, sync_date = now();
+end; $$;
+
+alter procedure sgraphs.upsert_equivalence_base owner to upa;
+
+-- sgraphs.add_equivalence_link sets parent as the equivalence parent of child
+create or replace procedure sgraphs.add_equivalence_link(p_parent_id text, p_child_id text) 
+language plpgsql as $$
+declare 
+begin 
+	if exists (select 1 from sgraphs.nodes where child_element_id = p_child_id) then 
+		raise exception 'element % already has an equivalence parent', p_child_id using errcode = '42710';
+	end if;
+
+	insert into sgraphs.nodes(source_element_id, child_element_id) 
+	values (p_parent_id, p_child_id);
+end; $$;
+
+alter procedure sgraphs.add_equivalence_link owner to upa;
@@ -57,7 +57,9 @@ create table susers.users (
     user_login text unique, 
     user_salt text not null, 
     user_secret text not null,
-    user_hash text not null
+    user_hash text not null,
+    -- access tokens issued before that moment are rejected
+    user_tokens_not_before timestamp with time zone
 );
 
 alter table susers.users owner to upa;
@@ -86,6 +88,118 @@ create table susers.resources_authorizations (
 
 alter table susers.resources_authorizations owner to upa;
 
+-- susers.audit_entries is the append only log of changes. 
+-- Target graph and user, if any, define who may read the entry. 
+-- Changes are, per part, the values before and after change
+create table susers.audit_entries (
+	audit_id bigserial primary key, 
+	audit_date timestamp without time zone not null default now(),
+	audit_actor text not null,
+	audit_operation text not null,
+	audit_graph_id text,
+	audit_user_id text, 
+	audit_element_ids text[],
+	audit_changes jsonb
+);
+
+alter table susers.audit_entries owner to upa;
+
+create index audit_entries_date_idx on susers.audit_entries(audit_date);
+
+-- susers.schema_version is the version of the schema, readiness checks that code expects that version. 
+-- Change value in storage.SCHEMA_VERSION too
+create table susers.schema_version (
+	schema_version int not null
+);
+
+alter table susers.schema_version owner to upa;
+
+insert into susers.schema_version(schema_version) values (7);
+
+-- susers.refresh_tokens are refresh tokens, stored as hashes. 
+-- A family is the chain of tokens from the same login: each refresh uses a token and replaces it by a new one. 
+-- Using a used token again revokes its family
+create table susers.refresh_tokens (
+	token_hash text primary key,
+	token_family text not null,
+	user_id text not null references susers.users(user_id) on delete cascade,
+	token_created_at timestamp without time zone not null default now(),
+	token_expires_at timestamp without time zone not null,
+	token_used_at timestamp without time zone,
+	token_revoked_at timestamp without time zone
+);
+
+alter table susers.refresh_tokens owner to upa;
+
+create index refresh_tokens_family_idx on susers.refresh_tokens(token_family);
+create index refresh_tokens_user_idx on susers.refresh_tokens(user_id);
+
+-- susers.api_keys are long lived keys of users, stored as hashes. 
+-- Null allowed graphs (or roles) means no restriction, otherwise key accesses only those graphs (with those roles). 
+-- Null expiration means key never expires
+create table susers.api_keys (
+	key_id text primary key,
+	key_hash text unique not null,
+	key_name text not null,
+	user_id text not null references susers.users(user_id) on delete cascade,
+	key_created_at timestamp without time zone not null default now(),
+	key_expires_at timestamp without time zone,
+	key_revoked_at timestamp without time zone,
+	key_allowed_graphs text[],
+	key_allowed_roles text[]
+);
+
+alter table susers.api_keys owner to upa;
+
+create index api_keys_user_idx on susers.api_keys(user_id);
+
+-- susers.signing_keys are the keys to sign access tokens, shared by all servers. 
+-- Newest key of an algorithm signs, all keys validate until they are deleted. 
+-- Private key is stored encrypted (AES-GCM) with a secret from server configuration; the secret is never stored in database
+create table susers.signing_keys (
+	key_id text primary key,
+	key_algorithm text not null,
+	key_private bytea not null,
+	key_created_at timestamp with time zone not null default now()
+);
+
+alter table susers.signing_keys owner to upa;
+
+-- susers.external_identities map subjects of an external issuer to local users
+create table susers.external_identities (
+	identity_issuer text not null,
+	identity_subject text not null,
+	user_id text not null references susers.users(user_id) on delete cascade,
+	identity_created_at timestamp with time zone not null default now(),
+	identity_last_login_at timestamp with time zone,
+	primary key (identity_issuer, identity_subject)
+);
+
+alter table susers.external_identities owner to upa;
+
+create index external_identities_user_idx on susers.external_identities(user_id);
+
+-- susers.external_authorizations are the authorizations granted from groups of an external issuer. 
+-- Issuer manages them: they are revoked once user is no longer in matching groups
+create table susers.external_authorizations (
+	auth_id bigint primary key references susers.authorizations(auth_id) on delete cascade
+);
+
+alter table susers.external_authorizations owner to upa;
+
+-- susers.login_failures count failed logins per scope, login or address, within a window. 
+-- Too many failures lock that login or address until failure_locked_until
+create table susers.login_failures (
+	failure_scope text not null,
+	failure_key text not null,
+	failure_count int not null default 0,
+	failure_first_at timestamp with time zone not null default now(),
+	failure_locked_until timestamp with time zone,
+	primary key (failure_scope, failure_key)
+);
+
+alter table susers.login_failures owner to upa;
+
 
 
 grant all privileges on all tables in schema susers to upa;
@@ -44,3 +44,26 @@ begin
 end;$$;
 
 alter function susers.find_secret_for_user owner to upa;
+
+-- susers.tokens_not_before_for_user returns the moment access tokens of an active user should be issued after, if any. 
+-- It raises exception if user is not active
+create or replace function susers.tokens_not_before_for_user(p_login text) returns timestamp with time zone 
+language plpgsql
+as $$
+declare
+	l_user_id text;
+	l_not_before timestamp with time zone;
+begin 
+	select user_id, user_tokens_not_before into l_user_id, l_not_before
+	from susers.users
+	where user_login = p_login
+	and user_active = true;
+	
+	if l_user_id is null then 
+		raise exception 'auth failure: no active user found for login %', p_login using errcode = '42501';
+	end if;
+
+	return l_not_before;
+end;$$;
+
+alter function susers.tokens_not_before_for_user owner to upa;
@@ -229,6 +229,19 @@ begin
 
 	-- all values are valid, start processing 
 
+	-- audit the change, actor is set by caller's transaction
+	insert into susers.audit_entries(
+		audit_actor, audit_operation, 
+		audit_graph_id, audit_user_id, audit_changes
+	) select coalesce(nullif(current_setting('patterns.audit_actor', true), ''), current_user), 
+	case when p_grant_access then 'auth_grant' else 'auth_revoke' end,
+	case when p_class_name = 'graph' then p_resource end, 
+	p_user_id,
+	jsonb_build_object(p_class_name, jsonb_build_object(
+		case when p_grant_access then 'after' else 'before' end,
+		jsonb_build_object(p_role_name, jsonb_build_array(coalesce(p_resource, '*')))
+	));
+
 	-- Exclude values from the opposite side:
 	-- If we grant values, then remove revoked said values. 
 	-- If we revoke values, then remove granted said values.
@@ -347,15 +360,63 @@ end;$$;
 
 alter procedure susers.revoke_access_to_user_for_resource owner to upa;
 
--- susers.accept_user_access_to_resource_or_raise test if user has access to given resource
+-- susers.current_api_key_restriction returns the allowed graphs and roles of the api key of the session, if any. 
+-- Dao sets patterns.api_key for requests authenticated by an api key. Null values mean no restriction
+create or replace function susers.current_api_key_restriction(out p_allowed_graphs text[], out p_allowed_roles text[]) 
+language plpgsql as $$
+declare 
+	l_key_id text;
+begin 
+	l_key_id := nullif(current_setting('patterns.api_key', true), '');
+	if l_key_id is null then 
+		return;
+	end if;
+
+	select AKE.key_allowed_graphs, AKE.key_allowed_roles 
+	into p_allowed_graphs, p_allowed_roles
+	from susers.api_keys AKE 
+	where AKE.key_id = l_key_id;
+
+	if not found then 
+		raise exception 'auth failure: unknown api key' using errcode = '42501';
+	end if;
+end; $$;
+
+alter function susers.current_api_key_restriction owner to upa;
+
+-- susers.accept_user_access_to_resource_or_raise test if user has access to given resource. 
+-- When request uses an api key, access is also restricted to the graphs and roles of that key
 create or replace procedure susers.accept_user_access_to_resource_or_raise(p_user_login text, p_class text, p_role_names text[], p_all_roles bool, p_resource text) 
 language plpgsql as $$
 declare
 	l_remaining_roles text[];
+	l_allowed_graphs text[];
+	l_allowed_roles text[];
+	l_expected_roles text[];
 begin 
 
+	select * into l_allowed_graphs, l_allowed_roles from susers.current_api_key_restriction();
+
+	if p_class = 'graph' and l_allowed_graphs is not null then 
+		if p_resource is null or not (p_resource = any(l_allowed_graphs)) then 
+			raise exception 'no auth for api key on resource' using errcode = '42501';
+		end if;
+	end if;
+
+	-- key roles should contain all expected roles, or at least one of them
+	l_expected_roles := p_role_names;
+	if l_allowed_roles is not null then 
+		select coalesce(array_agg(ERO.role_name), '{}') into l_expected_roles 
+		from unnest(p_role_names) as ERO(role_name)
+		where ERO.role_name = any(l_allowed_roles);
+
+		if cardinality(l_expected_roles) = 0 or (p_all_roles and cardinality(l_expected_roles) <> cardinality(p_role_names)) then 
+			raise exception 'no auth for api key on resource' using errcode = '42501';
+		end if;
+	end if;
+
 	with expected_roles as (
-		select unnest(p_role_names) as role_name
+		select unnest(l_expected_roles) as role_name
 	), granted_roles as (
 		select AFU.role_name
 		from susers.authorizations_for_user_on_resource(p_user_login, p_class, p_resource) AFU
@@ -366,7 +427,7 @@ begin
 	from granted_roles GRO;
 
 	if p_all_roles then 
-		if not (p_role_names <@ l_remaining_roles) then
+		if not (l_expected_roles <@ l_remaining_roles) then
 			raise exception 'no auth or no resource' using errcode = '42501';
 		end if;
 	elsif array_length(l_remaining_roles, 1) = 0  then 
@@ -385,8 +446,12 @@ create or replace function susers.all_resources_authorized_for_user(p_user_login
 returns table(resource text, role_names text[]) language plpgsql as $$
 declare 
     l_class_id int;
+	l_allowed_graphs text[];
+	l_allowed_roles text[];
 begin
     select class_id into l_class_id from susers.classes CLA where CLA.class_name = p_class_name;
+	-- api key, if any, restricts resources and roles
+	select * into l_allowed_graphs, l_allowed_roles from susers.current_api_key_restriction();
 
     return query
     with all_auths as (
@@ -418,6 +483,8 @@ begin
     from reduced_auths RAU
     where true = ALL(RAU.role_inclusions)
 	and array_length(RAU.role_inclusions, 1) > 0
+	and (l_allowed_roles is null or RAU.role_name = any(l_allowed_roles))
+	and (l_allowed_graphs is null or p_class_name <> 'graph' or RAU.resource = any(l_allowed_graphs))
 	group by  RAU.resource;
 end;$$;
 
@@ -104,25 +104,37 @@ begin
     ENT.entry_key as graph_md_key, ENT.entry_values as graph_md_values
     from sgraphs.graphs GRA
     join susers.all_graphs_authorized_for_user(p_user) GRO ON GRO.resource = GRA.graph_id
-    left outer join sgraphs.graph_entries ENT on ENT.graph_id = GRA.graph_id;
+    left outer join sgraphs.graph_entries ENT on ENT.graph_id = GRA.graph_id
+    where GRA.graph_deleted_at is null;
 end; $$;
 
 alter function susers.list_graphs_for_user owner to upa;
 
--- susers.load_graph_metadata returns the name, description and associated map of a graph, if user is authorized
-create or replace function susers.load_graph_metadata(p_user_login text, p_id text)
+-- susers.load_graph_metadata returns the name, description and associated map of a graph, if user is authorized. 
+-- A graph in trash has no metadata unless p_with_trash is set
+create or replace function susers.load_graph_metadata(p_user_login text, p_id text, p_with_trash bool default false)
 returns table (graph_name text, graph_description text, entry_key text, entry_values text[])
 language plpgsql as $$
 declare 
 begin 
 	call susers.accept_user_access_to_resource_or_raise(p_user_login, 'graph',ARRAY['manager','observer','modifier'], false, p_id);
+	if not p_with_trash and exists (
+		select 1 from sgraphs.graphs GRA 
+		where GRA.graph_id = p_id 
+		and GRA.graph_deleted_at is not null
+	) then 
+		return;
+	end if;
+
 	return query select * from sgraphs.load_graph_metadata(p_id);
 end; $$;
 
 alter function susers.load_graph_metadata owner to upa;
 
 
-create or replace function susers.transitive_visible_graphs_since(p_user_login text, p_id text)
+-- susers.transitive_visible_graphs_since returns the graphs visible from p_id, and user's roles. 
+-- Graphs in trash are ignored unless p_with_trash is set
+create or replace function susers.transitive_visible_graphs_since(p_user_login text, p_id text, p_with_trash bool default false)
 returns table (graph_id text, graph_roles text[]) language plpgsql as $$
 declare
     l_counter int;
@@ -151,7 +163,9 @@ begin
     with visible_graphs as (
         select AGA.resource as graph_id, AGA.role_names
         from susers.all_graphs_authorized_for_user(p_user_login) AGA
-        where 'modifier' =ANY(AGA.role_names) or 'observer' =ANY(AGA.role_names)
+        join sgraphs.graphs GRA on GRA.graph_id = AGA.resource
+        where ('modifier' =ANY(AGA.role_names) or 'observer' =ANY(AGA.role_names))
+        and (p_with_trash or GRA.graph_deleted_at is null)
     ), parents_graphs as (
         select GRA.graph_id, GRA.role_names, 
         INC.source_id as parent_id
@@ -239,7 +253,8 @@ end; $$;
 
 
 -- susers.transitive_load_base_elements_in_graph loads all visible elements from a graph to all its dependencies
-create or replace function susers.transitive_load_base_elements_in_graph(p_user_login text, p_id text, p_period text)
+-- Elements in trash are ignored unless p_with_trash is set
+create or replace function susers.transitive_load_base_elements_in_graph(p_user_login text, p_id text, p_period text, p_with_trash bool default false)
 returns table (
     graph_id text, editable bool, 
     element_id text, element_type int, activity text, traits text[], 
@@ -254,7 +269,7 @@ begin
         select 
         TVGS.graph_id, 
         ('modifier' = ANY(TVGS.graph_roles)) as editable
-        from susers.transitive_visible_graphs_since(p_user_login, p_id) TVGS 
+        from susers.transitive_visible_graphs_since(p_user_login, p_id, p_with_trash) TVGS 
     ), all_elements_in_graphs as (
         select 
         ELT.graph_id, 
@@ -266,6 +281,7 @@ begin
         join all_source_graphs ASG on ASG.graph_id = ELT.graph_id
         join sgraphs.periods PER on PER.period_id = ELT.element_period
         where not sgraphs.are_periods_disjoin(p_period, PER.period_value)
+        and (p_with_trash or ELT.element_deleted_at is null)
     ), all_traits_for_elements as (
         select AEG.element_id, array_agg(TRA.trait) as traits 
         from all_elements_in_graphs AEG
@@ -301,7 +317,7 @@ alter function susers.transitive_load_base_elements_in_graph owner to upa;
 
 
 -- susers.transitive_load_entities_in_graph gets all entities an user may use from a graph
-create or replace function susers.transitive_load_entities_in_graph(p_user_login text, p_id text, p_period text)
+create or replace function susers.transitive_load_entities_in_graph(p_user_login text, p_id text, p_period text, p_with_trash bool default false)
 returns table (
     graph_id text, editable bool, 
     element_id text, activity text, traits text[], 
@@ -316,7 +332,7 @@ begin
         select TLB.graph_id, TLB.editable, 
         TLB.element_id, TLB.activity, TLB.traits, 
         TLB.equivalence_parent, TLB.equivalence_parent_graph
-        from susers.transitive_load_base_elements_in_graph(p_user_login, p_id, p_period) TLB
+        from susers.transitive_load_base_elements_in_graph(p_user_login, p_id, p_period, p_with_trash) TLB
         where TLB.element_type in (1,10)
     ), all_entities as (
         select ETA.entity_id as element_id, ETA.attribute_name as attribute_key,  
@@ -345,7 +361,7 @@ end; $$;
 alter function susers.transitive_load_entities_in_graph owner to upa;
 
 -- susers.transitive_load_relations_in_graph loads all relations in dependent graphs starting at p_id a given graph
-create or replace function susers.transitive_load_relations_in_graph(p_user_login text, p_id text, p_period text)
+create or replace function susers.transitive_load_relations_in_graph(p_user_login text, p_id text, p_period text, p_with_trash bool default false)
 returns table (
     graph_id text, editable bool, 
     element_id text, activity text, traits text[], 
@@ -358,13 +374,13 @@ begin
     return query
     with all_visible_graphs as (
         select TGS.graph_id
-        from susers.transitive_visible_graphs_since(p_user_login, p_id) TGS
+        from susers.transitive_visible_graphs_since(p_user_login, p_id, p_with_trash) TGS
     ), all_source_elements as (
         select TLB.graph_id, TLB.editable, 
         TLB.element_id, TLB.activity, TLB.traits, 
         TLB.equivalence_parent, TLB.equivalence_parent_graph, 
         TLB.element_type
-        from susers.transitive_load_base_elements_in_graph(p_user_login, p_id, p_period) TLB
+        from susers.transitive_load_base_elements_in_graph(p_user_login, p_id, p_period, p_with_trash) TLB
         where TLB.activity <> '];['
     ), all_visible_relations as (
         select 
@@ -410,7 +426,8 @@ end; $$;
 alter function susers.transitive_load_relations_in_graph owner to upa;
 
 
--- susers.upsert_element_in_graph upserts an element from a graph, may raise an exception for auth
+-- susers.upsert_element_in_graph upserts an element from a graph, may raise an exception for auth. 
+-- Upserting an element in trash restores it
 create or replace procedure susers.upsert_element_in_graph(
     p_user_login text,
 	p_graph_id in text, 
@@ -421,16 +438,27 @@ create or replace procedure susers.upsert_element_in_graph(
 ) language plpgsql as $$
 declare 
     l_graph_id text;
+    l_deleted_at timestamp without time zone;
 begin 
     call susers.accept_user_access_to_resource_or_raise(p_user_login, 'graph', ARRAY['modifier'], true, p_graph_id);
+
+    if exists (select 1 from sgraphs.graphs GRA where GRA.graph_id = p_graph_id and GRA.graph_deleted_at is not null) then 
+        raise exception 'graph % is in trash, restore it first', p_graph_id using errcode = '23503';
+    end if;
     
-    select ELT.graph_id into l_graph_id
+    select ELT.graph_id, ELT.element_deleted_at into l_graph_id, l_deleted_at
     from sgraphs.elements ELT 
     where ELT.element_id = p_element_id;
     if l_graph_id is not null and l_graph_id <> p_graph_id then 
         raise exception 'element graph and graph parameter mismatch';
     end if;
 
+    -- upsert sets the content of an element in trash, so it restores it. 
+    -- Its links are upserted next, and they should not link elements in trash
+    if l_deleted_at is not null then 
+        update sgraphs.elements set element_deleted_at = null where element_id = p_element_id;
+    end if;
+
     call sgraphs.upsert_element_in_graph(p_graph_id, p_element_id, p_element_type, p_activity, p_traits); 
 end; $$;
 
@@ -459,7 +487,7 @@ end; $$;
 alter procedure susers.upsert_attributes owner to upa;
 
 
--- susers.upsert_links upserts links if user has access to all underlying graphs
+-- susers.upsert_links upserts links if user has access to all underlying graphs, and if linked elements are not in trash
 create or replace procedure susers.upsert_links(
     p_user_login text, p_role_id text, p_role_name text, 
     p_operands text[], p_periods text[])
@@ -500,6 +528,15 @@ begin
 
     if not l_all_auth then 
         raise exception 'auth failure: missing auth for linked elements graphs';
+    elsif exists (
+        select 1 
+        from unnest(p_operands) OPE(element_id)
+        join sgraphs.elements ELT on ELT.element_id = OPE.element_id
+        join sgraphs.graphs GRA on GRA.graph_id = ELT.graph_id
+        where ELT.element_deleted_at is not null 
+        or GRA.graph_deleted_at is not null
+    ) then 
+        raise exception 'relation % links elements in trash', p_role_id using errcode = '23503';
     else
         call sgraphs.upsert_links(p_role_id, p_role_name, p_operands, p_periods);
     end if;
@@ -507,7 +544,9 @@ end;$$;
 
 alter procedure susers.upsert_links owner to upa;
 
-create or replace function susers.load_element_by_id(p_user_login text, p_element_id text)
+-- susers.load_element_by_id loads an element. 
+-- Elements in trash, or in a graph in trash, are ignored unless p_with_trash is set
+create or replace function susers.load_element_by_id(p_user_login text, p_element_id text, p_with_trash bool default false)
 returns table (
 	element_id text,
 	traits text[], activity text,
@@ -521,7 +560,9 @@ begin
 	
     select ELT.graph_id into l_graph_id 
     from sgraphs.elements ELT 
-    where ELT.element_id = p_element_id;
+    join sgraphs.graphs GRA on GRA.graph_id = ELT.graph_id
+    where ELT.element_id = p_element_id
+    and (p_with_trash or (ELT.element_deleted_at is null and GRA.graph_deleted_at is null));
 
     if l_graph_id is null then 
         -- just returns empty
@@ -580,6 +621,53 @@ end;$$;
 
 alter function susers.load_element_by_id owner to upa;
 
+-- susers.import_cycle_path returns the path of the cycle that importing p_imported into p_base would create, null for no cycle. 
+-- Graphs not visible from user are displayed as hidden. Graphs in trash import nothing, restore checks cycles again
+create or replace function susers.import_cycle_path(p_user_id text, p_base text, p_imported text) 
+returns text language plpgsql as $$
+declare 
+	l_path text[];
+begin 
+	if p_base = p_imported then 
+		l_path = ARRAY[p_base, p_imported];
+	else 
+		-- follow imports of imported graph until base is found 
+		with recursive import_paths(graph_id, path) as (
+			select p_imported, ARRAY[p_base, p_imported]
+			UNION ALL 
+			select INC.source_id, IPA.path || INC.source_id
+			from import_paths IPA 
+			join sgraphs.inclusions INC on INC.child_id = IPA.graph_id
+			join sgraphs.graphs GRA on GRA.graph_id = INC.source_id
+			where not INC.source_id = any(IPA.path[2:])
+			and GRA.graph_deleted_at is null
+		)
+		select IPA.path into l_path
+		from import_paths IPA 
+		where IPA.graph_id = p_base 
+		and array_length(IPA.path, 1) > 2
+		order by array_length(IPA.path, 1)
+		limit 1;
+	end if;
+
+	if l_path is null then 
+		return null;
+	end if;
+
+	return (
+		select string_agg(coalesce(VIS.graph_name, '<hidden>'), ' -> ' order by PAT.position)
+		from unnest(l_path) with ordinality as PAT(graph_id, position)
+		left outer join (
+			select GRA.graph_id, GRA.graph_name 
+			from sgraphs.graphs GRA 
+			join susers.authorized_graphs AAG on AAG.graph_id = GRA.graph_id 
+			where AAG.auth_user_id = p_user_id
+		) VIS on VIS.graph_id = PAT.graph_id
+	);
+end; $$;
+
+alter function susers.import_cycle_path owner to upa;
+
 -- susers.graphs_dynamic_import adds, if possible, an imported graph to source. 
 -- In practice, reload the source graph to see new nodes.
 create or replace procedure susers.graphs_dynamic_import(p_user_login text, p_source_graph_id text, p_graph_to_import text)
@@ -587,6 +675,7 @@ language plpgsql as $$
 declare 
 	l_user_id text;
 	l_editable bool;
+	l_cycle text;
 begin 
 	-- test user and find matching id for authorized graphs
 	select user_id into l_user_id 
@@ -618,14 +707,21 @@ begin
 		raise exception 'imported graph does not exist or cannot be used' using errcode = '42704';
 	end if;
 
-	-- if source appears in graph dependencies, it creates a cycle
-	-- graph -- parent --> source -- to add --> graph as dependency
 	if exists (
 		select 1 
-		from susers.transitive_visible_graphs_since(p_user_login, p_graph_to_import)
-		where graph_id = p_source_graph_id
+		from sgraphs.graphs GRA 
+		where GRA.graph_id in (p_source_graph_id, p_graph_to_import)
+		and GRA.graph_deleted_at is not null
 	) then 
-		raise exception 'importing graph would create cycles' using errcode = '42P19';
+		raise exception 'graph in trash, restore it first' using errcode = '23503';
+	end if;
+
+	-- if source appears in graph dependencies, it creates a cycle
+	-- graph -- parent --> source -- to add --> graph as dependency.
+	-- All imports are followed, visible or not, and path is displayed with names of visible graphs
+	select susers.import_cycle_path(l_user_id, p_source_graph_id, p_graph_to_import) into l_cycle;
+	if l_cycle is not null then 
+		raise exception 'importing graph would create a cycle: %', l_cycle using errcode = '42P19';
 	end if;
 
 	-- operation is valid, but it may include a graph already in dependencies. 
@@ -665,7 +761,7 @@ end; $$;
 
 alter procedure susers.create_equivalent_element_into_graph owner to upa;
 
--- susers.delete_element deletes an element if it does not appear in a relation as a parameter
+-- susers.delete_element moves an element to the trash if it does not appear in a relation as a parameter
 create or replace procedure susers.delete_element(p_user_login text, p_element_id text)
 language plpgsql as $$
 declare 
@@ -673,7 +769,8 @@ declare
 begin 
 	select ELT.graph_id into l_graph_id 
 	from sgraphs.elements ELT 
-	where ELT.element_id = p_element_id;
+	where ELT.element_id = p_element_id
+	and ELT.element_deleted_at is null;
 
 	if l_graph_id is null then 
 		-- no element, no action 
@@ -686,20 +783,21 @@ begin
 	if exists (
 		select 1
 		from sgraphs.relation_role_values RRV
+		join sgraphs.relation_role RRO on RRO.relation_role_id = RRV.relation_role_id
+		join sgraphs.elements ELT on ELT.element_id = RRO.relation_id
 		where RRV.relation_value = p_element_id
+		and ELT.element_deleted_at is null
 	) then 
 		raise exception 'a relation depends on current element to delete' using errcode = '23503';
 	end if;
 
-	-- ok to delete 
-	delete from sgraphs.relation_role where relation_id = p_element_id;
-	delete from sgraphs.entity_attributes where entity_id = p_element_id;
-	delete from sgraphs.elements where element_id = p_element_id;
+	-- ok to delete, purge will remove it 
+	update sgraphs.elements set element_deleted_at = now() where element_id = p_element_id;
 end; $$;
 
 alter procedure susers.delete_element owner to upa;
 
--- susers.delete_graph deletes a graph if no relation depends on an element within that graph
+-- susers.delete_graph moves a graph to the trash if no relation depends on an element within that graph
 create or replace procedure susers.delete_graph(p_user_login text, p_graph_id text)
 language plpgsql as $$
 declare 
@@ -708,7 +806,8 @@ declare
 begin 
 	select GRA.graph_id into l_graph_id 
 	from sgraphs.graphs GRA 
-	where GRA.graph_id = p_graph_id;
+	where GRA.graph_id = p_graph_id
+	and GRA.graph_deleted_at is null;
 
 	if l_graph_id is null then 
 		-- no matching id, no action 
@@ -726,8 +825,11 @@ begin
 		select RRV.relation_value as role_operands  
 		from sgraphs.relation_role RRO
         join sgraphs.elements ELT on ELT.element_id = RRO.relation_id
+        join sgraphs.graphs GRA on GRA.graph_id = ELT.graph_id
         join sgraphs.relation_role_values RRV on RRV.relation_role_id = RRO.relation_role_id 
         where ELT.graph_id <> l_graph_id
+        and ELT.element_deleted_at is null
+        and GRA.graph_deleted_at is null
 	), all_dependencies as (
 		select count(*) as counter
 		from all_external_operands ALO 
@@ -738,10 +840,8 @@ begin
 		raise exception 'forbidden: a relation outside the graph depends on an element in the graph' using errcode = '23503';
 	end if;
 
-	-- ok to delete 
-	delete from sgraphs.elements where graph_id = p_graph_id;
-    delete from sgraphs.graphs where graph_id = p_graph_id;
-    call susers.delete_resource('graph', p_graph_id);
+	-- ok to delete, purge will remove it
+	update sgraphs.graphs set graph_deleted_at = now() where graph_id = p_graph_id;
 end; $$;
 
 alter procedure susers.delete_graph owner to upa;
@@ -783,3 +883,510 @@ begin
 end; $$;
 
 alter procedure susers.clear_graphs owner to upa;
+
+-- susers.merge_elements merges sources into target if user may modify all involved graphs.
+-- Involved graphs are the graphs of the target, of the sources, and of relations linking sources.
+-- It returns the graph of the target
+create or replace function susers.merge_elements(
+    p_user_login text, p_merge_id text, p_target_id text, p_sources text[], 
+    p_target_snapshot jsonb, p_sources_snapshots jsonb[]
+) returns text language plpgsql as $$
+declare 
+    l_target_graph text;
+    l_graph_id text;
+begin 
+    select ELT.graph_id into l_target_graph
+    from sgraphs.elements ELT 
+    join sgraphs.graphs GRA on GRA.graph_id = ELT.graph_id
+    where ELT.element_id = p_target_id
+    and ELT.element_deleted_at is null 
+    and GRA.graph_deleted_at is null;
+
+    if l_target_graph is null then 
+        raise exception 'no target matching id %', p_target_id using errcode = 'P0002';
+    elsif coalesce(array_length(p_sources, 1), 0) = 0 then 
+        raise exception 'need at least one source to merge' using errcode = '22023';
+    elsif array_length(p_sources, 1) <> coalesce(array_length(p_sources_snapshots, 1), 0) then 
+        raise exception 'different sizes for sources and snapshots' using errcode = '22023';
+    elsif p_target_id = any(p_sources) then 
+        raise exception 'cannot merge an element with itself' using errcode = '22023';
+    end if;
+
+    if exists (
+        select 1 
+        from unnest(p_sources) SRC(element_id)
+        left outer join (
+            select ELT.element_id 
+            from sgraphs.elements ELT 
+            join sgraphs.graphs GRA on GRA.graph_id = ELT.graph_id
+            where ELT.element_deleted_at is null 
+            and GRA.graph_deleted_at is null
+        ) ELT on ELT.element_id = SRC.element_id
+        where ELT.element_id is null
+    ) then 
+        raise exception 'no source matching id' using errcode = 'P0002';
+    end if;
+
+    -- user should be able to modify all involved graphs. 
+    -- Relations in trash are not redirected, they are not involved
+    for l_graph_id in 
+        select ELT.graph_id 
+        from sgraphs.elements ELT 
+        where ELT.element_id = p_target_id or ELT.element_id = any(p_sources)
+        UNION 
+        select REL.graph_id 
+        from sgraphs.relation_role_values RRV 
+        join sgraphs.relation_role RRO on RRO.relation_role_id = RRV.relation_role_id
+        join sgraphs.elements REL on REL.element_id = RRO.relation_id
+        join sgraphs.graphs GRA on GRA.graph_id = REL.graph_id
+        where RRV.relation_value = any(p_sources)
+        and REL.element_deleted_at is null 
+        and GRA.graph_deleted_at is null
+    loop 
+        call susers.accept_user_access_to_resource_or_raise(p_user_login, 'graph', ARRAY['modifier'], true, l_graph_id);
+        -- redirected operands should still be visible from the relations graphs
+        if not exists (
+            select 1 
+            from susers.transitive_visible_graphs_since(p_user_login, l_graph_id) TVGS 
+            where TVGS.graph_id = l_target_graph
+        ) then 
+            raise exception 'target is not visible from graph %', l_graph_id using errcode = '23503';
+        end if;
+    end loop;
+
+    call sgraphs.merge_elements(p_merge_id, p_target_id, p_sources, p_target_snapshot, p_sources_snapshots);
+    return l_target_graph;
+end; $$;
+
+alter function susers.merge_elements owner to upa;
+
+-- susers.load_merge_for_user returns target and sources of a merge as they were before the merge.
+-- User should be able to modify all the graphs involved in the merge
+create or replace function susers.load_merge_for_user(p_user_login text, p_merge_id text) 
+returns table (element_id text, graph_id text, snapshot text, is_target bool) 
+language plpgsql as $$
+declare 
+    l_graph_id text;
+begin 
+    if not exists (select 1 from sgraphs.merges MER where MER.merge_id = p_merge_id) then 
+        raise exception 'no merge matching id %', p_merge_id using errcode = 'P0002';
+    end if;
+
+    for l_graph_id in 
+        select MER.target_graph_id from sgraphs.merges MER where MER.merge_id = p_merge_id
+        UNION 
+        select MSO.source_graph_id from sgraphs.merge_sources MSO where MSO.merge_id = p_merge_id
+        UNION 
+        select ELT.graph_id 
+        from sgraphs.merge_links MLI 
+        join sgraphs.elements ELT on ELT.element_id = MLI.relation_id
+        where MLI.merge_id = p_merge_id
+    loop 
+        call susers.accept_user_access_to_resource_or_raise(p_user_login, 'graph', ARRAY['modifier'], true, l_graph_id);
+    end loop;
+
+    return query 
+    select MER.target_id, MER.target_graph_id, MER.target_snapshot::text, true
+    from sgraphs.merges MER 
+    where MER.merge_id = p_merge_id
+    UNION ALL
+    select MSO.source_id, MSO.source_graph_id, MSO.source_snapshot::text, false
+    from sgraphs.merge_sources MSO 
+    where MSO.merge_id = p_merge_id;
+end; $$;
+
+alter function susers.load_merge_for_user owner to upa;
+
+-- susers.undo_merge restores links of a merge once sources exist again
+create or replace procedure susers.undo_merge(p_user_login text, p_merge_id text) 
+language plpgsql as $$
+declare 
+begin 
+    -- just to check auth
+    perform susers.load_merge_for_user(p_user_login, p_merge_id);
+    call sgraphs.undo_merge(p_merge_id);
+end; $$;
+
+alter procedure susers.undo_merge owner to upa;
+
+-- susers.relations_to_split returns the element to split and the relations linking it, with their graphs.
+-- User should be able to modify all those graphs. Elements and relations in trash are ignored.
+-- Element and relations are locked until the end of the transaction, so that they do not change during the split
+create or replace function susers.relations_to_split(p_user_login text, p_element_id text) 
+returns table (element_id text, graph_id text) 
+language plpgsql as $$
+declare 
+    l_graph_id text;
+begin 
+    select ELT.graph_id into l_graph_id
+    from sgraphs.elements ELT 
+    join sgraphs.graphs GRA on GRA.graph_id = ELT.graph_id
+    where ELT.element_id = p_element_id
+    and ELT.element_deleted_at is null 
+    and GRA.graph_deleted_at is null
+    for update of ELT;
+
+    if l_graph_id is null then 
+        raise exception 'no element matching id %', p_element_id using errcode = 'P0002';
+    end if;
+
+    call susers.accept_user_access_to_resource_or_raise(p_user_login, 'graph', ARRAY['modifier'], true, l_graph_id);
+
+    for l_graph_id in 
+        select distinct ELT.graph_id 
+        from sgraphs.relation_role_values RRV 
+        join sgraphs.relation_role RRO on RRO.relation_role_id = RRV.relation_role_id
+        join sgraphs.elements ELT on ELT.element_id = RRO.relation_id
+        join sgraphs.graphs GRA on GRA.graph_id = ELT.graph_id
+        where RRV.relation_value = p_element_id
+        and ELT.element_deleted_at is null 
+        and GRA.graph_deleted_at is null
+    loop 
+        call susers.accept_user_access_to_resource_or_raise(p_user_login, 'graph', ARRAY['modifier'], true, l_graph_id);
+    end loop;
+
+    perform ELT.element_id 
+    from sgraphs.elements ELT 
+    where ELT.element_id in (
+        select RRO.relation_id
+        from sgraphs.relation_role_values RRV 
+        join sgraphs.relation_role RRO on RRO.relation_role_id = RRV.relation_role_id
+        where RRV.relation_value = p_element_id
+    )
+    and ELT.element_deleted_at is null 
+    order by ELT.element_id
+    for update;
+
+    return query 
+    select ELT.element_id, ELT.graph_id 
+    from sgraphs.elements ELT 
+    where ELT.element_id = p_element_id
+    UNION ALL
+    select distinct ELT.element_id, ELT.graph_id
+    from sgraphs.relation_role_values RRV 
+    join sgraphs.relation_role RRO on RRO.relation_role_id = RRV.relation_role_id
+    join sgraphs.elements ELT on ELT.element_id = RRO.relation_id
+    join sgraphs.graphs GRA on GRA.graph_id = ELT.graph_id
+    where RRV.relation_value = p_element_id
+    and ELT.element_deleted_at is null 
+    and GRA.graph_deleted_at is null;
+end; $$;
+
+alter function susers.relations_to_split owner to upa;
+
+-- susers.equivalence_parent_for_user returns the graph of a copy, its equivalence parent and graph, 
+-- and the parent snapshot at last synchronisation if any. 
+-- User should see both graphs 
+create or replace function susers.equivalence_parent_for_user(p_user_login text, p_element_id text) 
+returns table (graph_id text, parent_id text, parent_graph_id text, base_snapshot text) 
+language plpgsql as $$
+declare 
+    l_graph_id text;
+    l_parent_id text;
+    l_parent_graph_id text;
+begin 
+    select ELT.graph_id into l_graph_id
+    from sgraphs.elements ELT 
+    where ELT.element_id = p_element_id;
+
+    if l_graph_id is null then 
+        raise exception 'no element matching id %', p_element_id using errcode = 'P0002';
+    end if;
+
+    call susers.accept_user_access_to_resource_or_raise(p_user_login, 'graph', ARRAY['modifier','observer'], false, l_graph_id);
+
+    select NOD.source_element_id, ELT.graph_id into l_parent_id, l_parent_graph_id
+    from sgraphs.nodes NOD
+    join sgraphs.elements ELT on ELT.element_id = NOD.source_element_id
+    where NOD.child_element_id = p_element_id
+    limit 1;
+
+    if l_parent_id is null then 
+        raise exception 'element % is not a copy', p_element_id using errcode = 'P0002';
+    end if;
+
+    call susers.accept_user_access_to_resource_or_raise(p_user_login, 'graph', ARRAY['modifier','observer'], false, l_parent_graph_id);
+
+    return query 
+    select l_graph_id, l_parent_id, l_parent_graph_id, 
+    (select EQB.base_snapshot::text from sgraphs.equivalence_bases EQB where EQB.child_element_id = p_element_id);
+end; $$;
+
+alter function susers.equivalence_parent_for_user owner to upa;
+
+-- susers.list_graph_imports_for_user returns direct imports between graphs the user may see. 
+-- Graphs in trash are ignored
+create or replace function susers.list_graph_imports_for_user(p_user_login text) 
+returns table (graph_id text, imported_graph_id text) 
+language plpgsql as $$
+declare 
+begin 
+    return query 
+    with visible_graphs as (
+        select AGA.resource as graph_id
+        from susers.all_graphs_authorized_for_user(p_user_login) AGA
+        join sgraphs.graphs GRA on GRA.graph_id = AGA.resource
+        where ('modifier' = ANY(AGA.role_names) or 'observer' = ANY(AGA.role_names))
+        and GRA.graph_deleted_at is null
+    )
+    select INC.child_id, INC.source_id 
+    from sgraphs.inclusions INC 
+    join visible_graphs VCH on VCH.graph_id = INC.child_id
+    join visible_graphs VSO on VSO.graph_id = INC.source_id
+    order by INC.child_id, INC.source_id;
+end; $$;
+
+alter function susers.list_graph_imports_for_user owner to upa;
+
+-- susers.remove_graph_import removes a direct import of a graph. 
+-- It raises an exception if relations of base graph, or of graphs importing it (directly or not), would link elements no longer visible 
+create or replace procedure susers.remove_graph_import(p_user_login text, p_base_graph_id text, p_imported_graph_id text) 
+language plpgsql as $$
+declare 
+begin 
+    call susers.accept_user_access_to_resource_or_raise(p_user_login, 'graph', ARRAY['modifier'], true, p_base_graph_id);
+
+    if not exists (
+        select 1 
+        from sgraphs.inclusions 
+        where child_id = p_base_graph_id 
+        and source_id = p_imported_graph_id
+    ) then 
+        raise exception 'graph % does not import %', p_base_graph_id, p_imported_graph_id using errcode = 'P0002';
+    end if;
+
+    delete from sgraphs.inclusions 
+    where child_id = p_base_graph_id 
+    and source_id = p_imported_graph_id;
+
+    -- relations of the base graph and of graphs importing it should still see their operands
+    if exists (
+        with recursive dependent_graphs(graph_id) as (
+            select p_base_graph_id
+            UNION 
+            select INC.child_id 
+            from dependent_graphs DEG 
+            join sgraphs.inclusions INC on INC.source_id = DEG.graph_id
+        ), remaining_imports(graph_id, imported_id) as (
+            select DEG.graph_id, DEG.graph_id 
+            from dependent_graphs DEG
+            UNION 
+            select REI.graph_id, INC.source_id 
+            from remaining_imports REI 
+            join sgraphs.inclusions INC on INC.child_id = REI.imported_id
+        )
+        select 1 
+        from sgraphs.elements REL 
+        join dependent_graphs DEG on DEG.graph_id = REL.graph_id
+        join sgraphs.relation_role RRO on RRO.relation_id = REL.element_id
+        join sgraphs.relation_role_values RRV on RRV.relation_role_id = RRO.relation_role_id
+        join sgraphs.elements OPE on OPE.element_id = RRV.relation_value
+        where not exists (
+            select 1 
+            from remaining_imports REI 
+            where REI.graph_id = REL.graph_id 
+            and REI.imported_id = OPE.graph_id
+        )
+    ) then 
+        raise exception 'relations in graph % or in graphs importing it link elements from removed import', p_base_graph_id using errcode = '23503';
+    end if;
+end; $$;
+
+alter procedure susers.remove_graph_import owner to upa;
+
+-- susers.add_equivalence_link sets parent as the equivalence parent of child. 
+-- User should see parent and modify child
+create or replace procedure susers.add_equivalence_link(p_user_login text, p_parent_id text, p_child_id text) 
+language plpgsql as $$
+declare 
+    l_parent_graph_id text;
+    l_child_graph_id text;
+begin 
+    select ELT.graph_id into l_parent_graph_id from sgraphs.elements ELT where ELT.element_id = p_parent_id;
+    select ELT.graph_id into l_child_graph_id from sgraphs.elements ELT where ELT.element_id = p_child_id;
+    if l_parent_graph_id is null or l_child_graph_id is null then 
+        raise exception 'no element for equivalence link' using errcode = 'P0002';
+    end if;
+
+    call susers.accept_user_access_to_resource_or_raise(p_user_login, 'graph', ARRAY['modifier','observer'], false, l_parent_graph_id);
+    call susers.accept_user_access_to_resource_or_raise(p_user_login, 'graph', ARRAY['modifier'], true, l_child_graph_id);
+    call sgraphs.add_equivalence_link(p_parent_id, p_child_id);
+end; $$;
+
+alter procedure susers.add_equivalence_link owner to upa;
+
+-- susers.equivalence_bases_for_graph returns, for each copy in the graph, the parent snapshot at last synchronisation. 
+-- User should see the graph
+create or replace function susers.equivalence_bases_for_graph(p_user_login text, p_graph_id text) 
+returns table (element_id text, base_snapshot text) 
+language plpgsql as $$
+declare 
+begin 
+    call susers.accept_user_access_to_resource_or_raise(p_user_login, 'graph', ARRAY['modifier','observer'], false, p_graph_id);
+
+    return query 
+    select EQB.child_element_id, EQB.base_snapshot::text 
+    from sgraphs.equivalence_bases EQB
+    join sgraphs.elements ELT on ELT.element_id = EQB.child_element_id
+    where ELT.graph_id = p_graph_id;
+end; $$;
+
+alter function susers.equivalence_bases_for_graph owner to upa;
+
+-- susers.list_trash_for_user returns the elements in trash for a graph, and graph itself if it is in trash. 
+-- Element type is null for the graph. User should see the graph
+create or replace function susers.list_trash_for_user(p_user_login text, p_graph_id text) 
+returns table (item_id text, element_type int, deleted_at timestamp without time zone) 
+language plpgsql as $$
+declare 
+begin 
+    if not exists (select 1 from sgraphs.graphs GRA where GRA.graph_id = p_graph_id) then 
+        raise exception 'no graph matching id %', p_graph_id using errcode = 'P0002';
+    end if;
+
+    call susers.accept_user_access_to_resource_or_raise(p_user_login, 'graph', ARRAY['modifier','observer'], false, p_graph_id);
+
+    return query 
+    select GRA.graph_id, null::int, GRA.graph_deleted_at
+    from sgraphs.graphs GRA 
+    where GRA.graph_id = p_graph_id
+    and GRA.graph_deleted_at is not null
+    UNION ALL 
+    select ELT.element_id, ELT.element_type, ELT.element_deleted_at
+    from sgraphs.elements ELT 
+    where ELT.graph_id = p_graph_id
+    and ELT.element_deleted_at is not null;
+end; $$;
+
+alter function susers.list_trash_for_user owner to upa;
+
+-- susers.restore_element restores an element from the trash. 
+-- Graph should not be in trash, and operands of a relation should not be in trash either. 
+-- Sources of a merge are in trash until the merge is undone
+create or replace procedure susers.restore_element(p_user_login text, p_element_id text) 
+language plpgsql as $$
+declare 
+    l_graph_id text;
+    l_graph_deleted_at timestamp without time zone;
+begin 
+    select ELT.graph_id, GRA.graph_deleted_at into l_graph_id, l_graph_deleted_at
+    from sgraphs.elements ELT 
+    join sgraphs.graphs GRA on GRA.graph_id = ELT.graph_id
+    where ELT.element_id = p_element_id
+    and ELT.element_deleted_at is not null;
+
+    if l_graph_id is null then 
+        raise exception 'no element in trash matching id %', p_element_id using errcode = 'P0002';
+    end if;
+
+    call susers.accept_user_access_to_resource_or_raise(p_user_login, 'graph', ARRAY['modifier'], true, l_graph_id);
+
+    if l_graph_deleted_at is not null then 
+        raise exception 'graph % is in trash, restore it first', l_graph_id using errcode = '23503';
+    elsif exists (
+        select 1 
+        from sgraphs.relation_role RRO 
+        join sgraphs.relation_role_values RRV on RRV.relation_role_id = RRO.relation_role_id
+        join sgraphs.elements ELT on ELT.element_id = RRV.relation_value
+        join sgraphs.graphs GRA on GRA.graph_id = ELT.graph_id
+        where RRO.relation_id = p_element_id
+        and (ELT.element_deleted_at is not null or GRA.graph_deleted_at is not null)
+    ) then 
+        raise exception 'relation % links elements in trash', p_element_id using errcode = '23503';
+    elsif exists (select 1 from sgraphs.merge_sources MSO where MSO.source_id = p_element_id) then 
+        raise exception 'element % was merged, undo the merge to restore it', p_element_id using errcode = '23503';
+    end if;
+
+    update sgraphs.elements set element_deleted_at = null where element_id = p_element_id;
+end; $$;
+
+alter procedure susers.restore_element owner to upa;
+
+-- susers.restore_graph restores a graph from the trash, with the elements it contained when deleted
+create or replace procedure susers.restore_graph(p_user_login text, p_graph_id text) 
+language plpgsql as $$
+declare 
+begin 
+    if not exists (
+        select 1 from sgraphs.graphs GRA 
+        where GRA.graph_id = p_graph_id 
+        and GRA.graph_deleted_at is not null
+    ) then 
+        raise exception 'no graph in trash matching id %', p_graph_id using errcode = 'P0002';
+    end if;
+
+    call susers.accept_user_access_to_resource_or_raise(p_user_login, 'graph', ARRAY['manager'], true, p_graph_id);
+
+    update sgraphs.graphs set graph_deleted_at = null where graph_id = p_graph_id;
+
+    -- cycles ignore graphs in trash, restored graph may close one
+    if exists (
+        with recursive imported_graphs(graph_id) as (
+            select INC.source_id 
+            from sgraphs.inclusions INC 
+            join sgraphs.graphs GRA on GRA.graph_id = INC.source_id
+            where INC.child_id = p_graph_id
+            and GRA.graph_deleted_at is null
+            UNION 
+            select INC.source_id 
+            from imported_graphs IMG 
+            join sgraphs.inclusions INC on INC.child_id = IMG.graph_id
+            join sgraphs.graphs GRA on GRA.graph_id = INC.source_id
+            where GRA.graph_deleted_at is null
+        )
+        select 1 from imported_graphs IMG where IMG.graph_id = p_graph_id
+    ) then 
+        raise exception 'restoring graph % would create an import cycle', p_graph_id using errcode = '42P19';
+    end if;
+end; $$;
+
+alter procedure susers.restore_graph owner to upa;
+
+-- susers.purge_trash deletes elements and graphs in trash for longer than retention, with their resources, 
+-- and returns the number of deleted elements and graphs. 
+-- Relations in trash linking deleted elements would lose operands, they are deleted too. 
+-- It is a maintenance operation, not available to users
+create or replace function susers.purge_trash(p_retention interval) 
+returns table (purged_elements bigint, purged_graphs bigint)
+language plpgsql as $$
+declare 
+	l_limit timestamp without time zone;
+	l_elements bigint;
+	l_graphs_ids text[];
+	l_graph_id text;
+begin 
+	select now() - p_retention into l_limit;
+
+	select coalesce(array_agg(GRA.graph_id), '{}') into l_graphs_ids
+	from sgraphs.graphs GRA 
+	where GRA.graph_deleted_at < l_limit;
+
+	with recursive purged_elements(element_id) as (
+		select ELT.element_id 
+		from sgraphs.elements ELT 
+		where ELT.element_deleted_at < l_limit
+		or ELT.graph_id = any(l_graphs_ids)
+		UNION 
+		select REL.element_id 
+		from purged_elements PEL 
+		join sgraphs.relation_role_values RRV on RRV.relation_value = PEL.element_id
+		join sgraphs.relation_role RRO on RRO.relation_role_id = RRV.relation_role_id
+		join sgraphs.elements REL on REL.element_id = RRO.relation_id
+		join sgraphs.graphs GRA on GRA.graph_id = REL.graph_id
+		where REL.element_deleted_at is not null 
+		or GRA.graph_deleted_at is not null
+	), deleted_elements as (
+		delete from sgraphs.elements ELT
+		where ELT.element_id in (select PEL.element_id from purged_elements PEL)
+		returning ELT.element_id
+	) select count(*) into l_elements from deleted_elements;
+
+	foreach l_graph_id in array l_graphs_ids loop 
+		delete from sgraphs.graphs GRA where GRA.graph_id = l_graph_id;
+		call susers.delete_resource('graph', l_graph_id);
+	end loop;
+
+	return query select l_elements, cardinality(l_graphs_ids)::bigint;
+end; $$;
+
+alter function susers.purge_trash owner to upa;
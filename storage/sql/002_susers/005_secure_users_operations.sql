@@ -40,7 +40,8 @@ begin
 		update susers.users 
 		set user_salt = l_salt, 
 		user_secret = susers.generate_random_string(), 
-		user_hash = l_text_hash
+		user_hash = l_text_hash,
+		user_tokens_not_before = now()
 		where user_id = l_user;
 	end if;	
 end; $$;
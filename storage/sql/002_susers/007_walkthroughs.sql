@@ -1,8 +1,12 @@
 -- susers.authorized_graphs gets authorized graphs for all users. 
 -- Columns are user id, graph id, and editable set to true for modifiable graphs. 
--- Note that all graphs are visible. 
+-- Note that all graphs are visible, except graphs in trash. 
+-- When request uses an api key, graphs and roles are restricted to the graphs and roles of that key
 create or replace view susers.authorized_graphs(auth_user_id, graph_id, editable) as 
-with all_source_auths as (
+with key_restriction as (
+	select KRE.p_allowed_graphs, KRE.p_allowed_roles 
+	from susers.current_api_key_restriction() KRE
+), all_source_auths as (
 	select
 	AUT.auth_user_id, AUT.auth_id, ROL.role_name,
 	AUT.auth_all_resources, AUT.auth_inclusion, RAT.resource
@@ -21,6 +25,7 @@ with all_source_auths as (
 	from all_source_auths ASA 
 	cross join sgraphs.graphs GRA
 	where ASA.resource is null 
+	and GRA.graph_deleted_at is null
 	UNION 
 	select 
 	ASA.auth_user_id,
@@ -28,6 +33,7 @@ with all_source_auths as (
 	from all_source_auths ASA 
 	join sgraphs.graphs GRA on GRA.graph_id = ASA.resource
 	where ASA.resource is not null 
+	and GRA.graph_deleted_at is null
 ), auths_diff as (
 	select ATR.auth_user_id,
 	ATR.graph_id, ATR.role_name, array_agg(distinct ATR.auth_inclusion) as auth_inclusion
@@ -43,8 +49,11 @@ with all_source_auths as (
 )
 select AAG.auth_user_id, 
 AAG.graph_id, 
-('modifier' = ANY(AAG.role_names)) as editable 
-from auth_agg AAG;
+('modifier' = ANY(AAG.role_names) and (KRE.p_allowed_roles is null or 'modifier' = ANY(KRE.p_allowed_roles))) as editable 
+from auth_agg AAG
+cross join key_restriction KRE 
+where (KRE.p_allowed_graphs is null or AAG.graph_id = ANY(KRE.p_allowed_graphs))
+and (KRE.p_allowed_roles is null or AAG.role_names && KRE.p_allowed_roles);
 
 alter view susers.authorized_graphs owner to upa;
 
@@ -111,7 +120,7 @@ begin
 	-- first hight is 0
 	l_current_height = 0;
 
-	-- delete elements that are NOT visible from said user. 
+	-- delete elements that are NOT visible from said user, or in trash. 
 	-- We keep inactive elements, for user to deal with it. 
 	with all_authorized_graphs as (
         select TAG.graph_id
@@ -127,6 +136,7 @@ begin
 			from all_authorized_graphs AAG 
 			join sgraphs.elements ELT on TWA.element_id = ELT.element_id
 			where AAG.graph_id = ELT.graph_id 
+			and ELT.element_deleted_at is null
 		)
 		and TWA.walkthrough_id = p_walkthrough_id
 	);
@@ -145,6 +155,7 @@ begin
 			from all_authorized_graphs AAG 
 			join sgraphs.elements ELT on TWA.relation_operand = ELT.element_id
 			where AAG.graph_id = ELT.graph_id 
+			and ELT.element_deleted_at is null
 		)
 		and TWA.walkthrough_id = p_walkthrough_id
 	);
@@ -192,6 +203,7 @@ begin
 		join all_authorized_graphs AAG on AAG.graph_id = ELT.graph_id
 		where not sgraphs.are_periods_disjoin(p_period, PER.period_value)
 		and not sgraphs.are_periods_disjoin(p_period, PERLINK.period_value)
+		and ELT.element_deleted_at is null
 	), active_relations_with_visible_operands as (
 		select ARWO.relation_id, ARWO.relation_role, ARWO.relation_value  
 		from active_relations_with_operands ARWO 
@@ -203,6 +215,7 @@ begin
 			join sgraphs.elements ELTIN on ELTIN.element_id = ARWOIN.relation_value
 			left outer join all_authorized_graphs AAGIN on AAGIN.graph_id = ELTIN.graph_id 
 			where AAGIN.graph_id is null 
+			or ELTIN.element_deleted_at is not null
 		)
 	), new_elements_to_insert as (
 		select ARWVO.relation_id, ARWVO.relation_role, ARWVO.relation_value 
@@ -248,6 +261,7 @@ begin
 			join sgraphs.periods PER on PER.period_id = ELT.element_period 
 			-- restrict to active relations 
 			where not sgraphs.are_periods_disjoin(p_period, PER.period_value)
+			and ELT.element_deleted_at is null
 			and TW.walkthrough_id = p_walkthrough_id
 			-- and are inserted last
 			and TW.height = l_max_previous_height
@@ -268,6 +282,7 @@ begin
 				join sgraphs.elements ELTIN on ELTIN.element_id = AACRIN.relation_value  
 				left outer join all_authorized_graphs AAGIN on AAGIN.graph_id = ELTIN.graph_id
 				where AAGIN.graph_id is null 
+				or ELTIN.element_deleted_at is not null
 			)
 		), new_visible_relations as (
 			-- from visible relations, get only relations that were not inserted
@@ -479,6 +494,7 @@ begin
 			where TRA.trait = p_matching_trait
 			and not sgraphs.are_periods_disjoin(p_period, PER.period_value)
 			and ELT.element_type in (1,10)
+			and ELT.element_deleted_at is null
 		)
 		insert into  temp_walkthroughs(walkthrough_id,element_id,relation_role,relation_operand,height)
 		select p_walkthrough_id, MTE.element_id, null, null, 0
@@ -498,6 +514,7 @@ begin
 			where TRA.trait = p_matching_trait
 			and not sgraphs.are_periods_disjoin(p_period, PER.period_value)
 			and ELT.element_type in (1,10)
+			and ELT.element_deleted_at is null
 		), param_attributes as (
 			select unnest(p_attributes) as attr_key, unnest(p_values) as attr_value 
 		), matching_values as (
@@ -526,4 +543,68 @@ begin
 	call susers.find_neighbors_for_walkthrough(p_user_login, p_walkthrough_id, p_period);
 end;$$;
 
-alter procedure susers.find_neighbors_of_matching_entities owner to upa;
\ No newline at end of file
+alter procedure susers.find_neighbors_of_matching_entities owner to upa;
+
+-- susers.element_lineage_for_user returns the equivalence lineage of an element: 
+-- ancestors (negative depth), element (depth 0) and copies (positive depth), transitively. 
+-- Only elements in graphs the user may see are returned, and parents that are not visible are null.
+create or replace function susers.element_lineage_for_user(p_user_login text, p_element_id text)
+returns table (element_id text, graph_id text, parent_id text, depth int) 
+language plpgsql as $$
+declare 
+	l_graph_id text;
+begin 
+	select ELT.graph_id into l_graph_id
+	from sgraphs.elements ELT 
+	where ELT.element_id = p_element_id;
+
+	if l_graph_id is null then 
+		raise exception 'no element matching id %', p_element_id using errcode = 'P0002';
+	end if;
+
+	call susers.accept_user_access_to_resource_or_raise(p_user_login, 'graph', ARRAY['modifier','observer'], false, l_graph_id);
+
+	return query 
+	with recursive ancestors(lineage_id, lineage_depth) as (
+		select p_element_id, 0
+		UNION
+		select NOD.source_element_id, ANC.lineage_depth - 1
+		from ancestors ANC 
+		join sgraphs.nodes NOD on NOD.child_element_id = ANC.lineage_id
+		-- lineage should not loop, but better safe than sorry 
+		where ANC.lineage_depth > -1000
+	), descendants(lineage_id, lineage_depth) as (
+		select p_element_id, 0
+		UNION
+		select NOD.child_element_id, DES.lineage_depth + 1
+		from descendants DES 
+		join sgraphs.nodes NOD on NOD.source_element_id = DES.lineage_id
+		where DES.lineage_depth < 1000
+	), lineage as (
+		select ANC.lineage_id, min(ANC.lineage_depth) as lineage_depth from ancestors ANC group by ANC.lineage_id
+		UNION ALL 
+		select DES.lineage_id, min(DES.lineage_depth) from descendants DES where DES.lineage_depth > 0 group by DES.lineage_id
+	), visible_graphs as (
+		select AAG.graph_id
+		from susers.authorized_graphs AAG 
+		join susers.users USR on USR.user_id = AAG.auth_user_id 
+		where USR.user_login = p_user_login
+	), visible_lineage as (
+		select LIN.lineage_id, ELT.graph_id as lineage_graph, LIN.lineage_depth
+		from lineage LIN 
+		join sgraphs.elements ELT on ELT.element_id = LIN.lineage_id
+		join visible_graphs VGR on VGR.graph_id = ELT.graph_id
+	)
+	select VLI.lineage_id, VLI.lineage_graph, 
+	(
+		select NOD.source_element_id 
+		from sgraphs.nodes NOD 
+		join visible_lineage PAR on PAR.lineage_id = NOD.source_element_id
+		where NOD.child_element_id = VLI.lineage_id
+		limit 1
+	), VLI.lineage_depth
+	from visible_lineage VLI 
+	order by VLI.lineage_depth, VLI.lineage_id;
+end; $$;
+
+alter function susers.element_lineage_for_user owner to upa;
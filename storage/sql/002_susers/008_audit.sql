@@ -0,0 +1,120 @@
+-- susers.reject_audit_changes forbids changes in audit entries, log is append only
+create or replace function susers.reject_audit_changes() returns trigger
+language plpgsql as $$
+begin 
+	raise exception 'audit entries are append only' using errcode = '42501';
+end; $$;
+
+alter function susers.reject_audit_changes owner to upa;
+
+create or replace trigger audit_entries_append_only 
+before update or delete on susers.audit_entries 
+for each row execute function susers.reject_audit_changes();
+
+-- susers.set_audit_actor sets the actor of the audit entries of current transaction
+create or replace procedure susers.set_audit_actor(p_actor text) 
+language plpgsql as $$
+begin 
+	perform set_config('patterns.audit_actor', p_actor, true);
+end; $$;
+
+alter procedure susers.set_audit_actor owner to upa;
+
+-- susers.insert_audit_entry appends an entry to the audit log. 
+-- Target user is a login, it may be null. 
+-- If graph is null, it is the graph of the first element, if any
+create or replace procedure susers.insert_audit_entry(
+	p_actor text, p_operation text, 
+	p_graph_id text, p_target_login text, 
+	p_element_ids text[], p_changes jsonb
+) language plpgsql as $$
+declare 
+	l_user_id text;
+	l_graph_id text;
+begin 
+	l_graph_id = p_graph_id;
+	if l_graph_id is null and cardinality(p_element_ids) > 0 then 
+		select ELT.graph_id into l_graph_id from sgraphs.elements ELT where ELT.element_id = p_element_ids[1];
+	end if;
+
+	if p_target_login is not null then 
+		select USR.user_id into l_user_id from susers.users USR where USR.user_login = p_target_login;
+	end if;
+
+	insert into susers.audit_entries(
+		audit_actor, audit_operation, audit_graph_id, 
+		audit_user_id, audit_element_ids, audit_changes
+	) values (p_actor, p_operation, l_graph_id, l_user_id, p_element_ids, p_changes);
+end; $$;
+
+alter procedure susers.insert_audit_entry owner to upa;
+
+-- susers.list_audit_for_user returns the audit entries matching filters (null for no filter), most recent first. 
+-- Entries are visible for managers of their graph or their target user. 
+-- Entries with no target are visible for managers of all graphs only. 
+-- User should be a manager of at least a graph or an user
+create or replace function susers.list_audit_for_user(
+	p_user_login text, 
+	p_actor text, p_graph_id text, p_element_id text, 
+	p_start timestamp without time zone, p_end timestamp without time zone, 
+	p_limit int
+) returns table (
+	audit_id bigint, audit_date timestamp without time zone, 
+	audit_actor text, audit_operation text, 
+	audit_graph_id text, audit_user_login text, 
+	audit_element_ids text[], audit_changes text
+) language plpgsql as $$
+declare 
+	l_global_manager bool;
+begin 
+	select exists (
+		select 1 
+		from susers.authorizations_for_user(p_user_login) AFU
+		where AFU.class_name = 'graph'
+		and AFU.resource is null 
+		and AFU.included 
+		and 'manager' = ANY(AFU.roles)
+	) into l_global_manager;
+
+	if not l_global_manager 
+	and not exists (
+		select 1 from susers.all_graphs_authorized_for_user(p_user_login) AGA 
+		where 'manager' = ANY(AGA.role_names)
+	) and not exists (
+		select 1 from susers.all_users_authorized_for_user(p_user_login) AUA 
+		where 'manager' = ANY(AUA.role_names)
+	) then 
+		raise exception 'audit is restricted to managers' using errcode = '42501';
+	end if;
+
+	return query 
+	with managed_graphs as (
+		select AGA.resource 
+		from susers.all_graphs_authorized_for_user(p_user_login) AGA 
+		where 'manager' = ANY(AGA.role_names)
+	), managed_users as (
+		select AUA.resource 
+		from susers.all_users_authorized_for_user(p_user_login) AUA 
+		where 'manager' = ANY(AUA.role_names)
+	)
+	select AUD.audit_id, AUD.audit_date, 
+	AUD.audit_actor, AUD.audit_operation, 
+	AUD.audit_graph_id, USR.user_login, 
+	AUD.audit_element_ids, AUD.audit_changes::text
+	from susers.audit_entries AUD 
+	left outer join susers.users USR on USR.user_id = AUD.audit_user_id
+	where (p_actor is null or AUD.audit_actor = p_actor)
+	and (p_graph_id is null or AUD.audit_graph_id = p_graph_id)
+	and (p_element_id is null or p_element_id = ANY(AUD.audit_element_ids))
+	and (p_start is null or AUD.audit_date >= p_start)
+	and (p_end is null or AUD.audit_date <= p_end)
+	and (
+		(AUD.audit_graph_id is not null and AUD.audit_graph_id in (select MGR.resource from managed_graphs MGR))
+		or (AUD.audit_user_id is not null and AUD.audit_user_id in (select MUS.resource from managed_users MUS))
+		or (AUD.audit_graph_id is null and AUD.audit_user_id is null and l_global_manager)
+	)
+	order by AUD.audit_date desc, AUD.audit_id desc
+	limit p_limit;
+end; $$;
+
+alter function susers.list_audit_for_user owner to upa;
@@ -0,0 +1,113 @@
+-- susers.insert_refresh_token stores the hash of a new refresh token for an active user. 
+-- Expired tokens of that user are deleted
+create or replace procedure susers.insert_refresh_token(p_login text, p_token_hash text, p_family text, p_expires_at timestamp without time zone) 
+language plpgsql as $$
+declare 
+	l_user_id text;
+begin 
+	select USR.user_id into l_user_id 
+	from susers.users USR
+	where USR.user_login = p_login 
+	and USR.user_active = true;
+
+	if l_user_id is null then 
+		raise exception 'auth failure: no active user found for login %', p_login using errcode = '42501';
+	end if;
+
+	delete from susers.refresh_tokens RTO 
+	where RTO.user_id = l_user_id 
+	and RTO.token_expires_at < now();
+
+	insert into susers.refresh_tokens(token_hash, token_family, user_id, token_expires_at) 
+	values (p_token_hash, p_family, l_user_id, p_expires_at);
+end; $$;
+
+alter procedure susers.insert_refresh_token owner to upa;
+
+-- susers.rotate_refresh_token uses a refresh token and replaces it by a new token of the same family. 
+-- Status is valid (and login is set), invalid (unknown, expired, revoked token or inactive user) or reused. 
+-- Reuse of a used token revokes its family, so it does not raise to keep that change. 
+-- Token row is locked, so that only one rotation succeeds
+create or replace function susers.rotate_refresh_token(p_token_hash text, p_new_hash text, p_expires_at timestamp without time zone) 
+returns table(user_login text, token_status text) 
+language plpgsql as $$
+declare 
+	l_family text;
+	l_user_id text;
+	l_login text;
+	l_used_at timestamp without time zone;
+begin 
+	select RTO.token_family, RTO.user_id, RTO.token_used_at, USR.user_login 
+	into l_family, l_user_id, l_used_at, l_login
+	from susers.refresh_tokens RTO
+	join susers.users USR on USR.user_id = RTO.user_id 
+	where RTO.token_hash = p_token_hash 
+	and RTO.token_revoked_at is null 
+	and RTO.token_expires_at >= now() 
+	and USR.user_active = true
+	-- concurrent rotations of the same token wait, and then see it used
+	for update of RTO;
+
+	if l_family is null then 
+		return query select null::text, 'invalid'::text;
+		return;
+	elsif l_used_at is not null then 
+		update susers.refresh_tokens 
+		set token_revoked_at = now() 
+		where token_family = l_family 
+		and token_revoked_at is null;
+
+		return query select l_login, 'reused'::text;
+		return;
+	end if;
+
+	update susers.refresh_tokens 
+	set token_used_at = now() 
+	where token_hash = p_token_hash;
+
+	insert into susers.refresh_tokens(token_hash, token_family, user_id, token_expires_at) 
+	values (p_new_hash, l_family, l_user_id, p_expires_at);
+
+	return query select l_login, 'valid'::text;
+end; $$;
+
+alter function susers.rotate_refresh_token owner to upa;
+
+-- susers.revoke_refresh_token_family revokes all the tokens of the family of a token, if any
+create or replace procedure susers.revoke_refresh_token_family(p_token_hash text) 
+language plpgsql as $$
+begin 
+	update susers.refresh_tokens RTO
+	set token_revoked_at = now() 
+	where RTO.token_family in (
+		select FAM.token_family from susers.refresh_tokens FAM where FAM.token_hash = p_token_hash
+	) and RTO.token_revoked_at is null;
+end; $$;
+
+alter procedure susers.revoke_refresh_token_family owner to upa;
+
+-- susers.revoke_user_sessions revokes all refresh tokens of an user, and rejects its access tokens issued until now. 
+-- Actor should manage that user
+create or replace procedure susers.revoke_user_sessions(p_actor text, p_login text) 
+language plpgsql as $$
+declare 
+	l_user_id text;
+begin 
+	select USR.user_id into l_user_id from susers.users USR where USR.user_login = p_login;
+	if l_user_id is null then 
+		raise exception 'no user matching login %', p_login using errcode = 'P0002';
+	end if;
+
+	call susers.accept_user_access_to_resource_or_raise(p_actor, 'user', ARRAY['manager'], true, l_user_id);
+
+	update susers.refresh_tokens 
+	set token_revoked_at = now() 
+	where user_id = l_user_id 
+	and token_revoked_at is null;
+
+	update susers.users 
+	set user_tokens_not_before = now() 
+	where user_id = l_user_id;
+end; $$;
+
+alter procedure susers.revoke_user_sessions owner to upa;
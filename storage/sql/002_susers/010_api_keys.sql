@@ -0,0 +1,112 @@
+-- susers.create_api_key stores the hash of a new api key for an user.
+-- Actor should modify that user, and should not use an api key itself.
+-- Roles should exist, graphs are checked when key is used
+create or replace procedure susers.create_api_key(
+	p_actor text, p_login text, p_key_id text, p_key_hash text, p_name text,
+	p_expires_at timestamp without time zone, p_allowed_graphs text[], p_allowed_roles text[]
+) language plpgsql as $$
+declare
+	l_user_id text;
+begin
+	if nullif(current_setting('patterns.api_key', true), '') is not null then
+		raise exception 'auth failure: api key cannot create api keys' using errcode = '42501';
+	end if;
+
+	select USR.user_id into l_user_id
+	from susers.users USR
+	where USR.user_login = p_login
+	and USR.user_active = true;
+
+	if l_user_id is null then
+		raise exception 'no active user matching login %', p_login using errcode = 'P0002';
+	end if;
+
+	call susers.accept_user_access_to_resource_or_raise(p_actor, 'user', ARRAY['modifier'], true, l_user_id);
+
+	if exists (
+		select 1
+		from unnest(p_allowed_roles) as ARO(role_name)
+		left outer join susers.roles ROL on ROL.role_name = ARO.role_name
+		where ROL.role_id is null
+	) then
+		raise exception 'invalid roles %', p_allowed_roles using errcode = '23503';
+	end if;
+
+	insert into susers.api_keys(key_id, key_hash, key_name, user_id, key_expires_at, key_allowed_graphs, key_allowed_roles)
+	values (p_key_id, p_key_hash, p_name, l_user_id, p_expires_at, p_allowed_graphs, p_allowed_roles);
+end; $$;
+
+alter procedure susers.create_api_key owner to upa;
+
+-- susers.list_api_keys_for_user returns the keys of an user, revoked and expired keys included.
+-- Actor should observe that user
+create or replace function susers.list_api_keys_for_user(p_actor text, p_login text)
+returns table(
+	key_id text, key_name text, key_created_at timestamp without time zone,
+	key_expires_at timestamp without time zone, key_revoked_at timestamp without time zone,
+	key_allowed_graphs text[], key_allowed_roles text[]
+) language plpgsql as $$
+declare
+	l_user_id text;
+begin
+	select USR.user_id into l_user_id from susers.users USR where USR.user_login = p_login;
+	if l_user_id is null then
+		raise exception 'no user matching login %', p_login using errcode = 'P0002';
+	end if;
+
+	call susers.accept_user_access_to_resource_or_raise(p_actor, 'user', ARRAY['observer'], true, l_user_id);
+
+	return query
+	select AKE.key_id, AKE.key_name, AKE.key_created_at,
+	AKE.key_expires_at, AKE.key_revoked_at,
+	AKE.key_allowed_graphs, AKE.key_allowed_roles
+	from susers.api_keys AKE
+	where AKE.user_id = l_user_id
+	order by AKE.key_created_at;
+end; $$;
+
+alter function susers.list_api_keys_for_user owner to upa;
+
+-- susers.revoke_api_key revokes a key of an user. Actor should modify that user
+create or replace procedure susers.revoke_api_key(p_actor text, p_login text, p_key_id text)
+language plpgsql as $$
+declare
+	l_user_id text;
+begin
+	select USR.user_id into l_user_id from susers.users USR where USR.user_login = p_login;
+	if l_user_id is null then
+		raise exception 'no user matching login %', p_login using errcode = 'P0002';
+	end if;
+
+	call susers.accept_user_access_to_resource_or_raise(p_actor, 'user', ARRAY['modifier'], true, l_user_id);
+
+	update susers.api_keys AKE
+	set key_revoked_at = coalesce(AKE.key_revoked_at, now())
+	where AKE.key_id = p_key_id
+	and AKE.user_id = l_user_id;
+
+	if not found then
+		raise exception 'no api key % for user %', p_key_id, p_login using errcode = 'P0002';
+	end if;
+end; $$;
+
+alter procedure susers.revoke_api_key owner to upa;
+
+-- susers.find_api_key returns the login and the id of a valid key:
+-- not revoked, not expired, and its user is active.
+-- No row means invalid key
+create or replace function susers.find_api_key(p_key_hash text)
+returns table(user_login text, key_id text)
+language plpgsql as $$
+begin
+	return query
+	select USR.user_login, AKE.key_id
+	from susers.api_keys AKE
+	join susers.users USR on USR.user_id = AKE.user_id
+	where AKE.key_hash = p_key_hash
+	and AKE.key_revoked_at is null
+	and (AKE.key_expires_at is null or AKE.key_expires_at >= now())
+	and USR.user_active = true;
+end; $$;
+
+alter function susers.find_api_key owner to upa;
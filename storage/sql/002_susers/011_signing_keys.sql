@@ -0,0 +1,33 @@
+-- susers.list_signing_keys returns all the keys to sign access tokens, newest first
+create or replace function susers.list_signing_keys() 
+returns table(key_id text, key_algorithm text, key_private bytea, key_created_at timestamp with time zone) 
+language plpgsql as $$
+begin 
+	return query 
+	select SKE.key_id, SKE.key_algorithm, SKE.key_private, SKE.key_created_at 
+	from susers.signing_keys SKE 
+	order by SKE.key_created_at desc;
+end; $$;
+
+alter function susers.list_signing_keys owner to upa;
+
+-- susers.insert_signing_key stores a new key to sign access tokens
+create or replace procedure susers.insert_signing_key(p_key_id text, p_algorithm text, p_private bytea) 
+language plpgsql as $$
+begin 
+	insert into susers.signing_keys(key_id, key_algorithm, key_private) 
+	values (p_key_id, p_algorithm, p_private);
+end; $$;
+
+alter procedure susers.insert_signing_key owner to upa;
+
+-- susers.delete_signing_keys_before deletes keys created before a moment. 
+-- Tokens signed by those keys are no longer valid
+create or replace procedure susers.delete_signing_keys_before(p_moment timestamp with time zone) 
+language plpgsql as $$
+begin 
+	delete from susers.signing_keys SKE 
+	where SKE.key_created_at < p_moment;
+end; $$;
+
+alter procedure susers.delete_signing_keys_before owner to upa;
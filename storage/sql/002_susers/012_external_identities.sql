@@ -0,0 +1,172 @@
+-- susers.find_user_for_external_identity returns the active user of an external subject, if any, 
+-- and the moment its access tokens should be issued after
+create or replace function susers.find_user_for_external_identity(p_issuer text, p_subject text) 
+returns table(user_login text, tokens_not_before timestamp with time zone) 
+language plpgsql as $$
+begin 
+	return query 
+	select USR.user_login, USR.user_tokens_not_before
+	from susers.external_identities EID
+	join susers.users USR on USR.user_id = EID.user_id 
+	where EID.identity_issuer = p_issuer 
+	and EID.identity_subject = p_subject 
+	and USR.user_active = true;
+end; $$;
+
+alter function susers.find_user_for_external_identity owner to upa;
+
+-- susers.sync_external_roles sets the roles an issuer grants to an user, as class:role on all resources of the class. 
+-- Roles the issuer granted before and not in p_roles are revoked. 
+-- Roles the user has on all resources already are not managed by the issuer
+create or replace procedure susers.sync_external_roles(p_user_id text, p_roles text[]) 
+language plpgsql as $$
+declare 
+	l_role text;
+	l_class_name text;
+	l_role_name text;
+	l_auth_id bigint;
+begin 
+	-- revoke previous roles not granted anymore
+	for l_auth_id, l_class_name, l_role_name in 
+		select AUT.auth_id, CLA.class_name, ROL.role_name 
+		from susers.external_authorizations EAU 
+		join susers.authorizations AUT on AUT.auth_id = EAU.auth_id 
+		join susers.classes CLA on CLA.class_id = AUT.auth_class_id 
+		join susers.roles ROL on ROL.role_id = AUT.auth_role_id 
+		where AUT.auth_user_id = p_user_id 
+		and not ((CLA.class_name || ':' || ROL.role_name) = any(p_roles))
+	loop 
+		insert into susers.audit_entries(audit_actor, audit_operation, audit_user_id, audit_changes) 
+		select coalesce(nullif(current_setting('patterns.audit_actor', true), ''), current_user), 
+		'auth_revoke', p_user_id, 
+		jsonb_build_object(l_class_name, jsonb_build_object('before', jsonb_build_object(l_role_name, jsonb_build_array('*'))));
+
+		delete from susers.authorizations where auth_id = l_auth_id;
+	end loop;
+
+	-- grant new roles 
+	foreach l_role in array p_roles loop 
+		l_class_name := split_part(l_role, ':', 1);
+		l_role_name := split_part(l_role, ':', 2);
+
+		if exists (
+			select 1 
+			from susers.authorizations AUT 
+			join susers.classes CLA on CLA.class_id = AUT.auth_class_id 
+			join susers.roles ROL on ROL.role_id = AUT.auth_role_id 
+			where AUT.auth_user_id = p_user_id 
+			and AUT.auth_all_resources = true 
+			and AUT.auth_inclusion = true 
+			and CLA.class_name = l_class_name 
+			and ROL.role_name = l_role_name
+		) then 
+			continue;
+		end if;
+
+		call susers.change_access_to_user_for_resource(p_user_id, l_class_name, l_role_name, true, true, null);
+
+		insert into susers.external_authorizations(auth_id) 
+		select AUT.auth_id 
+		from susers.authorizations AUT 
+		join susers.classes CLA on CLA.class_id = AUT.auth_class_id 
+		join susers.roles ROL on ROL.role_id = AUT.auth_role_id 
+		where AUT.auth_user_id = p_user_id 
+		and AUT.auth_all_resources = true 
+		and AUT.auth_inclusion = true 
+		and CLA.class_name = l_class_name 
+		and ROL.role_name = l_role_name;
+	end loop;
+end; $$;
+
+alter procedure susers.sync_external_roles owner to upa;
+
+-- susers.login_external_identity returns the login of the local user of an external subject, and if user was created. 
+-- Unknown subject creates user p_login if auto provisioning is on, and raises otherwise. 
+-- Roles are synchronized with p_roles unless it is null
+create or replace function susers.login_external_identity(
+	p_issuer text, p_subject text, p_login text, p_auto_provision bool, p_roles text[]
+) returns table(user_login text, user_created bool) 
+language plpgsql as $$
+declare 
+	l_user_id text;
+	l_active bool;
+	l_login text;
+	l_created bool = false;
+begin 
+	select USR.user_id, USR.user_active, USR.user_login into l_user_id, l_active, l_login 
+	from susers.external_identities EID
+	join susers.users USR on USR.user_id = EID.user_id 
+	where EID.identity_issuer = p_issuer 
+	and EID.identity_subject = p_subject;
+
+	if l_user_id is null then 
+		if not p_auto_provision then 
+			raise exception 'auth failure: no local user for subject % of %', p_subject, p_issuer using errcode = '42501';
+		elsif exists (select 1 from susers.users USR where USR.user_login = p_login) then 
+			raise exception 'user % already exists, link it to subject % of %', p_login, p_subject, p_issuer using errcode = '42710';
+		end if;
+
+		-- user has no password: random hash matches no password
+		select gen_random_uuid() into l_user_id;
+		insert into susers.users(user_id, user_active, user_login, user_salt, user_secret, user_hash)
+		select l_user_id, true, p_login, susers.generate_random_string(), susers.generate_random_string(), susers.generate_random_string();
+		call susers.insert_new_resource(p_login, 'user', l_user_id);
+		call susers.grant_access_to_user_for_resource(p_login, 'user', 'observer', l_user_id);
+		call susers.grant_access_to_user_for_resource(p_login, 'user', 'modifier', l_user_id);
+
+		insert into susers.external_identities(identity_issuer, identity_subject, user_id) 
+		values (p_issuer, p_subject, l_user_id);
+
+		l_active := true;
+		l_login := p_login;
+		l_created := true;
+	end if;
+
+	if not l_active then 
+		raise exception 'auth failure: no active user for subject % of %', p_subject, p_issuer using errcode = '42501';
+	end if;
+
+	update susers.external_identities EID 
+	set identity_last_login_at = now() 
+	where EID.identity_issuer = p_issuer 
+	and EID.identity_subject = p_subject;
+
+	if p_roles is not null then 
+		call susers.sync_external_roles(l_user_id, p_roles);
+	end if;
+
+	return query select l_login, l_created;
+end; $$;
+
+alter function susers.login_external_identity owner to upa;
+
+-- susers.link_external_identity maps an external subject to an existing user. Actor should manage that user
+create or replace procedure susers.link_external_identity(p_actor text, p_login text, p_issuer text, p_subject text) 
+language plpgsql as $$
+declare 
+	l_user_id text;
+	l_linked_user_id text;
+begin 
+	select USR.user_id into l_user_id from susers.users USR where USR.user_login = p_login;
+	if l_user_id is null then 
+		raise exception 'no user matching login %', p_login using errcode = 'P0002';
+	end if;
+
+	call susers.accept_user_access_to_resource_or_raise(p_actor, 'user', ARRAY['manager'], true, l_user_id);
+
+	select EID.user_id into l_linked_user_id 
+	from susers.external_identities EID 
+	where EID.identity_issuer = p_issuer 
+	and EID.identity_subject = p_subject;
+
+	if l_linked_user_id = l_user_id then 
+		return;
+	elsif l_linked_user_id is not null then 
+		raise exception 'subject % of % is linked to another user', p_subject, p_issuer using errcode = '42710';
+	end if;
+
+	insert into susers.external_identities(identity_issuer, identity_subject, user_id) 
+	values (p_issuer, p_subject, l_user_id);
+end; $$;
+
+alter procedure susers.link_external_identity owner to upa;
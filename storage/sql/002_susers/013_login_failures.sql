@@ -0,0 +1,136 @@
+-- susers.count_login_attempt counts an attempt for a scope and a key, attempts before the window are forgotten. 
+-- Row of that key is locked until the end of the transaction, so that concurrent attempts are counted one after the other. 
+-- A locked key is not counted. Going over p_max_failures (0 for no limit) locks the key for p_lockout_seconds. 
+-- It returns the end of the lockout, null if not locked
+create or replace function susers.count_login_attempt(
+	p_scope text, p_key text, p_max_failures int, p_window_seconds int, p_lockout_seconds int
+) returns timestamp with time zone 
+language plpgsql as $$
+declare 
+	l_count int;
+	l_locked_until timestamp with time zone;
+begin 
+	insert into susers.login_failures as LFA (failure_scope, failure_key, failure_count, failure_first_at) 
+	values (p_scope, p_key, 1, now()) 
+	on conflict (failure_scope, failure_key) do update 
+	set failure_count = case 
+		when LFA.failure_locked_until > now() then LFA.failure_count 
+		when LFA.failure_first_at < now() - make_interval(secs => p_window_seconds) then 1 
+		else LFA.failure_count + 1 end, 
+	failure_first_at = case 
+		when LFA.failure_locked_until > now() then LFA.failure_first_at 
+		when LFA.failure_first_at < now() - make_interval(secs => p_window_seconds) then now() 
+		else LFA.failure_first_at end
+	returning LFA.failure_count, LFA.failure_locked_until into l_count, l_locked_until;
+
+	if l_locked_until > now() then 
+		return l_locked_until;
+	elsif p_max_failures > 0 and l_count > p_max_failures then 
+		-- counter starts again once locked
+		update susers.login_failures LFA 
+		set failure_count = 0, 
+		failure_first_at = now(), 
+		failure_locked_until = now() + make_interval(secs => p_lockout_seconds)
+		where LFA.failure_scope = p_scope 
+		and LFA.failure_key = p_key 
+		returning LFA.failure_locked_until into l_locked_until;
+
+		return l_locked_until;
+	end if;
+
+	return null;
+end; $$;
+
+alter function susers.count_login_attempt owner to upa;
+
+-- susers.start_login_attempt counts a login attempt for the login and the address, before its password is checked. 
+-- Checking and counting in a single call, with rows locked, means that concurrent attempts cannot go over the limits. 
+-- After p_max_login_failures failed attempts, next attempt locks the login, and so for the address. 
+-- It returns the end of the lockout of either, null if attempt may check its password. 
+-- Failures out of their window and past lockouts are deleted
+create or replace function susers.start_login_attempt(
+	p_login text, p_address text, 
+	p_max_login_failures int, p_max_address_failures int, 
+	p_window_seconds int, p_lockout_seconds int
+) returns timestamp with time zone 
+language plpgsql as $$
+declare 
+	l_login_locked_until timestamp with time zone;
+	l_address_locked_until timestamp with time zone;
+begin 
+	delete from susers.login_failures LFA 
+	where LFA.failure_first_at < now() - make_interval(secs => p_window_seconds) 
+	and coalesce(LFA.failure_locked_until, '-infinity') < now();
+
+	select susers.count_login_attempt('login', p_login, p_max_login_failures, p_window_seconds, p_lockout_seconds) 
+	into l_login_locked_until;
+
+	if p_address is not null and length(p_address) > 0 then 
+		select susers.count_login_attempt('address', p_address, p_max_address_failures, p_window_seconds, p_lockout_seconds) 
+		into l_address_locked_until;
+	end if;
+
+	-- greatest ignores null values
+	return greatest(l_login_locked_until, l_address_locked_until);
+end; $$;
+
+alter function susers.start_login_attempt owner to upa;
+
+-- susers.clear_login_failures forgets failures of a login after a successful login, and does not count that attempt for its address. 
+-- Other failures of the address stay, an user should not clear failures of its address
+create or replace procedure susers.clear_login_failures(p_login text, p_address text) 
+language plpgsql as $$
+begin 
+	delete from susers.login_failures LFA 
+	where LFA.failure_scope = 'login' 
+	and LFA.failure_key = p_login 
+	and coalesce(LFA.failure_locked_until, '-infinity') < now();
+
+	update susers.login_failures LFA 
+	set failure_count = greatest(LFA.failure_count - 1, 0) 
+	where LFA.failure_scope = 'address' 
+	and LFA.failure_key = p_address 
+	and coalesce(LFA.failure_locked_until, '-infinity') < now();
+end; $$;
+
+alter procedure susers.clear_login_failures owner to upa;
+
+-- susers.set_user_active activates or deactivates an user. Actor should manage that user, and cannot deactivate itself. 
+-- Deactivation revokes sessions of the user, activation ends its lockout
+create or replace procedure susers.set_user_active(p_actor text, p_login text, p_active bool) 
+language plpgsql as $$
+declare 
+	l_user_id text;
+begin 
+	select USR.user_id into l_user_id from susers.users USR where USR.user_login = p_login;
+	if l_user_id is null then 
+		raise exception 'no user matching login %', p_login using errcode = 'P0002';
+	end if;
+
+	call susers.accept_user_access_to_resource_or_raise(p_actor, 'user', ARRAY['manager'], true, l_user_id);
+
+	if not p_active and p_actor = p_login then 
+		raise exception 'user % cannot deactivate itself', p_login using errcode = '23503';
+	end if;
+
+	update susers.users 
+	set user_active = p_active 
+	where user_id = l_user_id;
+
+	if p_active then 
+		delete from susers.login_failures LFA 
+		where LFA.failure_scope = 'login' 
+		and LFA.failure_key = p_login;
+	else 
+		update susers.refresh_tokens 
+		set token_revoked_at = now() 
+		where user_id = l_user_id 
+		and token_revoked_at is null;
+
+		update susers.users 
+		set user_tokens_not_before = now() 
+		where user_id = l_user_id;
+	end if;
+end; $$;
+
+alter procedure susers.set_user_active owner to upa;
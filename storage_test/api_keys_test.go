@@ -0,0 +1,23 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/zefrenchwan/patterns.git/storage"
+)
+
+func TestApiKeyContext(t *testing.T) {
+	if _, found := storage.ApiKeyFromContext(context.Background()); found {
+		t.Error("no api key expected")
+	}
+
+	if _, found := storage.ApiKeyFromContext(storage.ContextWithApiKey(context.Background(), "")); found {
+		t.Error("empty api key should not be set")
+	}
+
+	ctx := storage.ContextWithRequestId(storage.ContextWithApiKey(context.Background(), "key"), "request")
+	if keyId, found := storage.ApiKeyFromContext(ctx); !found || keyId != "key" {
+		t.Errorf("expecting key, got %s", keyId)
+	}
+}
@@ -0,0 +1,57 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zefrenchwan/patterns.git/nodes"
+	"github.com/zefrenchwan/patterns.git/storage"
+)
+
+func TestSerializeElementChanges(t *testing.T) {
+	now := time.Now().UTC().Truncate(1 * time.Second)
+	fullPeriod := nodes.NewFullPeriod()
+	sincePeriod := nodes.NewPeriod(nodes.NewRightInfiniteTimeInterval(now, true))
+
+	before := nodes.NewEntity([]string{"Person"})
+	before.AddValue("first name", "John", fullPeriod)
+	before.AddValue("last name", "Doe", fullPeriod)
+
+	after, errCopy := nodes.CopyElementWithId(&before, before.Id())
+	if errCopy != nil {
+		t.Fatal(errCopy)
+	}
+
+	entity := after.(*nodes.Entity)
+	entity.SetValue("first name", "Jack")
+	entity.AddValue("city", "Paris", sincePeriod)
+
+	changes := storage.SerializeElementChanges(&before, after)
+	if len(changes) != 2 {
+		t.Fatalf("expecting first name and city changes, got %v", changes)
+	}
+
+	if change, found := changes["first name"]; !found {
+		t.Error("missing first name change")
+	} else if _, found := change.Before["John"]; !found {
+		t.Errorf("expecting John before, got %v", change.Before)
+	} else if _, found := change.After["Jack"]; !found {
+		t.Errorf("expecting Jack after, got %v", change.After)
+	}
+
+	if change, found := changes["city"]; !found {
+		t.Error("missing city change")
+	} else if len(change.Before) != 0 {
+		t.Errorf("city did not exist before, got %v", change.Before)
+	} else if periods := change.After["Paris"]; len(periods) != 1 {
+		t.Errorf("expecting a period for Paris, got %v", periods)
+	}
+
+	// deletion keeps all values before
+	deletion := storage.SerializeElementChanges(&before, nil)
+	if change, found := deletion["last name"]; !found || len(change.After) != 0 {
+		t.Errorf("expecting last name deleted, got %v", deletion)
+	} else if _, found := deletion[nodes.SYNC_ACTIVITY_PART]; !found {
+		t.Errorf("expecting activity deleted, got %v", deletion)
+	}
+}
@@ -0,0 +1,112 @@
+package storage_test
+
+import (
+	"context"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/zefrenchwan/patterns.git/nodes"
+	"github.com/zefrenchwan/patterns.git/storage"
+)
+
+// TEST_DB_URL_VARIABLE is the url of a disposable database for tests that need one.
+// Those tests are skipped if it is not set
+const TEST_DB_URL_VARIABLE = "PATTERNS_TEST_DB_URL"
+
+// testDatabaseUrl returns the url of the test database, and skips the test if there is none
+func testDatabaseUrl(t *testing.T) string {
+	url := os.Getenv(TEST_DB_URL_VARIABLE)
+	if len(url) == 0 {
+		t.Skipf("no test database, set %s to run this test", TEST_DB_URL_VARIABLE)
+	}
+
+	return url
+}
+
+// newTestDao connects the test database. Dao is closed at the end of the test
+func newTestDao(t *testing.T, settings storage.PoolSettings) *storage.Dao {
+	dao, err := storage.NewDao(context.Background(), testDatabaseUrl(t), settings, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(dao.Close)
+	return &dao
+}
+
+// execTestSql runs a statement on the test database, out of the dao
+func execTestSql(t *testing.T, query string, args ...any) {
+	conn, errConn := pgx.Connect(context.Background(), testDatabaseUrl(t))
+	if errConn != nil {
+		t.Fatal(errConn)
+	}
+
+	defer conn.Close(context.Background())
+	if _, err := conn.Exec(context.Background(), query, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestUser creates an user with all authorizations and returns its login
+func newTestUser(t *testing.T) string {
+	login := "test_" + uuid.NewString()
+	execTestSql(t, "call susers.insert_user($1, $2)", login, uuid.NewString())
+	execTestSql(t, "call susers.insert_super_user_roles($1)", login)
+	return login
+}
+
+// newTestGraph creates a graph importing sources and returns its id
+func newTestGraph(t *testing.T, dao *storage.Dao, user string, sources ...string) string {
+	graphId, err := dao.CreateGraph(context.Background(), user, "test graph", "", nil, sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return graphId
+}
+
+// newTestEntity upserts an entity, active for the full period, in a graph
+func newTestEntity(t *testing.T, dao *storage.Dao, user, graphId string) *nodes.Entity {
+	entity := nodes.NewEntity([]string{"Person"})
+	if err := dao.UpsertElement(context.Background(), user, graphId, &entity); err != nil {
+		t.Fatal(err)
+	}
+
+	return &entity
+}
+
+// newTestRelation upserts a relation, active for the full period, from subject to object in a graph
+func newTestRelation(t *testing.T, dao *storage.Dao, user, graphId, subject, object string) *nodes.Relation {
+	relation := nodes.NewRelation([]string{"Knows"})
+	relation.SetValuesForRole(nodes.RELATION_ROLE_SUBJECT, []string{subject})
+	relation.SetValuesForRole(nodes.RELATION_ROLE_OBJECT, []string{object})
+	if err := dao.UpsertElement(context.Background(), user, graphId, &relation); err != nil {
+		t.Fatal(err)
+	}
+
+	return &relation
+}
+
+// linkedIds returns all the operands of a relation
+func linkedIds(relation nodes.FormalRelation) []string {
+	result := make([]string, 0)
+	for _, values := range relation.ValuesPerRole() {
+		result = append(result, values...)
+	}
+
+	slices.Sort(result)
+	return slices.Compact(result)
+}
+
+// assertErrorCode fails if err is not a database error with that code
+func assertErrorCode(t *testing.T, err error, code string) {
+	t.Helper()
+	if err == nil {
+		t.Errorf("expecting error %s, got none", code)
+	} else if found := storage.FindCodeInPSQLException(err); found != code {
+		t.Errorf("expecting error %s, got %s: %s", code, found, err.Error())
+	}
+}
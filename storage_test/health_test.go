@@ -0,0 +1,25 @@
+package storage_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/zefrenchwan/patterns.git/storage"
+)
+
+func TestRequiredProceduresAreDefined(t *testing.T) {
+	definition := regexp.MustCompile(`(?i)create\s+(?:or\s+replace\s+)?(?:function|procedure)\s+([a-z_]+\.[a-z_]+)`)
+	defined := make(map[string]bool)
+	for _, script := range loadSqlScripts(t) {
+		for _, match := range definition.FindAllStringSubmatch(script, -1) {
+			defined[strings.ToLower(match[1])] = true
+		}
+	}
+
+	for _, name := range storage.REQUIRED_PROCEDURES {
+		if !defined[name] {
+			t.Errorf("%s is required but not defined in sql files", name)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package storage_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/zefrenchwan/patterns.git/nodes"
+	"github.com/zefrenchwan/patterns.git/storage"
+)
+
+func TestRemoveImportChecksGraphsImportingBase(t *testing.T) {
+	dao := newTestDao(t, storage.PoolSettings{})
+	user := newTestUser(t)
+	ctx := context.Background()
+
+	// child imports base that imports shared, child links an element of shared
+	shared := newTestGraph(t, dao, user)
+	base := newTestGraph(t, dao, user, shared)
+	child := newTestGraph(t, dao, user, base)
+	sharedEntity := newTestEntity(t, dao, user, shared)
+	childEntity := newTestEntity(t, dao, user, child)
+	relation := newTestRelation(t, dao, user, child, childEntity.Id(), sharedEntity.Id())
+
+	assertErrorCode(t, dao.RemoveImportFromGraph(ctx, user, base, shared), storage.INCONSISTENCY_CODE)
+	if imports, err := dao.ListImportsForUser(ctx, user); err != nil {
+		t.Fatal(err)
+	} else if !slices.Contains(imports.DirectImports(base), shared) {
+		t.Error("failed removal should keep the import")
+	}
+
+	// relation links elements of child only, import may be removed
+	otherEntity := newTestEntity(t, dao, user, child)
+	relation.SetValuesForRole(nodes.RELATION_ROLE_OBJECT, []string{otherEntity.Id()})
+	if err := dao.UpsertElement(ctx, user, child, relation); err != nil {
+		t.Fatal(err)
+	} else if err := dao.RemoveImportFromGraph(ctx, user, base, shared); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,73 @@
+package storage_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zefrenchwan/patterns.git/storage"
+)
+
+func TestLoginLockout(t *testing.T) {
+	dao := newTestDao(t, storage.PoolSettings{MaxConns: 10})
+	ctx := context.Background()
+	policy := storage.LockoutPolicy{MaxLoginFailures: 3, FailureWindow: time.Minute, LockoutDuration: time.Minute}
+
+	// case 1: maximum of failures, then next attempt locks
+	login, address := uuid.NewString(), uuid.NewString()
+	for index := range 3 {
+		if lockedUntil, err := dao.StartLoginAttempt(ctx, login, address, policy); err != nil {
+			t.Fatal(err)
+		} else if !lockedUntil.IsZero() {
+			t.Errorf("attempt %d should not be locked", index)
+		}
+	}
+
+	if lockedUntil, err := dao.StartLoginAttempt(ctx, login, address, policy); err != nil {
+		t.Fatal(err)
+	} else if !lockedUntil.After(time.Now()) {
+		t.Error("attempt after maximum of failures should be locked")
+	}
+
+	// case 2: concurrent attempts cannot go over the limit
+	login = uuid.NewString()
+	results := make([]time.Time, 10)
+	errs := make([]error, 10)
+	var group sync.WaitGroup
+	for index := range results {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			results[index], errs[index] = dao.StartLoginAttempt(ctx, login, address, policy)
+		}()
+	}
+
+	group.Wait()
+	allowed := 0
+	for index, lockedUntil := range results {
+		if errs[index] != nil {
+			t.Fatal(errs[index])
+		} else if lockedUntil.IsZero() {
+			allowed++
+		}
+	}
+
+	if allowed != policy.MaxLoginFailures {
+		t.Errorf("expecting %d allowed attempts, got %d", policy.MaxLoginFailures, allowed)
+	}
+
+	// case 3: successful logins are not failures, for login and address
+	login, address = uuid.NewString(), uuid.NewString()
+	policy.MaxAddressFailures = 2
+	for index := range 5 {
+		if lockedUntil, err := dao.StartLoginAttempt(ctx, login, address, policy); err != nil {
+			t.Fatal(err)
+		} else if !lockedUntil.IsZero() {
+			t.Errorf("attempt %d follows a successful login, it should not be locked", index)
+		} else if err := dao.ClearLoginFailures(ctx, login, address); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
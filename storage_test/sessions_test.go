@@ -0,0 +1,59 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zefrenchwan/patterns.git/storage"
+)
+
+func TestConcurrentRotationsRevokeSession(t *testing.T) {
+	dao := newTestDao(t, storage.PoolSettings{MaxConns: 4})
+	user := newTestUser(t)
+	ctx := context.Background()
+
+	token, errToken := dao.CreateRefreshToken(ctx, user, time.Hour)
+	if errToken != nil {
+		t.Fatal(errToken)
+	}
+
+	// both rotations start at once, only one may use the token
+	start := make(chan struct{})
+	newTokens := make([]string, 2)
+	errs := make([]error, 2)
+	var group sync.WaitGroup
+	for index := range 2 {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			<-start
+			_, newTokens[index], errs[index] = dao.RotateRefreshToken(ctx, token, time.Hour)
+		}()
+	}
+
+	close(start)
+	group.Wait()
+
+	valid := -1
+	for index, err := range errs {
+		if err == nil {
+			valid = index
+		} else if !errors.Is(err, storage.ErrRefreshTokenReuse) {
+			t.Errorf("expecting reuse, got %s", err.Error())
+		}
+	}
+
+	if valid < 0 {
+		t.Fatal("one rotation should succeed")
+	} else if errs[1-valid] == nil {
+		t.Fatal("one rotation should detect reuse")
+	}
+
+	// reuse revoked the session, token of the successful rotation too
+	if _, _, err := dao.RotateRefreshToken(ctx, newTokens[valid], time.Hour); !errors.Is(err, storage.ErrInvalidRefreshToken) {
+		t.Errorf("session should be revoked, got %v", err)
+	}
+}
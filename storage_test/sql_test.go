@@ -0,0 +1,33 @@
+package storage_test
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// sqlComment matches the comments in sql scripts
+var sqlComment = regexp.MustCompile(`--[^\n]*`)
+
+// loadSqlScripts returns the content of sql scripts, comments removed
+func loadSqlScripts(t *testing.T) []string {
+	files, errFiles := filepath.Glob(filepath.Join("..", "storage", "sql", "*", "*.sql"))
+	if errFiles != nil {
+		t.Fatal(errFiles)
+	} else if len(files) == 0 {
+		t.Fatal("no sql file")
+	}
+
+	result := make([]string, 0, len(files))
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result = append(result, sqlComment.ReplaceAllString(string(content), ""))
+	}
+
+	return result
+}
@@ -0,0 +1,170 @@
+package storage_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/zefrenchwan/patterns.git/nodes"
+	"github.com/zefrenchwan/patterns.git/storage"
+)
+
+func TestPurgeRemovesRelationsInTrashLinkingPurgedElements(t *testing.T) {
+	dao := newTestDao(t, storage.PoolSettings{})
+	user := newTestUser(t)
+	ctx := context.Background()
+
+	graphId := newTestGraph(t, dao, user)
+	subject := newTestEntity(t, dao, user, graphId)
+	object := newTestEntity(t, dao, user, graphId)
+	relation := newTestRelation(t, dao, user, graphId, subject.Id(), object.Id())
+
+	// relation goes to trash now, object was in trash long ago
+	if err := dao.DeleteElement(ctx, user, relation.Id()); err != nil {
+		t.Fatal(err)
+	} else if err := dao.DeleteElement(ctx, user, object.Id()); err != nil {
+		t.Fatal(err)
+	}
+
+	execTestSql(t, "update sgraphs.elements set element_deleted_at = now() - interval '2 days' where element_id = $1", object.Id())
+	if _, _, err := dao.PurgeTrash(ctx, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{object.Id(), relation.Id()} {
+		if element, err := dao.LoadElementWithTrashForUser(ctx, user, id); err != nil {
+			t.Error(err)
+		} else if element != nil {
+			t.Errorf("%s should be purged", id)
+		}
+	}
+
+	if element, err := dao.LoadElementForUser(ctx, user, subject.Id()); err != nil {
+		t.Error(err)
+	} else if element == nil {
+		t.Error("subject is not in trash, it should stay")
+	}
+}
+
+func TestUpsertRestoresElementsInTrash(t *testing.T) {
+	dao := newTestDao(t, storage.PoolSettings{})
+	user := newTestUser(t)
+	ctx := context.Background()
+
+	graphId := newTestGraph(t, dao, user)
+	subject := newTestEntity(t, dao, user, graphId)
+	object := newTestEntity(t, dao, user, graphId)
+	relation := newTestRelation(t, dao, user, graphId, subject.Id(), object.Id())
+	for _, id := range []string{relation.Id(), object.Id()} {
+		if err := dao.DeleteElement(ctx, user, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// restored relation should not link an element in trash
+	assertErrorCode(t, dao.UpsertElement(ctx, user, graphId, relation), storage.INCONSISTENCY_CODE)
+
+	for _, element := range []nodes.Element{object, relation} {
+		if err := dao.UpsertElement(ctx, user, graphId, element); err != nil {
+			t.Fatal(err)
+		} else if loaded, err := dao.LoadElementForUser(ctx, user, element.Id()); err != nil {
+			t.Error(err)
+		} else if loaded == nil {
+			t.Errorf("%s should be restored", element.Id())
+		}
+	}
+}
+
+func TestSplitIgnoresTrash(t *testing.T) {
+	dao := newTestDao(t, storage.PoolSettings{})
+	user := newTestUser(t)
+	ctx := context.Background()
+
+	graphId := newTestGraph(t, dao, user)
+	entity := newTestEntity(t, dao, user, graphId)
+	other := newTestEntity(t, dao, user, graphId)
+	active := newTestRelation(t, dao, user, graphId, other.Id(), entity.Id())
+	trashed := newTestRelation(t, dao, user, graphId, other.Id(), entity.Id())
+	if err := dao.DeleteElement(ctx, user, trashed.Id()); err != nil {
+		t.Fatal(err)
+	}
+
+	newId, rewiredIds, errSplit := dao.SplitElement(ctx, user, entity.Id(), time.Now().UTC().Truncate(time.Second))
+	if errSplit != nil {
+		t.Fatal(errSplit)
+	} else if slices.Compare(rewiredIds, []string{active.Id()}) != 0 {
+		t.Errorf("only active relation should be rewired, got %v", rewiredIds)
+	}
+
+	if loaded, err := dao.LoadElementWithTrashForUser(ctx, user, trashed.Id()); err != nil {
+		t.Fatal(err)
+	} else if relation, ok := loaded.(nodes.FormalRelation); !ok {
+		t.Fatal("expecting relation in trash")
+	} else if slices.Contains(linkedIds(relation), newId) {
+		t.Error("relation in trash should not link new entity")
+	}
+}
+
+func TestMergeIgnoresRelationsInTrash(t *testing.T) {
+	dao := newTestDao(t, storage.PoolSettings{})
+	user := newTestUser(t)
+	ctx := context.Background()
+
+	graphId := newTestGraph(t, dao, user)
+	target := newTestEntity(t, dao, user, graphId)
+	source := newTestEntity(t, dao, user, graphId)
+	other := newTestEntity(t, dao, user, graphId)
+	active := newTestRelation(t, dao, user, graphId, other.Id(), source.Id())
+	trashed := newTestRelation(t, dao, user, graphId, other.Id(), source.Id())
+	if err := dao.DeleteElement(ctx, user, trashed.Id()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dao.MergeElements(ctx, user, target.Id(), []string{source.Id()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded, err := dao.LoadElementForUser(ctx, user, active.Id()); err != nil {
+		t.Fatal(err)
+	} else if relation, ok := loaded.(nodes.FormalRelation); !ok {
+		t.Fatal("expecting active relation")
+	} else if ids := linkedIds(relation); !slices.Contains(ids, target.Id()) || slices.Contains(ids, source.Id()) {
+		t.Errorf("active relation should link target instead of source, got %v", ids)
+	}
+
+	if loaded, err := dao.LoadElementWithTrashForUser(ctx, user, trashed.Id()); err != nil {
+		t.Fatal(err)
+	} else if relation, ok := loaded.(nodes.FormalRelation); !ok {
+		t.Fatal("expecting relation in trash")
+	} else if ids := linkedIds(relation); slices.Contains(ids, target.Id()) || !slices.Contains(ids, source.Id()) {
+		t.Errorf("relation in trash should still link source, got %v", ids)
+	}
+}
+
+func TestImportsIgnoreGraphsInTrash(t *testing.T) {
+	dao := newTestDao(t, storage.PoolSettings{})
+	user := newTestUser(t)
+	ctx := context.Background()
+
+	// first imports middle that imports last
+	last := newTestGraph(t, dao, user)
+	middle := newTestGraph(t, dao, user, last)
+	first := newTestGraph(t, dao, user, middle)
+	if err := dao.DeleteGraph(ctx, user, middle); err != nil {
+		t.Fatal(err)
+	}
+
+	if imports, err := dao.ListImportsForUser(ctx, user); err != nil {
+		t.Fatal(err)
+	} else if slices.Contains(imports.DirectImports(first), middle) {
+		t.Error("graph in trash should not be listed as an import")
+	}
+
+	// no cycle while middle is in trash, restoring it closes the cycle
+	if err := dao.AddNewImportForGraph(ctx, user, last, first); err != nil {
+		t.Fatal(err)
+	}
+
+	assertErrorCode(t, dao.RestoreGraph(ctx, user, middle), storage.CYCLE_CODE)
+}
@@ -10,23 +10,57 @@ from datetime import datetime, timedelta
 base_url = 'http://localhost:8080'
 # status 
 status_url = base_url + "/status/"
+status_live_url = base_url + "/status/live/"
+status_ready_url = base_url + "/status/ready/"
+metrics_url = base_url + "/metrics"
+jwks_url = base_url + "/.well-known/jwks.json"
+openapi_url = base_url + "/openapi.json"
 # auth urls
 tokens_url = base_url + "/token/"
+token_refresh_url = base_url + "/token/refresh/"
+token_logout_url = base_url + "/token/logout/"
+user_sessions_url = base_url + "/user/sessions/{0}/"
+user_activate_url = base_url + "/user/activate/{0}/"
+user_deactivate_url = base_url + "/user/deactivate/{0}/"
+api_key_create_url = base_url + "/user/apikeys/create/{0}/"
+api_key_list_url = base_url + "/user/apikeys/list/{0}/"
+api_key_revoke_url = base_url + "/user/apikeys/revoke/{0}/{1}/"
+oidc_login_url = base_url + "/oidc/login/"
+oidc_callback_url = base_url + "/oidc/callback/"
+identity_link_url = base_url + "/user/identities/link/{0}/"
 user_upsert_url=base_url + "/user/upsert/"
 # graphs management url
 graph_create_url = base_url + "/graph/create/"
 graph_clear_all_url = base_url + "/graph/all/clear/"
+graph_fork_url = base_url + "/graph/fork/{0}/"
+graph_merge_preview_url = base_url + "/graph/merge/preview/{0}/into/{1}/"
+graph_merge_apply_url = base_url + "/graph/merge/apply/{0}/into/{1}/"
 graph_add_import_url = base_url + "/graph/import/{0}/into/{1}/"
+graph_remove_import_url = base_url + "/graph/import/{0}/from/{1}/"
+graph_imports_url = base_url + "/graph/imports/{0}/"
 graphs_list_url = base_url + "/graph/list/"
 graph_delete_url = base_url + "/graph/delete/{0}/"
+graph_trash_url = base_url + "/graph/trash/{0}/"
+graph_restore_url = base_url + "/graph/restore/{0}/"
 graph_load_url = base_url + "/graph/load/{0}/"
 graph_load_snapshot_url = base_url + "/graph/snapshot/{0}/at/{1}/"
 graph_load_since_url = base_url + "/graph/slice/{0}/since/{1}/"
 graph_load_between_url = base_url + "/graph/slice/{0}/between/{1}/and/{2}/"
+graph_aggregate_url = base_url + "/graph/aggregate/{0}/trait/{1}/between/{2}/and/{3}/per/{4}/"
 element_upsert_url = base_url + "/elements/upsert/graph/{0}/"
 element_load_url = base_url + "/elements/load/{0}/"
 element_copy_url = base_url + "/elements/copy/{0}/to/{1}/"
 element_delete_url = base_url + "/elements/delete/{0}/"
+element_restore_url = base_url + "/elements/restore/{0}/"
+element_merge_url = base_url + "/elements/merge/"
+element_merge_undo_url = base_url + "/elements/merge/undo/{0}/"
+element_split_url = base_url + "/elements/split/{0}/at/{1}/"
+element_equivalence_status_url = base_url + "/elements/{0}/equivalence/status/"
+element_equivalence_pull_url = base_url + "/elements/{0}/equivalence/pull/"
+element_equivalence_push_url = base_url + "/elements/{0}/equivalence/push/"
+element_lineage_url = base_url + "/elements/lineage/{0}/"
+# audit url
+audit_url = base_url + "/audit/"
 # find urls 
 neighbors_url = base_url + "/find/neighbors/of/entities/for/trait/{0}/"
 neighbors_url_since = base_url + "/find/neighbors/of/entities/for/trait/{0}/since/{1}"
@@ -69,6 +103,119 @@ def generate_token(username:str, password:str) -> str|None:
     values = json.loads(response.text)
     return values["token"]
 
+
+def generate_tokens(username:str, password:str) -> dict|None:
+    """
+    Given username and password, returns access token and refresh token from the api
+    """
+    response = requests.post(url=tokens_url, json = {"username": username, "password":password})
+    if response.status_code != 200:
+        print_response(response)
+        return None
+    return json.loads(response.text)
+
+
+def refresh_tokens(refresh_token: str) -> dict|None:
+    """
+    Exchanges a refresh token for new tokens. Refresh token may be used once
+    """
+    response = requests.post(url=token_refresh_url, json = {"refresh_token": refresh_token})
+    if response.status_code != 200:
+        print_response(response)
+        return None
+    return json.loads(response.text)
+
+
+def logout(refresh_token: str) -> bool:
+    """
+    Ends the session of a refresh token
+    """
+    response = requests.post(url=token_logout_url, json = {"refresh_token": refresh_token})
+    if response.status_code != 200:
+        print_response(response)
+        return False
+    return True
+
+
+def revoke_user_sessions(token: str, username: str) -> bool:
+    """
+    Ends all sessions of an user. Needs 'manager' authorization on that user
+    """
+    response = requests.delete(
+        url=user_sessions_url.format(username), 
+        headers= {"Authorization":"Bearer " + token},
+    )
+    if response.status_code != 200:
+        print_response(response)
+        return False
+    return True
+
+
+def create_api_key(token: str, username: str, name: str, expires_at: datetime|None = None, graphs: list[str] = list(), roles: list[str] = list()) -> dict|None:
+    """
+    Creates an api key for an user, and returns its id and its value (sent once). 
+    Use it as header Authorization: ApiKey <key>. Needs 'modifier' authorization on that user
+    """
+    body = {"name": name}
+    if expires_at is not None:
+        body["expires_at"] = datetime_to_api_string(expires_at)
+    if len(graphs) != 0:
+        body["graphs"] = graphs
+    if len(roles) != 0:
+        body["roles"] = roles
+
+    response = requests.post(url=api_key_create_url.format(username), headers= {"Authorization":"Bearer " + token}, json=body)
+    if response.status_code != 200:
+        print_response(response)
+        return None
+    return json.loads(response.text)
+
+
+def list_api_keys(token: str, username: str) -> list[dict]|None:
+    """
+    Lists api keys of an user, without their values. Needs 'observer' authorization on that user
+    """
+    response = requests.get(url=api_key_list_url.format(username), headers= {"Authorization":"Bearer " + token})
+    if response.status_code != 200:
+        print_response(response)
+        return None
+    return json.loads(response.text)
+
+
+def revoke_api_key(token: str, username: str, key_id: str) -> bool:
+    """
+    Revokes an api key of an user. Needs 'modifier' authorization on that user
+    """
+    response = requests.delete(url=api_key_revoke_url.format(username, key_id), headers= {"Authorization":"Bearer " + token})
+    if response.status_code != 200:
+        print_response(response)
+        return False
+    return True
+
+
+def set_user_active(token: str, username: str, active: bool) -> bool:
+    """
+    Activates or deactivates an user, deactivation ends its sessions. Needs 'manager' authorization on that user
+    """
+    url = user_activate_url if active else user_deactivate_url
+    response = requests.put(url=url.format(username), headers= {"Authorization":"Bearer " + token})
+    if response.status_code != 200:
+        print_response(response)
+        return False
+    return True
+
+
+def link_external_identity(token: str, username: str, issuer: str, subject: str) -> bool:
+    """
+    Maps a subject of an OpenID Connect issuer to an user. Needs 'manager' authorization on that user
+    """
+    body = {"issuer": issuer, "subject": subject}
+    response = requests.put(url=identity_link_url.format(username), json=body, headers= {"Authorization":"Bearer " + token})
+    if response.status_code != 200:
+        print_response(response)
+        return False
+    return True
+
     
 def upsert_user(token: str, username: str, password: str) -> bool:
     """
@@ -0,0 +1,61 @@
+from api import *
+from elements import *
+from uuid import uuid4
+from connection_data import *
+
+def test_restricted_api_key_routes():
+    assert check_api_connection(), "no connection to patterns"
+
+    token = generate_token(test_username, test_userpass)
+    assert token is not None, "user connection failed"
+
+    # clean it all before test
+    assert clear_all_graphs(token)
+
+    allowed_graph_id = create_graph(token,"allowed graph")
+    assert allowed_graph_id is not None, "failed to create graph"
+    other_graph_id = create_graph(token,"other graph")
+    assert other_graph_id is not None, "failed to create graph"
+
+    paris = Element(id = str(uuid4()))
+    paris.traits = ["City"]
+    paris.activity = ["]-oo;+oo["]
+    paris.add_attribute_value("name", "Paris")
+    assert upsert_element_in_graph(token, allowed_graph_id, paris)
+
+    lyon = Element(id = str(uuid4()))
+    lyon.traits = ["City"]
+    lyon.activity = ["]-oo;+oo["]
+    lyon.add_attribute_value("name", "Lyon")
+    assert upsert_element_in_graph(token, other_graph_id, lyon)
+
+    # key may only observe allowed graph
+    key = create_api_key(token, test_username, "restricted key " + str(uuid4()), graphs=[allowed_graph_id], roles=["observer"])
+    assert key is not None, "failed to create api key"
+    key_headers = {"Authorization":"ApiKey " + key["key"]}
+
+    # case 1: neighbors only in allowed graph
+    url = neighbors_url.format("City")
+    response = requests.get(url=url, headers=key_headers)
+    assert response.status_code == 200
+    result_graph = graph_from_json(response.json())
+    assert paris.element_id in result_graph.elements
+    assert lyon.element_id not in result_graph.elements
+
+    # case 2: lineage of an element outside allowed graph
+    response = requests.get(url=element_lineage_url.format(lyon.element_id), headers=key_headers)
+    assert response.status_code != 200
+    response = requests.get(url=element_lineage_url.format(paris.element_id), headers=key_headers)
+    assert response.status_code == 200
+
+    # case 3: no import, key has no modifier role and cannot see other graph
+    response = requests.put(url=graph_add_import_url.format(other_graph_id, allowed_graph_id), headers=key_headers)
+    assert response.status_code != 200
+    response = requests.get(url=graph_imports_url.format(allowed_graph_id), headers=key_headers)
+    assert response.status_code != 200 or other_graph_id not in response.text
+
+    # same user with a token is not restricted
+    assert add_imported_graph_to_current_graph(token, allowed_graph_id, other_graph_id)
+
+    assert revoke_api_key(token, test_username, key["id"])
+    assert clear_all_graphs(token)